@@ -0,0 +1,178 @@
+package c37118
+
+import (
+	"bytes"
+	"math/cmplx"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCRC(t *testing.T) {
+	// The CRC-CCITT (0xFFFF) check value.
+	require.Equal(t, uint16(0x29B1), CRC([]byte("123456789")))
+}
+
+func testConfig() *Config {
+	return &Config{
+		IDCode:   7,
+		Time:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		TimeBase: 1000000,
+		DataRate: 30,
+		PMUs: []*PMUConfig{
+			{
+				Station:          "SUB1",
+				IDCode:           7,
+				Polar:            true,
+				FloatPhasors:     true,
+				FloatAnalogs:     true,
+				FloatFrequency:   true,
+				Phasors:          []PhasorChannel{{Name: "L1"}, {Name: "C1", Current: true}},
+				Analogs:          []AnalogChannel{{Name: "TEMP", Kind: 1, Scale: -3}},
+				Digitals:         []DigitalWord{{Names: [16]string{"BREAKER"}, Valid: 0xFFFF}},
+				NominalFrequency: 60,
+				ConfigCount:      3,
+			},
+			{
+				Station:          "SUB2",
+				IDCode:           8,
+				Phasors:          []PhasorChannel{{Name: "L2", Scale: 915527}},
+				NominalFrequency: 50,
+			},
+		},
+	}
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	cfg := testConfig()
+	frame, err := cfg.Marshal()
+	require.NoError(t, err)
+
+	read, err := ReadFrame(bytes.NewReader(frame))
+	require.NoError(t, err)
+	header, err := ParseHeader(read)
+	require.NoError(t, err)
+	require.Equal(t, TypeConfig2, header.Type)
+	require.Equal(t, uint8(Version), header.Version)
+
+	parsed, err := ParseConfig(read)
+	require.NoError(t, err)
+	require.Equal(t, cfg, parsed)
+	require.Equal(t, time.Second/30, parsed.Period())
+
+	// A flipped bit fails the checksum.
+	frame[20] ^= 1
+	_, err = ReadFrame(bytes.NewReader(frame))
+	require.ErrorIs(t, err, ErrChecksum)
+
+	_, err = (&Config{TimeBase: 1000000, DataRate: 30, PMUs: []*PMUConfig{{Station: "A NAME LONGER THAN 16"}}}).Marshal()
+	require.Error(t, err)
+}
+
+func TestDataRoundTrip(t *testing.T) {
+	cfg := testConfig()
+	data := &Data{
+		IDCode:      7,
+		Time:        time.Date(2024, 1, 1, 0, 0, 1, 500000000, time.UTC),
+		TimeQuality: 0x05,
+		PMUs: []*PMUData{
+			{
+				Stat:      StatSyncLost,
+				Phasors:   []complex128{cmplx.Rect(7200, 0.5), cmplx.Rect(400, -1)},
+				Frequency: 60.01,
+				ROCOF:     0.2,
+				Analogs:   []float64{21.5},
+				Digitals:  []uint16{1},
+			},
+			{Phasors: []complex128{complex(100, -50)}, Frequency: 49.98, ROCOF: -0.5},
+		},
+	}
+
+	frame, err := cfg.MarshalData(data)
+	require.NoError(t, err)
+	require.NoError(t, Verify(frame))
+
+	parsed, err := ParseData(frame, cfg)
+	require.NoError(t, err)
+	require.Equal(t, data.Time, parsed.Time)
+	require.Equal(t, data.TimeQuality, parsed.TimeQuality)
+
+	first := parsed.PMUs[0]
+	require.Equal(t, uint16(StatSyncLost), first.Stat)
+	require.InDelta(t, 7200, cmplx.Abs(first.Phasors[0]), 1e-3)
+	require.InDelta(t, 0.5, cmplx.Phase(first.Phasors[0]), 1e-6)
+	require.InDelta(t, 60.01, first.Frequency, 1e-5)
+	require.Equal(t, []float64{21.5}, first.Analogs)
+	require.Equal(t, []uint16{1}, first.Digitals)
+
+	// Integer phasors and frequencies lose precision to their scale.
+	second := parsed.PMUs[1]
+	require.InDelta(t, 100, real(second.Phasors[0]), 10)
+	require.InDelta(t, -50, imag(second.Phasors[0]), 10)
+	require.InDelta(t, 49.98, second.Frequency, 1e-9)
+	require.InDelta(t, -0.5, second.ROCOF, 1e-9)
+
+	_, err = ParseData(frame, &Config{IDCode: 8})
+	require.Error(t, err)
+}
+
+func TestGenerator(t *testing.T) {
+	generator, err := NewGenerator("SUB1", 1, 50, []string{"L1", "C1"})
+	require.NoError(t, err)
+
+	pmu, concentrator := net.Pipe()
+	defer concentrator.Close()
+	done := make(chan struct{})
+	served := make(chan error, 1)
+	go func() {
+		served <- generator.Serve(pmu, done)
+		pmu.Close()
+	}()
+
+	// Nothing is sent until asked for. Pipes are unbuffered, so the
+	// commands go out while the replies are read.
+	commands := make(chan error, 1)
+	go func() {
+		for _, command := range []Command{CommandSendConfig2, CommandStart} {
+			frame, err := MarshalCommand(1, command, time.Now())
+			if err == nil {
+				_, err = concentrator.Write(frame)
+			}
+			if err != nil {
+				commands <- err
+				return
+			}
+		}
+		commands <- nil
+	}()
+
+	frame, err := ReadFrame(concentrator)
+	require.NoError(t, err)
+	cfg, err := ParseConfig(frame)
+	require.NoError(t, err)
+	require.NoError(t, <-commands)
+	require.Equal(t, "SUB1", cfg.PMUs[0].Station)
+	require.True(t, cfg.PMUs[0].Phasors[1].Current)
+
+	frame, err = ReadFrame(concentrator)
+	require.NoError(t, err)
+	data, err := ParseData(frame, cfg)
+	require.NoError(t, err)
+	require.InDelta(t, 7200, cmplx.Abs(data.PMUs[0].Phasors[0]), 100)
+	require.InDelta(t, 400, cmplx.Abs(data.PMUs[0].Phasors[1]), 10)
+	require.InDelta(t, 60, data.PMUs[0].Frequency, 0.05)
+	require.Zero(t, data.Time.Nanosecond()%int(20*time.Millisecond))
+
+	close(done)
+	// Drain frames written before the generator noticed.
+	go func() {
+		for {
+			if _, err := ReadFrame(concentrator); err != nil {
+				return
+			}
+		}
+	}()
+	require.NoError(t, <-served)
+}
@@ -0,0 +1,42 @@
+package c37118
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Commands a data concentrator sends a PMU.
+type Command uint16
+
+const (
+	CommandStop        Command = 0x0001 // turn off transmission of data frames.
+	CommandStart       Command = 0x0002 // turn on transmission of data frames.
+	CommandSendHeader  Command = 0x0003
+	CommandSendConfig1 Command = 0x0004
+	CommandSendConfig2 Command = 0x0005
+	CommandSendConfig3 Command = 0x0006
+)
+
+// Encode a command frame to the PMU stream idCode.
+func MarshalCommand(idCode uint16, command Command, at time.Time) ([]byte, error) {
+	soc, _ := splitTime(at, 1)
+	frame := newFrame(TypeCommand, idCode, soc, 0)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(command))
+	return finishFrame(frame)
+}
+
+// Decode a command frame read with ReadFrame.
+func ParseCommand(frame []byte) (Command, error) {
+	header, err := ParseHeader(frame)
+	if err != nil {
+		return 0, err
+	}
+	if header.Type != TypeCommand {
+		return 0, fmt.Errorf("c37118: expected a command frame, got %s", header.Type)
+	}
+
+	r := &reader{data: frame[headerSize : len(frame)-2]}
+	command := Command(r.uint16())
+	return command, r.err
+}
@@ -0,0 +1,293 @@
+package c37118
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Bits of the FORMAT field of a PMU's configuration.
+const (
+	formatPolar          = 1 << 0
+	formatFloatPhasors   = 1 << 1
+	formatFloatAnalogs   = 1 << 2
+	formatFloatFrequency = 1 << 3
+)
+
+// Bit of FNOM set when the nominal frequency is 50 Hz rather than 60 Hz.
+const fnom50Hz = 1 << 0
+
+// A configuration frame, describing the PMUs a data stream carries.
+type Config struct {
+	IDCode   uint16
+	Time     time.Time
+	TimeBase uint32 // FRACSEC counts per second.
+	PMUs     []*PMUConfig
+	// Frames per second when positive, seconds per frame when negative.
+	DataRate int16
+}
+
+// One PMU's block of a configuration frame.
+type PMUConfig struct {
+	Station string
+	IDCode  uint16
+
+	Polar          bool // phasors as magnitude and angle rather than real and imaginary.
+	FloatPhasors   bool
+	FloatAnalogs   bool
+	FloatFrequency bool // frequency and ROCOF.
+
+	Phasors  []PhasorChannel
+	Analogs  []AnalogChannel
+	Digitals []DigitalWord
+
+	NominalFrequency float64 // 50 or 60 Hz.
+	ConfigCount      uint16
+}
+
+// A phasor channel. Integer phasors count Scale·10⁻⁵ volts or amps per bit.
+type PhasorChannel struct {
+	Name    string
+	Current bool
+	Scale   uint32
+}
+
+// An analog channel. Kind is 0 for a point-on-wave value, 1 for RMS
+// and 2 for peak. Integer analogs are passed through unscaled.
+type AnalogChannel struct {
+	Name  string
+	Kind  uint8
+	Scale int32
+}
+
+// A 16 bit digital status word with a name for each bit and the
+// normal and valid masks from DIGUNIT.
+type DigitalWord struct {
+	Names  [16]string
+	Normal uint16
+	Valid  uint16
+}
+
+// Time between frames of the stream, zero if the rate isn't set.
+func (c *Config) Period() time.Duration {
+	switch {
+	case c.DataRate > 0:
+		return time.Second / time.Duration(c.DataRate)
+	case c.DataRate < 0:
+		return time.Duration(-c.DataRate) * time.Second
+	}
+	return 0
+}
+
+// Frames per second of the stream.
+func (c *Config) Rate() float64 {
+	switch {
+	case c.DataRate > 0:
+		return float64(c.DataRate)
+	case c.DataRate < 0:
+		return 1 / float64(-c.DataRate)
+	}
+	return 0
+}
+
+// Decode a CFG-1 or CFG-2 frame read with ReadFrame.
+func ParseConfig(frame []byte) (*Config, error) {
+	header, err := ParseHeader(frame)
+	if err != nil {
+		return nil, err
+	}
+	if header.Type != TypeConfig1 && header.Type != TypeConfig2 {
+		return nil, fmt.Errorf("c37118: expected a CFG-1 or CFG-2 frame, got %s", header.Type)
+	}
+
+	r := &reader{data: frame[headerSize : len(frame)-2]}
+	cfg := &Config{IDCode: header.IDCode, TimeBase: r.uint32() & 0xFFFFFF}
+	cfg.Time = header.Time(cfg.TimeBase)
+
+	count := int(r.uint16())
+	for i := 0; i < count && r.err == nil; i++ {
+		pmu := &PMUConfig{Station: r.name(), IDCode: r.uint16()}
+
+		format := r.uint16()
+		pmu.Polar = format&formatPolar != 0
+		pmu.FloatPhasors = format&formatFloatPhasors != 0
+		pmu.FloatAnalogs = format&formatFloatAnalogs != 0
+		pmu.FloatFrequency = format&formatFloatFrequency != 0
+
+		phasors, analogs, digitals := int(r.uint16()), int(r.uint16()), int(r.uint16())
+		if r.err != nil {
+			break
+		}
+		if phasors > 0 {
+			pmu.Phasors = make([]PhasorChannel, phasors)
+		}
+		if analogs > 0 {
+			pmu.Analogs = make([]AnalogChannel, analogs)
+		}
+		if digitals > 0 {
+			pmu.Digitals = make([]DigitalWord, digitals)
+		}
+
+		for j := range pmu.Phasors {
+			pmu.Phasors[j].Name = r.name()
+		}
+		for j := range pmu.Analogs {
+			pmu.Analogs[j].Name = r.name()
+		}
+		for j := range pmu.Digitals {
+			for k := range pmu.Digitals[j].Names {
+				pmu.Digitals[j].Names[k] = r.name()
+			}
+		}
+
+		for j := range pmu.Phasors {
+			unit := r.uint32()
+			pmu.Phasors[j].Current = unit>>24 == 1
+			pmu.Phasors[j].Scale = unit & 0xFFFFFF
+		}
+		for j := range pmu.Analogs {
+			unit := r.uint32()
+			pmu.Analogs[j].Kind = uint8(unit >> 24)
+			// Sign extend the 24 bit scale.
+			pmu.Analogs[j].Scale = int32(unit<<8) >> 8
+		}
+		for j := range pmu.Digitals {
+			pmu.Digitals[j].Normal = r.uint16()
+			pmu.Digitals[j].Valid = r.uint16()
+		}
+
+		pmu.NominalFrequency = 60
+		if r.uint16()&fnom50Hz != 0 {
+			pmu.NominalFrequency = 50
+		}
+		pmu.ConfigCount = r.uint16()
+		cfg.PMUs = append(cfg.PMUs, pmu)
+	}
+	cfg.DataRate = r.int16()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	return cfg, nil
+}
+
+// Encode the configuration as a CFG-2 frame.
+func (c *Config) Marshal() ([]byte, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	soc, fracSec := splitTime(c.Time, c.TimeBase)
+	frame := newFrame(TypeConfig2, c.IDCode, soc, fracSec)
+	frame = binary.BigEndian.AppendUint32(frame, c.TimeBase&0xFFFFFF)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(c.PMUs)))
+
+	for _, pmu := range c.PMUs {
+		frame = appendName(frame, pmu.Station)
+		frame = binary.BigEndian.AppendUint16(frame, pmu.IDCode)
+
+		var format uint16
+		if pmu.Polar {
+			format |= formatPolar
+		}
+		if pmu.FloatPhasors {
+			format |= formatFloatPhasors
+		}
+		if pmu.FloatAnalogs {
+			format |= formatFloatAnalogs
+		}
+		if pmu.FloatFrequency {
+			format |= formatFloatFrequency
+		}
+		frame = binary.BigEndian.AppendUint16(frame, format)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(pmu.Phasors)))
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(pmu.Analogs)))
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(pmu.Digitals)))
+
+		for _, phasor := range pmu.Phasors {
+			frame = appendName(frame, phasor.Name)
+		}
+		for _, analog := range pmu.Analogs {
+			frame = appendName(frame, analog.Name)
+		}
+		for _, digital := range pmu.Digitals {
+			for _, name := range digital.Names {
+				frame = appendName(frame, name)
+			}
+		}
+
+		for _, phasor := range pmu.Phasors {
+			var unit uint32
+			if phasor.Current {
+				unit = 1 << 24
+			}
+			frame = binary.BigEndian.AppendUint32(frame, unit|phasor.Scale&0xFFFFFF)
+		}
+		for _, analog := range pmu.Analogs {
+			frame = binary.BigEndian.AppendUint32(frame, uint32(analog.Kind)<<24|uint32(analog.Scale)&0xFFFFFF)
+		}
+		for _, digital := range pmu.Digitals {
+			frame = binary.BigEndian.AppendUint16(frame, digital.Normal)
+			frame = binary.BigEndian.AppendUint16(frame, digital.Valid)
+		}
+
+		var fnom uint16
+		if pmu.NominalFrequency == 50 {
+			fnom = fnom50Hz
+		}
+		frame = binary.BigEndian.AppendUint16(frame, fnom)
+		frame = binary.BigEndian.AppendUint16(frame, pmu.ConfigCount)
+	}
+	frame = binary.BigEndian.AppendUint16(frame, uint16(c.DataRate))
+
+	return finishFrame(frame)
+}
+
+// Size of a PMU's block in a data frame.
+func (p *PMUConfig) dataSize() int {
+	phasor, value := 4, 2
+	if p.FloatPhasors {
+		phasor = 8
+	}
+	size := 2 + len(p.Phasors)*phasor + len(p.Digitals)*2
+	if p.FloatFrequency {
+		size += 8
+	} else {
+		size += 4
+	}
+	if p.FloatAnalogs {
+		value = 4
+	}
+	return size + len(p.Analogs)*value
+}
+
+// Check that every name fits its 16 byte field and the counts fit
+// the frame, before the configuration is sent.
+func (c *Config) validate() error {
+	if c.TimeBase == 0 || c.TimeBase > 0xFFFFFF {
+		return fmt.Errorf("c37118: time base %d must be between 1 and %d", c.TimeBase, 0xFFFFFF)
+	}
+	size := headerSize + 2
+	for _, pmu := range c.PMUs {
+		names := []string{pmu.Station}
+		for _, phasor := range pmu.Phasors {
+			names = append(names, phasor.Name)
+		}
+		for _, analog := range pmu.Analogs {
+			names = append(names, analog.Name)
+		}
+		for _, name := range names {
+			if len(name) > 16 || strings.ContainsRune(name, 0) {
+				return fmt.Errorf("c37118: name %q must be at most 16 bytes", name)
+			}
+		}
+		size += pmu.dataSize()
+	}
+	if size > maxFrameSize {
+		return fmt.Errorf("c37118: data frames of %d bytes are too large", size)
+	}
+	if c.DataRate == 0 {
+		return fmt.Errorf("c37118: data rate must be set")
+	}
+	return nil
+}
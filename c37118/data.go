@@ -0,0 +1,181 @@
+package c37118
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"time"
+)
+
+// Bits of a PMU's STAT word.
+const (
+	StatDataError     = 0xC000 // PMU error or test mode, the data is not valid.
+	StatSyncLost      = 1 << 13
+	StatSortByArrival = 1 << 12
+	StatTrigger       = 1 << 11
+	StatConfigChanged = 1 << 10
+	StatModified      = 1 << 9
+)
+
+// A data frame decoded with the configuration of its stream.
+type Data struct {
+	IDCode      uint16
+	Time        time.Time
+	TimeQuality uint8
+	PMUs        []*PMUData
+}
+
+// One PMU's measurements. Phasors are in volts or amps, frequency
+// in hertz and ROCOF in hertz per second, whatever the wire format.
+type PMUData struct {
+	Stat      uint16
+	Phasors   []complex128
+	Frequency float64
+	ROCOF     float64
+	Analogs   []float64
+	Digitals  []uint16
+}
+
+// Decode a data frame read with ReadFrame.
+func ParseData(frame []byte, cfg *Config) (*Data, error) {
+	header, err := ParseHeader(frame)
+	if err != nil {
+		return nil, err
+	}
+	if header.Type != TypeData {
+		return nil, fmt.Errorf("c37118: expected a data frame, got %s", header.Type)
+	}
+	if header.IDCode != cfg.IDCode {
+		return nil, fmt.Errorf("c37118: data frame from stream %d decoded with the configuration of %d", header.IDCode, cfg.IDCode)
+	}
+
+	data := &Data{IDCode: header.IDCode, Time: header.Time(cfg.TimeBase), TimeQuality: header.TimeQuality()}
+	r := &reader{data: frame[headerSize : len(frame)-2]}
+	for _, pmu := range cfg.PMUs {
+		values := &PMUData{Stat: r.uint16()}
+
+		for _, channel := range pmu.Phasors {
+			var value complex128
+			switch {
+			case pmu.FloatPhasors && pmu.Polar:
+				value = cmplx.Rect(r.float32(), r.float32())
+			case pmu.FloatPhasors:
+				value = complex(r.float32(), r.float32())
+			case pmu.Polar:
+				magnitude := float64(r.uint16()) * channel.scale()
+				value = cmplx.Rect(magnitude, float64(r.int16())/1e4)
+			default:
+				value = complex(float64(r.int16())*channel.scale(), float64(r.int16())*channel.scale())
+			}
+			values.Phasors = append(values.Phasors, value)
+		}
+
+		if pmu.FloatFrequency {
+			values.Frequency, values.ROCOF = r.float32(), r.float32()
+		} else {
+			values.Frequency = pmu.NominalFrequency + float64(r.int16())/1000
+			values.ROCOF = float64(r.int16()) / 100
+		}
+
+		for range pmu.Analogs {
+			if pmu.FloatAnalogs {
+				values.Analogs = append(values.Analogs, r.float32())
+			} else {
+				values.Analogs = append(values.Analogs, float64(r.int16()))
+			}
+		}
+		for range pmu.Digitals {
+			values.Digitals = append(values.Digitals, r.uint16())
+		}
+		data.PMUs = append(data.PMUs, values)
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.data) != 0 {
+		return nil, fmt.Errorf("c37118: %d bytes left over after decoding data frame", len(r.data))
+	}
+	return data, nil
+}
+
+// Encode measurements as a data frame of the configuration's stream.
+func (c *Config) MarshalData(data *Data) ([]byte, error) {
+	if len(data.PMUs) != len(c.PMUs) {
+		return nil, fmt.Errorf("c37118: data for %d PMUs, configuration has %d", len(data.PMUs), len(c.PMUs))
+	}
+
+	soc, fracSec := splitTime(data.Time, c.TimeBase)
+	frame := newFrame(TypeData, c.IDCode, soc, uint32(data.TimeQuality)<<24|fracSec)
+	for i, pmu := range c.PMUs {
+		values := data.PMUs[i]
+		if len(values.Phasors) != len(pmu.Phasors) || len(values.Analogs) != len(pmu.Analogs) || len(values.Digitals) != len(pmu.Digitals) {
+			return nil, fmt.Errorf("c37118: data for %s doesn't match its channel counts", pmu.Station)
+		}
+		frame = binary.BigEndian.AppendUint16(frame, values.Stat)
+
+		for j, channel := range pmu.Phasors {
+			value := values.Phasors[j]
+			switch {
+			case pmu.FloatPhasors && pmu.Polar:
+				magnitude, angle := cmplx.Polar(value)
+				frame = appendFloat32(frame, magnitude, angle)
+			case pmu.FloatPhasors:
+				frame = appendFloat32(frame, real(value), imag(value))
+			case pmu.Polar:
+				magnitude, angle := cmplx.Polar(value)
+				frame = binary.BigEndian.AppendUint16(frame, uint16(clamp(magnitude/channel.scale(), 0, math.MaxUint16)))
+				frame = appendInt16(frame, angle*1e4)
+			default:
+				frame = appendInt16(frame, real(value)/channel.scale(), imag(value)/channel.scale())
+			}
+		}
+
+		if pmu.FloatFrequency {
+			frame = appendFloat32(frame, values.Frequency, values.ROCOF)
+		} else {
+			frame = appendInt16(frame, (values.Frequency-pmu.NominalFrequency)*1000, values.ROCOF*100)
+		}
+
+		for _, value := range values.Analogs {
+			if pmu.FloatAnalogs {
+				frame = appendFloat32(frame, value)
+			} else {
+				frame = appendInt16(frame, value)
+			}
+		}
+		for _, word := range values.Digitals {
+			frame = binary.BigEndian.AppendUint16(frame, word)
+		}
+	}
+
+	return finishFrame(frame)
+}
+
+// Volts or amps per bit of an integer phasor.
+func (p *PhasorChannel) scale() float64 {
+	if p.Scale == 0 {
+		return 1e-5
+	}
+	return float64(p.Scale) * 1e-5
+}
+
+func appendFloat32(frame []byte, values ...float64) []byte {
+	for _, value := range values {
+		frame = binary.BigEndian.AppendUint32(frame, math.Float32bits(float32(value)))
+	}
+	return frame
+}
+
+// Append values rounded and clamped to 16 bit signed integers.
+func appendInt16(frame []byte, values ...float64) []byte {
+	for _, value := range values {
+		frame = binary.BigEndian.AppendUint16(frame, uint16(int16(clamp(value, math.MinInt16, math.MaxInt16))))
+	}
+	return frame
+}
+
+func clamp(value, low, high float64) float64 {
+	return math.Max(low, math.Min(high, math.Round(value)))
+}
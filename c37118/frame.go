@@ -0,0 +1,236 @@
+// Package c37118 encodes and decodes IEEE C37.118.2 synchrophasor
+// frames: configuration (CFG-1 and CFG-2), data and command frames.
+// Header and CFG-3 frames are recognized but not decoded.
+package c37118
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// First byte of every frame.
+const syncByte = 0xAA
+
+// Version written into frames, the 2011 revision of the standard.
+const Version = 2
+
+// Bytes before the first field specific to the frame type:
+// SYNC, FRAMESIZE, IDCODE, SOC and FRACSEC.
+const headerSize = 14
+
+// Largest frame FRAMESIZE can describe.
+const maxFrameSize = 65535
+
+// Kind of frame, from bits 6-4 of the SYNC word.
+type FrameType uint8
+
+const (
+	TypeData    FrameType = 0
+	TypeHeader  FrameType = 1
+	TypeConfig1 FrameType = 2
+	TypeConfig2 FrameType = 3
+	TypeCommand FrameType = 4
+	TypeConfig3 FrameType = 5
+)
+
+func (t FrameType) String() string {
+	switch t {
+	case TypeData:
+		return "data"
+	case TypeHeader:
+		return "header"
+	case TypeConfig1:
+		return "CFG-1"
+	case TypeConfig2:
+		return "CFG-2"
+	case TypeCommand:
+		return "command"
+	case TypeConfig3:
+		return "CFG-3"
+	}
+	return fmt.Sprintf("frame type %d", uint8(t))
+}
+
+var (
+	ErrSync     = errors.New("c37118: frame doesn't start with the sync byte")
+	ErrChecksum = errors.New("c37118: frame checksum mismatch")
+	ErrShort    = errors.New("c37118: frame too short")
+)
+
+// Fields common to every frame.
+type Header struct {
+	Type    FrameType
+	Version uint8
+	Size    uint16
+	IDCode  uint16
+	SOC     uint32 // seconds since the Unix epoch.
+	FracSec uint32 // time quality in the top byte, fraction of a second below.
+}
+
+// The frame's timestamp, given the time base of its configuration.
+func (h *Header) Time(timeBase uint32) time.Time {
+	t := time.Unix(int64(h.SOC), 0).UTC()
+	if timeBase == 0 {
+		return t
+	}
+	fraction := int64(h.FracSec & 0xFFFFFF)
+	return t.Add(time.Duration(fraction * int64(time.Second) / int64(timeBase)))
+}
+
+// Time quality flags from the top byte of FRACSEC.
+func (h *Header) TimeQuality() uint8 {
+	return uint8(h.FracSec >> 24)
+}
+
+// Split t into the SOC and FRACSEC fields for a time base.
+func splitTime(t time.Time, timeBase uint32) (soc, fracSec uint32) {
+	soc = uint32(t.Unix())
+	fracSec = uint32(int64(t.Nanosecond()) * int64(timeBase) / int64(time.Second))
+	return soc, fracSec & 0xFFFFFF
+}
+
+// Read the header of a frame read with ReadFrame.
+func ParseHeader(frame []byte) (*Header, error) {
+	if len(frame) < headerSize+2 {
+		return nil, ErrShort
+	}
+	if frame[0] != syncByte {
+		return nil, ErrSync
+	}
+	return &Header{
+		Type:    FrameType(frame[1] >> 4 & 0x7),
+		Version: frame[1] & 0xF,
+		Size:    binary.BigEndian.Uint16(frame[2:]),
+		IDCode:  binary.BigEndian.Uint16(frame[4:]),
+		SOC:     binary.BigEndian.Uint32(frame[6:]),
+		FracSec: binary.BigEndian.Uint32(frame[10:]),
+	}, nil
+}
+
+// Read one whole frame from a stream and check its checksum.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	prefix := make([]byte, 4)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, err
+	}
+	if prefix[0] != syncByte {
+		return nil, ErrSync
+	}
+	size := int(binary.BigEndian.Uint16(prefix[2:]))
+	if size < headerSize+2 {
+		return nil, ErrShort
+	}
+
+	frame := make([]byte, size)
+	copy(frame, prefix)
+	if _, err := io.ReadFull(r, frame[4:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return frame, Verify(frame)
+}
+
+// Check a frame's size and checksum.
+func Verify(frame []byte) error {
+	if len(frame) < headerSize+2 {
+		return ErrShort
+	}
+	if frame[0] != syncByte {
+		return ErrSync
+	}
+	if int(binary.BigEndian.Uint16(frame[2:])) != len(frame) {
+		return fmt.Errorf("c37118: frame size %d doesn't match the %d bytes read", binary.BigEndian.Uint16(frame[2:]), len(frame))
+	}
+	n := len(frame) - 2
+	if CRC(frame[:n]) != binary.BigEndian.Uint16(frame[n:]) {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// CRC-CCITT of data as used by the CHK field: polynomial 0x1021,
+// initial value 0xFFFF, no reflection and no final XOR.
+func CRC(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Start a frame of a type, leaving FRAMESIZE to finishFrame.
+func newFrame(typ FrameType, idCode uint16, soc, fracSec uint32) []byte {
+	frame := make([]byte, headerSize, 64)
+	frame[0] = syncByte
+	frame[1] = byte(typ)<<4 | Version
+	binary.BigEndian.PutUint16(frame[4:], idCode)
+	binary.BigEndian.PutUint32(frame[6:], soc)
+	binary.BigEndian.PutUint32(frame[10:], fracSec)
+	return frame
+}
+
+// Fill in FRAMESIZE and append the checksum.
+func finishFrame(frame []byte) ([]byte, error) {
+	if len(frame)+2 > maxFrameSize {
+		return nil, fmt.Errorf("c37118: frame of %d bytes is too large", len(frame)+2)
+	}
+	binary.BigEndian.PutUint16(frame[2:], uint16(len(frame)+2))
+	return binary.BigEndian.AppendUint16(frame, CRC(frame)), nil
+}
+
+// Reads big-endian fields from a frame body, remembering the first
+// error so callers can check once at the end.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) take(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	if len(r.data) < n {
+		r.err = ErrShort
+		return make([]byte, n)
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) uint16() uint16 { return binary.BigEndian.Uint16(r.take(2)) }
+func (r *reader) uint32() uint32 { return binary.BigEndian.Uint32(r.take(4)) }
+func (r *reader) int16() int16   { return int16(r.uint16()) }
+
+func (r *reader) float32() float64 {
+	return float64(math.Float32frombits(r.uint32()))
+}
+
+// A name padded to 16 bytes with spaces or NULs.
+func (r *reader) name() string {
+	b := r.take(16)
+	end := len(b)
+	for end > 0 && (b[end-1] == ' ' || b[end-1] == 0) {
+		end--
+	}
+	return string(b[:end])
+}
+
+func appendName(frame []byte, name string) []byte {
+	padded := []byte("                ")
+	copy(padded, name)
+	return append(frame, padded...)
+}
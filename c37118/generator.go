@@ -0,0 +1,181 @@
+package c37118
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default FRACSEC resolution of generated streams, microseconds.
+const generatorTimeBase = 1000000
+
+// A fake PMU streaming synthetic measurements, for exercising a data
+// concentrator without hardware. Frequency wanders slowly around
+// nominal and magnitudes ripple around their nominal values.
+type Generator struct {
+	Config *Config
+	// Nominal magnitude of each phasor of each PMU, volts or amps.
+	Magnitudes [][]float64
+}
+
+// A generator for a single 60 Hz PMU with a float polar phasor per
+// name. Names starting with C or I are currents of 400 A, the rest
+// voltages of 7200 V.
+func NewGenerator(station string, idCode uint16, rate int16, phasors []string) (*Generator, error) {
+	pmu := &PMUConfig{
+		Station:          station,
+		IDCode:           idCode,
+		Polar:            true,
+		FloatPhasors:     true,
+		FloatFrequency:   true,
+		NominalFrequency: 60,
+	}
+	magnitudes := make([]float64, len(phasors))
+	for i, name := range phasors {
+		current := strings.HasPrefix(name, "C") || strings.HasPrefix(name, "I")
+		pmu.Phasors = append(pmu.Phasors, PhasorChannel{Name: name, Current: current})
+		magnitudes[i] = 7200
+		if current {
+			magnitudes[i] = 400
+		}
+	}
+
+	cfg := &Config{
+		IDCode:   idCode,
+		TimeBase: generatorTimeBase,
+		PMUs:     []*PMUConfig{pmu},
+		DataRate: rate,
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &Generator{Config: cfg, Magnitudes: [][]float64{magnitudes}}, nil
+}
+
+// Synthetic measurements at t.
+func (g *Generator) Data(t time.Time) *Data {
+	seconds := float64(t.UnixNano()) / float64(time.Second)
+	wander := 2 * math.Pi * seconds / 10
+	ripple := 1 + 0.01*math.Sin(2*math.Pi*seconds/7)
+
+	data := &Data{IDCode: g.Config.IDCode, Time: t}
+	for i, pmu := range g.Config.PMUs {
+		// Frequency swings ±20 mHz over ten seconds, and the phase
+		// angles drift by its integral.
+		values := &PMUData{
+			Frequency: pmu.NominalFrequency + 0.02*math.Sin(wander),
+			ROCOF:     0.02 * 2 * math.Pi / 10 * math.Cos(wander),
+		}
+		drift := -0.2 * math.Cos(wander)
+		for j := range pmu.Phasors {
+			// Consecutive phasors are a phase apart, -120° each.
+			angle := drift - float64(j%3)*2*math.Pi/3
+			values.Phasors = append(values.Phasors, cmplx.Rect(g.Magnitudes[i][j]*ripple, angle))
+		}
+		values.Analogs = make([]float64, len(pmu.Analogs))
+		values.Digitals = make([]uint16, len(pmu.Digitals))
+		data.PMUs = append(data.PMUs, values)
+	}
+	return data
+}
+
+// Round t down to the frame slot it falls in.
+func (g *Generator) slot(t time.Time) time.Time {
+	second := t.Truncate(time.Second)
+	if g.Config.DataRate <= 0 {
+		return second
+	}
+	rate := int64(g.Config.DataRate)
+	n := int64(t.Sub(second)) * rate / int64(time.Second)
+	return second.Add(time.Duration(n * int64(time.Second) / rate))
+}
+
+// Stream frames to a data concentrator over conn until writing fails
+// or done is closed. Over a stream the generator waits for commands as
+// a PMU would, sending its configuration on request and data once
+// turned on. Over datagrams it sends data unprompted and repeats its
+// configuration every second.
+func (g *Generator) Serve(conn net.Conn, done <-chan struct{}) error {
+	var mu sync.Mutex
+	write := func(frame []byte, err error) error {
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		_, err = conn.Write(frame)
+		return err
+	}
+	sendConfig := func() error {
+		g.Config.Time = time.Now()
+		return write(g.Config.Marshal())
+	}
+
+	var sending atomic.Bool
+	failed := make(chan error, 1)
+	_, datagrams := conn.(net.PacketConn)
+	if datagrams {
+		sending.Store(true)
+	} else {
+		go func() {
+			for {
+				frame, err := ReadFrame(conn)
+				if err != nil {
+					failed <- err
+					return
+				}
+				command, err := ParseCommand(frame)
+				if err != nil {
+					continue
+				}
+				switch command {
+				case CommandSendConfig1, CommandSendConfig2:
+					err = sendConfig()
+				case CommandStart:
+					sending.Store(true)
+				case CommandStop:
+					sending.Store(false)
+				}
+				if err != nil {
+					failed <- err
+					return
+				}
+			}
+		}()
+	}
+
+	period := g.Config.Period()
+	if period <= 0 {
+		return fmt.Errorf("c37118: data rate must be set")
+	}
+	perSecond := int(math.Max(1, g.Config.Rate()))
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for frames := 0; ; {
+		select {
+		case <-done:
+			return nil
+		case err := <-failed:
+			return err
+		case now := <-ticker.C:
+			if !sending.Load() {
+				continue
+			}
+			if datagrams && frames%perSecond == 0 {
+				if err := sendConfig(); err != nil {
+					return err
+				}
+			}
+			if err := write(g.Config.MarshalData(g.Data(g.slot(now)))); err != nil {
+				return err
+			}
+			frames++
+		}
+	}
+}
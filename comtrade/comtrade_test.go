@@ -0,0 +1,190 @@
+package comtrade
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `SUB1,RELAY7,1999
+4,3A,1D
+1,VA,A,LINE1,kV,0.01,0,0,-32767,32767,132,0.11,P
+2,IA,A,LINE1,A,0.001,0,0,-32767,32767,800,5,S
+3,VB,B,LINE1,kV,0.01,0,0,-32767,32767,132,0.11,P
+1,TRIP,,LINE1,0
+60
+1
+1200,4
+01/02/2024,10:00:00.000000
+01/02/2024,10:00:00.002500
+%s
+1
+`
+
+func parseTestConfig(t *testing.T, format, rates string) *Config {
+	text := strings.Replace(testConfig, "%s", format, 1)
+	if rates != "" {
+		text = strings.Replace(text, "1\n1200,4\n", rates, 1)
+	}
+	cfg, err := ParseConfig(strings.NewReader(text))
+	require.NoError(t, err)
+	return cfg
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg := parseTestConfig(t, "ASCII", "")
+	require.Equal(t, "SUB1", cfg.Station)
+	require.Equal(t, "RELAY7", cfg.Device)
+	require.Equal(t, 1999, cfg.Revision)
+	require.Equal(t, FormatASCII, cfg.Format)
+	require.Equal(t, 60.0, cfg.Frequency)
+	require.Equal(t, 1200.0, cfg.SampleRate())
+	require.Equal(t, 4, cfg.Samples())
+	require.Equal(t, time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC), cfg.Start)
+	require.Equal(t, cfg.Start.Add(2500*time.Microsecond), cfg.Trigger)
+
+	require.Len(t, cfg.Analogs, 3)
+	current := cfg.Analogs[1]
+	require.Equal(t, "IA", current.Name)
+	require.Equal(t, "A", current.Unit)
+	require.InDelta(t, 64, current.ToPrimary(current.Value(400)), 1e-9)
+	require.InDelta(t, 72, cfg.Analogs[0].ToPrimary(cfg.Analogs[0].Value(7200)), 1e-9)
+	require.Equal(t, []DigitalChannel{{Index: 1, Name: "TRIP", Circuit: "LINE1"}}, cfg.Digitals)
+}
+
+func TestReadASCII(t *testing.T) {
+	cfg := parseTestConfig(t, "ASCII", "")
+	dat := "1,0,7200,400,-3600,0\r\n2,833,7100,410,99999,0\r\n3,1667,7000,420,-3500,1\r\n4,2500,6900,430,-3400,1\r\n"
+
+	samples, err := cfg.ReadData(strings.NewReader(dat))
+	require.NoError(t, err)
+	require.Len(t, samples, 4)
+
+	third := samples[2]
+	require.Equal(t, 3, third.Number)
+	require.Equal(t, cfg.Start.Add(1666667*time.Nanosecond), third.Time)
+	require.InDelta(t, 70, third.Analogs[0], 1e-9)
+	require.InDelta(t, 0.42, third.Analogs[1], 1e-9)
+	require.Equal(t, []bool{true}, third.Digitals)
+	require.True(t, math.IsNaN(samples[1].Analogs[2]))
+
+	_, err = cfg.ReadData(strings.NewReader("1,0,7200,400\n"))
+	require.Error(t, err)
+	_, err = cfg.ReadData(strings.NewReader("1,0,7200,400,-3600,2\n"))
+	require.Error(t, err)
+}
+
+// A binary .dat file with timestamps 500 apart.
+func binaryData(t *testing.T, format Format, values [][]float64) []byte {
+	var buf bytes.Buffer
+	for i, row := range values {
+		fields := []interface{}{uint32(i + 1), uint32(i * 500)}
+		for _, value := range row {
+			switch format {
+			case FormatBinary:
+				fields = append(fields, int16(value))
+			case FormatBinary32:
+				fields = append(fields, int32(value))
+			case FormatFloat32:
+				fields = append(fields, float32(value))
+			}
+		}
+		fields = append(fields, uint16(i%2))
+		for _, field := range fields {
+			require.NoError(t, binary.Write(&buf, binary.LittleEndian, field))
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestReadBinary(t *testing.T) {
+	// Without a sample rate, samples are timed by their timestamps
+	// in microseconds times the multiplier of 1.
+	for _, format := range []Format{FormatBinary, FormatBinary32, FormatFloat32} {
+		cfg := parseTestConfig(t, string(format), "0\n0,3\n")
+		require.Zero(t, cfg.SampleRate())
+
+		missing := -32768.0
+		if format == FormatFloat32 {
+			missing = math.NaN()
+		}
+		if format == FormatBinary32 {
+			missing = math.MinInt32
+		}
+		dat := binaryData(t, format, [][]float64{{7200, 400, -3600}, {7100, 410, missing}, {7000, 420, -3500}})
+
+		samples, err := cfg.ReadData(bytes.NewReader(dat))
+		require.NoError(t, err, format)
+		require.Len(t, samples, 3, format)
+		require.Equal(t, cfg.Start.Add(time.Millisecond), samples[2].Time, format)
+		require.InDelta(t, 71, samples[1].Analogs[0], 1e-9, format)
+		require.True(t, math.IsNaN(samples[1].Analogs[2]), format)
+		require.Equal(t, []bool{true}, samples[1].Digitals, format)
+
+		_, err = cfg.ReadData(bytes.NewReader(dat[:len(dat)-1]))
+		require.Error(t, err, format)
+	}
+}
+
+func TestRevisions(t *testing.T) {
+	// 1991 dates are mm/dd/yy, and channels have fewer fields.
+	cfg, err := ParseConfig(strings.NewReader(`SUB1,RELAY7
+2,1A,1D
+1,VA,A,,kV,1,0,0,-100,100
+1,TRIP,1
+50
+1
+1000,2
+02/01/91,10:00:00.000
+02/01/91,10:00:00.001
+ASCII
+`))
+	require.NoError(t, err)
+	require.Equal(t, 1991, cfg.Revision)
+	require.Equal(t, time.Date(1991, 2, 1, 10, 0, 0, 0, time.UTC), cfg.Start)
+	require.True(t, cfg.Digitals[0].Normal)
+	require.Empty(t, cfg.Analogs[0].PS)
+
+	// 2013 times are local with a time code, timestamps counting
+	// nanoseconds when the start time is given to the nanosecond.
+	cfg, err = ParseConfig(strings.NewReader(`SUB1,RELAY7,2013
+1,1A,0D
+1,VA,A,,kV,1,0,0,-100,100,1,1,P
+50
+0
+0,2
+01/02/2024,10:00:00.000000000
+01/02/2024,10:00:00.000000000
+ASCII
+1
+-5h30,-5h30
+0,0
+`))
+	require.NoError(t, err)
+	require.Equal(t, -5*time.Hour-30*time.Minute, cfg.TimeCode)
+	require.Equal(t, time.Date(2024, 2, 1, 15, 30, 0, 0, time.UTC), cfg.Start)
+	samples, err := cfg.ReadData(strings.NewReader("1,0,1\n2,250,\n"))
+	require.NoError(t, err)
+	require.Equal(t, cfg.Start.Add(250*time.Nanosecond), samples[1].Time)
+	require.True(t, math.IsNaN(samples[1].Analogs[0]))
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for name, text := range map[string]string{
+		"empty":    "",
+		"revision": "SUB1,RELAY7,2001\n",
+		"counts":   "SUB1,RELAY7,1999\n3,1A,1D\n",
+		"short":    "SUB1,RELAY7,1999\n1,1A,0D\n1,VA,A,,kV,1,0\n",
+		"format":   strings.Replace(testConfig, "%s", "XML", 1),
+		"date":     strings.Replace(strings.Replace(testConfig, "%s", "ASCII", 1), "01/02/2024,10:00:00.000000", "31/02/2024,10:00:00", 1),
+		"rate":     strings.Replace(strings.Replace(testConfig, "%s", "ASCII", 1), "1200,4", "-1,4", 1),
+	} {
+		_, err := ParseConfig(strings.NewReader(text))
+		require.Error(t, err, name)
+	}
+}
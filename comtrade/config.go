@@ -0,0 +1,446 @@
+// Package comtrade reads IEEE C37.111 COMTRADE records: the .cfg file
+// describing a disturbance recording and the .dat file holding its
+// samples in ASCII, BINARY, BINARY32 or FLOAT32 format. The 1991, 1999
+// and 2013 revisions are understood; combined .cff files are not.
+package comtrade
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layout of a .dat file.
+type Format string
+
+const (
+	FormatASCII    Format = "ASCII"
+	FormatBinary   Format = "BINARY"   // 16-bit integer samples.
+	FormatBinary32 Format = "BINARY32" // 32-bit integer samples.
+	FormatFloat32  Format = "FLOAT32"  // 32-bit float samples.
+)
+
+// A record's configuration, from its .cfg file.
+type Config struct {
+	Station  string
+	Device   string
+	Revision int // 1991, 1999 or 2013.
+
+	Analogs  []AnalogChannel
+	Digitals []DigitalChannel
+
+	Frequency float64 // Nominal line frequency, Hz.
+	Rates     []Rate
+	Start     time.Time // Time of the first sample, UTC.
+	Trigger   time.Time // UTC.
+	Format    Format
+	TimeMult  float64 // Multiplier of the .dat timestamps.
+	// Offset of the recorded times from UTC, from the 2013 time code.
+	TimeCode time.Duration
+
+	// What a .dat timestamp counts: microseconds, or nanoseconds when
+	// the start time is given to the nanosecond.
+	timestampUnit time.Duration
+}
+
+// An analog channel. Sample x stands for the value A·x+B in Unit.
+type AnalogChannel struct {
+	Index   int
+	Name    string
+	Phase   string
+	Circuit string
+	Unit    string
+	A       float64
+	B       float64
+	Skew    float64 // Microseconds the channel lags the sample time.
+	Min     float64 // Range of the samples x.
+	Max     float64
+
+	// Transformer ratio, and whether A·x+B is a primary (P) or
+	// secondary (S) value. Files before 1999 have neither.
+	Primary   float64
+	Secondary float64
+	PS        string
+}
+
+// The value sample x stands for.
+func (ch *AnalogChannel) Value(x float64) float64 {
+	return ch.A*x + ch.B
+}
+
+// The primary value of a channel value, scaling secondary values by
+// the transformer ratio.
+func (ch *AnalogChannel) ToPrimary(value float64) float64 {
+	if strings.EqualFold(ch.PS, "S") && ch.Secondary != 0 {
+		return value * ch.Primary / ch.Secondary
+	}
+	return value
+}
+
+// A status channel.
+type DigitalChannel struct {
+	Index   int
+	Name    string
+	Phase   string
+	Circuit string
+	Normal  bool // State of the channel in normal operation.
+}
+
+// A run of samples taken at one rate, up to and including EndSample.
+// A rate of zero means sample times come from the .dat timestamps.
+type Rate struct {
+	Rate      float64 // Samples per second.
+	EndSample int
+}
+
+// Number of samples the configuration promises.
+func (cfg *Config) Samples() int {
+	if len(cfg.Rates) == 0 {
+		return 0
+	}
+	return cfg.Rates[len(cfg.Rates)-1].EndSample
+}
+
+// Samples per second of the first run, zero if the record is
+// timestamped instead.
+func (cfg *Config) SampleRate() float64 {
+	if len(cfg.Rates) == 0 {
+		return 0
+	}
+	return cfg.Rates[0].Rate
+}
+
+// Lines of a .cfg file split into trimmed fields.
+type cfgLines struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// The next line's fields, or io.EOF.
+func (l *cfgLines) next() ([]string, error) {
+	if !l.scanner.Scan() {
+		if err := l.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	l.line++
+	fields := strings.Split(strings.TrimRight(l.scanner.Text(), "\r"), ",")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	return fields, nil
+}
+
+// The next line's fields, which must be at least min.
+func (l *cfgLines) require(min int, what string) ([]string, error) {
+	fields, err := l.next()
+	if err == io.EOF {
+		return nil, fmt.Errorf("comtrade: cfg ends before the %s", what)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) < min {
+		return nil, l.errorf("%s needs %d fields, got %d", what, min, len(fields))
+	}
+	return fields, nil
+}
+
+func (l *cfgLines) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("comtrade: cfg line %d: %s", l.line, fmt.Sprintf(format, args...))
+}
+
+func (l *cfgLines) float(field, what string) (float64, error) {
+	if field == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseFloat(field, 64)
+	if err != nil || math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0, l.errorf("%s %q isn't a number", what, field)
+	}
+	return value, nil
+}
+
+func (l *cfgLines) int(field, what string) (int, error) {
+	value, err := strconv.Atoi(field)
+	if err != nil || value < 0 {
+		return 0, l.errorf("%s %q isn't a count", what, field)
+	}
+	return value, nil
+}
+
+// Parse a .cfg file.
+func ParseConfig(r io.Reader) (*Config, error) {
+	l := &cfgLines{scanner: bufio.NewScanner(r)}
+	cfg := &Config{Revision: 1991, TimeMult: 1, timestampUnit: time.Microsecond}
+
+	fields, err := l.require(2, "station line")
+	if err != nil {
+		return nil, err
+	}
+	cfg.Station, cfg.Device = fields[0], fields[1]
+	if len(fields) > 2 && fields[2] != "" {
+		if cfg.Revision, err = strconv.Atoi(fields[2]); err != nil || (cfg.Revision != 1991 && cfg.Revision != 1999 && cfg.Revision != 2013) {
+			return nil, l.errorf("unsupported revision %q", fields[2])
+		}
+	}
+
+	if fields, err = l.require(3, "channel counts"); err != nil {
+		return nil, err
+	}
+	total, err := l.int(fields[0], "channel count")
+	if err != nil {
+		return nil, err
+	}
+	analogs, err := l.int(strings.TrimSuffix(strings.ToUpper(fields[1]), "A"), "analog count")
+	if err != nil {
+		return nil, err
+	}
+	digitals, err := l.int(strings.TrimSuffix(strings.ToUpper(fields[2]), "D"), "digital count")
+	if err != nil {
+		return nil, err
+	}
+	if analogs+digitals != total {
+		return nil, l.errorf("%d analog and %d digital channels don't add up to %d", analogs, digitals, total)
+	}
+
+	for i := 0; i < analogs; i++ {
+		channel, err := l.analog()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Analogs = append(cfg.Analogs, channel)
+	}
+	for i := 0; i < digitals; i++ {
+		channel, err := l.digital()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Digitals = append(cfg.Digitals, channel)
+	}
+
+	if fields, err = l.require(1, "line frequency"); err != nil {
+		return nil, err
+	}
+	if cfg.Frequency, err = l.float(fields[0], "line frequency"); err != nil {
+		return nil, err
+	}
+
+	if fields, err = l.require(1, "sample rate count"); err != nil {
+		return nil, err
+	}
+	nrates, err := l.int(fields[0], "sample rate count")
+	if err != nil {
+		return nil, err
+	}
+	// Records without a fixed rate still give the last sample number.
+	for i := 0; i < nrates || i == 0; i++ {
+		if fields, err = l.require(2, "sample rate"); err != nil {
+			return nil, err
+		}
+		var rate Rate
+		if rate.Rate, err = l.float(fields[0], "sample rate"); err != nil {
+			return nil, err
+		}
+		if rate.EndSample, err = l.int(fields[1], "last sample number"); err != nil {
+			return nil, err
+		}
+		if rate.Rate < 0 || (nrates > 0 && rate.Rate == 0) {
+			return nil, l.errorf("sample rate %q must be positive", fields[0])
+		}
+		cfg.Rates = append(cfg.Rates, rate)
+	}
+
+	var precision time.Duration
+	if fields, err = l.require(2, "start time"); err != nil {
+		return nil, err
+	}
+	if cfg.Start, precision, err = parseTime(fields[0], fields[1], cfg.Revision); err != nil {
+		return nil, l.errorf("start time: %v", err)
+	}
+	if precision < time.Microsecond {
+		cfg.timestampUnit = time.Nanosecond
+	}
+	if fields, err = l.require(2, "trigger time"); err != nil {
+		return nil, err
+	}
+	if cfg.Trigger, _, err = parseTime(fields[0], fields[1], cfg.Revision); err != nil {
+		return nil, l.errorf("trigger time: %v", err)
+	}
+
+	if fields, err = l.require(1, "file format"); err != nil {
+		return nil, err
+	}
+	switch cfg.Format = Format(strings.ToUpper(fields[0])); cfg.Format {
+	case FormatASCII, FormatBinary, FormatBinary32, FormatFloat32:
+	default:
+		return nil, l.errorf("unknown file format %q", fields[0])
+	}
+
+	// The timestamp multiplier and time codes came with later
+	// revisions, and are often left out anyway.
+	if fields, err = l.next(); err == nil && fields[0] != "" {
+		if cfg.TimeMult, err = l.float(fields[0], "timestamp multiplier"); err != nil {
+			return nil, err
+		}
+		if cfg.TimeMult <= 0 {
+			cfg.TimeMult = 1
+		}
+		if fields, err = l.next(); err == nil && fields[0] != "" {
+			if cfg.TimeCode, err = parseTimeCode(fields[0]); err != nil {
+				return nil, l.errorf("time code: %v", err)
+			}
+		}
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	cfg.Start = cfg.Start.Add(-cfg.TimeCode)
+	cfg.Trigger = cfg.Trigger.Add(-cfg.TimeCode)
+	return cfg, nil
+}
+
+// Parse an analog channel line. Files before 1999 end at max.
+func (l *cfgLines) analog() (ch AnalogChannel, err error) {
+	var fields []string
+	if fields, err = l.require(10, "analog channel"); err != nil {
+		return ch, err
+	}
+	if ch.Index, err = l.int(fields[0], "channel index"); err != nil {
+		return ch, err
+	}
+	ch.Name, ch.Phase, ch.Circuit, ch.Unit = fields[1], fields[2], fields[3], fields[4]
+	for i, value := range []*float64{&ch.A, &ch.B, &ch.Skew, &ch.Min, &ch.Max} {
+		if *value, err = l.float(fields[5+i], "analog channel "+ch.Name); err != nil {
+			return ch, err
+		}
+	}
+	if len(fields) >= 13 {
+		if ch.Primary, err = l.float(fields[10], "primary ratio"); err != nil {
+			return ch, err
+		}
+		if ch.Secondary, err = l.float(fields[11], "secondary ratio"); err != nil {
+			return ch, err
+		}
+		ch.PS = strings.ToUpper(fields[12])
+	}
+	return ch, nil
+}
+
+// Parse a digital channel line, which before 1999 has only the index,
+// name and normal state.
+func (l *cfgLines) digital() (ch DigitalChannel, err error) {
+	var fields []string
+	if fields, err = l.require(3, "digital channel"); err != nil {
+		return ch, err
+	}
+	if ch.Index, err = l.int(fields[0], "channel index"); err != nil {
+		return ch, err
+	}
+	ch.Name = fields[1]
+	normal := fields[len(fields)-1]
+	if len(fields) >= 5 {
+		ch.Phase, ch.Circuit, normal = fields[2], fields[3], fields[4]
+	}
+	switch normal {
+	case "0", "":
+	case "1":
+		ch.Normal = true
+	default:
+		return ch, l.errorf("normal state %q of %s must be 0 or 1", normal, ch.Name)
+	}
+	return ch, nil
+}
+
+// Parse a date and time of day as the revision writes them: mm/dd/yy
+// in 1991 and dd/mm/yyyy since. Returns the resolution the seconds
+// were given to.
+func parseTime(date, clock string, revision int) (_ time.Time, precision time.Duration, err error) {
+	parts := strings.Split(date, "/")
+	if len(parts) != 3 {
+		return time.Time{}, 0, fmt.Errorf("date %q isn't dd/mm/yyyy", date)
+	}
+	numbers := make([]int, 3)
+	for i, part := range parts {
+		if numbers[i], err = strconv.Atoi(part); err != nil {
+			return time.Time{}, 0, fmt.Errorf("date %q isn't dd/mm/yyyy", date)
+		}
+	}
+	day, month, year := numbers[0], numbers[1], numbers[2]
+	if revision == 1991 {
+		day, month = month, day
+	}
+	if len(parts[2]) <= 2 {
+		year += 1900
+		if year < 1970 {
+			year += 100
+		}
+	}
+
+	parts = strings.Split(clock, ":")
+	if len(parts) != 3 {
+		return time.Time{}, 0, fmt.Errorf("time %q isn't hh:mm:ss.ssssss", clock)
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("time %q isn't hh:mm:ss.ssssss", clock)
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("time %q isn't hh:mm:ss.ssssss", clock)
+	}
+	seconds, fraction, _ := strings.Cut(parts[2], ".")
+	second, err := strconv.Atoi(seconds)
+	if err != nil || len(fraction) > 9 {
+		return time.Time{}, 0, fmt.Errorf("time %q isn't hh:mm:ss.ssssss", clock)
+	}
+	precision = time.Second
+	nanos := 0
+	if fraction != "" {
+		if nanos, err = strconv.Atoi(fraction + strings.Repeat("0", 9-len(fraction))); err != nil {
+			return time.Time{}, 0, fmt.Errorf("time %q isn't hh:mm:ss.ssssss", clock)
+		}
+		for range fraction {
+			precision /= 10
+		}
+	}
+
+	t := time.Date(year, time.Month(month), day, hour, minute, second, nanos, time.UTC)
+	if t.Day() != day || int(t.Month()) != month || t.Hour() != hour || t.Minute() != minute || t.Second() != second {
+		return time.Time{}, 0, fmt.Errorf("%s,%s isn't a valid time", date, clock)
+	}
+	return t, precision, nil
+}
+
+// Parse a 2013 time code, the offset of local time from UTC such as
+// -5 or +5h30. An x means it isn't known and is taken as UTC.
+func parseTimeCode(code string) (time.Duration, error) {
+	if strings.EqualFold(code, "x") {
+		return 0, nil
+	}
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(code, "-"):
+		sign, code = -1, code[1:]
+	case strings.HasPrefix(code, "+"):
+		code = code[1:]
+	}
+	hours, minutes, found := strings.Cut(code, "h")
+	h, err := strconv.Atoi(hours)
+	if err != nil || h > 24 {
+		return 0, fmt.Errorf("%q isn't an offset like -5 or +5h30", code)
+	}
+	m := 0
+	if found {
+		if m, err = strconv.Atoi(minutes); err != nil || m >= 60 {
+			return 0, fmt.Errorf("%q isn't an offset like -5 or +5h30", code)
+		}
+	}
+	return sign * (time.Duration(h)*time.Hour + time.Duration(m)*time.Minute), nil
+}
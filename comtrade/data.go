@@ -0,0 +1,183 @@
+package comtrade
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sample value standing for missing data in ASCII files before 2013,
+// which leave the field empty instead.
+const missingASCII = "99999"
+
+// Timestamp standing for a missing one in binary files.
+const missingTimestamp = math.MaxUint32
+
+// One sample of every channel.
+type Sample struct {
+	Number int
+	Time   time.Time
+	// Channel values, A·x+B for each sample x. NaN where the
+	// recorder had no data.
+	Analogs  []float64
+	Digitals []bool
+}
+
+// Read the samples of a .dat file described by cfg.
+func (cfg *Config) ReadData(r io.Reader) ([]*Sample, error) {
+	if cfg.Format == FormatASCII {
+		return cfg.readASCII(r)
+	}
+	return cfg.readBinary(r)
+}
+
+func (cfg *Config) readASCII(r io.Reader) (samples []*Sample, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	want := 2 + len(cfg.Analogs) + len(cfg.Digitals)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		fields := strings.Split(text, ",")
+		if len(fields) < want {
+			return nil, fmt.Errorf("comtrade: dat line %d has %d fields, want %d", line, len(fields), want)
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+
+		number, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("comtrade: dat line %d: sample number %q isn't a number", line, fields[0])
+		}
+		timestamp, hasTimestamp := uint64(0), fields[1] != ""
+		if hasTimestamp {
+			if timestamp, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+				return nil, fmt.Errorf("comtrade: dat line %d: timestamp %q isn't a number", line, fields[1])
+			}
+		}
+
+		sample := &Sample{Number: number}
+		if sample.Time, err = cfg.sampleTime(number, timestamp, hasTimestamp); err != nil {
+			return nil, err
+		}
+		for i := range cfg.Analogs {
+			field := fields[2+i]
+			if field == "" || (cfg.Revision < 2013 && field == missingASCII) {
+				sample.Analogs = append(sample.Analogs, math.NaN())
+				continue
+			}
+			x, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, fmt.Errorf("comtrade: dat line %d: %s sample %q isn't a number", line, cfg.Analogs[i].Name, field)
+			}
+			sample.Analogs = append(sample.Analogs, cfg.Analogs[i].Value(x))
+		}
+		for i := range cfg.Digitals {
+			field := fields[2+len(cfg.Analogs)+i]
+			if field != "0" && field != "1" {
+				return nil, fmt.Errorf("comtrade: dat line %d: %s state %q must be 0 or 1", line, cfg.Digitals[i].Name, field)
+			}
+			sample.Digitals = append(sample.Digitals, field == "1")
+		}
+		samples = append(samples, sample)
+	}
+
+	return samples, scanner.Err()
+}
+
+func (cfg *Config) readBinary(r io.Reader) (samples []*Sample, err error) {
+	var size int
+	switch cfg.Format {
+	case FormatBinary:
+		size = 2
+	case FormatBinary32, FormatFloat32:
+		size = 4
+	default:
+		return nil, fmt.Errorf("comtrade: unknown file format %q", cfg.Format)
+	}
+	words := (len(cfg.Digitals) + 15) / 16
+	record := make([]byte, 8+size*len(cfg.Analogs)+2*words)
+
+	reader := bufio.NewReader(r)
+	for {
+		if _, err = io.ReadFull(reader, record); err != nil {
+			if errors.Is(err, io.EOF) {
+				return samples, nil
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, fmt.Errorf("comtrade: dat file ends within sample %d", len(samples)+1)
+			}
+			return nil, err
+		}
+
+		number := int(binary.LittleEndian.Uint32(record))
+		timestamp := binary.LittleEndian.Uint32(record[4:])
+		sample := &Sample{Number: number}
+		if sample.Time, err = cfg.sampleTime(number, uint64(timestamp), timestamp != missingTimestamp); err != nil {
+			return nil, err
+		}
+
+		for i := range cfg.Analogs {
+			field := record[8+size*i:]
+			var x float64
+			missing := false
+			switch cfg.Format {
+			case FormatBinary:
+				value := int16(binary.LittleEndian.Uint16(field))
+				x, missing = float64(value), value == math.MinInt16
+			case FormatBinary32:
+				value := int32(binary.LittleEndian.Uint32(field))
+				x, missing = float64(value), value == math.MinInt32
+			case FormatFloat32:
+				x = float64(math.Float32frombits(binary.LittleEndian.Uint32(field)))
+				missing = math.IsNaN(x)
+			}
+			if missing {
+				sample.Analogs = append(sample.Analogs, math.NaN())
+				continue
+			}
+			sample.Analogs = append(sample.Analogs, cfg.Analogs[i].Value(x))
+		}
+
+		status := record[8+size*len(cfg.Analogs):]
+		for i := range cfg.Digitals {
+			word := binary.LittleEndian.Uint16(status[2*(i/16):])
+			sample.Digitals = append(sample.Digitals, word&(1<<(i%16)) != 0)
+		}
+		samples = append(samples, sample)
+	}
+}
+
+// Time of sample number n. Records with a sample rate are timed by
+// it, the rest by their timestamps.
+func (cfg *Config) sampleTime(n int, timestamp uint64, hasTimestamp bool) (time.Time, error) {
+	if cfg.SampleRate() > 0 {
+		var elapsed float64
+		first := 1
+		for i, rate := range cfg.Rates {
+			if n <= rate.EndSample || i == len(cfg.Rates)-1 {
+				elapsed += float64(n-first) / rate.Rate
+				break
+			}
+			elapsed += float64(rate.EndSample-first+1) / rate.Rate
+			first = rate.EndSample + 1
+		}
+		return cfg.Start.Add(time.Duration(math.Round(elapsed * float64(time.Second)))), nil
+	}
+
+	if !hasTimestamp {
+		return time.Time{}, fmt.Errorf("comtrade: sample %d has neither a sample rate nor a timestamp", n)
+	}
+	offset := float64(timestamp) * cfg.TimeMult * float64(cfg.timestampUnit)
+	return cfg.Start.Add(time.Duration(math.Round(offset))), nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"pingthings/server"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var alarmsCommand = &cli.Command{
+	Name:     "alarms",
+	Category: "client",
+	Usage:    "list, acknowledge and watch alarms, and manage alarm rules",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "endpoint",
+			Aliases: []string{"e"},
+			Value:   "http://localhost:8080",
+		},
+	},
+	Subcommands: []*cli.Command{
+		{
+			Name:   "list",
+			Action: listAlarms,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "state",
+					Usage: "comma separated raised, acknowledged, cleared or \"all\", open alarms by default",
+				},
+				&cli.StringFlag{
+					Name: "sensor",
+				},
+			},
+		},
+		{
+			Name:   "ack",
+			Action: acknowledgeAlarm,
+			Flags: []cli.Flag{
+				&cli.Int64Flag{
+					Name:     "id",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  "by",
+					Value: os.Getenv("USER"),
+				},
+			},
+		},
+		{
+			Name:   "watch",
+			Usage:  "print alarm events as they happen",
+			Action: watchAlarms,
+		},
+		{
+			Name: "rules",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "list",
+					Action: listAlarmRules,
+				},
+				{
+					Name:   "create",
+					Action: createAlarmRule,
+					Flags:  alarmRuleFlags,
+				},
+				{
+					Name:   "update",
+					Action: updateAlarmRule,
+					Flags:  alarmRuleFlags,
+				},
+				{
+					Name:   "delete",
+					Action: deleteAlarmRule,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "id",
+							Required: true,
+						},
+					},
+				},
+			},
+		},
+	},
+}
+
+var alarmRuleFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "id",
+		Required: true,
+	},
+	&cli.StringFlag{
+		Name:  "sensor",
+		Usage: "sensor the rule applies to",
+	},
+	&cli.StringSliceFlag{
+		Name:  "selector",
+		Usage: "key=value matched against sensor unit, ingress, distiller or asset, repeatable",
+	},
+	&cli.StringFlag{
+		Name:     "kind",
+		Usage:    "above, below, rate or stale",
+		Required: true,
+	},
+	&cli.Float64Flag{
+		Name:  "threshold",
+		Usage: "threshold in --unit, per second for rate rules",
+	},
+	&cli.StringFlag{
+		Name:  "unit",
+		Usage: "unit of the threshold, the sensor's own unit by default",
+	},
+	&cli.StringFlag{
+		Name: "description",
+	},
+}
+
+func listAlarms(c *cli.Context) (err error) {
+	query := url.Values{}
+	for _, name := range []string{"state", "sensor"} {
+		if value := c.String(name); value != "" {
+			query.Set(name, value)
+		}
+	}
+
+	endpoint := c.String("endpoint") + "/alarms"
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	return printJSON(http.MethodGet, endpoint, nil)
+}
+
+func acknowledgeAlarm(c *cli.Context) (err error) {
+	endpoint := fmt.Sprintf("%s/alarms/%d/ack", c.String("endpoint"), c.Int64("id"))
+	return printJSON(http.MethodPost, endpoint, &server.Acknowledgement{By: c.String("by")})
+}
+
+// Follow the server's event stream, printing alarm events until interrupted.
+func watchAlarms(c *cli.Context) (err error) {
+	var response *http.Response
+	if response, err = api.do(http.MethodGet, c.String("endpoint")+"/events?type=alarm.", "", nil); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		err = responseError(response, body)
+		fmt.Println(err)
+		return err
+	}
+
+	return printAlarmEvents(os.Stdout, response.Body)
+}
+
+// Print one line per alarm event read from a server-sent event stream.
+func printAlarmEvents(w io.Writer, stream io.Reader) error {
+	scanner := bufio.NewScanner(stream)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+
+		var event struct {
+			Type string       `json:"type"`
+			Time time.Time    `json:"time"`
+			Data server.Alarm `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s %-18s #%d %s: %s\n",
+			event.Time.Format(time.RFC3339), event.Type, event.Data.ID, event.Data.Rule, event.Data.Message)
+	}
+	return scanner.Err()
+}
+
+func listAlarmRules(c *cli.Context) (err error) {
+	return printJSON(http.MethodGet, c.String("endpoint")+"/alarms/rules", nil)
+}
+
+func createAlarmRule(c *cli.Context) (err error) {
+	var rule *server.AlarmRule
+	if rule, err = alarmRuleFromFlags(c); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	return printJSON(http.MethodPost, c.String("endpoint")+"/alarms/rules", rule)
+}
+
+func updateAlarmRule(c *cli.Context) (err error) {
+	var rule *server.AlarmRule
+	if rule, err = alarmRuleFromFlags(c); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	return printJSON(http.MethodPut, alarmRuleURL(c), rule)
+}
+
+func deleteAlarmRule(c *cli.Context) (err error) {
+	if err = requestJSON(http.MethodDelete, alarmRuleURL(c), nil, nil); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Printf("deleted %s\n", c.String("id"))
+
+	return nil
+}
+
+func alarmRuleURL(c *cli.Context) string {
+	return fmt.Sprintf("%s/alarms/rules/%s", c.String("endpoint"), url.PathEscape(c.String("id")))
+}
+
+func alarmRuleFromFlags(c *cli.Context) (*server.AlarmRule, error) {
+	rule := &server.AlarmRule{
+		ID:          c.String("id"),
+		Sensor:      c.String("sensor"),
+		Kind:        c.String("kind"),
+		Threshold:   c.Float64("threshold"),
+		Unit:        c.String("unit"),
+		Description: c.String("description"),
+	}
+
+	for _, pair := range c.StringSlice("selector") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("selector %q must be formatted as key=value", pair)
+		}
+		if rule.Selector == nil {
+			rule.Selector = make(map[string]string)
+		}
+		rule.Selector[key] = value
+	}
+
+	return rule, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintAlarmEvents(t *testing.T) {
+	stream := strings.NewReader(`event:alarm.raised
+data:{"type":"alarm.raised","time":"2024-01-01T00:00:00Z","data":{"id":3,"rule":"overvoltage","sensor":"L1MAG","message":"L1MAG is 1500 V, above 1000 V"}}
+
+event:alarm.cleared
+data:{"type":"alarm.cleared","time":"2024-01-01T00:00:05Z","data":{"id":3,"rule":"overvoltage","sensor":"L1MAG","message":"L1MAG is 1500 V, above 1000 V"}}
+
+`)
+
+	var out bytes.Buffer
+	require.NoError(t, printAlarmEvents(&out, stream))
+	require.Equal(t, `2024-01-01T00:00:00Z alarm.raised       #3 overvoltage: L1MAG is 1500 V, above 1000 V
+2024-01-01T00:00:05Z alarm.cleared      #3 overvoltage: L1MAG is 1500 V, above 1000 V
+`, out.String())
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"pingthings/server"
+	"sort"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// A manifest declares the full set of sensors that should
+// exist on the server. JSON manifests are valid YAML, so
+// both formats are accepted.
+type manifest struct {
+	Sensors []*server.Sensor `yaml:"sensors"`
+}
+
+type action string
+
+const (
+	actionCreate action = "create"
+	actionUpdate action = "update"
+	actionDelete action = "delete"
+)
+
+// A single change needed to bring the server in line with the manifest.
+type change struct {
+	action  action
+	name    string
+	desired *server.Sensor
+	fields  []string // human readable field differences for updates.
+}
+
+// Reconcile the server with a manifest, creating, updating
+// and optionally deleting sensors.
+func applyManifest(c *cli.Context) (err error) {
+	var plan []*change
+	if plan, err = buildPlan(c); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	printPlan(os.Stdout, plan)
+	if len(plan) == 0 || c.Bool("dry-run") {
+		return nil
+	}
+
+	endpoint := c.String("endpoint")
+	for _, ch := range plan {
+		if err = executeChange(endpoint, ch); err != nil {
+			err = fmt.Errorf("%s %s: %w", ch.action, ch.name, err)
+			fmt.Println(err)
+			return err
+		}
+	}
+	fmt.Printf("applied %d change(s)\n", len(plan))
+
+	return nil
+}
+
+// Print the changes between a manifest and the server. Exits
+// with a non-zero status when they differ so it can gate CI.
+func diffManifest(c *cli.Context) (err error) {
+	var plan []*change
+	if plan, err = buildPlan(c); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	printPlan(os.Stdout, plan)
+	if len(plan) > 0 {
+		return cli.Exit("", 1)
+	}
+
+	return nil
+}
+
+// Load the manifest and the server's sensors and work out what has to change.
+func buildPlan(c *cli.Context) (_ []*change, err error) {
+	var desired *manifest
+	if desired, err = loadManifest(c.String("file")); err != nil {
+		return nil, err
+	}
+
+	var current []*server.Sensor
+	url := fmt.Sprintf("%s/allsensors?state=all", c.String("endpoint"))
+	if err = requestJSON(http.MethodGet, url, nil, &current); err != nil {
+		return nil, err
+	}
+
+	return planChanges(desired.Sensors, current, c.Bool("prune")), nil
+}
+
+// Read and sanity check a sensor manifest.
+func loadManifest(path string) (_ *manifest, err error) {
+	var data []byte
+	if data, err = os.ReadFile(path); err != nil {
+		return nil, err
+	}
+
+	m := &manifest{}
+	if err = yaml.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("could not parse manifest %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for _, sensor := range m.Sensors {
+		if sensor == nil || sensor.Name == "" {
+			return nil, errors.New("every sensor in the manifest needs a name")
+		}
+		if seen[sensor.Name] {
+			return nil, fmt.Errorf("sensor %s is declared more than once", sensor.Name)
+		}
+		seen[sensor.Name] = true
+
+		if err = server.ValidateSensor(sensor); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Compare the desired sensors with the current ones. Sensors only
+// present on the server are deleted when prune is set.
+func planChanges(desired, current []*server.Sensor, prune bool) (plan []*change) {
+	existing := make(map[string]*server.Sensor, len(current))
+	for _, sensor := range current {
+		existing[sensor.Name] = sensor
+	}
+
+	declared := make(map[string]bool, len(desired))
+	for _, sensor := range desired {
+		declared[sensor.Name] = true
+
+		found, ok := existing[sensor.Name]
+		if !ok {
+			plan = append(plan, &change{action: actionCreate, name: sensor.Name, desired: sensor})
+			continue
+		}
+
+		if fields := diffSensors(found, sensor); len(fields) > 0 {
+			plan = append(plan, &change{action: actionUpdate, name: sensor.Name, desired: sensor, fields: fields})
+		}
+	}
+
+	if !prune {
+		return plan
+	}
+
+	var deletes []*change
+	for _, sensor := range current {
+		if !declared[sensor.Name] {
+			deletes = append(deletes, &change{action: actionDelete, name: sensor.Name})
+		}
+	}
+	sort.Slice(deletes, func(i, j int) bool { return deletes[i].name < deletes[j].name })
+
+	return append(plan, deletes...)
+}
+
+// List the fields that differ between two versions of a sensor.
+func diffSensors(current, desired *server.Sensor) (fields []string) {
+	compare := func(field string, from, to interface{}) {
+		if from != to {
+			fields = append(fields, fmt.Sprintf("%s: %v -> %v", field, from, to))
+		}
+	}
+
+	// An inherited location is the same as not setting one.
+	currentLocation := current.Location
+	if current.LocationFrom != "" {
+		currentLocation = nil
+	}
+	compare("location", formatLocation(currentLocation), formatLocation(desired.Location))
+	compare("asset", current.Asset, desired.Asset)
+	compare("stale_after", time.Duration(current.StaleAfter), time.Duration(desired.StaleAfter))
+	compare("sample_rate", current.SampleRate, desired.SampleRate)
+	compare("tags.name", current.Tags.Name, desired.Tags.Name)
+	compare("tags.unit", current.Tags.Unit, desired.Tags.Unit)
+	compare("tags.ingress", current.Tags.Ingress, desired.Tags.Ingress)
+	compare("tags.distiller", current.Tags.Distiller, desired.Tags.Distiller)
+
+	return fields
+}
+
+func formatLocation(location *server.Coordinates) string {
+	if location == nil {
+		return "none"
+	}
+	return fmt.Sprintf("(%v, %v)", location.Latitude, location.Longitude)
+}
+
+func printPlan(w io.Writer, plan []*change) {
+	if len(plan) == 0 {
+		fmt.Fprintln(w, "no changes, server matches the manifest")
+		return
+	}
+
+	var creates, updates, deletes int
+	for _, ch := range plan {
+		switch ch.action {
+		case actionCreate:
+			creates++
+			fmt.Fprintf(w, "+ %s\n", ch.name)
+		case actionUpdate:
+			updates++
+			fmt.Fprintf(w, "~ %s\n", ch.name)
+			for _, field := range ch.fields {
+				fmt.Fprintf(w, "    %s\n", field)
+			}
+		case actionDelete:
+			deletes++
+			fmt.Fprintf(w, "- %s\n", ch.name)
+		}
+	}
+	fmt.Fprintf(w, "plan: %d to create, %d to update, %d to delete\n", creates, updates, deletes)
+}
+
+func executeChange(endpoint string, ch *change) error {
+	url := fmt.Sprintf("%s/sensor", endpoint)
+	switch ch.action {
+	case actionCreate:
+		return requestJSON(http.MethodPost, url, ch.desired, nil)
+	case actionUpdate:
+		// A manifest's state only applies to new sensors, existing
+		// ones move between states with pingcli transition.
+		update := *ch.desired
+		update.State = ""
+		return requestJSON(http.MethodPut, fmt.Sprintf("%s/%s", url, ch.name), &update, nil)
+	case actionDelete:
+		return requestJSON(http.MethodDelete, fmt.Sprintf("%s/%s", url, ch.name), nil, nil)
+	}
+	return fmt.Errorf("unknown action %q", ch.action)
+}
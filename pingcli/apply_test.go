@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"pingthings/server"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanChanges(t *testing.T) {
+	current := []*server.Sensor{
+		server.CreateSensor("L1MAG", "volts", "Middle of the Ocean", "foo", 0, 0),
+		server.CreateSensor("L1ANG", "deg", "Anaheim", "bar", 33.8, 117.9),
+		server.CreateSensor("C1MAG", "amps", "New Zealand", "baz", 37.8, 175.7),
+	}
+	desired := []*server.Sensor{
+		server.CreateSensor("L1MAG", "volts", "Middle of the Ocean", "foo", 0, 0),
+		server.CreateSensor("L1ANG", "deg", "Anaheim", "qux", 33.8, 117.9),
+		server.CreateSensor("C2MAG", "amps", "brazil", "foo", 38.4, 26.9),
+	}
+
+	plan := planChanges(desired, current, false)
+	require.Len(t, plan, 2)
+	require.Equal(t, actionUpdate, plan[0].action)
+	require.Equal(t, "L1ANG", plan[0].name)
+	require.Equal(t, []string{"tags.distiller: bar -> qux"}, plan[0].fields)
+	require.Equal(t, actionCreate, plan[1].action)
+	require.Equal(t, "C2MAG", plan[1].name)
+
+	plan = planChanges(desired, current, true)
+	require.Len(t, plan, 3)
+	require.Equal(t, actionDelete, plan[2].action)
+	require.Equal(t, "C1MAG", plan[2].name)
+
+	require.Empty(t, planChanges(current, current, true))
+}
+
+func TestLoadManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sensors.yaml")
+	contents := `
+sensors:
+  - name: C2MAG
+    location: {latitude: 38.4, longitude: 26.9}
+    tags: {unit: amps, ingress: brazil, distiller: foo}
+`
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+
+	m, err := loadManifest(path)
+	require.NoError(t, err)
+	require.Equal(t, []*server.Sensor{server.CreateSensor("C2MAG", "A", "brazil", "foo", 38.4, 26.9)}, m.Sensors)
+
+	duplicate := contents + "  - name: C2MAG\n"
+	require.NoError(t, os.WriteFile(path, []byte(duplicate), 0o644))
+	_, err = loadManifest(path)
+	require.Error(t, err)
+}
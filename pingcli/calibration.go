@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"pingthings/server"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var calibrationsCommand = &cli.Command{
+	Name:     "calibrations",
+	Category: "client",
+	Usage:    "manage the calibrations applied to sensor readings",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "endpoint",
+			Aliases: []string{"e"},
+			Value:   "http://localhost:8080",
+		},
+	},
+	Subcommands: []*cli.Command{
+		{
+			Name:   "list",
+			Action: listCalibrations,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "name",
+					Aliases:  []string{"n"},
+					Required: true,
+				},
+			},
+		},
+		{
+			Name:   "add",
+			Action: addCalibration,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "name",
+					Aliases:  []string{"n"},
+					Required: true,
+				},
+				&cli.Float64Flag{
+					Name:     "scale",
+					Usage:    "multiplier from raw values to the sensor's unit, e.g. a CT ratio",
+					Required: true,
+				},
+				&cli.Float64Flag{
+					Name:  "offset",
+					Usage: "added after scaling",
+				},
+				&cli.StringFlag{
+					Name:     "from",
+					Usage:    "RFC 3339 time the calibration takes effect",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  "certificate",
+					Usage: "calibration certificate reference",
+				},
+				&cli.StringFlag{
+					Name:  "due",
+					Usage: "RFC 3339 time the next calibration is due",
+				},
+			},
+		},
+		{
+			Name:   "delete",
+			Action: deleteCalibration,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "name",
+					Aliases:  []string{"n"},
+					Required: true,
+				},
+				&cli.Int64Flag{
+					Name:     "id",
+					Required: true,
+				},
+			},
+		},
+		{
+			Name:   "overdue",
+			Usage:  "list the calibrations in force that are past due",
+			Action: listOverdueCalibrations,
+		},
+	},
+}
+
+func calibrationsURL(c *cli.Context) string {
+	return fmt.Sprintf("%s/sensor/%s/calibrations", c.String("endpoint"), url.PathEscape(c.String("name")))
+}
+
+func listCalibrations(c *cli.Context) (err error) {
+	return printJSON(http.MethodGet, calibrationsURL(c), nil)
+}
+
+func addCalibration(c *cli.Context) (err error) {
+	cal := &server.Calibration{
+		Scale:       c.Float64("scale"),
+		Offset:      c.Float64("offset"),
+		Certificate: c.String("certificate"),
+	}
+	if cal.EffectiveFrom, err = time.Parse(time.RFC3339Nano, c.String("from")); err != nil {
+		err = fmt.Errorf("from must be an RFC 3339 time: %w", err)
+		fmt.Println(err)
+		return err
+	}
+	if due := c.String("due"); due != "" {
+		var at time.Time
+		if at, err = time.Parse(time.RFC3339Nano, due); err != nil {
+			err = fmt.Errorf("due must be an RFC 3339 time: %w", err)
+			fmt.Println(err)
+			return err
+		}
+		cal.DueAt = &at
+	}
+	return printJSON(http.MethodPost, calibrationsURL(c), cal)
+}
+
+func deleteCalibration(c *cli.Context) (err error) {
+	endpoint := fmt.Sprintf("%s/%d", calibrationsURL(c), c.Int64("id"))
+	if err = requestJSON(http.MethodDelete, endpoint, nil, nil); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Printf("deleted calibration %d\n", c.Int64("id"))
+
+	return nil
+}
+
+func listOverdueCalibrations(c *cli.Context) (err error) {
+	return printJSON(http.MethodGet, c.String("endpoint")+"/calibrations/overdue", nil)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var certsCommand = &cli.Command{
+	Name:     "certs",
+	Category: "server",
+	Usage:    "generate certificates",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "dev",
+			Usage:  "generate a self-signed CA and server and client certificates it signs, for local testing only",
+			Action: devCerts,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "dir",
+					Usage: "directory to write the certificates and keys to",
+					Value: "certs",
+				},
+				&cli.StringSliceFlag{
+					Name:  "host",
+					Usage: "host names and IP addresses the server certificate is for",
+					Value: cli.NewStringSlice("localhost", "127.0.0.1", "::1"),
+				},
+				&cli.StringFlag{
+					Name:  "client-name",
+					Usage: "common name of the client certificate",
+					Value: "pingcli",
+				},
+				&cli.DurationFlag{
+					Name:  "valid-for",
+					Usage: "how long the certificates are valid",
+					Value: 365 * 24 * time.Hour,
+				},
+				&cli.BoolFlag{
+					Name:  "force",
+					Usage: "overwrite certificates already in the directory",
+				},
+			},
+		},
+	},
+}
+
+// Files written by certs dev, as certificate and key pairs.
+var devCertFiles = []string{"ca.pem", "ca-key.pem", "server.pem", "server-key.pem", "client.pem", "client-key.pem"}
+
+func devCerts(c *cli.Context) (err error) {
+	dir := c.String("dir")
+	if !c.Bool("force") {
+		for _, name := range devCertFiles {
+			if _, err = os.Stat(filepath.Join(dir, name)); err == nil {
+				err = fmt.Errorf("%s already exists, use --force to replace it", filepath.Join(dir, name))
+				fmt.Println(err)
+				return err
+			}
+		}
+	}
+
+	if err = writeDevCerts(dir, c.StringSlice("host"), c.String("client-name"), c.Duration("valid-for")); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Printf("wrote a CA and server and client certificates to %s\n", dir)
+	fmt.Printf("serve with: pingcli serve --tls-cert %s --tls-key %s --client-ca %s\n",
+		filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem"), filepath.Join(dir, "ca.pem"))
+	fmt.Printf("connect with: pingcli --ca-cert %s --client-cert %s --client-key %s ...\n",
+		filepath.Join(dir, "ca.pem"), filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem"))
+
+	return nil
+}
+
+// Generate a CA and a server and a client certificate signed by it,
+// writing each certificate and key to dir in PEM.
+func writeDevCerts(dir string, hosts []string, clientName string, validFor time.Duration) (err error) {
+	if len(hosts) == 0 {
+		return errors.New("the server certificate needs at least one host")
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	now := time.Now()
+
+	template := &x509.Certificate{
+		Subject:               pkix.Name{Organization: []string{"pingthings development"}, CommonName: "pingthings development CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validFor),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	ca, caKey, err := writeCert(dir, "ca", template, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	serverCert := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: hosts[0]},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(validFor),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			serverCert.IPAddresses = append(serverCert.IPAddresses, ip)
+		} else {
+			serverCert.DNSNames = append(serverCert.DNSNames, host)
+		}
+	}
+	if _, _, err = writeCert(dir, "server", serverCert, ca, caKey); err != nil {
+		return err
+	}
+
+	clientCert := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: clientName},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(validFor),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	_, _, err = writeCert(dir, "client", clientCert, ca, caKey)
+	return err
+}
+
+// Generate a key for a certificate, sign the certificate with the
+// parent's key, or its own if it has no parent, and write both to
+// name.pem and name-key.pem. Returns the certificate as issued.
+func writeCert(dir, name string, cert, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (issued *x509.Certificate, key *ecdsa.PrivateKey, err error) {
+	if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return nil, nil, err
+	}
+	if cert.SerialNumber, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128)); err != nil {
+		return nil, nil, err
+	}
+	if parent == nil {
+		parent, parentKey = cert, key
+	}
+
+	var der, keyDER []byte
+	if der, err = x509.CreateCertificate(rand.Reader, cert, parent, &key.PublicKey, parentKey); err != nil {
+		return nil, nil, err
+	}
+	if issued, err = x509.ParseCertificate(der); err != nil {
+		return nil, nil, err
+	}
+	if keyDER, err = x509.MarshalECPrivateKey(key); err != nil {
+		return nil, nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err = os.WriteFile(filepath.Join(dir, name+".pem"), certPEM, 0644); err != nil {
+		return nil, nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err = os.WriteFile(filepath.Join(dir, name+"-key.pem"), keyPEM, 0600); err != nil {
+		return nil, nil, err
+	}
+	return issued, key, nil
+}
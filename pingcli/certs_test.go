@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"pingthings/server"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestDevCerts(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, writeDevCerts(dir, []string{"localhost", "127.0.0.1"}, "tester", time.Hour))
+
+	// A server requiring client certificates signed by the dev CA.
+	serverCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem"))
+	require.NoError(t, err)
+	clientCAs, err := server.LoadCertPool(filepath.Join(dir, "ca.pem"))
+	require.NoError(t, err)
+	listener := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	listener.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert}, ClientCAs: clientCAs, ClientAuth: tls.RequireAndVerifyClientCert}
+	listener.StartTLS()
+	defer listener.Close()
+
+	get := func(args ...string) (string, error) {
+		app := &cli.App{Flags: clientFlags, Before: configureClient, Action: func(*cli.Context) error { return nil }}
+		require.NoError(t, app.Run(append([]string{"pingcli", "--config", ""}, args...)))
+		return getRequest(listener.URL)
+	}
+	body, err := get("--ca-cert", filepath.Join(dir, "ca.pem"),
+		"--client-cert", filepath.Join(dir, "client.pem"), "--client-key", filepath.Join(dir, "client-key.pem"))
+	require.NoError(t, err)
+	require.Equal(t, "tester", body)
+
+	_, err = get("--ca-cert", filepath.Join(dir, "ca.pem"))
+	require.Error(t, err)
+	_, err = get()
+	require.Error(t, err)
+
+	_, err = clientTLSConfig("", filepath.Join(dir, "client.pem"), "")
+	require.Error(t, err)
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"pingthings/server"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/propagation"
+	"gopkg.in/yaml.v3"
+)
+
+// What every request the client commands make shares, set up from the
+// global flags before a command runs.
+type client struct {
+	http   *http.Client
+	apiKey string // sent in the X-API-Key header when set.
+	token  string // bearer token sent in the Authorization header when set.
+	// Namespace requests are made in, by prefixing their paths with
+	// /ns/{namespace}. Empty for the default namespace.
+	namespace string
+	sleep     func(time.Duration) // waits before retrying.
+	ctx       context.Context     // trace requests are made in, nil for a new one each.
+}
+
+var api = &client{http: &http.Client{}, sleep: time.Sleep}
+
+// Most times a rate limited request is sent again, and the longest
+// Retry-After waited for.
+const (
+	maxRetries   = 3
+	maxRetryWait = time.Minute
+)
+
+// Settings read from the config file. Flags and environment variables
+// take precedence over it.
+type clientConfig struct {
+	APIKey     string `yaml:"api_key"`
+	Token      string `yaml:"token"`
+	Namespace  string `yaml:"namespace"`
+	CACert     string `yaml:"ca_cert"`
+	ClientCert string `yaml:"client_cert"`
+	ClientKey  string `yaml:"client_key"`
+}
+
+// Global flags configuring the client.
+var clientFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "api-key",
+		Usage:   "API key for servers that require one",
+		EnvVars: []string{"PINGCLI_API_KEY"},
+	},
+	&cli.StringFlag{
+		Name:    "token",
+		Usage:   "bearer token for servers that accept them",
+		EnvVars: []string{"PINGCLI_TOKEN"},
+	},
+	&cli.StringFlag{
+		Name:    "namespace",
+		Aliases: []string{"ns"},
+		Usage:   "namespace to work in, instead of the default one",
+		EnvVars: []string{"PINGCLI_NAMESPACE"},
+	},
+	&cli.StringFlag{
+		Name:    "ca-cert",
+		Usage:   "PEM bundle of CAs to trust for https endpoints, instead of the system's",
+		EnvVars: []string{"PINGCLI_CA_CERT"},
+	},
+	&cli.StringFlag{
+		Name:    "client-cert",
+		Usage:   "PEM client certificate for servers that require one",
+		EnvVars: []string{"PINGCLI_CLIENT_CERT"},
+	},
+	&cli.StringFlag{
+		Name:    "client-key",
+		Usage:   "PEM key of --client-cert",
+		EnvVars: []string{"PINGCLI_CLIENT_KEY"},
+	},
+	&cli.StringFlag{
+		Name:    "config",
+		Usage:   "config file with client settings",
+		EnvVars: []string{"PINGCLI_CONFIG"},
+		Value:   defaultConfigPath(),
+	},
+	&cli.StringFlag{
+		Name:    "traceparent",
+		Usage:   "W3C trace context to make requests in, such as that of a script running pingcli",
+		EnvVars: []string{"TRACEPARENT"},
+	},
+}
+
+// The config file in the user's config directory.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "pingcli", "config.yaml")
+}
+
+// Set up the client from the global flags, falling back to the config
+// file. A missing config file is only an error if it was asked for.
+func configureClient(c *cli.Context) (err error) {
+	var config clientConfig
+	if path := c.String("config"); path != "" {
+		var data []byte
+		data, err = os.ReadFile(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist) && !c.IsSet("config"):
+		case err != nil:
+			return err
+		default:
+			if err = yaml.Unmarshal(data, &config); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	}
+
+	setting := func(flag, fromFile string) string {
+		if value := c.String(flag); value != "" {
+			return value
+		}
+		return fromFile
+	}
+	api.apiKey = setting("api-key", config.APIKey)
+	api.token = setting("token", config.Token)
+	api.namespace = setting("namespace", config.Namespace)
+	if api.ctx, err = startTrace(c.String("traceparent")); err != nil {
+		return err
+	}
+
+	var tlsConfig *tls.Config
+	if tlsConfig, err = clientTLSConfig(setting("ca-cert", config.CACert), setting("client-cert", config.ClientCert), setting("client-key", config.ClientKey)); err != nil {
+		return err
+	}
+	api.http = &http.Client{}
+	if tlsConfig != nil {
+		api.http.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}
+	}
+	return nil
+}
+
+// TLS settings trusting the CAs in caCert and presenting a client
+// certificate, nil when neither is set.
+func clientTLSConfig(caCert, clientCert, clientKey string) (_ *tls.Config, err error) {
+	if caCert == "" && clientCert == "" && clientKey == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caCert != "" {
+		if config.RootCAs, err = server.LoadCertPool(caCert); err != nil {
+			return nil, err
+		}
+	}
+	if clientCert != "" || clientKey != "" {
+		if clientCert == "" || clientKey == "" {
+			return nil, errors.New("a client certificate needs both --client-cert and --client-key")
+		}
+		var cert tls.Certificate
+		if cert, err = tls.LoadX509KeyPair(clientCert, clientKey); err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// The URL of a request in the client's namespace. Keys and namespaces
+// are only managed in the default namespace, so their paths are kept.
+func (cl *client) namespaced(rawURL string) (string, error) {
+	if cl.namespace == "" || cl.namespace == server.DefaultNamespace {
+		return rawURL, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	for _, kept := range []string{"/ns/", "/keys", "/namespaces"} {
+		if strings.HasPrefix(u.Path, kept) {
+			return rawURL, nil
+		}
+	}
+	u.Path = "/ns/" + cl.namespace + u.Path
+	if u.RawPath != "" {
+		u.RawPath = "/ns/" + cl.namespace + u.RawPath
+	}
+	return u.String(), nil
+}
+
+// Send a request with an optional body of the given content type.
+// Rate limited requests are sent again once the server's Retry-After
+// has passed, unless it's too long to wait.
+func (cl *client) do(method, rawURL, contentType string, body io.Reader) (_ *http.Response, err error) {
+	if rawURL, err = cl.namespaced(rawURL); err != nil {
+		return nil, err
+	}
+	var payload []byte
+	if body != nil {
+		if payload, err = io.ReadAll(body); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, span := cl.startSpan(method)
+	defer span.End()
+	for attempt := 0; ; attempt++ {
+		var request *http.Request
+		if request, err = http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(payload)); err != nil {
+			return nil, err
+		}
+		propagator.Inject(ctx, propagation.HeaderCarrier(request.Header))
+		if contentType != "" {
+			request.Header.Set("Content-Type", contentType)
+		}
+		if cl.apiKey != "" {
+			request.Header.Set(server.APIKeyHeader, cl.apiKey)
+		}
+		if cl.token != "" {
+			request.Header.Set("Authorization", "Bearer "+cl.token)
+		}
+
+		var response *http.Response
+		if response, err = cl.http.Do(request); err != nil {
+			return nil, err
+		}
+		if response.StatusCode != http.StatusTooManyRequests || attempt == maxRetries {
+			return response, nil
+		}
+		wait, ok := retryAfter(response.Header.Get("Retry-After"), time.Now())
+		if !ok || wait > maxRetryWait {
+			return response, nil
+		}
+		response.Body.Close()
+		fmt.Fprintf(os.Stderr, "rate limited, retrying in %s\n", wait)
+		cl.sleep(wait)
+	}
+}
+
+// How long a Retry-After header says to wait, in seconds or until an
+// HTTP date.
+func retryAfter(header string, now time.Time) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		if wait := at.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// Send a request and read the whole response body.
+func (cl *client) send(method, url, contentType string, body io.Reader) (_ *http.Response, responseBody []byte, err error) {
+	var response *http.Response
+	if response, err = cl.do(method, url, contentType, body); err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+
+	if responseBody, err = io.ReadAll(response.Body); err != nil {
+		return nil, nil, err
+	}
+	return response, responseBody, nil
+}
+
+func getRequest(url string) (_ string, err error) {
+	var response *http.Response
+	var body []byte
+	if response, body, err = api.send(http.MethodGet, url, "", nil); err != nil {
+		return "", err
+	}
+	noteFailedRequest(response)
+
+	return string(body), nil
+}
+
+func postRequest(url string, toMarshal interface{}) (_ string, err error) {
+	var jsonBytes []byte
+	if jsonBytes, err = json.Marshal(toMarshal); err != nil {
+		return "", err
+	}
+
+	var response *http.Response
+	var responseBody []byte
+	if response, responseBody, err = api.send(http.MethodPost, url, "application/json", bytes.NewBuffer(jsonBytes)); err != nil {
+		return "", err
+	}
+	noteFailedRequest(response)
+
+	return string(responseBody), nil
+}
+
+func putRequest(url string, toMarshal interface{}) (_ string, err error) {
+	var jsonBytes []byte
+	if jsonBytes, err = json.Marshal(toMarshal); err != nil {
+		return "", err
+	}
+
+	var response *http.Response
+	var responseBody []byte
+	if response, responseBody, err = api.send(http.MethodPut, url, "application/json", bytes.NewBuffer(jsonBytes)); err != nil {
+		return "", err
+	}
+	noteFailedRequest(response)
+
+	return string(responseBody), nil
+}
+
+// Send a request with an optional JSON body and decode the JSON
+// response into out. Non-2xx responses are returned as errors
+// carrying the server's error message.
+func requestJSON(method, url string, toMarshal, out interface{}) (err error) {
+	var requestBody io.Reader
+	contentType := ""
+	if toMarshal != nil {
+		var jsonBytes []byte
+		if jsonBytes, err = json.Marshal(toMarshal); err != nil {
+			return err
+		}
+		requestBody = bytes.NewBuffer(jsonBytes)
+		contentType = "application/json"
+	}
+
+	var response *http.Response
+	var responseBody []byte
+	if response, responseBody, err = api.send(method, url, contentType, requestBody); err != nil {
+		return err
+	}
+
+	if err = responseError(response, responseBody); err != nil {
+		return err
+	}
+
+	if out == nil || len(responseBody) == 0 {
+		return nil
+	}
+	return json.Unmarshal(responseBody, out)
+}
+
+// Send a raw request body, returning the response body. Non-2xx
+// responses are returned as errors like requestJSON.
+func uploadRequest(method, url, contentType string, body io.Reader) (_ string, err error) {
+	var response *http.Response
+	var responseBody []byte
+	if response, responseBody, err = api.send(method, url, contentType, body); err != nil {
+		return "", err
+	}
+	if err = responseError(response, responseBody); err != nil {
+		return "", err
+	}
+
+	return string(responseBody), nil
+}
+
+// Post files as a multipart form of field and path pairs, decoding the
+// JSON response into out.
+func uploadFiles(url string, files [][2]string, out interface{}) (err error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, file := range files {
+		var data []byte
+		if data, err = os.ReadFile(file[1]); err != nil {
+			return err
+		}
+		var part io.Writer
+		if part, err = form.CreateFormFile(file[0], filepath.Base(file[1])); err != nil {
+			return err
+		}
+		if _, err = part.Write(data); err != nil {
+			return err
+		}
+	}
+	if err = form.Close(); err != nil {
+		return err
+	}
+
+	var responseBody string
+	if responseBody, err = uploadRequest(http.MethodPost, url, form.FormDataContentType(), &body); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(responseBody), out)
+}
+
+// Turn a non-2xx response into an error carrying the server's error message.
+func responseError(response *http.Response, responseBody []byte) error {
+	if response.StatusCode >= 200 && response.StatusCode <= 299 {
+		return nil
+	}
+
+	var apiError struct {
+		Error string `json:"error"`
+	}
+	message := response.Status
+	if json.Unmarshal(responseBody, &apiError) == nil && apiError.Error != "" {
+		message += ": " + apiError.Error
+	}
+	if id := response.Header.Get(server.RequestIDHeader); id != "" {
+		message += fmt.Sprintf(" (request ID %s)", id)
+	}
+	return errors.New(message)
+}
+
+// Print the request ID of a failed request whose response body is
+// printed as it is, to quote when reporting the problem.
+func noteFailedRequest(response *http.Response) {
+	if response.StatusCode >= 200 && response.StatusCode <= 299 {
+		return
+	}
+	if id := response.Header.Get(server.RequestIDHeader); id != "" {
+		fmt.Fprintf(os.Stderr, "request ID: %s\n", id)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"pingthings/server"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestConfigureClient(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(config, []byte("api_key: from-file\ntoken: token-from-file\n"), 0600))
+
+	apiKey := func(env string, args ...string) string {
+		t.Setenv("PINGCLI_API_KEY", env)
+		app := &cli.App{Flags: clientFlags, Before: configureClient, Action: func(*cli.Context) error { return nil }}
+		require.NoError(t, app.Run(append([]string{"pingcli"}, args...)))
+		return api.apiKey
+	}
+	require.Equal(t, "from-file", apiKey("", "--config", config))
+	require.Equal(t, "from-env", apiKey("from-env", "--config", config))
+	require.Equal(t, "from-flag", apiKey("from-env", "--config", config, "--api-key", "from-flag"))
+
+	token := func(env string, args ...string) string {
+		t.Setenv("PINGCLI_TOKEN", env)
+		app := &cli.App{Flags: clientFlags, Before: configureClient, Action: func(*cli.Context) error { return nil }}
+		require.NoError(t, app.Run(append([]string{"pingcli"}, args...)))
+		return api.token
+	}
+	require.Equal(t, "token-from-file", token("", "--config", config))
+	require.Equal(t, "from-env", token("from-env", "--config", config))
+	// The key flag doesn't stop the token coming from the file.
+	require.Equal(t, "token-from-file", token("", "--config", config, "--api-key", "from-flag"))
+
+	// A config file that was asked for has to exist.
+	t.Setenv("PINGCLI_API_KEY", "")
+	t.Setenv("PINGCLI_TOKEN", "")
+	app := &cli.App{Flags: clientFlags, Before: configureClient}
+	require.Error(t, app.Run([]string{"pingcli", "--config", config + ".missing"}))
+}
+
+func TestNamespaced(t *testing.T) {
+	cl := &client{namespace: "east"}
+	for url, expected := range map[string]string{
+		"http://localhost:8080/sensor/L1MAG":            "http://localhost:8080/ns/east/sensor/L1MAG",
+		"http://localhost:8080/ingresses/New%20Zealand": "http://localhost:8080/ns/east/ingresses/New%20Zealand",
+		"http://localhost:8080/allsensors?state=all":    "http://localhost:8080/ns/east/allsensors?state=all",
+		"http://localhost:8080/keys":                    "http://localhost:8080/keys",
+		"http://localhost:8080/namespaces":              "http://localhost:8080/namespaces",
+		"http://localhost:8080/ns/west/allsensors":      "http://localhost:8080/ns/west/allsensors",
+	} {
+		namespaced, err := cl.namespaced(url)
+		require.NoError(t, err)
+		require.Equal(t, expected, namespaced)
+	}
+
+	cl.namespace = ""
+	namespaced, err := cl.namespaced("http://localhost:8080/sensor/L1MAG")
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost:8080/sensor/L1MAG", namespaced)
+}
+
+func TestRetryAfter(t *testing.T) {
+	attempts := 0
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		switch {
+		case r.URL.Path == "/slow":
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		case attempts == 1:
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		io.Copy(w, r.Body)
+	}))
+	defer limited.Close()
+
+	var waited []time.Duration
+	cl := &client{http: &http.Client{}, sleep: func(d time.Duration) { waited = append(waited, d) }}
+
+	// The body is sent again after waiting.
+	response, body, err := cl.send(http.MethodPost, limited.URL, "text/plain", strings.NewReader("again"))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "again", string(body))
+	require.Equal(t, []time.Duration{2 * time.Second}, waited)
+
+	// Waits that are too long aren't.
+	response, _, err = cl.send(http.MethodGet, limited.URL+"/slow", "", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusTooManyRequests, response.StatusCode)
+	require.Len(t, waited, 1)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	wait, ok := retryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now)
+	require.True(t, ok)
+	require.Equal(t, 30*time.Second, wait)
+	_, ok = retryAfter("soon", now)
+	require.False(t, ok)
+}
+
+func TestResponseError(t *testing.T) {
+	response := &http.Response{StatusCode: 404, Status: "404 Not Found", Header: http.Header{}}
+	require.NoError(t, responseError(&http.Response{StatusCode: 204}, nil))
+	require.EqualError(t, responseError(response, []byte("not json")), "404 Not Found")
+
+	response.Header.Set(server.RequestIDHeader, "3f2a")
+	require.EqualError(t, responseError(response, []byte(`{"error": "Sensor not found in store"}`)),
+		"404 Not Found: Sensor not found in store (request ID 3f2a)")
+}
+
+func TestTraceContext(t *testing.T) {
+	var traceparents []string
+	traced := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents = append(traceparents, r.Header.Get("traceparent"))
+	}))
+	defer traced.Close()
+
+	run := func(args ...string) {
+		t.Setenv("TRACEPARENT", "")
+		app := &cli.App{Flags: clientFlags, Before: configureClient, After: finishTrace, Action: func(*cli.Context) error {
+			for i := 0; i < 2; i++ {
+				if _, _, err := api.send(http.MethodGet, traced.URL, "", nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		}}
+		require.NoError(t, app.Run(append([]string{"pingcli", "--config", ""}, args...)))
+	}
+	traceID := func(traceparent string) string {
+		require.Regexp(t, `^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`, traceparent)
+		return strings.Split(traceparent, "-")[1]
+	}
+
+	// A command's requests share a trace, in their own spans.
+	run()
+	require.Len(t, traceparents, 2)
+	require.Equal(t, traceID(traceparents[0]), traceID(traceparents[1]))
+	require.NotEqual(t, traceparents[0], traceparents[1])
+
+	// Or continue the one they're given.
+	run("--traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.Len(t, traceparents, 4)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID(traceparents[2]))
+	require.NotContains(t, traceparents[2], "00f067aa0ba902b7")
+
+	app := &cli.App{Flags: clientFlags, Before: configureClient, Action: func(*cli.Context) error { return nil }}
+	require.Error(t, app.Run([]string{"pingcli", "--config", "", "--traceparent", "not-a-trace"}))
+}
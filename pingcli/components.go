@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"pingthings/server"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Build the command managing ingresses or distillers, which share
+// the same endpoints under /<plural>.
+func componentCommand(name, plural string) *cli.Command {
+	endpoint := &cli.StringFlag{
+		Name:    "endpoint",
+		Aliases: []string{"e"},
+		Value:   "http://localhost:8080/" + plural,
+	}
+	nameFlag := &cli.StringFlag{
+		Name:     "name",
+		Aliases:  []string{"n"},
+		Required: true,
+	}
+	fields := []cli.Flag{
+		&cli.StringFlag{
+			Name: "description",
+		},
+		&cli.StringSliceFlag{
+			Name:  "metadata",
+			Usage: "key=value, repeatable",
+		},
+	}
+	if name == "ingress" {
+		fields = append(fields, &cli.DurationFlag{
+			Name:  "stale-after",
+			Usage: "liveness threshold for the ingress's sensors",
+		})
+	}
+
+	return &cli.Command{
+		Name:     name,
+		Category: "client",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "list",
+				Action: listComponents,
+				Flags:  []cli.Flag{endpoint},
+			},
+			{
+				Name:   "get",
+				Action: getComponent,
+				Flags:  []cli.Flag{endpoint, nameFlag},
+			},
+			{
+				Name:   "create",
+				Action: createComponent,
+				Flags:  append([]cli.Flag{endpoint, nameFlag}, fields...),
+			},
+			{
+				Name:   "update",
+				Action: updateComponent,
+				Flags:  append([]cli.Flag{endpoint, nameFlag}, fields...),
+			},
+			{
+				Name:   "delete",
+				Action: deleteComponent,
+				Flags:  []cli.Flag{endpoint, nameFlag},
+			},
+			{
+				Name:   "sensors",
+				Action: listComponentSensors,
+				Flags:  []cli.Flag{endpoint, nameFlag},
+			},
+		},
+	}
+}
+
+func listComponents(c *cli.Context) (err error) {
+	return printJSON(http.MethodGet, c.String("endpoint"), nil)
+}
+
+func getComponent(c *cli.Context) (err error) {
+	return printJSON(http.MethodGet, componentURL(c), nil)
+}
+
+func createComponent(c *cli.Context) (err error) {
+	var component *server.Component
+	if component, err = componentFromFlags(c); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	return printJSON(http.MethodPost, c.String("endpoint"), component)
+}
+
+func updateComponent(c *cli.Context) (err error) {
+	var component *server.Component
+	if component, err = componentFromFlags(c); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	return printJSON(http.MethodPut, componentURL(c), component)
+}
+
+func deleteComponent(c *cli.Context) (err error) {
+	if err = requestJSON(http.MethodDelete, componentURL(c), nil, nil); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Printf("deleted %s\n", c.String("name"))
+
+	return nil
+}
+
+func listComponentSensors(c *cli.Context) (err error) {
+	return printJSON(http.MethodGet, componentURL(c)+"/sensors", nil)
+}
+
+func componentURL(c *cli.Context) string {
+	return fmt.Sprintf("%s/%s", c.String("endpoint"), url.PathEscape(c.String("name")))
+}
+
+func componentFromFlags(c *cli.Context) (*server.Component, error) {
+	component := &server.Component{
+		Name:        c.String("name"),
+		Description: c.String("description"),
+		StaleAfter:  server.Duration(c.Duration("stale-after")),
+	}
+
+	for _, pair := range c.StringSlice("metadata") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("metadata %q must be formatted as key=value", pair)
+		}
+		if component.Metadata == nil {
+			component.Metadata = make(map[string]string)
+		}
+		component.Metadata[key] = value
+	}
+
+	return component, nil
+}
+
+// Send a request and print the indented JSON response.
+func printJSON(method, url string, toMarshal interface{}) (err error) {
+	var response json.RawMessage
+	if err = requestJSON(method, url, toMarshal, &response); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Println(string(response))
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+var comtradeCommand = &cli.Command{
+	Name:     "comtrade",
+	Category: "client",
+	Usage:    "import and browse COMTRADE event records",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "endpoint",
+			Aliases: []string{"e"},
+			Value:   "http://localhost:8080",
+		},
+	},
+	Subcommands: []*cli.Command{
+		{
+			Name:   "import",
+			Usage:  "upload a record, storing mapped channels as sensor readings",
+			Action: importRecord,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "cfg",
+					Usage:    "the record's .cfg file",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  "dat",
+					Usage: "the record's .dat file, by default next to the .cfg",
+				},
+				&cli.StringFlag{
+					Name:  "mapping",
+					Usage: "JSON or YAML file mapping channel names to sensor names",
+				},
+			},
+		},
+		{
+			Name:   "list",
+			Action: listRecords,
+		},
+		{
+			Name:   "get",
+			Action: getRecord,
+			Flags: []cli.Flag{
+				&cli.Int64Flag{
+					Name:     "id",
+					Required: true,
+				},
+			},
+		},
+		{
+			Name:   "channel",
+			Usage:  "print one channel's samples",
+			Action: getRecordChannel,
+			Flags: []cli.Flag{
+				&cli.Int64Flag{
+					Name:     "id",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "channel",
+					Aliases:  []string{"c"},
+					Required: true,
+				},
+			},
+		},
+		{
+			Name:   "delete",
+			Usage:  "delete a record, keeping the readings imported from it",
+			Action: deleteRecord,
+			Flags: []cli.Flag{
+				&cli.Int64Flag{
+					Name:     "id",
+					Required: true,
+				},
+			},
+		},
+	},
+}
+
+// The .dat file next to a .cfg file, matching the case of its extension.
+func datPath(cfg string) string {
+	ext := filepath.Ext(cfg)
+	dat := ".dat"
+	if ext != "" && ext == strings.ToUpper(ext) {
+		dat = ".DAT"
+	}
+	return strings.TrimSuffix(cfg, ext) + dat
+}
+
+func importRecord(c *cli.Context) (err error) {
+	files := [][2]string{{"cfg", c.String("cfg")}, {"dat", c.String("dat")}}
+	if files[1][1] == "" {
+		files[1][1] = datPath(files[0][1])
+	}
+	if mapping := c.String("mapping"); mapping != "" {
+		files = append(files, [2]string{"mapping", mapping})
+	}
+
+	var response json.RawMessage
+	if err = uploadFiles(c.String("endpoint")+"/records", files, &response); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Println(string(response))
+
+	return nil
+}
+
+func listRecords(c *cli.Context) (err error) {
+	return printJSON(http.MethodGet, c.String("endpoint")+"/records", nil)
+}
+
+func getRecord(c *cli.Context) (err error) {
+	return printJSON(http.MethodGet, fmt.Sprintf("%s/records/%d", c.String("endpoint"), c.Int64("id")), nil)
+}
+
+func getRecordChannel(c *cli.Context) (err error) {
+	endpoint := fmt.Sprintf("%s/records/%d/channels/%s", c.String("endpoint"), c.Int64("id"), url.PathEscape(c.String("channel")))
+	return printJSON(http.MethodGet, endpoint, nil)
+}
+
+func deleteRecord(c *cli.Context) (err error) {
+	endpoint := fmt.Sprintf("%s/records/%d", c.String("endpoint"), c.Int64("id"))
+	if err = requestJSON(http.MethodDelete, endpoint, nil, nil); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Printf("deleted record %d\n", c.Int64("id"))
+
+	return nil
+}
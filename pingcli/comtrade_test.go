@@ -0,0 +1,13 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatPath(t *testing.T) {
+	require.Equal(t, "records/fault.dat", datPath("records/fault.cfg"))
+	require.Equal(t, "FAULT.DAT", datPath("FAULT.CFG"))
+	require.Equal(t, "fault.dat", datPath("fault"))
+}
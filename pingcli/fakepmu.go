@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"pingthings/c37118"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+)
+
+var fakePMUCommand = &cli.Command{
+	Name:     "fakepmu",
+	Category: "server",
+	Usage:    "stream synthetic C37.118 frames to a server's PMU listener",
+	Action:   fakePMU,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "address",
+			Aliases: []string{"a"},
+			Value:   "localhost:4712",
+		},
+		&cli.StringFlag{
+			Name:  "network",
+			Usage: "tcp or udp",
+			Value: "tcp",
+		},
+		&cli.StringFlag{
+			Name:  "station",
+			Value: "SUB1",
+		},
+		&cli.UintFlag{
+			Name:  "id",
+			Usage: "IDCODE of the stream",
+			Value: 1,
+		},
+		&cli.IntFlag{
+			Name:  "rate",
+			Usage: "frames per second",
+			Value: 30,
+		},
+		&cli.StringSliceFlag{
+			Name:  "phasor",
+			Usage: "phasor channel, currents start with C or I, repeatable",
+			Value: cli.NewStringSlice("L1", "C1"),
+		},
+	},
+}
+
+// Connect to a PMU listener and stream frames until interrupted.
+func fakePMU(c *cli.Context) (err error) {
+	if c.Uint("id") > 0xFFFF || c.Int("rate") < 1 || c.Int("rate") > 0x7FFF {
+		err = fmt.Errorf("id must fit in 16 bits and rate be between 1 and %d", 0x7FFF)
+		fmt.Println(err)
+		return err
+	}
+
+	var generator *c37118.Generator
+	if generator, err = c37118.NewGenerator(c.String("station"), uint16(c.Uint("id")), int16(c.Int("rate")), c.StringSlice("phasor")); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	var conn net.Conn
+	if conn, err = net.Dial(c.String("network"), c.String("address")); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, syscall.SIGINT)
+	go func() {
+		<-interrupt
+		close(done)
+	}()
+
+	log.Printf("streaming %s to %s %s at %d frames per second", c.String("station"), c.String("network"), c.String("address"), c.Int("rate"))
+	if err = generator.Serve(conn, done); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	return nil
+}
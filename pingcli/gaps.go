@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"pingthings/server"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var gapsCommand = &cli.Command{
+	Name:     "gaps",
+	Category: "client",
+	Usage:    "report missing and duplicate samples in a sensor's readings",
+	Action:   sensorGaps,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "endpoint",
+			Aliases: []string{"e"},
+			Value:   "http://localhost:8080/sensor",
+		},
+		&cli.StringFlag{
+			Name:     "name",
+			Aliases:  []string{"n"},
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "start",
+			Usage: "RFC 3339 time, the first reading by default",
+		},
+		&cli.StringFlag{
+			Name:  "end",
+			Usage: "RFC 3339 time, one period past the last reading by default",
+		},
+		&cli.StringFlag{
+			Name:  "rate",
+			Usage: "samples per second, overriding the sensor's sample rate",
+		},
+		&cli.StringFlag{
+			Name:  "tolerance",
+			Usage: "fraction of a period readings may drift before it counts as a gap",
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "text or json",
+			Value:   "text",
+		},
+	},
+}
+
+func sensorGaps(c *cli.Context) (err error) {
+	query := url.Values{}
+	for _, name := range []string{"start", "end", "rate", "tolerance"} {
+		if value := c.String(name); value != "" {
+			query.Set(name, value)
+		}
+	}
+
+	endpoint := fmt.Sprintf("%s/%s/gaps", c.String("endpoint"), url.PathEscape(c.String("name")))
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	switch c.String("output") {
+	case "json":
+		return printJSON(http.MethodGet, endpoint, nil)
+	case "text":
+		var report *server.GapReport
+		if err = requestJSON(http.MethodGet, endpoint, nil, &report); err != nil {
+			fmt.Println(err)
+			return err
+		}
+		writeGapReport(os.Stdout, report)
+	default:
+		err = fmt.Errorf("unknown output %q, use text or json", c.String("output"))
+		fmt.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// Write a gap report as a summary followed by each gap and duplicate.
+func writeGapReport(w io.Writer, report *server.GapReport) {
+	fmt.Fprintf(w, "%s at %g Hz\n", report.Sensor, report.SampleRate)
+	if report.Start == nil {
+		fmt.Fprintln(w, "no readings in range")
+		return
+	}
+	fmt.Fprintf(w, "%s to %s\n", report.Start.Format(time.RFC3339Nano), report.End.Format(time.RFC3339Nano))
+	fmt.Fprintf(w, "%d of %d samples received, %g%% complete\n", report.Received, report.Expected, report.Completeness)
+
+	fmt.Fprintf(w, "\n%d gaps\n", len(report.Gaps))
+	for _, gap := range report.Gaps {
+		fmt.Fprintf(w, "  %s  %d missing over %s\n",
+			gap.Start.Format(time.RFC3339Nano), gap.Missing, time.Duration(gap.Duration))
+	}
+
+	fmt.Fprintf(w, "\n%d duplicate timestamps\n", len(report.Duplicates))
+	for _, duplicate := range report.Duplicates {
+		fmt.Fprintf(w, "  %s  %d times\n", duplicate.Time.Format(time.RFC3339Nano), duplicate.Count)
+	}
+}
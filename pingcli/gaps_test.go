@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"pingthings/server"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteGapReport(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Second)
+	report := &server.GapReport{
+		Sensor:       "C1MAG",
+		SampleRate:   10,
+		Start:        &start,
+		End:          &end,
+		Expected:     10,
+		Received:     7,
+		Completeness: 70,
+		Gaps: []*server.Gap{
+			{Start: start.Add(300 * time.Millisecond), Missing: 3, Duration: server.Duration(300 * time.Millisecond)},
+		},
+		Duplicates: []*server.Duplicate{{Time: start, Count: 2}},
+	}
+
+	var out bytes.Buffer
+	writeGapReport(&out, report)
+	require.Equal(t, `C1MAG at 10 Hz
+2024-01-01T00:00:00Z to 2024-01-01T00:00:01Z
+7 of 10 samples received, 70% complete
+
+1 gaps
+  2024-01-01T00:00:00.3Z  3 missing over 300ms
+
+1 duplicate timestamps
+  2024-01-01T00:00:00Z  2 times
+`, out.String())
+
+	out.Reset()
+	writeGapReport(&out, &server.GapReport{Sensor: "C1MAG", SampleRate: 30})
+	require.Equal(t, "C1MAG at 30 Hz\nno readings in range\n", out.String())
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"pingthings/server"
+
+	"github.com/urfave/cli/v2"
+)
+
+var keysCommand = &cli.Command{
+	Name:     "keys",
+	Category: "client",
+	Usage:    "manage API keys, which needs an admin key",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "endpoint",
+			Aliases: []string{"e"},
+			Value:   "http://localhost:8080",
+		},
+	},
+	Subcommands: []*cli.Command{
+		{
+			Name:   "create",
+			Usage:  "create a key and print it, which is the only time it's shown",
+			Action: createKey,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "name",
+					Aliases:  []string{"n"},
+					Usage:    "who or what the key is for",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  "scope",
+					Usage: "read, write or admin",
+					Value: string(server.ScopeRead),
+				},
+			},
+		},
+		{
+			Name:   "list",
+			Action: listKeys,
+		},
+		{
+			Name:   "revoke",
+			Action: revokeKey,
+			Flags: []cli.Flag{
+				&cli.Int64Flag{
+					Name:     "id",
+					Required: true,
+				},
+			},
+		},
+	},
+}
+
+func createKey(c *cli.Context) (err error) {
+	key := &server.APIKey{Name: c.String("name"), Scope: server.Scope(c.String("scope"))}
+	if err = requestJSON(http.MethodPost, c.String("endpoint")+"/keys", key, key); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Printf("created %s key %d (%s):\n%s\n", key.Scope, key.ID, key.Name, key.Key)
+
+	return nil
+}
+
+func listKeys(c *cli.Context) (err error) {
+	return printJSON(http.MethodGet, c.String("endpoint")+"/keys", nil)
+}
+
+func revokeKey(c *cli.Context) (err error) {
+	endpoint := fmt.Sprintf("%s/keys/%d", c.String("endpoint"), c.Int64("id"))
+	if err = requestJSON(http.MethodDelete, endpoint, nil, nil); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Printf("revoked key %d\n", c.Int64("id"))
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"pingthings/server"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var outputFlag = &cli.StringFlag{
+	Name:    "output",
+	Aliases: []string{"o"},
+	Usage:   "json or table",
+	Value:   "json",
+}
+
+// Record a heartbeat for a sensor.
+func heartbeat(c *cli.Context) (err error) {
+	endpoint := fmt.Sprintf("%s/%s/heartbeat", c.String("endpoint"), url.PathEscape(c.String("name")))
+	return printJSON(http.MethodPost, endpoint, nil)
+}
+
+// List the active sensors that have gone silent.
+func listStaleSensors(c *cli.Context) (err error) {
+	return printSensors(c, c.String("endpoint"))
+}
+
+// Print the sensors at url in the format picked by --output.
+func printSensors(c *cli.Context, url string) (err error) {
+	switch c.String("output") {
+	case "json":
+		var responseString string
+		if responseString, err = getRequest(url); err != nil {
+			fmt.Println(err)
+			return err
+		}
+		fmt.Println(responseString)
+	case "table":
+		var sensors []*server.Sensor
+		if err = requestJSON(http.MethodGet, url, nil, &sensors); err != nil {
+			fmt.Println(err)
+			return err
+		}
+		writeSensorTable(os.Stdout, sensors, time.Now())
+	default:
+		err = fmt.Errorf("unknown output %q, use json or table", c.String("output"))
+		fmt.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// Write one row per sensor with its lifecycle state and liveness.
+func writeSensorTable(w io.Writer, sensors []*server.Sensor, now time.Time) {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "NAME\tUNIT\tINGRESS\tSTATE\tSTATUS\tLAST SEEN")
+	for _, sensor := range sensors {
+		lastSeen := "never"
+		if sensor.LastSeen != nil {
+			lastSeen = fmt.Sprintf("%s ago", now.Sub(*sensor.LastSeen).Truncate(time.Second))
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			sensor.Name, sensor.Tags.Unit, sensor.Tags.Ingress, sensor.State, sensor.Status, lastSeen)
+	}
+	table.Flush()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"pingthings/server"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteSensorTable(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	seen := now.Add(-90 * time.Second)
+	sensors := []*server.Sensor{
+		{
+			Name:     "L1MAG",
+			State:    server.StateActive,
+			Status:   server.StatusLive,
+			LastSeen: &seen,
+			Tags:     server.SensorTags{Unit: "V", Ingress: "foo"},
+		},
+		{
+			Name:   "C1MAG",
+			State:  server.StatePlanned,
+			Status: server.StatusUnseen,
+			Tags:   server.SensorTags{Unit: "A", Ingress: "New Zealand"},
+		},
+	}
+
+	var out bytes.Buffer
+	writeSensorTable(&out, sensors, now)
+	require.Equal(t, `NAME   UNIT  INGRESS      STATE    STATUS  LAST SEEN
+L1MAG  V     foo          active   live    1m30s ago
+C1MAG  A     New Zealand  planned  unseen  never
+`, out.String())
+}
@@ -1,11 +1,10 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"pingthings/server"
 
@@ -14,19 +13,14 @@ import (
 
 func main() {
 	app := cli.NewApp()
+	app.Flags = clientFlags
+	app.Before = configureClient
+	app.After = finishTrace
 	app.Commands = []*cli.Command{
-		{
-			Name:     "serve",
-			Category: "server",
-			Action:   serve,
-			Flags: []cli.Flag{
-				&cli.StringFlag{
-					Name:    "address",
-					Aliases: []string{"a"},
-					Value:   "localhost:8080",
-				},
-			},
-		},
+		serveCommand,
+		configCommand,
+		fakePMUCommand,
+		certsCommand,
 		{
 			Name:     "list",
 			Category: "client",
@@ -37,6 +31,11 @@ func main() {
 					Aliases: []string{"e"},
 					Value:   "http://localhost:8080/allsensors",
 				},
+				&cli.StringFlag{
+					Name:  "state",
+					Usage: "comma separated lifecycle states or \"all\", decommissioned sensors are left out by default",
+				},
+				outputFlag,
 			},
 		},
 		{
@@ -55,14 +54,27 @@ func main() {
 					Required: true,
 				},
 				&cli.Float64Flag{
-					Name:     "lat",
-					Aliases:  []string{"la"},
-					Required: true,
+					Name:    "lat",
+					Aliases: []string{"la"},
 				},
 				&cli.Float64Flag{
-					Name:     "lon",
-					Aliases:  []string{"lo"},
-					Required: true,
+					Name:    "lon",
+					Aliases: []string{"lo"},
+				},
+				&cli.StringFlag{
+					Name: "asset",
+				},
+				&cli.DurationFlag{
+					Name:  "stale-after",
+					Usage: "liveness threshold, overriding the ingress's",
+				},
+				&cli.Float64Flag{
+					Name:  "sample-rate",
+					Usage: "nominal reporting rate in samples per second",
+				},
+				&cli.StringFlag{
+					Name:  "state",
+					Usage: "initial lifecycle state: planned, commissioned or active",
 				},
 				&cli.StringFlag{
 					Name:     "unit",
@@ -97,14 +109,23 @@ func main() {
 					Required: true,
 				},
 				&cli.Float64Flag{
-					Name:     "lat",
-					Aliases:  []string{"la"},
-					Required: true,
+					Name:    "lat",
+					Aliases: []string{"la"},
 				},
 				&cli.Float64Flag{
-					Name:     "lon",
-					Aliases:  []string{"lo"},
-					Required: true,
+					Name:    "lon",
+					Aliases: []string{"lo"},
+				},
+				&cli.StringFlag{
+					Name: "asset",
+				},
+				&cli.DurationFlag{
+					Name:  "stale-after",
+					Usage: "liveness threshold, overriding the ingress's",
+				},
+				&cli.Float64Flag{
+					Name:  "sample-rate",
+					Usage: "nominal reporting rate in samples per second",
 				},
 				&cli.StringFlag{
 					Name:     "unit",
@@ -140,6 +161,66 @@ func main() {
 				},
 			},
 		},
+		{
+			Name:     "delete",
+			Category: "client",
+			Action:   deleteSensor,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "endpoint",
+					Aliases: []string{"e"},
+					Value:   "http://localhost:8080/sensor",
+				},
+				&cli.StringFlag{
+					Name:     "name",
+					Aliases:  []string{"n"},
+					Required: true,
+				},
+			},
+		},
+		{
+			Name:     "apply",
+			Category: "client",
+			Action:   applyManifest,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "endpoint",
+					Aliases: []string{"e"},
+					Value:   "http://localhost:8080",
+				},
+				&cli.StringFlag{
+					Name:     "file",
+					Aliases:  []string{"f"},
+					Required: true,
+				},
+				&cli.BoolFlag{
+					Name: "dry-run",
+				},
+				&cli.BoolFlag{
+					Name: "prune",
+				},
+			},
+		},
+		{
+			Name:     "diff",
+			Category: "client",
+			Action:   diffManifest,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "endpoint",
+					Aliases: []string{"e"},
+					Value:   "http://localhost:8080",
+				},
+				&cli.StringFlag{
+					Name:     "file",
+					Aliases:  []string{"f"},
+					Required: true,
+				},
+				&cli.BoolFlag{
+					Name: "prune",
+				},
+			},
+		},
 		{
 			Name:     "nearest",
 			Category: "client",
@@ -160,6 +241,106 @@ func main() {
 					Aliases:  []string{"lo"},
 					Required: true,
 				},
+				&cli.StringFlag{
+					Name: "state",
+				},
+			},
+		},
+		{
+			Name:     "transition",
+			Category: "client",
+			Action:   transitionSensor,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "endpoint",
+					Aliases: []string{"e"},
+					Value:   "http://localhost:8080/sensor",
+				},
+				&cli.StringFlag{
+					Name:     "name",
+					Aliases:  []string{"n"},
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "to",
+					Usage:    "planned, commissioned, active, maintenance or decommissioned",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name: "reason",
+				},
+				&cli.BoolFlag{
+					Name:  "history",
+					Usage: "print every transition of the sensor afterwards",
+				},
+			},
+		},
+		{
+			Name:     "heartbeat",
+			Category: "client",
+			Action:   heartbeat,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "endpoint",
+					Aliases: []string{"e"},
+					Value:   "http://localhost:8080/sensor",
+				},
+				&cli.StringFlag{
+					Name:     "name",
+					Aliases:  []string{"n"},
+					Required: true,
+				},
+			},
+		},
+		{
+			Name:     "stale",
+			Category: "client",
+			Action:   listStaleSensors,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "endpoint",
+					Aliases: []string{"e"},
+					Value:   "http://localhost:8080/sensors/stale",
+				},
+				outputFlag,
+			},
+		},
+		{
+			Name:     "tree",
+			Category: "client",
+			Action:   printTree,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "endpoint",
+					Aliases: []string{"e"},
+					Value:   "http://localhost:8080",
+				},
+				&cli.StringFlag{
+					Name:    "root",
+					Aliases: []string{"r"},
+				},
+			},
+		},
+		topologyCommand,
+		alarmsCommand,
+		qualityCommand,
+		gapsCommand,
+		calibrationsCommand,
+		comtradeCommand,
+		keysCommand,
+		namespacesCommand,
+		componentCommand("ingress", "ingresses"),
+		componentCommand("distiller", "distillers"),
+		{
+			Name:     "units",
+			Category: "client",
+			Action:   listUnits,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "endpoint",
+					Aliases: []string{"e"},
+					Value:   "http://localhost:8080/units",
+				},
 			},
 		},
 		{
@@ -178,25 +359,21 @@ func main() {
 	app.Run(os.Args)
 }
 
-var srv *server.Server
-
-func serve(c *cli.Context) (err error) {
-	addr := c.String("address")
-	if srv, err = server.New(addr); err != nil {
-		return err
-	}
+func listSensors(c *cli.Context) (err error) {
+	url := withState(c.String("endpoint"), c.String("state"))
+	return printSensors(c, url)
+}
 
-	if err := srv.Serve(); err != nil {
+func addSensor(c *cli.Context) (err error) {
+	var sensor *server.Sensor
+	if sensor, err = sensorFromFlags(c); err != nil {
+		fmt.Println(err)
 		return err
 	}
 
-	return nil
-}
-
-func listSensors(c *cli.Context) (err error) {
-	url := c.String("endpoint")
 	var responseString string
-	if responseString, err = getRequest(url); err != nil {
+	url := c.String("endpoint")
+	if responseString, err = postRequest(url, sensor); err != nil {
 		fmt.Println(err)
 		return err
 	}
@@ -205,18 +382,17 @@ func listSensors(c *cli.Context) (err error) {
 	return nil
 }
 
-func addSensor(c *cli.Context) (err error) {
-	name := c.String("name")
-	unit := c.String("unit")
-	ingress := c.String("ingress")
-	distiller := c.String("distiller")
-	lat := c.Float64("lat")
-	lon := c.Float64("lon")
-	sensor := server.CreateSensor(name, unit, ingress, distiller, lat, lon)
+func updateSensor(c *cli.Context) (err error) {
+	var sensor *server.Sensor
+	if sensor, err = sensorFromFlags(c); err != nil {
+		fmt.Println(err)
+		return err
+	}
 
 	var responseString string
-	url := c.String("endpoint")
-	if responseString, err = postRequest(url, sensor); err != nil {
+	endpoint := c.String("endpoint")
+	url := fmt.Sprintf("%s/%s", endpoint, sensor.Name)
+	if responseString, err = putRequest(url, sensor); err != nil {
 		fmt.Println(err)
 		return err
 	}
@@ -225,7 +401,9 @@ func addSensor(c *cli.Context) (err error) {
 	return nil
 }
 
-func updateSensor(c *cli.Context) (err error) {
+// Build and validate a sensor from the add and update flags. The
+// location is optional for sensors that inherit one from their asset.
+func sensorFromFlags(c *cli.Context) (_ *server.Sensor, err error) {
 	name := c.String("name")
 	unit := c.String("unit")
 	ingress := c.String("ingress")
@@ -233,17 +411,25 @@ func updateSensor(c *cli.Context) (err error) {
 	lat := c.Float64("lat")
 	lon := c.Float64("lon")
 	sensor := server.CreateSensor(name, unit, ingress, distiller, lat, lon)
-
-	var responseString string
-	endpoint := c.String("endpoint")
-	url := fmt.Sprintf("%s/%s", endpoint, name)
-	if responseString, err = putRequest(url, sensor); err != nil {
-		fmt.Println(err)
-		return err
+	sensor.Asset = c.String("asset")
+	sensor.State = c.String("state")
+	sensor.StaleAfter = server.Duration(c.Duration("stale-after"))
+	sensor.SampleRate = c.Float64("sample-rate")
+
+	switch {
+	case !c.IsSet("lat") && !c.IsSet("lon"):
+		if sensor.Asset == "" {
+			return nil, errors.New("set --lat and --lon, or --asset to inherit a location")
+		}
+		sensor.Location = nil
+	case !c.IsSet("lat") || !c.IsSet("lon"):
+		return nil, errors.New("--lat and --lon must be set together")
 	}
-	fmt.Println(responseString)
 
-	return nil
+	if err = server.ValidateSensor(sensor); err != nil {
+		return nil, err
+	}
+	return sensor, nil
 }
 
 func getSensor(c *cli.Context) (err error) {
@@ -261,25 +447,27 @@ func getSensor(c *cli.Context) (err error) {
 	return nil
 }
 
-func nearestSensor(c *cli.Context) (err error) {
-	var responseString string
-	lat := c.Float64("lat")
-	lon := c.Float64("lon")
+func deleteSensor(c *cli.Context) (err error) {
+	name := c.String("name")
 	endpoint := c.String("endpoint")
-	url := fmt.Sprintf("%s/%f/%f", endpoint, lat, lon)
+	url := fmt.Sprintf("%s/%s", endpoint, name)
 
-	if responseString, err = getRequest(url); err != nil {
+	if err = requestJSON(http.MethodDelete, url, nil, nil); err != nil {
 		fmt.Println(err)
 		return err
 	}
-	fmt.Println(responseString)
+	fmt.Printf("deleted %s\n", name)
 
 	return nil
 }
 
-func statusCheck(c *cli.Context) (err error) {
-	url := c.String("endpoint")
+func nearestSensor(c *cli.Context) (err error) {
 	var responseString string
+	lat := c.Float64("lat")
+	lon := c.Float64("lon")
+	endpoint := c.String("endpoint")
+	url := withState(fmt.Sprintf("%s/%f/%f", endpoint, lat, lon), c.String("state"))
+
 	if responseString, err = getRequest(url); err != nil {
 		fmt.Println(err)
 		return err
@@ -289,63 +477,51 @@ func statusCheck(c *cli.Context) (err error) {
 	return nil
 }
 
-func getRequest(url string) (_ string, err error) {
-	var response *http.Response
-	if response, err = http.Get(url); err != nil {
-		return "", err
-	}
-
-	var body []byte
-	if body, err = io.ReadAll(response.Body); err != nil {
-		return "", err
+// Add a state filter to a sensor listing URL if one is set.
+func withState(endpoint, state string) string {
+	if state == "" {
+		return endpoint
 	}
-
-	return string(body), nil
+	return fmt.Sprintf("%s?state=%s", endpoint, url.QueryEscape(state))
 }
 
-func postRequest(url string, toMarshal interface{}) (_ string, err error) {
-	var jsonBytes []byte
-	if jsonBytes, err = json.Marshal(toMarshal); err != nil {
-		return "", err
+// Move a sensor to another lifecycle state.
+func transitionSensor(c *cli.Context) (err error) {
+	endpoint := fmt.Sprintf("%s/%s", c.String("endpoint"), c.String("name"))
+	request := &server.TransitionRequest{
+		To:     c.String("to"),
+		Reason: c.String("reason"),
 	}
-
-	var response *http.Response
-	requestBody := bytes.NewBuffer(jsonBytes)
-	if response, err = http.Post(url, "application/json", requestBody); err != nil {
-		return "", err
+	if err = printJSON(http.MethodPost, endpoint+"/transition", request); err != nil {
+		return err
 	}
 
-	var responseBody []byte
-	if responseBody, err = io.ReadAll(response.Body); err != nil {
-		return "", err
+	if c.Bool("history") {
+		return printJSON(http.MethodGet, endpoint+"/transitions", nil)
 	}
-
-	return string(responseBody), nil
+	return nil
 }
 
-func putRequest(url string, toMarshal interface{}) (_ string, err error) {
-	var jsonBytes []byte
-	if jsonBytes, err = json.Marshal(toMarshal); err != nil {
-		return "", err
-	}
-
-	var request *http.Request
-	byteBuffer := bytes.NewBuffer(jsonBytes)
-	if request, err = http.NewRequest("PUT", url, byteBuffer); err != nil {
-		return "", err
+func listUnits(c *cli.Context) (err error) {
+	url := c.String("endpoint")
+	var responseString string
+	if responseString, err = getRequest(url); err != nil {
+		fmt.Println(err)
+		return err
 	}
+	fmt.Println(responseString)
 
-	client := &http.Client{}
-	var response *http.Response
-	request.Header.Set("Content-Type", "application/json")
-	if response, err = client.Do(request); err != nil {
-		return "", err
-	}
+	return nil
+}
 
-	var responseBody []byte
-	if responseBody, err = io.ReadAll(response.Body); err != nil {
-		return "", err
+func statusCheck(c *cli.Context) (err error) {
+	url := c.String("endpoint")
+	var responseString string
+	if responseString, err = getRequest(url); err != nil {
+		fmt.Println(err)
+		return err
 	}
+	fmt.Println(responseString)
 
-	return string(responseBody), nil
+	return nil
 }
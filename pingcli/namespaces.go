@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"pingthings/server"
+
+	"github.com/urfave/cli/v2"
+)
+
+var namespacesCommand = &cli.Command{
+	Name:     "namespaces",
+	Category: "client",
+	Usage:    "manage namespaces, which other commands work in with --namespace",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "endpoint",
+			Aliases: []string{"e"},
+			Value:   "http://localhost:8080",
+		},
+	},
+	Subcommands: []*cli.Command{
+		{
+			Name:   "create",
+			Action: createNamespace,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "name",
+					Aliases:  []string{"n"},
+					Required: true,
+				},
+			},
+		},
+		{
+			Name:   "list",
+			Usage:  "list namespaces other than the default one",
+			Action: listNamespaces,
+		},
+	},
+}
+
+func createNamespace(c *cli.Context) (err error) {
+	ns := &server.Namespace{Name: c.String("name")}
+	if err = requestJSON(http.MethodPost, c.String("endpoint")+"/namespaces", ns, ns); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Printf("created namespace %s\n", ns.Name)
+
+	return nil
+}
+
+func listNamespaces(c *cli.Context) (err error) {
+	return printJSON(http.MethodGet, c.String("endpoint")+"/namespaces", nil)
+}
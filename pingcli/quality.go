@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/urfave/cli/v2"
+)
+
+var qualityCommand = &cli.Command{
+	Name:     "quality",
+	Category: "client",
+	Usage:    "summarize the quality flags of a sensor's readings",
+	Action:   sensorQuality,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "endpoint",
+			Aliases: []string{"e"},
+			Value:   "http://localhost:8080/sensor",
+		},
+		&cli.StringFlag{
+			Name:     "name",
+			Aliases:  []string{"n"},
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "start",
+			Usage: "RFC 3339 time",
+		},
+		&cli.StringFlag{
+			Name:  "end",
+			Usage: "RFC 3339 time",
+		},
+	},
+}
+
+func sensorQuality(c *cli.Context) (err error) {
+	query := url.Values{}
+	for _, name := range []string{"start", "end"} {
+		if value := c.String(name); value != "" {
+			query.Set(name, value)
+		}
+	}
+
+	endpoint := fmt.Sprintf("%s/%s/quality", c.String("endpoint"), url.PathEscape(c.String("name")))
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	return printJSON(http.MethodGet, endpoint, nil)
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"pingthings/server"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults shown in the serve flags' help. Flags only override the
+// config file and environment when they're given.
+var serverDefaults = server.DefaultConfig()
+
+// Flags of the server settings, shared by serve and config print.
+var serveFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "server-config",
+		Usage:   "YAML or TOML file with server settings, overridden by PINGTHINGS_* environment variables and flags",
+		EnvVars: []string{server.EnvPrefix + "CONFIG"},
+	},
+	&cli.StringFlag{
+		Name:    "address",
+		Aliases: []string{"a"},
+		Value:   serverDefaults.Address,
+	},
+	&cli.StringFlag{
+		Name:  "mode",
+		Usage: "debug, release or test",
+		Value: serverDefaults.Mode,
+	},
+	&cli.StringFlag{
+		Name:  "log-level",
+		Usage: "least important logs to write: debug, info, warn or error",
+		Value: serverDefaults.Log.Level,
+	},
+	&cli.StringFlag{
+		Name:  "log-format",
+		Usage: "json or text",
+		Value: serverDefaults.Log.Format,
+	},
+	&cli.StringFlag{
+		Name:  "db",
+		Value: serverDefaults.Database,
+	},
+	&cli.StringFlag{
+		Name: "seed",
+	},
+	&cli.BoolFlag{
+		Name: "lax-references",
+	},
+	&cli.StringSliceFlag{
+		Name:  "allowed-ingress",
+		Usage: "ingress to register when starting if it doesn't exist; sensors may only reference registered ones (repeatable)",
+	},
+	&cli.DurationFlag{
+		Name:  "stale-after",
+		Usage: "default time without a heartbeat or reading before a sensor is stale",
+		Value: time.Duration(serverDefaults.StaleAfter),
+	},
+	&cli.DurationFlag{
+		Name:  "read-timeout",
+		Usage: "longest time to read a request, 0 for none",
+		Value: time.Duration(serverDefaults.Timeouts.Read),
+	},
+	&cli.DurationFlag{
+		Name:  "read-header-timeout",
+		Usage: "longest time to read a request's headers, 0 for none",
+		Value: time.Duration(serverDefaults.Timeouts.ReadHeader),
+	},
+	&cli.DurationFlag{
+		Name:  "write-timeout",
+		Usage: "longest time to write a response, other than event streams, 0 for none",
+		Value: time.Duration(serverDefaults.Timeouts.Write),
+	},
+	&cli.DurationFlag{
+		Name:  "idle-timeout",
+		Usage: "longest time to keep an idle connection open, 0 for none",
+		Value: time.Duration(serverDefaults.Timeouts.Idle),
+	},
+	&cli.Int64Flag{
+		Name:  "max-upload",
+		Usage: "largest COMTRADE record upload accepted, in bytes",
+		Value: serverDefaults.MaxUpload,
+	},
+	&cli.StringFlag{
+		Name:  "pmu-tcp",
+		Usage: "address to accept C37.118 streams on over TCP, e.g. :4712; unauthenticated, keep it on a trusted network",
+	},
+	&cli.StringFlag{
+		Name:  "pmu-udp",
+		Usage: "address to receive C37.118 frames on over UDP, e.g. :4713; unauthenticated, keep it on a trusted network",
+	},
+	&cli.BoolFlag{
+		Name:  "pmu-auto-register",
+		Usage: "register sensors for PMU channels from configuration frames",
+	},
+	&cli.BoolFlag{
+		Name:  "api-keys",
+		Usage: "require API keys for changes, creating an admin key if there's none",
+	},
+	&cli.StringFlag{
+		Name:  "api-key-file",
+		Usage: "with --api-keys, write the admin key created to this new file instead of standard error",
+	},
+	&cli.BoolFlag{
+		Name:  "private-reads",
+		Usage: "with --api-keys or --jwks, require credentials for reads too",
+	},
+	&cli.StringFlag{
+		Name:  "read-limit",
+		Usage: "limit each client's reads, as count/unit with unit s, m or h and an optional :burst, e.g. 20/s",
+	},
+	&cli.StringFlag{
+		Name:  "write-limit",
+		Usage: "limit each client's changes, like --read-limit",
+	},
+	&cli.StringFlag{
+		Name:  "expensive-limit",
+		Usage: "limit each client's nearest sensor, topology and overdue calibration queries, like --read-limit",
+	},
+	&cli.StringSliceFlag{
+		Name:  "trusted-proxy",
+		Usage: "address or CIDR range of a proxy whose X-Forwarded-For header gives the client's address (repeatable)",
+	},
+	&cli.StringFlag{
+		Name:  "tls-cert",
+		Usage: "serve HTTPS with this PEM certificate chain",
+	},
+	&cli.StringFlag{
+		Name:  "tls-key",
+		Usage: "PEM key of --tls-cert",
+	},
+	&cli.StringFlag{
+		Name:  "client-ca",
+		Usage: "with --tls-cert, require client certificates signed by a CA in this PEM bundle",
+	},
+	&cli.StringFlag{
+		Name:  "jwks",
+		Usage: "accept bearer tokens signed by the keys in this JWKS file or URL",
+	},
+	&cli.StringFlag{
+		Name:  "jwt-issuer",
+		Usage: "with --jwks, the issuer tokens must have",
+	},
+	&cli.StringFlag{
+		Name:  "jwt-audience",
+		Usage: "with --jwks, the audience tokens must have",
+	},
+	&cli.StringFlag{
+		Name:  "jwt-roles-claim",
+		Usage: "with --jwks, the claim holding a token's roles, dotted for nested claims",
+		Value: serverDefaults.JWT.RolesClaim,
+	},
+	&cli.StringSliceFlag{
+		Name:  "jwt-role",
+		Usage: "with --jwks, map a roles claim value to viewer, operator or admin as value=role, instead of taking role names as they are",
+	},
+	&cli.StringFlag{
+		Name:  "jwt-ingress-claim",
+		Usage: "with --jwks, a claim listing the only ingresses a token may access",
+	},
+	&cli.StringFlag{
+		Name:  "trace-exporter",
+		Usage: "export traces of requests and store queries: otlp, or stdout for local debugging",
+	},
+	&cli.StringFlag{
+		Name:  "trace-endpoint",
+		Usage: "with --trace-exporter otlp, the OTLP/HTTP collector address, e.g. localhost:4318",
+	},
+	&cli.BoolFlag{
+		Name:  "trace-insecure",
+		Usage: "with --trace-exporter otlp, export over HTTP rather than HTTPS",
+	},
+	&cli.StringFlag{
+		Name:  "trace-file",
+		Usage: "with --trace-exporter stdout, append spans to this file instead",
+	},
+	&cli.StringFlag{
+		Name:  "trace-service",
+		Usage: "service name spans are exported under",
+		Value: serverDefaults.Tracing.ServiceName,
+	},
+	&cli.Float64Flag{
+		Name:  "trace-sample-ratio",
+		Usage: "share of new traces to keep, from 0 to 1",
+		Value: serverDefaults.Tracing.SampleRatio,
+	},
+}
+
+var serveCommand = &cli.Command{
+	Name:     "serve",
+	Category: "server",
+	Action:   serve,
+	Flags:    serveFlags,
+}
+
+var configCommand = &cli.Command{
+	Name:     "config",
+	Category: "server",
+	Usage:    "inspect server settings",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "print",
+			Usage:  "print the settings serve would run with, from the config file, environment and flags",
+			Action: printConfig,
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:  "format",
+					Usage: "yaml or toml",
+					Value: "yaml",
+				},
+			}, serveFlags...),
+		},
+	},
+}
+
+var srv *server.Server
+
+func serve(c *cli.Context) (err error) {
+	var cfg server.Config
+	if cfg, err = serverConfig(c); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	if srv, err = server.NewFromConfig(cfg); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if err := srv.Serve(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func printConfig(c *cli.Context) (err error) {
+	var cfg server.Config
+	if cfg, err = serverConfig(c); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	switch c.String("format") {
+	case "yaml":
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		err = encoder.Encode(cfg)
+	case "toml":
+		err = toml.NewEncoder(os.Stdout).Encode(cfg)
+	default:
+		err = fmt.Errorf("unknown format %q, use yaml or toml", c.String("format"))
+	}
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	// Shown after the settings so the ones at fault can be found.
+	if err = cfg.Validate(); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	return nil
+}
+
+// The server settings: defaults, overridden by the config file, then
+// PINGTHINGS_* environment variables, then the flags given.
+func serverConfig(c *cli.Context) (cfg server.Config, err error) {
+	if cfg, err = server.LoadConfig(c.String("server-config"), os.LookupEnv); err != nil {
+		return cfg, err
+	}
+
+	strs := map[string]*string{
+		"address":           &cfg.Address,
+		"mode":              &cfg.Mode,
+		"db":                &cfg.Database,
+		"log-level":         &cfg.Log.Level,
+		"log-format":        &cfg.Log.Format,
+		"seed":              &cfg.Seed,
+		"api-key-file":      &cfg.APIKeyFile,
+		"pmu-tcp":           &cfg.PMU.TCP,
+		"pmu-udp":           &cfg.PMU.UDP,
+		"read-limit":        &cfg.RateLimits.Read,
+		"write-limit":       &cfg.RateLimits.Write,
+		"expensive-limit":   &cfg.RateLimits.Expensive,
+		"tls-cert":          &cfg.TLS.Cert,
+		"tls-key":           &cfg.TLS.Key,
+		"client-ca":         &cfg.TLS.ClientCA,
+		"jwks":              &cfg.JWT.JWKS,
+		"jwt-issuer":        &cfg.JWT.Issuer,
+		"jwt-audience":      &cfg.JWT.Audience,
+		"jwt-roles-claim":   &cfg.JWT.RolesClaim,
+		"jwt-ingress-claim": &cfg.JWT.IngressClaim,
+		"trace-exporter":    &cfg.Tracing.Exporter,
+		"trace-endpoint":    &cfg.Tracing.Endpoint,
+		"trace-file":        &cfg.Tracing.File,
+		"trace-service":     &cfg.Tracing.ServiceName,
+	}
+	for flag, setting := range strs {
+		if c.IsSet(flag) {
+			*setting = c.String(flag)
+		}
+	}
+	bools := map[string]*bool{
+		"lax-references":    &cfg.LaxReferences,
+		"pmu-auto-register": &cfg.PMU.AutoRegister,
+		"api-keys":          &cfg.APIKeys,
+		"private-reads":     &cfg.PrivateReads,
+		"trace-insecure":    &cfg.Tracing.Insecure,
+	}
+	for flag, setting := range bools {
+		if c.IsSet(flag) {
+			*setting = c.Bool(flag)
+		}
+	}
+	durations := map[string]*server.Duration{
+		"stale-after":         &cfg.StaleAfter,
+		"read-timeout":        &cfg.Timeouts.Read,
+		"read-header-timeout": &cfg.Timeouts.ReadHeader,
+		"write-timeout":       &cfg.Timeouts.Write,
+		"idle-timeout":        &cfg.Timeouts.Idle,
+	}
+	for flag, setting := range durations {
+		if c.IsSet(flag) {
+			*setting = server.Duration(c.Duration(flag))
+		}
+	}
+	if c.IsSet("max-upload") {
+		cfg.MaxUpload = c.Int64("max-upload")
+	}
+	if c.IsSet("trace-sample-ratio") {
+		cfg.Tracing.SampleRatio = c.Float64("trace-sample-ratio")
+	}
+
+	if c.IsSet("allowed-ingress") {
+		cfg.Ingresses = c.StringSlice("allowed-ingress")
+	}
+	if c.IsSet("trusted-proxy") {
+		cfg.TrustedProxies = c.StringSlice("trusted-proxy")
+	}
+	if c.IsSet("jwt-role") {
+		cfg.JWT.Roles = make(map[string]string)
+		for _, mapping := range c.StringSlice("jwt-role") {
+			value, role, ok := strings.Cut(mapping, "=")
+			if !ok {
+				return cfg, fmt.Errorf("--jwt-role %q isn't value=role", mapping)
+			}
+			cfg.JWT.Roles[value] = role
+		}
+	}
+	return cfg, nil
+}
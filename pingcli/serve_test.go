@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"pingthings/server"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestServerConfig(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "pingthings.yaml")
+	require.NoError(t, os.WriteFile(config, []byte("address: :9090\ndatabase: from-file.db\nseed: seed.json\n"), 0600))
+
+	load := func(args ...string) (cfg server.Config) {
+		app := &cli.App{Flags: serveFlags, Action: func(c *cli.Context) (err error) {
+			cfg, err = serverConfig(c)
+			return err
+		}}
+		require.NoError(t, app.Run(append([]string{"pingcli", "--server-config", config}, args...)))
+		return cfg
+	}
+
+	// Flag defaults don't override the file.
+	cfg := load()
+	require.Equal(t, ":9090", cfg.Address)
+	require.Equal(t, "from-file.db", cfg.Database)
+	require.Equal(t, server.Duration(time.Minute), cfg.Timeouts.Write)
+	require.Equal(t, 1.0, cfg.Tracing.SampleRatio)
+
+	t.Setenv("PINGTHINGS_DATABASE", "from-env.db")
+	t.Setenv("PINGTHINGS_SEED", "")
+	cfg = load("--write-timeout", "0", "--jwt-role", "ops=operator")
+	require.Equal(t, ":9090", cfg.Address)
+	require.Equal(t, "from-env.db", cfg.Database)
+	require.Equal(t, "", cfg.Seed)
+	require.Equal(t, server.Duration(0), cfg.Timeouts.Write)
+	require.Equal(t, map[string]string{"ops": "operator"}, cfg.JWT.Roles)
+
+	cfg = load("--db", "from-flag.db", "-a", ":7070", "--allowed-ingress", "brazil", "--allowed-ingress", "chile")
+	require.Equal(t, ":7070", cfg.Address)
+	require.Equal(t, "from-flag.db", cfg.Database)
+	require.Equal(t, []string{"brazil", "chile"}, cfg.Ingresses)
+
+	cfg = load("--trusted-proxy", "10.0.0.0/8")
+	require.Equal(t, []string{"10.0.0.0/8"}, cfg.TrustedProxies)
+
+	require.Equal(t, int64(64<<20), cfg.MaxUpload)
+
+	cfg = load("--max-upload", "1024")
+	require.Equal(t, int64(1024), cfg.MaxUpload)
+
+	cfg = load("--trace-exporter", "stdout", "--trace-file", "spans.json", "--trace-sample-ratio", "0.1")
+	require.Equal(t, server.TracingConfig{Exporter: "stdout", File: "spans.json", ServiceName: "pingthings", SampleRatio: 0.1}, cfg.Tracing)
+}
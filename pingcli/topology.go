@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+var topologyCommand = &cli.Command{
+	Name:     "topology",
+	Category: "client",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "endpoint",
+			Aliases: []string{"e"},
+			Value:   "http://localhost:8080/topology",
+		},
+	},
+	Subcommands: []*cli.Command{
+		{
+			Name:   "get",
+			Action: getTopology,
+		},
+		{
+			Name:   "import",
+			Action: importTopology,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "file",
+					Aliases:  []string{"f"},
+					Required: true,
+				},
+			},
+		},
+		{
+			Name:   "path",
+			Action: topologyPath,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "from",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "to",
+					Required: true,
+				},
+			},
+		},
+		{
+			Name:   "neighbors",
+			Action: topologyNeighbors,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "name",
+					Aliases:  []string{"n"},
+					Required: true,
+				},
+				&cli.IntFlag{
+					Name:  "hops",
+					Value: 1,
+				},
+			},
+		},
+		{
+			Name:   "islands",
+			Action: topologyIslands,
+			Flags: []cli.Flag{
+				&cli.StringSliceFlag{
+					Name:  "without",
+					Usage: "branch to remove, repeatable",
+				},
+			},
+		},
+	},
+}
+
+func getTopology(c *cli.Context) (err error) {
+	return printJSON(http.MethodGet, c.String("endpoint"), nil)
+}
+
+// Upload a JSON or CSV topology file, replacing the current topology.
+func importTopology(c *cli.Context) (err error) {
+	path := c.String("file")
+	contentType := "application/json"
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		contentType = "text/csv"
+	}
+
+	var file *os.File
+	if file, err = os.Open(path); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer file.Close()
+
+	var responseString string
+	if responseString, err = uploadRequest(http.MethodPut, c.String("endpoint"), contentType, file); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Println(responseString)
+
+	return nil
+}
+
+func topologyPath(c *cli.Context) (err error) {
+	query := url.Values{"from": {c.String("from")}, "to": {c.String("to")}}
+	return printJSON(http.MethodGet, c.String("endpoint")+"/path?"+query.Encode(), nil)
+}
+
+func topologyNeighbors(c *cli.Context) (err error) {
+	url := fmt.Sprintf("%s/neighbors/%s?hops=%d", c.String("endpoint"), url.PathEscape(c.String("name")), c.Int("hops"))
+	return printJSON(http.MethodGet, url, nil)
+}
+
+func topologyIslands(c *cli.Context) (err error) {
+	query := url.Values{"without": c.StringSlice("without")}
+	return printJSON(http.MethodGet, c.String("endpoint")+"/islands?"+query.Encode(), nil)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Requests carry W3C trace context, so the server's spans of a
+// command's requests share one trace, or join the trace the command
+// was run as part of. The client's own spans only give requests their
+// IDs and aren't exported.
+var (
+	tracer     = sdktrace.NewTracerProvider().Tracer("pingthings/pingcli")
+	propagator = propagation.TraceContext{}
+)
+
+// Start the span of a command, continuing traceparent if it's set.
+func startTrace(traceparent string) (context.Context, error) {
+	ctx := context.Background()
+	if traceparent != "" {
+		ctx = propagator.Extract(ctx, propagation.MapCarrier{"traceparent": traceparent})
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			return nil, errors.New("--traceparent isn't a W3C traceparent header")
+		}
+	}
+	ctx, _ = tracer.Start(ctx, "pingcli")
+	return ctx, nil
+}
+
+// End the span of the command once it's run.
+func finishTrace(*cli.Context) error {
+	trace.SpanFromContext(api.ctx).End()
+	return nil
+}
+
+// Start the span of a request, which it's sent as a child of.
+func (cl *client) startSpan(method string) (context.Context, trace.Span) {
+	ctx := cl.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return tracer.Start(ctx, method, trace.WithSpanKind(trace.SpanKindClient))
+}
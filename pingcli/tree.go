@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"pingthings/server"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Print the asset hierarchy, or the subtree below --root,
+// with the sensors attached to each asset.
+func printTree(c *cli.Context) (err error) {
+	endpoint := c.String("endpoint")
+
+	var nodes []*server.AssetNode
+	if root := c.String("root"); root != "" {
+		node := &server.AssetNode{}
+		if err = requestJSON(http.MethodGet, fmt.Sprintf("%s/assets/%s/tree", endpoint, url.PathEscape(root)), nil, node); err != nil {
+			fmt.Println(err)
+			return err
+		}
+		nodes = append(nodes, node)
+	} else if err = requestJSON(http.MethodGet, endpoint+"/tree", nil, &nodes); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if len(nodes) == 0 {
+		fmt.Println("no assets")
+		return nil
+	}
+	for _, node := range nodes {
+		writeTree(os.Stdout, node, "", "")
+	}
+
+	return nil
+}
+
+// Write a node and its descendants. prefix starts the node's own
+// line, indent the lines of everything below it.
+func writeTree(w io.Writer, node *server.AssetNode, prefix, indent string) {
+	fmt.Fprintf(w, "%s%s (%s %s)\n", prefix, node.Name, node.Kind, node.ID)
+
+	last := len(node.Children) + len(node.Sensors) - 1
+	for i, child := range node.Children {
+		branch, next := "├── ", "│   "
+		if i == last {
+			branch, next = "└── ", "    "
+		}
+		writeTree(w, child, indent+branch, indent+next)
+	}
+	for i, sensor := range node.Sensors {
+		branch := "├── "
+		if len(node.Children)+i == last {
+			branch = "└── "
+		}
+		fmt.Fprintf(w, "%s%s%s\n", indent, branch, sensor)
+	}
+}
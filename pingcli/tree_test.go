@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"pingthings/server"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteTree(t *testing.T) {
+	root := &server.AssetNode{
+		Asset: &server.Asset{ID: "SUB1", Name: "Anaheim", Kind: "substation"},
+		Children: []*server.AssetNode{
+			{
+				Asset:   &server.Asset{ID: "BAY1", Name: "BAY1", Kind: "bay"},
+				Sensors: []string{"L1ANG", "L1MAG"},
+			},
+		},
+		Sensors: []string{"C1MAG"},
+	}
+
+	var out bytes.Buffer
+	writeTree(&out, root, "", "")
+	require.Equal(t, `Anaheim (substation SUB1)
+├── BAY1 (bay BAY1)
+│   ├── L1ANG
+│   └── L1MAG
+└── C1MAG
+`, out.String())
+}
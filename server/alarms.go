@@ -0,0 +1,829 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Kinds of alarm rule.
+const (
+	RuleAbove = "above" // reading above the threshold.
+	RuleBelow = "below" // reading below the threshold.
+	RuleRate  = "rate"  // reading changing faster than the threshold per second.
+	RuleStale = "stale" // sensor stale or never seen, see liveness.
+)
+
+// States of an alarm. Alarms clear on their own once the rule
+// is no longer violated, whether or not they were acknowledged.
+const (
+	AlarmRaised       = "raised"
+	AlarmAcknowledged = "acknowledged"
+	AlarmCleared      = "cleared"
+)
+
+// How often stale rules are checked while serving.
+const staleCheckInterval = 15 * time.Second
+
+var (
+	errAlarmRuleNotFound = errors.New("Alarm rule not found in store")
+	errAlarmNotFound     = errors.New("Alarm not found in store")
+)
+
+// Sensor fields a rule selector can match on.
+var selectorKeys = []string{"unit", "ingress", "distiller", "asset"}
+
+// A condition checked against the sensor it names, or every
+// sensor whose fields match all of its selector.
+type AlarmRule struct {
+	ID       string            `json:"id"`
+	Sensor   string            `json:"sensor,omitempty"`
+	Selector map[string]string `json:"selector,omitempty"`
+	Kind     string            `json:"kind"`
+	// Threshold in Unit, per second for rate rules. Without a
+	// unit the threshold is in each sensor's own unit.
+	Threshold   float64 `json:"threshold"`
+	Unit        string  `json:"unit,omitempty"`
+	Description string  `json:"description,omitempty"`
+}
+
+// An occurrence of a rule being violated by a sensor. A rule
+// has at most one open (raised or acknowledged) alarm per sensor.
+type Alarm struct {
+	ID             int64      `json:"id"`
+	Rule           string     `json:"rule"`
+	Sensor         string     `json:"sensor"`
+	State          string     `json:"state"`
+	Message        string     `json:"message"`
+	Value          *float64   `json:"value,omitempty"`
+	RaisedAt       time.Time  `json:"raised_at"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy string     `json:"acknowledged_by,omitempty"`
+	ClearedAt      *time.Time `json:"cleared_at,omitempty"`
+}
+
+// Body of an acknowledge request.
+type Acknowledgement struct {
+	By string `json:"by,omitempty"`
+}
+
+// Whether a rule applies to a sensor.
+func (r *AlarmRule) matches(sensor *Sensor) bool {
+	if r.Sensor != "" {
+		return r.Sensor == sensor.Name
+	}
+	for key, value := range r.Selector {
+		if selectorValue(sensor, key) != value {
+			return false
+		}
+	}
+	return true
+}
+
+func selectorValue(sensor *Sensor, key string) string {
+	switch key {
+	case "unit":
+		return sensor.Tags.Unit
+	case "ingress":
+		return sensor.Tags.Ingress
+	case "distiller":
+		return sensor.Tags.Distiller
+	case "asset":
+		return sensor.Asset
+	}
+	return ""
+}
+
+// The rule's threshold in a sensor's unit.
+func (r *AlarmRule) thresholdIn(unit string) (float64, error) {
+	if r.Unit == "" {
+		return r.Threshold, nil
+	}
+	return ConvertUnit(r.Threshold, r.Unit, unit)
+}
+
+// Query every alarm rule, ordered by id.
+func (db *store) queryAlarmRules(ctx context.Context) (rules []*AlarmRule, err error) {
+	return db.queryAlarmRulesWhere(ctx, "")
+}
+
+// Query an alarm rule by id.
+func (db *store) queryAlarmRule(ctx context.Context, id string) (_ *AlarmRule, err error) {
+	var rules []*AlarmRule
+	if rules, err = db.queryAlarmRulesWhere(ctx, "id = ?", id); err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, errAlarmRuleNotFound
+	}
+	return rules[0], nil
+}
+
+func (db *store) queryAlarmRulesWhere(ctx context.Context, where string, args ...interface{}) (rules []*AlarmRule, err error) {
+	selectStatement := `SELECT id, sensor, selector, kind, threshold, unit, description FROM alarm_rules`
+	if where != "" {
+		selectStatement += ` WHERE ` + where
+	}
+	selectStatement += ` ORDER BY id`
+
+	var rows *timedRows
+	if rows, err = db.conn.QueryContext(ctx, selectStatement, args...); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var selector string
+		rule := &AlarmRule{}
+		if err = rows.Scan(&rule.ID, &rule.Sensor, &selector, &rule.Kind, &rule.Threshold, &rule.Unit, &rule.Description); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal([]byte(selector), &rule.Selector); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func alarmRuleValues(rule *AlarmRule) (_ []interface{}, err error) {
+	var selector []byte
+	if selector, err = json.Marshal(rule.Selector); err != nil {
+		return nil, err
+	}
+	return []interface{}{rule.Sensor, string(selector), rule.Kind, rule.Threshold, rule.Unit, rule.Description, rule.ID}, nil
+}
+
+// Insert a new alarm rule.
+func (db *store) insertAlarmRule(ctx context.Context, rule *AlarmRule) (err error) {
+	var values []interface{}
+	if values, err = alarmRuleValues(rule); err != nil {
+		return err
+	}
+
+	insertStatement := `
+		INSERT INTO alarm_rules (sensor, selector, kind, threshold, unit, description, id)
+		VALUES(?, ?, ?, ?, ?, ?, ?)`
+	if _, err = db.conn.ExecContext(ctx, insertStatement, values...); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Update an existing alarm rule. Open alarms of sensors it still
+// applies to stay open until readings show the new condition is no
+// longer violated; the others are cleared.
+func (db *store) updateAlarmRule(ctx context.Context, rule *AlarmRule, at time.Time) (cleared []*Alarm, err error) {
+	var values []interface{}
+	if values, err = alarmRuleValues(rule); err != nil {
+		return nil, err
+	}
+
+	var open []*Alarm
+	if open, err = db.queryAlarmsWhere(ctx, "rule = ? AND state != ?", rule.ID, AlarmCleared); err != nil {
+		return nil, err
+	}
+	for _, alarm := range open {
+		var sensors []*Sensor
+		if sensors, err = db.querySensorsWhere(ctx, "name = ?", alarm.Sensor); err != nil {
+			return nil, err
+		}
+		if len(sensors) == 0 || !rule.matches(sensors[0]) {
+			cleared = append(cleared, alarm)
+		}
+	}
+
+	var tx *timedTx
+	if tx, err = db.conn.BeginTx(ctx, nil); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var result sql.Result
+	updateStatement := `
+		UPDATE alarm_rules
+		SET sensor = ?, selector = ?, kind = ?, threshold = ?, unit = ?, description = ?
+		WHERE id = ?`
+	if result, err = tx.ExecContext(ctx, updateStatement, values...); err != nil {
+		return nil, err
+	}
+	if err = requireAffected(result, errAlarmRuleNotFound); err != nil {
+		return nil, err
+	}
+
+	// Sensors the rule no longer applies to won't have their alarms
+	// cleared by it, so they're cleared now.
+	for _, alarm := range cleared {
+		if err = clearAlarm(ctx, tx, alarm, at); err != nil {
+			return nil, err
+		}
+	}
+	return cleared, tx.Commit()
+}
+
+// Delete an alarm rule, clearing its open alarms.
+func (db *store) deleteAlarmRule(ctx context.Context, id string, at time.Time) (cleared []*Alarm, err error) {
+	if cleared, err = db.queryAlarmsWhere(ctx, "rule = ? AND state != ?", id, AlarmCleared); err != nil {
+		return nil, err
+	}
+
+	var tx *timedTx
+	if tx, err = db.conn.BeginTx(ctx, nil); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var result sql.Result
+	if result, err = tx.ExecContext(ctx, `DELETE FROM alarm_rules WHERE id = ?`, id); err != nil {
+		return nil, err
+	}
+	if err = requireAffected(result, errAlarmRuleNotFound); err != nil {
+		return nil, err
+	}
+
+	for _, alarm := range cleared {
+		if err = clearAlarm(ctx, tx, alarm, at); err != nil {
+			return nil, err
+		}
+	}
+	return cleared, tx.Commit()
+}
+
+// Columns selected by alarm queries, in the order queryAlarmsWhere scans them.
+const alarmColumns = `id, rule, sensor, state, message, value, raised_at, acknowledged_at, acknowledged_by, cleared_at`
+
+// Query the alarms matching a WHERE clause, newest first.
+func (db *store) queryAlarmsWhere(ctx context.Context, where string, args ...interface{}) (alarms []*Alarm, err error) {
+	selectStatement := `SELECT ` + alarmColumns + ` FROM alarms`
+	if where != "" {
+		selectStatement += ` WHERE ` + where
+	}
+	selectStatement += ` ORDER BY raised_at DESC, id DESC`
+
+	var rows *timedRows
+	if rows, err = db.conn.QueryContext(ctx, selectStatement, args...); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var value sql.NullFloat64
+		var raisedAt int64
+		var acknowledgedAt, clearedAt sql.NullInt64
+		alarm := &Alarm{}
+		if err = rows.Scan(
+			&alarm.ID,
+			&alarm.Rule,
+			&alarm.Sensor,
+			&alarm.State,
+			&alarm.Message,
+			&value,
+			&raisedAt,
+			&acknowledgedAt,
+			&alarm.AcknowledgedBy,
+			&clearedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		alarm.RaisedAt = time.Unix(0, raisedAt).UTC()
+		if value.Valid {
+			alarm.Value = &value.Float64
+		}
+		if acknowledgedAt.Valid {
+			at := time.Unix(0, acknowledgedAt.Int64).UTC()
+			alarm.AcknowledgedAt = &at
+		}
+		if clearedAt.Valid {
+			at := time.Unix(0, clearedAt.Int64).UTC()
+			alarm.ClearedAt = &at
+		}
+		alarms = append(alarms, alarm)
+	}
+
+	return alarms, rows.Err()
+}
+
+// Query an alarm by id.
+func (db *store) queryAlarm(ctx context.Context, id int64) (_ *Alarm, err error) {
+	var alarms []*Alarm
+	if alarms, err = db.queryAlarmsWhere(ctx, "id = ?", id); err != nil {
+		return nil, err
+	}
+	if len(alarms) == 0 {
+		return nil, errAlarmNotFound
+	}
+	return alarms[0], nil
+}
+
+// Query the open alarm of a rule on a sensor, nil if there is none.
+func (db *store) queryOpenAlarm(ctx context.Context, rule, sensor string) (_ *Alarm, err error) {
+	var alarms []*Alarm
+	if alarms, err = db.queryAlarmsWhere(ctx, "rule = ? AND sensor = ? AND state != ?", rule, sensor, AlarmCleared); err != nil {
+		return nil, err
+	}
+	if len(alarms) == 0 {
+		return nil, nil
+	}
+	return alarms[0], nil
+}
+
+// Insert a raised alarm, setting its id.
+func (db *store) insertAlarm(ctx context.Context, alarm *Alarm) (err error) {
+	var value sql.NullFloat64
+	if alarm.Value != nil {
+		value = sql.NullFloat64{Float64: *alarm.Value, Valid: true}
+	}
+
+	var result sql.Result
+	insertStatement := `
+		INSERT INTO alarms (rule, sensor, state, message, value, raised_at)
+		VALUES(?, ?, ?, ?, ?, ?)`
+	if result, err = db.conn.ExecContext(ctx, insertStatement, alarm.Rule, alarm.Sensor, alarm.State, alarm.Message, value, alarm.RaisedAt.UnixNano()); err != nil {
+		return err
+	}
+	alarm.ID, err = result.LastInsertId()
+	return err
+}
+
+// Acknowledge a raised alarm.
+func (db *store) acknowledgeAlarm(ctx context.Context, alarm *Alarm, by string, at time.Time) (err error) {
+	if alarm.State != AlarmRaised {
+		return &conflictError{fmt.Sprintf("Alarm is already %s", alarm.State)}
+	}
+
+	updateStatement := `
+		UPDATE alarms
+		SET state = ?, acknowledged_at = ?, acknowledged_by = ?
+		WHERE id = ?`
+	if _, err = db.conn.ExecContext(ctx, updateStatement, AlarmAcknowledged, at.UnixNano(), by, alarm.ID); err != nil {
+		return err
+	}
+
+	alarm.State, alarm.AcknowledgedAt, alarm.AcknowledgedBy = AlarmAcknowledged, &at, by
+	return nil
+}
+
+// Clear an open alarm.
+func clearAlarm(ctx context.Context, exec execer, alarm *Alarm, at time.Time) (err error) {
+	updateStatement := `UPDATE alarms SET state = ?, cleared_at = ? WHERE id = ?`
+	if _, err = exec.ExecContext(ctx, updateStatement, AlarmCleared, at.UnixNano(), alarm.ID); err != nil {
+		return err
+	}
+
+	alarm.State, alarm.ClearedAt = AlarmCleared, &at
+	return nil
+}
+
+// Check an alarm rule's fields and what it references.
+func (s *Server) validateAlarmRule(ctx context.Context, rule *AlarmRule, pathID string) error {
+	verr := &ValidationError{}
+	if rule == nil {
+		verr.add("rule", "required", "is required")
+		return verr
+	}
+
+	switch {
+	case rule.ID == "":
+		verr.add("id", "required", "is required")
+	case !sensorNamePattern.MatchString(rule.ID):
+		verr.add("id", "ruleid", "must start with a letter or digit and only contain letters, digits, '_', '-' or '.' (at most 64 characters)")
+	case pathID != "" && rule.ID != pathID:
+		verr.add("id", "path", fmt.Sprintf("must match the rule in the URL (%s)", pathID))
+	}
+
+	switch rule.Kind {
+	case RuleAbove, RuleBelow:
+	case RuleRate:
+		if rule.Threshold <= 0 {
+			verr.add("threshold", "gt", "must be greater than 0 for rate rules")
+		}
+	case RuleStale:
+		if rule.Threshold != 0 || rule.Unit != "" {
+			verr.add("threshold", "stale", "is not used by stale rules, set stale_after on the sensor or ingress")
+		}
+	default:
+		verr.add("kind", "oneof", "must be one of above, below, rate, stale")
+	}
+
+	unitKnown := true
+	if rule.Unit != "" {
+		var unit *Unit
+		if unit, unitKnown = LookupUnit(rule.Unit); unitKnown {
+			rule.Unit = unit.Symbol
+		} else {
+			verr.add("unit", "unit", "must be a unit from the catalog at /units")
+		}
+	}
+
+	switch {
+	case rule.Sensor != "" && len(rule.Selector) > 0:
+		verr.add("selector", "exclusive", "must not be set together with sensor")
+	case rule.Sensor != "":
+		sensor, err := s.db.querySensor(ctx, rule.Sensor)
+		if err != nil {
+			verr.add("sensor", "exists", fmt.Sprintf("references unknown sensor %q", rule.Sensor))
+		} else if _, err = rule.thresholdIn(sensor.Tags.Unit); err != nil && unitKnown {
+			verr.add("unit", "convertible", err.Error())
+		}
+	case len(rule.Selector) > 0:
+		for key, value := range rule.Selector {
+			field := "selector." + key
+			switch {
+			case !contains(selectorKeys, key):
+				verr.add(field, "key", fmt.Sprintf("must be one of %s", strings.Join(selectorKeys, ", ")))
+			case value == "":
+				verr.add(field, "required", "is required")
+			case key == "unit":
+				if unit, ok := LookupUnit(value); ok {
+					rule.Selector[key] = unit.Symbol
+				}
+			}
+		}
+	default:
+		verr.add("sensor", "required", "is required unless a selector is set")
+	}
+
+	return verr.orNil()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Check the rules matching a sensor against readings it just reported.
+// Readings are checked in time order, so a batch can raise and clear
+// the same alarm. Readings flagged invalid are ignored.
+func (s *Server) evaluateReadings(ctx context.Context, name string, readings []*Reading) (err error) {
+	s.alarmsMu.Lock()
+	defer s.alarmsMu.Unlock()
+
+	var sensor *Sensor
+	if sensor, err = s.db.querySensor(ctx, name); err != nil {
+		return err
+	}
+	var rules []*AlarmRule
+	if rules, err = s.db.queryAlarmRules(ctx); err != nil {
+		return err
+	}
+
+	// Rules are checked against calibrated copies of the readings.
+	var sorted []*Reading
+	for _, reading := range readings {
+		if reading.Quality&QualityInvalid == 0 {
+			copied := *reading
+			sorted = append(sorted, &copied)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+	if err = s.db.calibrate(ctx, name, sorted); err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		if !rule.matches(sensor) {
+			continue
+		}
+		if rule.Kind == RuleStale {
+			if err = s.checkStale(ctx, rule, sensor); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Selector rules skip sensors their unit doesn't apply to.
+		threshold, convertErr := rule.thresholdIn(sensor.Tags.Unit)
+		if convertErr != nil {
+			continue
+		}
+
+		var previous *Reading
+		if rule.Kind == RuleRate && len(sorted) > 0 {
+			if previous, err = s.db.queryReadingBefore(ctx, name, sorted[0].Time); err != nil {
+				return err
+			}
+			if previous != nil {
+				if err = s.db.calibrate(ctx, name, []*Reading{previous}); err != nil {
+					return err
+				}
+			}
+		}
+		for _, reading := range sorted {
+			if err = s.checkReading(ctx, rule, sensor, threshold, reading, previous); err != nil {
+				return err
+			}
+			previous = reading
+		}
+	}
+	return nil
+}
+
+// Raise or clear a rule's alarm on a sensor for one reading.
+func (s *Server) checkReading(ctx context.Context, rule *AlarmRule, sensor *Sensor, threshold float64, reading, previous *Reading) error {
+	unit := sensor.Tags.Unit
+	value := reading.Value
+	var message string
+
+	switch rule.Kind {
+	case RuleAbove:
+		if value > threshold {
+			message = fmt.Sprintf("%s is %g %s, above %g %s", sensor.Name, value, unit, threshold, unit)
+		}
+	case RuleBelow:
+		if value < threshold {
+			message = fmt.Sprintf("%s is %g %s, below %g %s", sensor.Name, value, unit, threshold, unit)
+		}
+	case RuleRate:
+		if previous == nil || !reading.Time.After(previous.Time) {
+			return nil
+		}
+		value = math.Abs(reading.Value-previous.Value) / reading.Time.Sub(previous.Time).Seconds()
+		if value > threshold {
+			message = fmt.Sprintf("%s is changing %g %s/s, faster than %g %s/s", sensor.Name, value, unit, threshold, unit)
+		}
+	}
+
+	return s.updateAlarm(ctx, rule, sensor.Name, reading.Time, message, &value)
+}
+
+// Raise a stale rule's alarm on a sensor that is active but
+// not live, or clear it once the sensor reports again.
+func (s *Server) checkStale(ctx context.Context, rule *AlarmRule, sensor *Sensor) error {
+	var message string
+	if sensor.State == StateActive && sensor.Status != StatusLive {
+		message = fmt.Sprintf("%s has never reported", sensor.Name)
+		if sensor.LastSeen != nil {
+			message = fmt.Sprintf("%s has not reported since %s", sensor.Name, sensor.LastSeen.Format(time.RFC3339))
+		}
+	}
+	return s.updateAlarm(ctx, rule, sensor.Name, time.Now().UTC(), message, nil)
+}
+
+// Raise an alarm if there is a message and no open alarm for the
+// rule and sensor, or clear the open one if there is no message.
+func (s *Server) updateAlarm(ctx context.Context, rule *AlarmRule, sensor string, at time.Time, message string, value *float64) (err error) {
+	var open *Alarm
+	if open, err = s.db.queryOpenAlarm(ctx, rule.ID, sensor); err != nil {
+		return err
+	}
+
+	switch {
+	case message != "" && open == nil:
+		alarm := &Alarm{
+			Rule:     rule.ID,
+			Sensor:   sensor,
+			State:    AlarmRaised,
+			Message:  message,
+			Value:    value,
+			RaisedAt: at.UTC(),
+		}
+		if err = s.db.insertAlarm(ctx, alarm); err != nil {
+			return err
+		}
+		s.events.publish("alarm.raised", alarm)
+	case message == "" && open != nil:
+		if err = clearAlarm(ctx, s.db.conn, open, at.UTC()); err != nil {
+			return err
+		}
+		s.events.publish("alarm.cleared", open)
+	}
+	return nil
+}
+
+// Check every stale rule against the sensors it matches.
+func (s *Server) evaluateStaleRules(ctx context.Context) (err error) {
+	s.alarmsMu.Lock()
+	defer s.alarmsMu.Unlock()
+
+	var rules []*AlarmRule
+	if rules, err = s.db.queryAlarmRulesWhere(ctx, "kind = ?", RuleStale); err != nil || len(rules) == 0 {
+		return err
+	}
+	var sensors []*Sensor
+	if sensors, err = s.db.queryAllSensors(ctx); err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		for _, sensor := range sensors {
+			if !rule.matches(sensor) {
+				continue
+			}
+			if err = s.checkStale(ctx, rule, sensor); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Periodically check stale rules in every namespace until the server
+// shuts down.
+func (s *Server) watchStale(interval time.Duration) {
+	ctx := context.Background()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, ns := range s.allNamespaces() {
+				if err := ns.evaluateStaleRules(ctx); err != nil {
+					ns.logger.Error("evaluating stale rules", "error", err)
+				}
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// List alarms in the comma separated states of the state query
+// parameter, or "all". Open alarms are listed by default.
+func (s *Server) listAlarms(c *gin.Context) {
+	where := []string{}
+	args := []interface{}{}
+
+	switch states := c.Query("state"); states {
+	case "all":
+	case "":
+		where = append(where, "state != ?")
+		args = append(args, AlarmCleared)
+	default:
+		list := strings.Split(states, ",")
+		for _, state := range list {
+			if !contains([]string{AlarmRaised, AlarmAcknowledged, AlarmCleared}, state) {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown alarm state %q", state)})
+				return
+			}
+			args = append(args, state)
+		}
+		where = append(where, "state IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(list)), ", ")+")")
+	}
+	if sensor := c.Query("sensor"); sensor != "" {
+		where = append(where, "sensor = ?")
+		args = append(args, sensor)
+	}
+
+	alarms, err := s.db.queryAlarmsWhere(c.Request.Context(), strings.Join(where, " AND "), args...)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if alarms == nil {
+		alarms = []*Alarm{}
+	}
+	c.IndentedJSON(http.StatusOK, alarms)
+}
+
+// Query an alarm by id.
+func (s *Server) getAlarm(c *gin.Context) {
+	alarm, err := s.alarmFromPath(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, alarm)
+}
+
+// Acknowledge a raised alarm, optionally saying who by.
+func (s *Server) acknowledgeAlarm(c *gin.Context) {
+	ack := &Acknowledgement{}
+	if c.Request.ContentLength > 0 {
+		if err := c.BindJSON(ack); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	s.alarmsMu.Lock()
+	defer s.alarmsMu.Unlock()
+
+	alarm, err := s.alarmFromPath(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = s.db.acknowledgeAlarm(c.Request.Context(), alarm, ack.By, time.Now().UTC())
+	var conflict *conflictError
+	switch {
+	case errors.As(err, &conflict):
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	s.events.publish("alarm.acknowledged", alarm)
+	c.IndentedJSON(http.StatusOK, alarm)
+}
+
+func (s *Server) alarmFromPath(c *gin.Context) (*Alarm, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, errAlarmNotFound
+	}
+	return s.db.queryAlarm(c.Request.Context(), id)
+}
+
+// List every alarm rule.
+func (s *Server) listAlarmRules(c *gin.Context) {
+	rules, err := s.db.queryAlarmRules(c.Request.Context())
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, rules)
+}
+
+// Query an alarm rule by id.
+func (s *Server) getAlarmRule(c *gin.Context) {
+	rule, err := s.db.queryAlarmRule(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, rule)
+}
+
+// Add an alarm rule. It applies to readings ingested from now on.
+func (s *Server) addAlarmRule(c *gin.Context) {
+	var rule *AlarmRule
+	if err := c.BindJSON(&rule); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.validateAlarmRule(c.Request.Context(), rule, ""); err != nil {
+		abortInvalid(c, err)
+		return
+	}
+
+	if _, err := s.db.queryAlarmRule(c.Request.Context(), rule.ID); err == nil {
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": "Alarm rule already exists"})
+		return
+	}
+	if err := s.db.insertAlarmRule(c.Request.Context(), rule); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, rule)
+}
+
+// Replace an alarm rule.
+func (s *Server) updateAlarmRule(c *gin.Context) {
+	var rule *AlarmRule
+	if err := c.BindJSON(&rule); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.validateAlarmRule(c.Request.Context(), rule, c.Param("id")); err != nil {
+		abortInvalid(c, err)
+		return
+	}
+
+	s.alarmsMu.Lock()
+	defer s.alarmsMu.Unlock()
+
+	cleared, err := s.db.updateAlarmRule(c.Request.Context(), rule, time.Now().UTC())
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	for _, alarm := range cleared {
+		s.events.publish("alarm.cleared", alarm)
+	}
+	c.IndentedJSON(http.StatusOK, rule)
+}
+
+// Delete an alarm rule and clear its open alarms.
+func (s *Server) deleteAlarmRule(c *gin.Context) {
+	s.alarmsMu.Lock()
+	defer s.alarmsMu.Unlock()
+
+	cleared, err := s.db.deleteAlarmRule(c.Request.Context(), c.Param("id"), time.Now().UTC())
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	for _, alarm := range cleared {
+		s.events.publish("alarm.cleared", alarm)
+	}
+	c.Status(http.StatusNoContent)
+}
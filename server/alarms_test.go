@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+func (suite *testSuite) TestAlarmRules() {
+	rule := &AlarmRule{ID: "overvoltage", Sensor: "L1MAG", Kind: RuleAbove, Threshold: 1, Unit: "kV"}
+	response := suite.request("POST", "/alarms/rules", rule)
+	suite.Equal(201, response.Code)
+	response = suite.request("POST", "/alarms/rules", rule)
+	suite.Equal(409, response.Code)
+
+	events, unsubscribe := suite.srv.events.subscribe()
+	defer unsubscribe()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	response = suite.request("POST", "/sensor/L1MAG/readings", []*Reading{
+		{Time: start, Value: 500},
+		{Time: start.Add(time.Second), Value: 1500},
+		{Time: start.Add(2 * time.Second), Value: 1200},
+	})
+	suite.Equal(201, response.Code)
+
+	// One alarm for the whole excursion, raised by the first reading above 1 kV.
+	alarms := suite.alarms("/alarms")
+	suite.Len(alarms, 1)
+	alarm := alarms[0]
+	suite.Equal(AlarmRaised, alarm.State)
+	suite.Equal("overvoltage", alarm.Rule)
+	suite.Equal(1500.0, *alarm.Value)
+	suite.Equal(start.Add(time.Second), alarm.RaisedAt)
+	suite.Equal("L1MAG is 1500 V, above 1000 V", alarm.Message)
+
+	event := <-events
+	suite.Equal("alarm.raised", event.Type)
+	suite.Equal(alarm.ID, event.Data.(*Alarm).ID)
+
+	url := "/alarms/" + strconv.FormatInt(alarm.ID, 10)
+	response = suite.request("POST", url+"/ack", &Acknowledgement{By: "operator"})
+	suite.Equal(200, response.Code)
+	response = suite.request("POST", url+"/ack", nil)
+	suite.Equal(409, response.Code)
+	suite.Equal("alarm.acknowledged", (<-events).Type)
+
+	response = suite.request("POST", "/sensor/L1MAG/readings", []*Reading{
+		{Time: start.Add(3 * time.Second), Value: 900},
+	})
+	suite.Equal(201, response.Code)
+	suite.Empty(suite.alarms("/alarms"))
+	suite.Equal("alarm.cleared", (<-events).Type)
+
+	alarms = suite.alarms("/alarms?state=cleared&sensor=L1MAG")
+	suite.Len(alarms, 1)
+	suite.Equal("operator", alarms[0].AcknowledgedBy)
+	suite.NotNil(alarms[0].ClearedAt)
+
+	// Sensors named by a rule can't be deleted until the rule is.
+	response = suite.request("DELETE", "/sensor/L1MAG", nil)
+	suite.Equal(409, response.Code)
+	response = suite.request("DELETE", "/alarms/rules/overvoltage", nil)
+	suite.Equal(204, response.Code)
+	response = suite.request("DELETE", "/sensor/L1MAG", nil)
+	suite.Equal(204, response.Code)
+
+	response = suite.request("GET", "/alarms/404", nil)
+	suite.Equal(404, response.Code)
+	response = suite.request("GET", "/alarms?state=open", nil)
+	suite.Equal(400, response.Code)
+}
+
+func (suite *testSuite) TestUpdatedAlarmRule() {
+	rule := &AlarmRule{ID: "ocean", Selector: map[string]string{"ingress": "Middle of the Ocean"}, Kind: RuleAbove, Threshold: 1, Unit: "kV"}
+	suite.Equal(201, suite.request("POST", "/alarms/rules", rule).Code)
+	response := suite.request("POST", "/sensor/L1MAG/readings", []*Reading{{Time: time.Now(), Value: 1500}})
+	suite.Equal(201, response.Code)
+	suite.Len(suite.alarms("/alarms"), 1)
+
+	events, unsubscribe := suite.srv.events.subscribe()
+	defer unsubscribe()
+
+	// Alarms of sensors the rule still applies to stay open.
+	rule.Threshold = 2
+	suite.Equal(200, suite.request("PUT", "/alarms/rules/ocean", rule).Code)
+	suite.Len(suite.alarms("/alarms"), 1)
+
+	// The others are cleared.
+	rule.Selector = map[string]string{"ingress": "Anaheim"}
+	suite.Equal(200, suite.request("PUT", "/alarms/rules/ocean", rule).Code)
+	suite.Empty(suite.alarms("/alarms"))
+	suite.Equal("alarm.cleared", (<-events).Type)
+	suite.Len(suite.alarms("/alarms?state=cleared&sensor=L1MAG"), 1)
+}
+
+func (suite *testSuite) TestRateAlarm() {
+	// Selector rules apply to every matching sensor.
+	rule := &AlarmRule{ID: "angle-swing", Selector: map[string]string{"unit": "degrees"}, Kind: RuleRate, Threshold: 10}
+	response := suite.request("POST", "/alarms/rules", rule)
+	suite.Equal(201, response.Code)
+
+	response = suite.request("GET", "/alarms/rules/angle-swing", nil)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &rule))
+	suite.Equal(map[string]string{"unit": "deg"}, rule.Selector)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	response = suite.request("POST", "/sensor/L1ANG/readings", []*Reading{
+		{Time: start, Value: 0},
+		{Time: start.Add(time.Second), Value: 5},
+	})
+	suite.Equal(201, response.Code)
+	suite.Empty(suite.alarms("/alarms"))
+
+	// The change is measured from the last stored valid reading.
+	response = suite.request("POST", "/sensor/L1ANG/readings", []*Reading{
+		{Time: start.Add(2 * time.Second), Value: 1000, Quality: QualityInvalid},
+	})
+	suite.Equal(201, response.Code)
+	response = suite.request("POST", "/sensor/L1ANG/readings", []*Reading{
+		{Time: start.Add(3 * time.Second), Value: 45},
+	})
+	suite.Equal(201, response.Code)
+
+	alarms := suite.alarms("/alarms")
+	suite.Len(alarms, 1)
+	suite.Equal("L1ANG", alarms[0].Sensor)
+	suite.Equal(20.0, *alarms[0].Value)
+}
+
+func (suite *testSuite) TestStaleAlarm() {
+	rule := &AlarmRule{ID: "silent", Sensor: "C1MAG", Kind: RuleStale}
+	response := suite.request("POST", "/alarms/rules", rule)
+	suite.Equal(201, response.Code)
+
+	suite.Nil(suite.srv.evaluateStaleRules(context.Background()))
+	alarms := suite.alarms("/alarms")
+	suite.Len(alarms, 1)
+	suite.Equal("C1MAG has never reported", alarms[0].Message)
+
+	response = suite.request("POST", "/sensor/C1MAG/heartbeat", nil)
+	suite.Equal(200, response.Code)
+	suite.Nil(suite.srv.evaluateStaleRules(context.Background()))
+	suite.Empty(suite.alarms("/alarms"))
+}
+
+func (suite *testSuite) TestInvalidAlarmRules() {
+	for _, rule := range []*AlarmRule{
+		{ID: "missing", Sensor: "nope", Kind: RuleAbove},
+		{ID: "amps", Sensor: "L1MAG", Kind: RuleAbove, Unit: "A"},
+		{ID: "kind", Sensor: "L1MAG", Kind: "between"},
+		{ID: "both", Sensor: "L1MAG", Selector: map[string]string{"unit": "V"}, Kind: RuleAbove},
+		{ID: "key", Selector: map[string]string{"color": "red"}, Kind: RuleAbove},
+		{ID: "rate", Sensor: "L1MAG", Kind: RuleRate},
+		{ID: "stale", Sensor: "L1MAG", Kind: RuleStale, Threshold: 5},
+	} {
+		response := suite.request("POST", "/alarms/rules", rule)
+		suite.Equal(400, response.Code, rule.ID)
+	}
+}
+
+// Fetch and decode a list of alarms.
+func (suite *testSuite) alarms(url string) (alarms []*Alarm) {
+	response := suite.request("GET", url, nil)
+	suite.Equal(200, response.Code)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &alarms))
+	return alarms
+}
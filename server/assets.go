@@ -0,0 +1,414 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errAssetNotFound = errors.New("Asset not found in store")
+
+// A node in the asset hierarchy, e.g. substation -> bay -> device.
+// Sensors reference the asset they belong to, and inherit the
+// nearest location up the tree when they don't have one.
+type Asset struct {
+	ID       string       `json:"id"`
+	Name     string       `json:"name"`
+	Kind     string       `json:"kind"`
+	Parent   string       `json:"parent,omitempty"`
+	Location *Coordinates `json:"location,omitempty"`
+}
+
+// An asset with its children and the sensors directly attached to it.
+type AssetNode struct {
+	*Asset
+	Children []*AssetNode `json:"children,omitempty"`
+	Sensors  []string     `json:"sensors,omitempty"`
+}
+
+// Query every asset, keyed by id.
+func (db *store) queryAssets(ctx context.Context) (assets map[string]*Asset, err error) {
+	var rows *timedRows
+	selectStatement := `SELECT id, name, kind, parent, latitude, longitude FROM assets`
+	if rows, err = db.conn.QueryContext(ctx, selectStatement); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assets = make(map[string]*Asset)
+	for rows.Next() {
+		var lat, lon sql.NullFloat64
+		asset := &Asset{}
+		if err = rows.Scan(&asset.ID, &asset.Name, &asset.Kind, &asset.Parent, &lat, &lon); err != nil {
+			return nil, err
+		}
+		if lat.Valid && lon.Valid {
+			asset.Location = &Coordinates{Latitude: lat.Float64, Longitude: lon.Float64}
+		}
+		assets[asset.ID] = asset
+	}
+
+	return assets, rows.Err()
+}
+
+// Query a single asset by id.
+func (db *store) queryAsset(ctx context.Context, id string) (_ *Asset, err error) {
+	var assets map[string]*Asset
+	if assets, err = db.queryAssets(ctx); err != nil {
+		return nil, err
+	}
+	asset, ok := assets[id]
+	if !ok {
+		return nil, errAssetNotFound
+	}
+	return asset, nil
+}
+
+func assetValues(asset *Asset) []interface{} {
+	var lat, lon sql.NullFloat64
+	if asset.Location != nil {
+		lat = sql.NullFloat64{Float64: asset.Location.Latitude, Valid: true}
+		lon = sql.NullFloat64{Float64: asset.Location.Longitude, Valid: true}
+	}
+	return []interface{}{asset.Name, asset.Kind, asset.Parent, lat, lon, asset.ID}
+}
+
+// Insert a new asset.
+func (db *store) insertAsset(ctx context.Context, asset *Asset) (err error) {
+	insertStatement := `
+		INSERT INTO assets (name, kind, parent, latitude, longitude, id)
+		VALUES(?, ?, ?, ?, ?, ?)`
+	if _, err = db.conn.ExecContext(ctx, insertStatement, assetValues(asset)...); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Update an existing asset.
+func (db *store) updateAsset(ctx context.Context, asset *Asset) (err error) {
+	var result sql.Result
+	updateStatement := `
+		UPDATE assets
+		SET name=?, kind=?, parent=?, latitude=?, longitude=?
+		WHERE id = ?`
+	if result, err = db.conn.ExecContext(ctx, updateStatement, assetValues(asset)...); err != nil {
+		return err
+	}
+	return requireAffected(result, errAssetNotFound)
+}
+
+// Delete an asset that has no children or sensors.
+func (db *store) deleteAsset(ctx context.Context, id string) (err error) {
+	var tx *timedTx
+	if tx, err = db.conn.BeginTx(ctx, nil); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var children, sensors int
+	if err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM assets WHERE parent = ?`, id).Scan(&children); err != nil {
+		return err
+	}
+	if err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM sensors WHERE asset = ?`, id).Scan(&sensors); err != nil {
+		return err
+	}
+	if children > 0 || sensors > 0 {
+		return &conflictError{fmt.Sprintf("Asset has %d child asset(s) and %d sensor(s)", children, sensors)}
+	}
+
+	var result sql.Result
+	if result, err = tx.ExecContext(ctx, `DELETE FROM assets WHERE id = ?`, id); err != nil {
+		return err
+	}
+	if err = requireAffected(result, errAssetNotFound); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Query every sensor attached to an asset or any of its descendants.
+func (db *store) queryAssetSensors(ctx context.Context, id string) (sensors []*Sensor, err error) {
+	if _, err = db.queryAsset(ctx, id); err != nil {
+		return nil, err
+	}
+
+	return db.querySensorsWhere(ctx, `asset IN (
+		WITH RECURSIVE subtree(id) AS (
+			SELECT id FROM assets WHERE id = ?
+			UNION
+			SELECT assets.id FROM assets JOIN subtree ON assets.parent = subtree.id
+		)
+		SELECT id FROM subtree
+	) ORDER BY name`, id)
+}
+
+// Fill in the location of sensors that don't have one from the
+// nearest ancestor asset that does.
+func (db *store) inheritLocations(ctx context.Context, sensors []*Sensor) (err error) {
+	var assets map[string]*Asset
+	for _, sensor := range sensors {
+		if sensor.Location != nil || sensor.Asset == "" {
+			continue
+		}
+
+		// Only load the assets when a sensor actually needs them.
+		if assets == nil {
+			if assets, err = db.queryAssets(ctx); err != nil {
+				return err
+			}
+		}
+
+		if asset := locatedAncestor(assets, sensor.Asset); asset != nil {
+			sensor.Location = asset.Location
+			sensor.LocationFrom = asset.ID
+		}
+	}
+	return nil
+}
+
+// Walk up from an asset to the first one with a location.
+func locatedAncestor(assets map[string]*Asset, id string) *Asset {
+	for seen := make(map[string]bool); id != "" && !seen[id]; {
+		seen[id] = true
+		asset, ok := assets[id]
+		if !ok {
+			return nil
+		}
+		if asset.Location != nil {
+			return asset
+		}
+		id = asset.Parent
+	}
+	return nil
+}
+
+// Build the tree of assets rooted at root, or every root asset if it's empty.
+func (db *store) queryAssetTree(ctx context.Context, root string) (nodes []*AssetNode, err error) {
+	var assets map[string]*Asset
+	if assets, err = db.queryAssets(ctx); err != nil {
+		return nil, err
+	}
+	if root != "" {
+		if _, ok := assets[root]; !ok {
+			return nil, errAssetNotFound
+		}
+	}
+
+	var rows *timedRows
+	if rows, err = db.conn.QueryContext(ctx, `SELECT name, asset FROM sensors WHERE asset != '' ORDER BY name`); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sensors := make(map[string][]string)
+	for rows.Next() {
+		var name, asset string
+		if err = rows.Scan(&name, &asset); err != nil {
+			return nil, err
+		}
+		sensors[asset] = append(sensors[asset], name)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	children := make(map[string][]*Asset)
+	for _, asset := range assets {
+		children[asset.Parent] = append(children[asset.Parent], asset)
+	}
+	for _, siblings := range children {
+		sort.Slice(siblings, func(i, j int) bool { return siblings[i].ID < siblings[j].ID })
+	}
+
+	var build func(asset *Asset) *AssetNode
+	build = func(asset *Asset) *AssetNode {
+		node := &AssetNode{Asset: asset, Sensors: sensors[asset.ID]}
+		for _, child := range children[asset.ID] {
+			node.Children = append(node.Children, build(child))
+		}
+		return node
+	}
+
+	if root != "" {
+		return []*AssetNode{build(assets[root])}, nil
+	}
+	for _, asset := range children[""] {
+		nodes = append(nodes, build(asset))
+	}
+	return nodes, nil
+}
+
+// Check an asset's fields and that its parent exists without creating a cycle.
+func (s *Server) validateAsset(ctx context.Context, asset *Asset, pathID string) error {
+	verr := &ValidationError{}
+	if asset == nil {
+		verr.add("asset", "required", "is required")
+		return verr
+	}
+
+	switch {
+	case asset.ID == "":
+		verr.add("id", "required", "is required")
+	case !sensorNamePattern.MatchString(asset.ID):
+		verr.add("id", "assetid", "must start with a letter or digit and only contain letters, digits, '_', '-' or '.' (at most 64 characters)")
+	case pathID != "" && asset.ID != pathID:
+		verr.add("id", "path", fmt.Sprintf("must match the asset in the URL (%s)", pathID))
+	}
+	if asset.Name == "" {
+		asset.Name = asset.ID
+	}
+	if asset.Kind == "" {
+		verr.add("kind", "required", "is required")
+	}
+	if loc := asset.Location; loc != nil {
+		if loc.Latitude < -90 || loc.Latitude > 90 {
+			verr.add("location.latitude", "range", "must be between -90 and 90")
+		}
+		if loc.Longitude < -180 || loc.Longitude > 180 {
+			verr.add("location.longitude", "range", "must be between -180 and 180")
+		}
+	}
+
+	if asset.Parent != "" {
+		assets, err := s.db.queryAssets(ctx)
+		if err != nil {
+			return err
+		}
+		if _, ok := assets[asset.Parent]; !ok {
+			verr.add("parent", "exists", fmt.Sprintf("references unknown asset %q", asset.Parent))
+		}
+
+		// Walk up from the parent, reaching this asset means a cycle.
+		for id, seen := asset.Parent, make(map[string]bool); id != "" && !seen[id]; id = assets[id].Parent {
+			seen[id] = true
+			if id == asset.ID {
+				verr.add("parent", "cycle", "must not be the asset itself or one of its descendants")
+				break
+			}
+			if _, ok := assets[id]; !ok {
+				break
+			}
+		}
+	}
+
+	return verr.orNil()
+}
+
+// List every asset, ordered by id.
+func (s *Server) listAssets(c *gin.Context) {
+	assets, err := s.db.queryAssets(c.Request.Context())
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	list := make([]*Asset, 0, len(assets))
+	for _, asset := range assets {
+		list = append(list, asset)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	c.IndentedJSON(http.StatusOK, list)
+}
+
+// Query an asset by id.
+func (s *Server) getAsset(c *gin.Context) {
+	asset, err := s.db.queryAsset(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, asset)
+}
+
+// Add a new asset to the hierarchy.
+func (s *Server) addAsset(c *gin.Context) {
+	var asset *Asset
+	if err := c.BindJSON(&asset); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.validateAsset(c.Request.Context(), asset, ""); err != nil {
+		abortInvalid(c, err)
+		return
+	}
+
+	if _, err := s.db.queryAsset(c.Request.Context(), asset.ID); err == nil {
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": "Asset already exists"})
+		return
+	}
+	if err := s.db.insertAsset(c.Request.Context(), asset); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, asset)
+}
+
+// Update an asset, which may move it and its subtree to a new parent.
+func (s *Server) updateAsset(c *gin.Context) {
+	var asset *Asset
+	if err := c.BindJSON(&asset); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.validateAsset(c.Request.Context(), asset, c.Param("id")); err != nil {
+		abortInvalid(c, err)
+		return
+	}
+
+	if err := s.db.updateAsset(c.Request.Context(), asset); err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, asset)
+}
+
+// Delete a leaf asset.
+func (s *Server) deleteAsset(c *gin.Context) {
+	err := s.db.deleteAsset(c.Request.Context(), c.Param("id"))
+	var conflict *conflictError
+	switch {
+	case errors.As(err, &conflict):
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Browse the subtree below an asset.
+func (s *Server) getAssetTree(c *gin.Context) {
+	nodes, err := s.db.queryAssetTree(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, nodes[0])
+}
+
+// Browse the whole asset hierarchy.
+func (s *Server) getTree(c *gin.Context) {
+	nodes, err := s.db.queryAssetTree(c.Request.Context(), "")
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, nodes)
+}
+
+// List every sensor below an asset.
+func (s *Server) listAssetSensors(c *gin.Context) {
+	sensors, err := s.db.queryAssetSensors(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, sensors)
+}
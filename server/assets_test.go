@@ -0,0 +1,75 @@
+package server
+
+import "encoding/json"
+
+func (suite *testSuite) TestAssets() {
+	for _, asset := range []*Asset{
+		{ID: "SUB1", Name: "Anaheim substation", Kind: "substation", Location: &Coordinates{Latitude: 33.8, Longitude: 117.9}},
+		{ID: "BAY1", Kind: "bay", Parent: "SUB1"},
+		{ID: "PMU1", Kind: "device", Parent: "BAY1"},
+	} {
+		response := suite.request("POST", "/assets", asset)
+		suite.Equal(201, response.Code)
+	}
+
+	response := suite.request("POST", "/assets", &Asset{ID: "PMU2", Kind: "device", Parent: "NOPE"})
+	suite.Equal(400, response.Code)
+
+	// Moving an asset below its own descendant would create a cycle.
+	response = suite.request("PUT", "/assets/SUB1", &Asset{ID: "SUB1", Kind: "substation", Parent: "PMU1"})
+	suite.Equal(400, response.Code)
+
+	// Sensors without a location inherit the nearest one up the tree.
+	sensor := CreateSensor("L3MAG", "kV", "Anaheim", "foo", 0, 0)
+	sensor.Location = nil
+	sensor.Asset = "PMU1"
+	response = suite.request("POST", "/sensor", sensor)
+	suite.Equal(201, response.Code)
+
+	response = suite.request("GET", "/sensor/L3MAG", nil)
+	suite.Equal(200, response.Code)
+
+	var result *Sensor
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &result))
+	suite.Equal(&Coordinates{Latitude: 33.8, Longitude: 117.9}, result.Location)
+	suite.Equal("SUB1", result.LocationFrom)
+
+	// Sending the inherited location back doesn't pin it on the sensor.
+	response = suite.request("PUT", "/sensor/L3MAG", result)
+	suite.Equal(200, response.Code)
+	response = suite.request("PUT", "/assets/SUB1", &Asset{ID: "SUB1", Kind: "substation", Location: &Coordinates{Latitude: 10, Longitude: 10}})
+	suite.Equal(200, response.Code)
+	response = suite.request("GET", "/sensor/L3MAG", nil)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &result))
+	suite.Equal(&Coordinates{Latitude: 10, Longitude: 10}, result.Location)
+
+	response = suite.request("GET", "/assets/SUB1/sensors", nil)
+	suite.Equal(200, response.Code)
+
+	var sensors []*Sensor
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &sensors))
+	suite.Len(sensors, 1)
+	suite.Equal("L3MAG", sensors[0].Name)
+
+	response = suite.request("GET", "/tree", nil)
+	suite.Equal(200, response.Code)
+
+	var tree []*AssetNode
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &tree))
+	suite.Len(tree, 1)
+	suite.Equal("SUB1", tree[0].ID)
+	suite.Equal("PMU1", tree[0].Children[0].Children[0].ID)
+	suite.Equal([]string{"L3MAG"}, tree[0].Children[0].Children[0].Sensors)
+
+	response = suite.request("GET", "/assets/BAY1/tree", nil)
+	suite.Equal(200, response.Code)
+	response = suite.request("GET", "/assets/NOPE/tree", nil)
+	suite.Equal(404, response.Code)
+
+	response = suite.request("DELETE", "/assets/PMU1", nil)
+	suite.Equal(409, response.Code)
+	response = suite.request("DELETE", "/sensor/L3MAG", nil)
+	suite.Equal(204, response.Code)
+	response = suite.request("DELETE", "/assets/PMU1", nil)
+	suite.Equal(204, response.Code)
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Context key the request's principal is stored under.
+const principalContextKey = "principal"
+
+// Roles a bearer token can grant, and the API key scope each matches.
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+var roleScopes = map[string]Scope{RoleViewer: ScopeRead, RoleOperator: ScopeWrite, RoleAdmin: ScopeAdmin}
+
+// Routes needing more than their method implies, by method and route
+// path, for API keys and bearer tokens alike. Deleting records with
+// history and replacing or restructuring configuration are for admins.
+var routeScopes = map[string]Scope{
+	"DELETE /sensor/:name":     ScopeAdmin,
+	"DELETE /assets/:id":       ScopeAdmin,
+	"PUT /topology":            ScopeAdmin,
+	"DELETE /alarms/rules/:id": ScopeAdmin,
+	"DELETE /phasors/:id":      ScopeAdmin,
+	"DELETE /records/:id":      ScopeAdmin,
+	"POST /ingresses":          ScopeAdmin,
+	"PUT /ingresses/:name":     ScopeAdmin,
+	"DELETE /ingresses/:name":  ScopeAdmin,
+	"POST /distillers":         ScopeAdmin,
+	"PUT /distillers/:name":    ScopeAdmin,
+	"DELETE /distillers/:name": ScopeAdmin,
+	"POST /namespaces":         ScopeAdmin,
+}
+
+// Routes outside /sensor/:name and /ingresses/:name that principals
+// limited to some ingresses may use, by method and route path: they
+// only show such a principal the sensors it's allowed, or don't touch
+// sensors at all. Every other route could reveal or change sensors of
+// other ingresses, so it's closed to them.
+var ingressAwareRoutes = map[string]bool{
+	"GET /allsensors":        true,
+	"POST /sensor":           true,
+	"GET /sensors/stale":     true,
+	"GET /nearest/:lat/:lon": true,
+	"GET /units":             true,
+	"GET /units/convert":     true,
+	"GET /units/:symbol":     true,
+	"GET /health":            true,
+}
+
+// Who a request is made by, from its API key or bearer token.
+type principal struct {
+	Name  string // key prefix or token subject.
+	Scope Scope
+	// The only ingresses whose sensors it may touch, nil for all.
+	Ingresses []string
+}
+
+// Whether the principal may touch sensors of an ingress.
+func (p *principal) allowsIngress(ingress string) bool {
+	if p == nil || p.Ingresses == nil {
+		return true
+	}
+	for _, allowed := range p.Ingresses {
+		if allowed == ingress {
+			return true
+		}
+	}
+	return false
+}
+
+// The principal a request was authorized as, nil if it had no
+// credentials.
+func requestPrincipal(c *gin.Context) *principal {
+	if p, ok := c.Get(principalContextKey); ok {
+		return p.(*principal)
+	}
+	return nil
+}
+
+// The scope a request needs, empty if it needs none. Managing keys
+// needs admin, as do the routes in routeScopes, and changing data
+// needs write. Reads need read when they're private, except for
+// health checks.
+func (s *Server) requiredScope(c *gin.Context) Scope {
+	method, path := c.Request.Method, c.FullPath()
+	if scope, ok := routeScopes[method+" "+path]; ok {
+		return scope
+	}
+	switch {
+	case path == "/keys" || strings.HasPrefix(path, "/keys/"):
+		return ScopeAdmin
+	case method != http.MethodGet && method != http.MethodHead && method != http.MethodOptions:
+		return ScopeWrite
+	case s.privateReads && path != "/health":
+		return ScopeRead
+	}
+	return ""
+}
+
+// Reject a request as unauthenticated. Token failures say so in
+// WWW-Authenticate as bearer token clients expect.
+func abortUnauthorized(c *gin.Context, bearer bool, message string) {
+	if bearer {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+	}
+	c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": message})
+	c.Abort()
+}
+
+// The principal of an API key, nil if it isn't a valid one.
+func (s *Server) authenticateAPIKey(ctx context.Context, header string) (*principal, error) {
+	keys := s.base().db
+	key, err := keys.queryAPIKey(ctx, header)
+	if errors.Is(err, errAPIKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if now := time.Now(); key.LastUsed == nil || now.Sub(*key.LastUsed) > apiKeyTouchInterval {
+		if err = keys.touchAPIKey(ctx, key.ID, now); err != nil {
+			s.logger.WarnContext(ctx, "recording use of API key", "key", key.ID, "error", err)
+		}
+	}
+	return &principal{Name: key.Prefix, Scope: key.Scope}, nil
+}
+
+// Middleware authenticating a request's API key or bearer token and
+// rejecting it if it needs a scope the principal doesn't have or
+// names a sensor or ingress it isn't allowed.
+func (s *Server) authorize(c *gin.Context) {
+	// Namespaced requests are authorized by the namespace's routes.
+	if c.FullPath() == namespaceRoute {
+		c.Next()
+		return
+	}
+
+	var p *principal
+	authorization := c.GetHeader("Authorization")
+	raw, bearer := strings.CutPrefix(authorization, "Bearer ")
+	switch {
+	case bearer && s.tokens == nil:
+		abortUnauthorized(c, true, "bearer tokens aren't accepted")
+		return
+	case bearer:
+		var err error
+		if p, err = s.tokens.verify(strings.TrimSpace(raw)); err != nil {
+			abortUnauthorized(c, true, err.Error())
+			return
+		}
+	case s.apiKeys && c.GetHeader(APIKeyHeader) != "":
+		var err error
+		if p, err = s.authenticateAPIKey(c.Request.Context(), c.GetHeader(APIKeyHeader)); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		if p == nil {
+			abortUnauthorized(c, false, "invalid or revoked API key")
+			return
+		}
+	}
+
+	required := s.requiredScope(c)
+	switch {
+	case p == nil && required != "":
+		abortUnauthorized(c, false, s.credentialsHint())
+		return
+	case p != nil && required != "" && !p.Scope.allows(required):
+		message := fmt.Sprintf("%s %s needs %s scope", c.Request.Method, c.FullPath(), required)
+		if p.Scope != "" {
+			message = fmt.Sprintf("%s has %s scope, %s", p.Name, p.Scope, message)
+		}
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": message})
+		c.Abort()
+		return
+	}
+
+	if p != nil {
+		c.Set(principalContextKey, p)
+		if !s.allowRouteIngress(c, p) {
+			return
+		}
+	}
+	c.Next()
+}
+
+// What a request without credentials needs to send.
+func (s *Server) credentialsHint() string {
+	switch {
+	case s.apiKeys && s.tokens != nil:
+		return fmt.Sprintf("an API key in the %s header or a bearer token is required", APIKeyHeader)
+	case s.tokens != nil:
+		return "a bearer token is required"
+	}
+	return fmt.Sprintf("an API key is required in the %s header", APIKeyHeader)
+}
+
+// Check a principal limited to some ingresses against the sensor or
+// ingress a route names, and keep it off routes that aren't
+// ingress-aware. Sensors and routes that don't exist are left for the
+// handler to report.
+func (s *Server) allowRouteIngress(c *gin.Context, p *principal) bool {
+	if p.Ingresses == nil {
+		return true
+	}
+
+	path := c.FullPath()
+	switch {
+	case path == "" || ingressAwareRoutes[c.Request.Method+" "+path]:
+		return true
+	case strings.HasPrefix(path, "/sensor/:name"):
+		sensor, err := s.db.querySensor(c.Request.Context(), c.Param("name"))
+		if err != nil {
+			return true
+		}
+		return allowIngress(c, sensor.Tags.Ingress)
+	case strings.HasPrefix(path, "/"+ingressKind.table+"/:name"):
+		return allowIngress(c, c.Param("name"))
+	}
+	message := fmt.Sprintf("%s %s isn't available to %s, which is limited to ingresses %s", c.Request.Method, path, p.Name, strings.Join(p.Ingresses, ", "))
+	c.IndentedJSON(http.StatusForbidden, gin.H{"error": message})
+	c.Abort()
+	return false
+}
+
+// Check the request's principal may touch sensors of an ingress,
+// responding with a 403 if not.
+func allowIngress(c *gin.Context, ingress string) bool {
+	if requestPrincipal(c).allowsIngress(ingress) {
+		return true
+	}
+	c.IndentedJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("not allowed to access ingress %s", ingress)})
+	c.Abort()
+	return false
+}
+
+// The sensors the request's principal may see.
+func visibleSensors(c *gin.Context, sensors []*Sensor) []*Sensor {
+	p := requestPrincipal(c)
+	if p == nil || p.Ingresses == nil {
+		return sensors
+	}
+	visible := []*Sensor{}
+	for _, sensor := range sensors {
+		if p.allowsIngress(sensor.Tags.Ingress) {
+			visible = append(visible, sensor)
+		}
+	}
+	return visible
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errCalibrationNotFound = errors.New("Calibration not found in store")
+
+// A linear correction from a sensor's raw values to its unit, such as
+// a CT or PT ratio, in force from EffectiveFrom until the next one.
+// Readings before a sensor's first calibration are served as stored.
+type Calibration struct {
+	ID            int64      `json:"id"`
+	Sensor        string     `json:"sensor"`
+	Scale         float64    `json:"scale"`
+	Offset        float64    `json:"offset"`
+	EffectiveFrom time.Time  `json:"effective_from"`
+	Certificate   string     `json:"certificate,omitempty"`
+	DueAt         *time.Time `json:"due_at,omitempty"`
+}
+
+// The calibrated value of a raw one.
+func (cal *Calibration) apply(raw float64) float64 {
+	return raw*cal.Scale + cal.Offset
+}
+
+// A sensor's calibrations, oldest first.
+type calibrationHistory []*Calibration
+
+// The calibration in force at t, nil if there was none yet.
+func (h calibrationHistory) at(t time.Time) *Calibration {
+	i := sort.Search(len(h), func(i int) bool { return h[i].EffectiveFrom.After(t) })
+	if i == 0 {
+		return nil
+	}
+	return h[i-1]
+}
+
+// Columns selected by calibration queries, in the order queryCalibrationsWhere scans them.
+const calibrationColumns = `id, sensor, scale, offset, effective_from, certificate, due_at`
+
+// Query the calibrations matching a WHERE clause.
+func (db *store) queryCalibrationsWhere(ctx context.Context, where, order string, args ...interface{}) (calibrations []*Calibration, err error) {
+	selectStatement := `SELECT ` + calibrationColumns + ` FROM calibrations WHERE ` + where + ` ORDER BY ` + order
+
+	var rows *timedRows
+	if rows, err = db.conn.QueryContext(ctx, selectStatement, args...); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var effectiveFrom int64
+		var dueAt sql.NullInt64
+		cal := &Calibration{}
+		if err = rows.Scan(&cal.ID, &cal.Sensor, &cal.Scale, &cal.Offset, &effectiveFrom, &cal.Certificate, &dueAt); err != nil {
+			return nil, err
+		}
+		cal.EffectiveFrom = time.Unix(0, effectiveFrom).UTC()
+		if dueAt.Valid {
+			at := time.Unix(0, dueAt.Int64).UTC()
+			cal.DueAt = &at
+		}
+		calibrations = append(calibrations, cal)
+	}
+
+	return calibrations, rows.Err()
+}
+
+// Query a sensor's calibrations, oldest first.
+func (db *store) queryCalibrations(ctx context.Context, name string) (calibrationHistory, error) {
+	return db.queryCalibrationsWhere(ctx, "sensor = ?", "effective_from", name)
+}
+
+// Query the calibration in force on each sensor that is still in
+// service whose due date is before now, most overdue first.
+func (db *store) queryOverdueCalibrations(ctx context.Context, now time.Time) (calibrations []*Calibration, err error) {
+	where := `
+		due_at IS NOT NULL AND due_at < ?
+		AND effective_from = (
+			SELECT MAX(effective_from) FROM calibrations latest
+			WHERE latest.sensor = calibrations.sensor AND latest.effective_from <= ?)
+		AND sensor IN (SELECT name FROM sensors WHERE state != ?)`
+	return db.queryCalibrationsWhere(ctx, where, "due_at, sensor", now.UnixNano(), now.UnixNano(), StateDecommissioned)
+}
+
+// Insert a calibration, setting its id. Only one calibration
+// of a sensor can take effect at a given time.
+func (db *store) insertCalibration(ctx context.Context, cal *Calibration) (err error) {
+	var existing []*Calibration
+	if existing, err = db.queryCalibrationsWhere(ctx, "sensor = ? AND effective_from = ?", "id", cal.Sensor, cal.EffectiveFrom.UnixNano()); err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return &conflictError{fmt.Sprintf("Calibration %d already takes effect at %s", existing[0].ID, cal.EffectiveFrom.Format(time.RFC3339Nano))}
+	}
+
+	var dueAt sql.NullInt64
+	if cal.DueAt != nil {
+		dueAt = sql.NullInt64{Int64: cal.DueAt.UnixNano(), Valid: true}
+	}
+
+	var result sql.Result
+	insertStatement := `
+		INSERT INTO calibrations (sensor, scale, offset, effective_from, certificate, due_at)
+		VALUES(?, ?, ?, ?, ?, ?)`
+	if result, err = db.conn.ExecContext(ctx, insertStatement, cal.Sensor, cal.Scale, cal.Offset, cal.EffectiveFrom.UnixNano(), cal.Certificate, dueAt); err != nil {
+		return err
+	}
+	cal.ID, err = result.LastInsertId()
+	return err
+}
+
+// Delete one of a sensor's calibrations.
+func (db *store) deleteCalibration(ctx context.Context, name string, id int64) (err error) {
+	var result sql.Result
+	if result, err = db.conn.ExecContext(ctx, `DELETE FROM calibrations WHERE sensor = ? AND id = ?`, name, id); err != nil {
+		return err
+	}
+	return requireAffected(result, errCalibrationNotFound)
+}
+
+// Replace the raw values of a sensor's readings with calibrated ones.
+func (db *store) calibrate(ctx context.Context, name string, readings []*Reading) (err error) {
+	if len(readings) == 0 {
+		return nil
+	}
+
+	var history calibrationHistory
+	if history, err = db.queryCalibrations(ctx, name); err != nil {
+		return err
+	}
+	for _, reading := range readings {
+		if cal := history.at(reading.Time); cal != nil {
+			reading.Value = cal.apply(reading.Value)
+		}
+	}
+	return nil
+}
+
+// Parse the raw query parameter, which skips calibration.
+func parseRaw(c *gin.Context) (bool, error) {
+	raw, err := strconv.ParseBool(c.DefaultQuery("raw", "false"))
+	if err != nil {
+		return false, errors.New("raw must be true or false")
+	}
+	return raw, nil
+}
+
+// Check a calibration's fields.
+func validateCalibration(cal *Calibration) error {
+	verr := &ValidationError{}
+	if cal == nil {
+		verr.add("calibration", "required", "is required")
+		return verr
+	}
+	if cal.Scale == 0 || math.IsNaN(cal.Scale) || math.IsInf(cal.Scale, 0) {
+		verr.add("scale", "required", "must be a finite non-zero number")
+	}
+	if math.IsNaN(cal.Offset) || math.IsInf(cal.Offset, 0) {
+		verr.add("offset", "finite", "must be a finite number")
+	}
+	if cal.EffectiveFrom.IsZero() {
+		verr.add("effective_from", "required", "is required")
+	}
+	if cal.DueAt != nil && !cal.DueAt.After(cal.EffectiveFrom) {
+		verr.add("due_at", "gtfield", "must be after effective_from")
+	}
+	return verr.orNil()
+}
+
+// List a sensor's calibrations, oldest first.
+func (s *Server) listCalibrations(c *gin.Context) {
+	sensor, err := s.db.querySensor(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	history, err := s.db.queryCalibrations(c.Request.Context(), sensor.Name)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if history == nil {
+		history = calibrationHistory{}
+	}
+	c.IndentedJSON(http.StatusOK, history)
+}
+
+// Add a calibration to a sensor. It applies to every reading from its
+// effective time on, including those already stored.
+func (s *Server) addCalibration(c *gin.Context) {
+	sensor, err := s.db.querySensor(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	var cal *Calibration
+	if err = c.BindJSON(&cal); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err = validateCalibration(cal); err != nil {
+		abortInvalid(c, err)
+		return
+	}
+	cal.ID, cal.Sensor = 0, sensor.Name
+
+	err = s.db.insertCalibration(c.Request.Context(), cal)
+	var conflict *conflictError
+	switch {
+	case errors.As(err, &conflict):
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, cal)
+}
+
+// Delete one of a sensor's calibrations.
+func (s *Server) deleteCalibration(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": errCalibrationNotFound.Error()})
+		return
+	}
+	if err = s.db.deleteCalibration(c.Request.Context(), c.Param("name"), id); err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// List the calibrations in force that are past due.
+func (s *Server) listOverdueCalibrations(c *gin.Context) {
+	calibrations, err := s.db.queryOverdueCalibrations(c.Request.Context(), time.Now())
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if calibrations == nil {
+		calibrations = []*Calibration{}
+	}
+	c.IndentedJSON(http.StatusOK, calibrations)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+func (suite *testSuite) TestCalibration() {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	response := suite.request("POST", "/sensor/C1MAG/readings", []*Reading{
+		{Time: start, Value: 1},
+		{Time: start.Add(time.Hour), Value: 1},
+		{Time: start.Add(2 * time.Hour), Value: 1},
+	})
+	suite.Equal(201, response.Code)
+
+	// A 400:5 CT, later recalibrated with a small offset.
+	due := start.Add(365 * 24 * time.Hour)
+	ct := &Calibration{Scale: 80, EffectiveFrom: start.Add(time.Hour), Certificate: "CT-1", DueAt: &due}
+	response = suite.request("POST", "/sensor/C1MAG/calibrations", ct)
+	suite.Equal(201, response.Code)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &ct))
+	suite.Equal("C1MAG", ct.Sensor)
+	suite.NotZero(ct.ID)
+
+	response = suite.request("POST", "/sensor/C1MAG/calibrations", ct)
+	suite.Equal(409, response.Code)
+	response = suite.request("POST", "/sensor/C1MAG/calibrations", &Calibration{Scale: 80, Offset: 0.5, EffectiveFrom: start.Add(2 * time.Hour)})
+	suite.Equal(201, response.Code)
+
+	readings := func(query string) (values []float64) {
+		response := suite.request("GET", "/sensor/C1MAG/readings"+query, nil)
+		suite.Equal(200, response.Code)
+
+		var body Readings
+		suite.Nil(json.Unmarshal(response.Body.Bytes(), &body))
+		for _, reading := range body.Readings {
+			values = append(values, reading.Value)
+		}
+		return values
+	}
+	suite.Equal([]float64{1, 80, 80.5}, readings(""))
+	suite.Equal([]float64{1, 1, 1}, readings("?raw=true"))
+	suite.Equal([]float64{0.001, 0.08, 0.0805}, readings("?unit=kA"))
+
+	response = suite.request("GET", "/sensor/C1MAG/readings?raw=maybe", nil)
+	suite.Equal(400, response.Code)
+
+	var history []*Calibration
+	response = suite.request("GET", "/sensor/C1MAG/calibrations", nil)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &history))
+	suite.Len(history, 2)
+	suite.Equal(ct, history[0])
+
+	// The first calibration is past due but no longer in force.
+	suite.Empty(suite.calibrations("/calibrations/overdue"))
+	url := "/sensor/C1MAG/calibrations/" + strconv.FormatInt(history[1].ID, 10)
+	response = suite.request("DELETE", url, nil)
+	suite.Equal(204, response.Code)
+	response = suite.request("DELETE", url, nil)
+	suite.Equal(404, response.Code)
+
+	overdue := suite.calibrations("/calibrations/overdue")
+	suite.Len(overdue, 1)
+	suite.Equal(ct, overdue[0])
+
+	// Decommissioned sensors don't need calibrating.
+	for _, state := range []string{StateMaintenance, StateDecommissioned} {
+		response = suite.request("POST", "/sensor/C1MAG/transition", &TransitionRequest{To: state})
+		suite.Equal(200, response.Code)
+	}
+	suite.Empty(suite.calibrations("/calibrations/overdue"))
+}
+
+func (suite *testSuite) TestCalibratedAlarms() {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	response := suite.request("POST", "/sensor/C1MAG/calibrations", &Calibration{Scale: 100, EffectiveFrom: start})
+	suite.Equal(201, response.Code)
+	response = suite.request("POST", "/alarms/rules", &AlarmRule{ID: "overcurrent", Sensor: "C1MAG", Kind: RuleAbove, Threshold: 500})
+	suite.Equal(201, response.Code)
+
+	response = suite.request("POST", "/sensor/C1MAG/readings", []*Reading{{Time: start, Value: 6}})
+	suite.Equal(201, response.Code)
+
+	alarms := suite.alarms("/alarms")
+	suite.Len(alarms, 1)
+	suite.Equal(600.0, *alarms[0].Value)
+}
+
+func (suite *testSuite) TestInvalidCalibrations() {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	before := start.Add(-time.Hour)
+	for _, cal := range []*Calibration{
+		{EffectiveFrom: start},
+		{Scale: 1},
+		{Scale: 1, EffectiveFrom: start, DueAt: &before},
+	} {
+		response := suite.request("POST", "/sensor/C1MAG/calibrations", cal)
+		suite.Equal(400, response.Code)
+	}
+
+	response := suite.request("POST", "/sensor/nope/calibrations", &Calibration{Scale: 1, EffectiveFrom: start})
+	suite.Equal(404, response.Code)
+}
+
+// Fetch and decode a list of calibrations.
+func (suite *testSuite) calibrations(url string) (calibrations []*Calibration) {
+	response := suite.request("GET", url, nil)
+	suite.Equal(200, response.Code)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &calibrations))
+	return calibrations
+}
@@ -0,0 +1,334 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Ingresses and distillers share the same shape, a named
+// pipeline component with a description and free form metadata.
+type Component struct {
+	Name        string            `json:"name" yaml:"name"`
+	Description string            `json:"description" yaml:"description"`
+	Metadata    map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	// Liveness threshold for an ingress's sensors. Not used for distillers.
+	StaleAfter Duration `json:"stale_after,omitempty" yaml:"stale_after,omitempty"`
+}
+
+// Describes where a kind of component is stored and which
+// sensor column references it.
+type componentKind struct {
+	table    string // table holding the components.
+	column   string // sensors column referencing the component.
+	label    string // capitalized name used in messages.
+	notFound error  // returned when a component doesn't exist.
+}
+
+var (
+	ingressKind = &componentKind{
+		table:    "ingresses",
+		column:   "ingress",
+		label:    "Ingress",
+		notFound: errors.New("Ingress not found in store"),
+	}
+	distillerKind = &componentKind{
+		table:    "distillers",
+		column:   "distiller",
+		label:    "Distiller",
+		notFound: errors.New("Distiller not found in store"),
+	}
+)
+
+// Query every component of a kind, ordered by name.
+func (db *store) queryComponents(ctx context.Context, kind *componentKind) (components []*Component, err error) {
+	var rows *timedRows
+	selectStatement := `SELECT name, description, metadata, stale_after FROM ` + kind.table + ` ORDER BY name`
+	if rows, err = db.conn.QueryContext(ctx, selectStatement); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var component *Component
+		if component, err = scanComponent(rows); err != nil {
+			return nil, err
+		}
+		components = append(components, component)
+	}
+
+	return components, rows.Err()
+}
+
+// Query a single component by name.
+func (db *store) queryComponent(ctx context.Context, kind *componentKind, name string) (_ *Component, err error) {
+	var rows *timedRows
+	selectStatement := `SELECT name, description, metadata, stale_after FROM ` + kind.table + ` WHERE name = ?`
+	if rows, err = db.conn.QueryContext(ctx, selectStatement, name); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, kind.notFound
+	}
+	return scanComponent(rows)
+}
+
+func scanComponent(rows *timedRows) (_ *Component, err error) {
+	var metadata string
+	component := &Component{}
+	if err = rows.Scan(&component.Name, &component.Description, &metadata, &component.StaleAfter); err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal([]byte(metadata), &component.Metadata); err != nil {
+		return nil, err
+	}
+	return component, nil
+}
+
+// Insert a new component, failing if the name is taken.
+func (db *store) insertComponent(ctx context.Context, kind *componentKind, component *Component) (err error) {
+	var metadata []byte
+	if metadata, err = json.Marshal(component.Metadata); err != nil {
+		return err
+	}
+
+	insertStatement := `INSERT INTO ` + kind.table + ` (name, description, metadata, stale_after) VALUES(?, ?, ?, ?)`
+	if _, err = db.conn.ExecContext(ctx, insertStatement, component.Name, component.Description, string(metadata), int64(component.StaleAfter)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Update the description, metadata and threshold of an existing component.
+func (db *store) updateComponent(ctx context.Context, kind *componentKind, component *Component) (err error) {
+	var metadata []byte
+	if metadata, err = json.Marshal(component.Metadata); err != nil {
+		return err
+	}
+
+	var result sql.Result
+	updateStatement := `UPDATE ` + kind.table + ` SET description = ?, metadata = ?, stale_after = ? WHERE name = ?`
+	if result, err = db.conn.ExecContext(ctx, updateStatement, component.Description, string(metadata), int64(component.StaleAfter), component.Name); err != nil {
+		return err
+	}
+	return requireAffected(result, kind.notFound)
+}
+
+// Delete a component that no sensor references.
+func (db *store) deleteComponent(ctx context.Context, kind *componentKind, name string) (err error) {
+	var tx *timedTx
+	if tx, err = db.conn.BeginTx(ctx, nil); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var references int
+	countStatement := `SELECT COUNT(*) FROM sensors WHERE ` + kind.column + ` = ?`
+	if err = tx.QueryRowContext(ctx, countStatement, name).Scan(&references); err != nil {
+		return err
+	}
+	if references > 0 {
+		return &conflictError{fmt.Sprintf("%s is referenced by %d sensor(s)", kind.label, references)}
+	}
+
+	var result sql.Result
+	deleteStatement := `DELETE FROM ` + kind.table + ` WHERE name = ?`
+	if result, err = tx.ExecContext(ctx, deleteStatement, name); err != nil {
+		return err
+	}
+	if err = requireAffected(result, kind.notFound); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Create a bare component if it doesn't exist yet.
+func ensureComponent(ctx context.Context, exec execer, kind *componentKind, name string) (err error) {
+	insertStatement := `INSERT INTO ` + kind.table + ` (name, description, metadata) VALUES(?, '', 'null') ON CONFLICT(name) DO NOTHING`
+	_, err = exec.ExecContext(ctx, insertStatement, name)
+	return err
+}
+
+// Query the sensors referencing a component.
+func (db *store) queryComponentSensors(ctx context.Context, kind *componentKind, name string) (sensors []*Sensor, err error) {
+	if _, err = db.queryComponent(ctx, kind, name); err != nil {
+		return nil, err
+	}
+	return db.querySensorsWhere(ctx, kind.column+" = ?", name)
+}
+
+// Check that the ingress, distiller and asset a sensor references exist,
+// in the transaction writing it so they can't be deleted in between. In
+// lax mode missing components are created instead of rejected.
+func (db *store) checkReferences(ctx context.Context, tx *timedTx, sensor *Sensor) (err error) {
+	references := []struct {
+		kind  *componentKind
+		field string
+		name  string
+	}{
+		{ingressKind, "tags.ingress", sensor.Tags.Ingress},
+		{distillerKind, "tags.distiller", sensor.Tags.Distiller},
+	}
+
+	verr := &ValidationError{}
+	for _, ref := range references {
+		if db.laxReferences {
+			if err = ensureComponent(ctx, tx, ref.kind, ref.name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var exists bool
+		existsStatement := `SELECT EXISTS (SELECT 1 FROM ` + ref.kind.table + ` WHERE name = ?)`
+		if err = tx.QueryRowContext(ctx, existsStatement, ref.name).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			verr.add(ref.field, "exists", fmt.Sprintf("references unknown %s %q", ref.kind.column, ref.name))
+		}
+	}
+
+	if sensor.Asset != "" {
+		var exists bool
+		if err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM assets WHERE id = ?)`, sensor.Asset).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			verr.add("asset", "exists", fmt.Sprintf("references unknown asset %q", sensor.Asset))
+		}
+	}
+
+	return verr.orNil()
+}
+
+// List every component of a kind.
+func (s *Server) listComponents(kind *componentKind) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		components, err := s.db.queryComponents(c.Request.Context(), kind)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.IndentedJSON(http.StatusOK, components)
+	}
+}
+
+// Query a component by name.
+func (s *Server) getComponent(kind *componentKind) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		component, err := s.db.queryComponent(c.Request.Context(), kind, c.Param("name"))
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.IndentedJSON(http.StatusOK, component)
+	}
+}
+
+// Register a new component.
+func (s *Server) addComponent(kind *componentKind) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var component *Component
+		if err := c.BindJSON(&component); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateComponent(kind, component, ""); err != nil {
+			abortInvalid(c, err)
+			return
+		}
+
+		if _, err := s.db.queryComponent(c.Request.Context(), kind, component.Name); err == nil {
+			c.IndentedJSON(http.StatusConflict, gin.H{"error": kind.label + " already exists"})
+			return
+		}
+		if err := s.db.insertComponent(c.Request.Context(), kind, component); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.IndentedJSON(http.StatusCreated, component)
+	}
+}
+
+// Update a component's description and metadata.
+func (s *Server) updateComponent(kind *componentKind) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var component *Component
+		if err := c.BindJSON(&component); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateComponent(kind, component, c.Param("name")); err != nil {
+			abortInvalid(c, err)
+			return
+		}
+
+		if err := s.db.updateComponent(c.Request.Context(), kind, component); err != nil {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.IndentedJSON(http.StatusOK, component)
+	}
+}
+
+// Delete a component, refusing while sensors still reference it.
+func (s *Server) deleteComponent(kind *componentKind) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := s.db.deleteComponent(c.Request.Context(), kind, c.Param("name"))
+		var conflict *conflictError
+		switch {
+		case errors.As(err, &conflict):
+			c.IndentedJSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// List the sensors referencing a component.
+func (s *Server) listComponentSensors(kind *componentKind) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sensors, err := s.db.queryComponentSensors(c.Request.Context(), kind, c.Param("name"))
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.IndentedJSON(http.StatusOK, sensors)
+	}
+}
+
+func validateComponent(kind *componentKind, component *Component, pathName string) error {
+	verr := &ValidationError{}
+	switch {
+	case component == nil || component.Name == "":
+		verr.add("name", "required", "is required")
+		return verr
+	case len(component.Name) > 128:
+		verr.add("name", "max", "must be at most 128 characters")
+	case pathName != "" && component.Name != pathName:
+		verr.add("name", "path", fmt.Sprintf("must match the name in the URL (%s)", pathName))
+	}
+
+	switch {
+	case component.StaleAfter < 0:
+		verr.add("stale_after", "gte", "must be at least 0")
+	case component.StaleAfter != 0 && kind != ingressKind:
+		verr.add("stale_after", "ingress", "is only used for ingresses")
+	}
+	return verr.orNil()
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+)
+
+func (suite *testSuite) TestComponentRoutes() {
+	response := suite.request("GET", "/ingresses", nil)
+	suite.Equal(200, response.Code)
+
+	// Seeding creates the components the fixture sensors reference.
+	var components []*Component
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &components))
+	suite.Len(components, 3)
+	suite.Equal("Anaheim", components[0].Name)
+
+	brazil := &Component{Name: "brazil", Description: "Sao Paulo", Metadata: map[string]string{"owner": "ops"}}
+	response = suite.request("POST", "/ingresses", brazil)
+	suite.Equal(201, response.Code)
+	response = suite.request("POST", "/ingresses", brazil)
+	suite.Equal(409, response.Code)
+
+	brazil.Description = "Rio de Janeiro"
+	response = suite.request("PUT", "/ingresses/brazil", brazil)
+	suite.Equal(200, response.Code)
+	response = suite.request("PUT", "/ingresses/chile", brazil)
+	suite.Equal(400, response.Code)
+
+	response = suite.request("GET", "/ingresses/brazil", nil)
+	suite.Equal(200, response.Code)
+
+	var component *Component
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &component))
+	suite.Equal(brazil, component)
+
+	// Sensors can only reference registered components.
+	response = suite.request("POST", "/sensor", CreateSensor("C2MAG", "amps", "brazil", "qux", 0, 0))
+	suite.Equal(400, response.Code)
+	response = suite.request("POST", "/sensor", CreateSensor("C2MAG", "amps", "brazil", "foo", 0, 0))
+	suite.Equal(201, response.Code)
+
+	response = suite.request("GET", "/ingresses/brazil/sensors", nil)
+	suite.Equal(200, response.Code)
+
+	var sensors []*Sensor
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &sensors))
+	suite.Len(sensors, 1)
+	suite.Equal("C2MAG", sensors[0].Name)
+
+	response = suite.request("DELETE", "/ingresses/brazil", nil)
+	suite.Equal(409, response.Code)
+	response = suite.request("DELETE", "/sensor/C2MAG", nil)
+	suite.Equal(204, response.Code)
+	response = suite.request("DELETE", "/ingresses/brazil", nil)
+	suite.Equal(204, response.Code)
+	response = suite.request("GET", "/ingresses/brazil", nil)
+	suite.Equal(404, response.Code)
+
+	response = suite.request("GET", "/distillers/foo/sensors", nil)
+	suite.Equal(200, response.Code)
+}
+
+func (suite *testSuite) TestLaxReferences() {
+	var err error
+	suite.srv, err = New("fakeaddress", WithLaxReferences())
+	suite.Nil(err)
+
+	response := suite.request("POST", "/sensor", CreateSensor("C2MAG", "amps", "brazil", "qux", 0, 0))
+	suite.Equal(201, response.Code)
+
+	response = suite.request("GET", "/distillers/qux", nil)
+	suite.Equal(200, response.Code)
+}
+
+func (suite *testSuite) TestWithIngresses() {
+	var err error
+	suite.srv, err = New("fakeaddress", WithIngresses("brazil", "chile"))
+	suite.Nil(err)
+
+	suite.Equal(200, suite.request("GET", "/ingresses/chile", nil).Code)
+	suite.Nil(ensureComponent(context.Background(), suite.srv.db.conn, distillerKind, "foo"))
+	suite.Equal(201, suite.request("POST", "/sensor", CreateSensor("C2MAG", "amps", "brazil", "foo", 0, 0)).Code)
+	suite.Equal(400, suite.request("POST", "/sensor", CreateSensor("C3MAG", "amps", "peru", "foo", 0, 0)).Code)
+}
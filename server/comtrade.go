@@ -0,0 +1,531 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"pingthings/comtrade"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	errRecordNotFound  = errors.New("Record not found in store")
+	errChannelNotFound = errors.New("Channel not found in record")
+)
+
+// Largest record upload accepted unless configured otherwise, in bytes.
+const defaultMaxUpload = 64 << 20
+
+// Reject record uploads larger than n bytes, all files included.
+func WithMaxUpload(n int64) Option {
+	return func(o *options) {
+		o.maxUpload = n
+	}
+}
+
+// A COMTRADE event record imported from a recorder's .cfg and .dat
+// files. The files are kept so every channel can be browsed, whether
+// or not its samples went to a sensor.
+type EventRecord struct {
+	ID         int64            `json:"id"`
+	Station    string           `json:"station"`
+	Device     string           `json:"device"`
+	Revision   int              `json:"revision"`
+	Format     string           `json:"format"`
+	Frequency  float64          `json:"frequency"`
+	SampleRate float64          `json:"sample_rate,omitempty"`
+	Start      time.Time        `json:"start"`
+	Trigger    time.Time        `json:"trigger"`
+	End        time.Time        `json:"end"`
+	Samples    int              `json:"samples"`
+	ImportedAt time.Time        `json:"imported_at"`
+	Channels   []*RecordChannel `json:"channels,omitempty"`
+}
+
+// A channel of an event record, and the sensor its samples were
+// stored as readings of. Problem says why a channel that names a
+// sensor wasn't stored.
+type RecordChannel struct {
+	Index    int    `json:"index"`
+	Name     string `json:"name"`
+	Phase    string `json:"phase,omitempty"`
+	Circuit  string `json:"circuit,omitempty"`
+	Unit     string `json:"unit,omitempty"`
+	Digital  bool   `json:"digital,omitempty"`
+	Sensor   string `json:"sensor,omitempty"`
+	Readings int    `json:"readings,omitempty"`
+	Problem  string `json:"problem,omitempty"`
+}
+
+// One channel's samples from a record's files. Analog values are
+// primary values in the channel's unit, digital ones 0 or 1.
+type RecordSamples struct {
+	Record  int64      `json:"record"`
+	Channel string     `json:"channel"`
+	Unit    string     `json:"unit,omitempty"`
+	Samples []*Reading `json:"samples"`
+}
+
+// Columns selected by record queries, in the order queryRecordsWhere scans them.
+const recordColumns = `id, station, device, revision, format, frequency, sample_rate, start, trigger_at, end_at, samples, imported_at, channels`
+
+// Query the records matching a WHERE clause, newest first.
+func (db *store) queryRecordsWhere(ctx context.Context, where string, args ...interface{}) (records []*EventRecord, err error) {
+	selectStatement := `SELECT ` + recordColumns + ` FROM event_records WHERE ` + where + ` ORDER BY start DESC, id DESC`
+
+	var rows *timedRows
+	if rows, err = db.conn.QueryContext(ctx, selectStatement, args...); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var start, trigger, end, imported int64
+		var channels string
+		record := &EventRecord{}
+		if err = rows.Scan(&record.ID, &record.Station, &record.Device, &record.Revision, &record.Format, &record.Frequency,
+			&record.SampleRate, &start, &trigger, &end, &record.Samples, &imported, &channels); err != nil {
+			return nil, err
+		}
+		record.Start = time.Unix(0, start).UTC()
+		record.Trigger = time.Unix(0, trigger).UTC()
+		record.End = time.Unix(0, end).UTC()
+		record.ImportedAt = time.Unix(0, imported).UTC()
+		if err = json.Unmarshal([]byte(channels), &record.Channels); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// Query a record by ID.
+func (db *store) queryRecord(ctx context.Context, id int64) (*EventRecord, error) {
+	records, err := db.queryRecordsWhere(ctx, `id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errRecordNotFound
+	}
+	return records[0], nil
+}
+
+// Query a record's original .cfg and .dat files.
+func (db *store) queryRecordFiles(ctx context.Context, id int64) (cfg, dat []byte, err error) {
+	selectStatement := `SELECT cfg, dat FROM event_records WHERE id = ?`
+	if err = db.conn.QueryRowContext(ctx, selectStatement, id).Scan(&cfg, &dat); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, errRecordNotFound
+		}
+		return nil, nil, err
+	}
+	return cfg, dat, nil
+}
+
+// Insert a record with its files and the readings taken from it. A
+// recorder's record of the same start time can only be imported once.
+func (db *store) insertRecord(ctx context.Context, record *EventRecord, cfg, dat []byte, readings map[string][]*Reading) (err error) {
+	var tx *timedTx
+	if tx, err = db.conn.BeginTx(ctx, nil); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var existing int64
+	existingStatement := `SELECT id FROM event_records WHERE station = ? AND device = ? AND start = ?`
+	switch err = tx.QueryRowContext(ctx, existingStatement, record.Station, record.Device, record.Start.UnixNano()).Scan(&existing); {
+	case err == nil:
+		return &conflictError{fmt.Sprintf("Record was already imported as %d", existing)}
+	case !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+
+	var channels []byte
+	if channels, err = json.Marshal(record.Channels); err != nil {
+		return err
+	}
+
+	var result sql.Result
+	insertStatement := `
+		INSERT INTO event_records (station, device, revision, format, frequency, sample_rate, start, trigger_at, end_at, samples, imported_at, channels, cfg, dat)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	if result, err = tx.ExecContext(ctx, insertStatement, record.Station, record.Device, record.Revision, record.Format, record.Frequency, record.SampleRate,
+		record.Start.UnixNano(), record.Trigger.UnixNano(), record.End.UnixNano(), record.Samples, record.ImportedAt.UnixNano(), string(channels), cfg, dat); err != nil {
+		return err
+	}
+	if record.ID, err = result.LastInsertId(); err != nil {
+		return err
+	}
+
+	ingested := 0
+	for name, batch := range readings {
+		if err = insertReadingRows(ctx, tx, name, batch); err != nil {
+			return err
+		}
+		ingested += len(batch)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	db.conn.metrics.ingested(ingested)
+	return nil
+}
+
+// Delete a record. Readings imported from it stay with their sensors.
+func (db *store) deleteRecord(ctx context.Context, id int64) (err error) {
+	var result sql.Result
+	if result, err = db.conn.ExecContext(ctx, `DELETE FROM event_records WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return requireAffected(result, errRecordNotFound)
+}
+
+// Work out the sensor each analog channel of a record goes to and the
+// readings it contributes. Channels map to the sensor named in mapping
+// if they're in it, an empty name leaving them out, and to the sensor
+// named after them otherwise. Mappings naming a channel or sensor that
+// doesn't exist fail validation. Values are stored as primary values in
+// the sensor's unit.
+func (s *Server) mapRecordChannels(ctx context.Context, cfg *comtrade.Config, samples []*comtrade.Sample, mapping map[string]string) (channels []*RecordChannel, readings map[string][]*Reading, err error) {
+	names := make(map[string]bool)
+	for _, analog := range cfg.Analogs {
+		names[analog.Name] = true
+	}
+	mapped := make([]string, 0, len(mapping))
+	for channel := range mapping {
+		mapped = append(mapped, channel)
+	}
+	sort.Strings(mapped)
+	verr := &ValidationError{}
+	for _, channel := range mapped {
+		switch name := mapping[channel]; {
+		case !names[channel]:
+			verr.add("mapping."+channel, "channel", "isn't a channel of the record")
+		case name != "":
+			if _, err = s.db.querySensor(ctx, name); errors.Is(err, errSensorNotFound) {
+				verr.add("mapping."+channel, "sensor", fmt.Sprintf("names unknown sensor %s", name))
+			} else if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if err = verr.orNil(); err != nil {
+		return nil, nil, err
+	}
+
+	readings = make(map[string][]*Reading)
+	taken := make(map[string]string)
+	for i := range cfg.Analogs {
+		analog := &cfg.Analogs[i]
+		channel := &RecordChannel{Index: analog.Index, Name: analog.Name, Phase: analog.Phase, Circuit: analog.Circuit, Unit: analog.Unit}
+		channels = append(channels, channel)
+
+		name, isMapped := mapping[analog.Name]
+		var sensor *Sensor
+		switch {
+		case isMapped && name == "":
+			continue
+		case isMapped:
+			if sensor, err = s.db.querySensor(ctx, name); err != nil {
+				return nil, nil, err
+			}
+		default:
+			if sensor, err = s.db.querySensor(ctx, analog.Name); errors.Is(err, errSensorNotFound) {
+				sensor, err = s.db.querySensor(ctx, pmuSensorName(analog.Name))
+			}
+			if errors.Is(err, errSensorNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		channel.Sensor = sensor.Name
+
+		from, ok := LookupUnit(analog.Unit)
+		to, _ := LookupUnit(sensor.Tags.Unit)
+		switch {
+		case sensor.State == StateDecommissioned:
+			channel.Problem = fmt.Sprintf("sensor %s is decommissioned", sensor.Name)
+		case taken[sensor.Name] != "":
+			channel.Problem = fmt.Sprintf("sensor %s already takes channel %s", sensor.Name, taken[sensor.Name])
+		case !ok:
+			channel.Problem = fmt.Sprintf("unknown unit %q", analog.Unit)
+		case to == nil || to.Quantity != from.Quantity:
+			channel.Problem = fmt.Sprintf("sensor %s measures %s, not %s", sensor.Name, sensor.Tags.Unit, from.Quantity)
+		}
+		if channel.Problem != "" {
+			continue
+		}
+		taken[sensor.Name] = analog.Name
+
+		skew := time.Duration(analog.Skew * float64(time.Microsecond))
+		for _, sample := range samples {
+			value := sample.Analogs[i]
+			if math.IsNaN(value) {
+				continue
+			}
+			if value, err = convert(analog.ToPrimary(value), from, to); err != nil {
+				return nil, nil, err
+			}
+			readings[sensor.Name] = append(readings[sensor.Name], &Reading{Time: sample.Time.Add(skew), Value: value})
+		}
+		channel.Readings = len(readings[sensor.Name])
+	}
+
+	for _, digital := range cfg.Digitals {
+		channels = append(channels, &RecordChannel{Index: digital.Index, Name: digital.Name, Phase: digital.Phase, Circuit: digital.Circuit, Digital: true})
+	}
+	return channels, readings, nil
+}
+
+// Read an uploaded file, nil if the form doesn't have it.
+func formFile(c *gin.Context, name string) ([]byte, error) {
+	header, err := c.FormFile(name)
+	if errors.Is(err, http.ErrMissingFile) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// Import a COMTRADE record uploaded as the cfg and dat files of a
+// multipart form. An optional mapping file, JSON or YAML, maps channel
+// names to sensor names. Samples of mapped channels are stored as
+// readings at the record's own times. Historical records don't raise
+// alarms.
+func (s *Server) importRecord(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, s.maxUpload)
+	files := make(map[string][]byte)
+	for _, name := range []string{"cfg", "dat", "mapping"} {
+		data, err := formFile(c, name)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.IndentedJSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("uploads are limited to %d bytes", tooLarge.Limit)})
+			return
+		}
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if data == nil && name != "mapping" {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("the %s file is required", name)})
+			return
+		}
+		files[name] = data
+	}
+
+	cfg, err := comtrade.ParseConfig(bytes.NewReader(files["cfg"]))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	samples, err := cfg.ReadData(bytes.NewReader(files["dat"]))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(samples) == 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "the dat file has no samples"})
+		return
+	}
+
+	var mapping map[string]string
+	if err = yaml.Unmarshal(files["mapping"], &mapping); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("mapping: %v", err)})
+		return
+	}
+
+	channels, readings, err := s.mapRecordChannels(c.Request.Context(), cfg, samples, mapping)
+	var verr *ValidationError
+	switch {
+	case errors.As(err, &verr):
+		abortInvalid(c, err)
+		return
+	case err != nil:
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	record := &EventRecord{
+		Station:    cfg.Station,
+		Device:     cfg.Device,
+		Revision:   cfg.Revision,
+		Format:     string(cfg.Format),
+		Frequency:  cfg.Frequency,
+		SampleRate: cfg.SampleRate(),
+		Start:      cfg.Start,
+		Trigger:    cfg.Trigger,
+		End:        samples[len(samples)-1].Time,
+		Samples:    len(samples),
+		ImportedAt: time.Now().UTC(),
+		Channels:   channels,
+	}
+	err = s.db.insertRecord(c.Request.Context(), record, files["cfg"], files["dat"], readings)
+	var conflict *conflictError
+	switch {
+	case errors.As(err, &conflict):
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// Imported readings raise alarms like any others.
+	for name, sensorReadings := range readings {
+		if err = s.evaluateReadings(c.Request.Context(), name, sensorReadings); err != nil {
+			s.logger.ErrorContext(c.Request.Context(), "evaluating alarm rules", "sensor", name, "error", err)
+		}
+	}
+	c.IndentedJSON(http.StatusCreated, record)
+}
+
+// Parse the id path parameter of a record route.
+func recordID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": errRecordNotFound.Error()})
+		return 0, false
+	}
+	return id, true
+}
+
+// List the imported records, newest first, without their channels.
+func (s *Server) listRecords(c *gin.Context) {
+	records, err := s.db.queryRecordsWhere(c.Request.Context(), `1 = 1`)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, record := range records {
+		record.Channels = nil
+	}
+	if records == nil {
+		records = []*EventRecord{}
+	}
+	c.IndentedJSON(http.StatusOK, records)
+}
+
+// Get a record and its channels.
+func (s *Server) getRecord(c *gin.Context) {
+	id, ok := recordID(c)
+	if !ok {
+		return
+	}
+	record, err := s.db.queryRecord(c.Request.Context(), id)
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, record)
+}
+
+// Get one channel's samples, read back from the record's files.
+func (s *Server) getRecordChannel(c *gin.Context) {
+	id, ok := recordID(c)
+	if !ok {
+		return
+	}
+	cfgData, datData, err := s.db.queryRecordFiles(c.Request.Context(), id)
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	cfg, err := comtrade.ParseConfig(bytes.NewReader(cfgData))
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	samples, err := cfg.ReadData(bytes.NewReader(datData))
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := &RecordSamples{Record: id, Channel: c.Param("channel"), Samples: []*Reading{}}
+	for i := range cfg.Analogs {
+		analog := &cfg.Analogs[i]
+		if analog.Name != response.Channel {
+			continue
+		}
+		response.Unit = analog.Unit
+		skew := time.Duration(analog.Skew * float64(time.Microsecond))
+		for _, sample := range samples {
+			if value := sample.Analogs[i]; !math.IsNaN(value) {
+				response.Samples = append(response.Samples, &Reading{Time: sample.Time.Add(skew), Value: analog.ToPrimary(value)})
+			}
+		}
+		c.IndentedJSON(http.StatusOK, response)
+		return
+	}
+	for i, digital := range cfg.Digitals {
+		if digital.Name != response.Channel {
+			continue
+		}
+		for _, sample := range samples {
+			value := 0.0
+			if sample.Digitals[i] {
+				value = 1
+			}
+			response.Samples = append(response.Samples, &Reading{Time: sample.Time, Value: value})
+		}
+		c.IndentedJSON(http.StatusOK, response)
+		return
+	}
+	c.IndentedJSON(http.StatusNotFound, gin.H{"error": errChannelNotFound.Error()})
+}
+
+// Download a record's original cfg or dat file.
+func (s *Server) getRecordFile(c *gin.Context) {
+	id, ok := recordID(c)
+	if !ok {
+		return
+	}
+	cfg, dat, err := s.db.queryRecordFiles(c.Request.Context(), id)
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	data := map[string][]byte{"cfg": cfg, "dat": dat}[c.Param("file")]
+	if data == nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "file must be cfg or dat"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="record-%d.%s"`, id, c.Param("file")))
+	c.Data(http.StatusOK, "application/octet-stream", data)
+}
+
+// Delete a record.
+func (s *Server) deleteRecord(c *gin.Context) {
+	id, ok := recordID(c)
+	if !ok {
+		return
+	}
+	if err := s.db.deleteRecord(c.Request.Context(), id); err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Upload a record's files as a multipart form, reading the named
+// testdata files. Mapping is sent as is when set.
+func (suite *testSuite) uploadRecord(cfg, dat, mapping string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for field, name := range map[string]string{"cfg": cfg, "dat": dat} {
+		if name == "" {
+			continue
+		}
+		data, err := os.ReadFile("testdata/" + name)
+		suite.Nil(err)
+		part, err := form.CreateFormFile(field, name)
+		suite.Nil(err)
+		part.Write(data)
+	}
+	if mapping != "" {
+		part, err := form.CreateFormFile("mapping", "mapping.yaml")
+		suite.Nil(err)
+		part.Write([]byte(mapping))
+	}
+	suite.Nil(form.Close())
+
+	request := httptest.NewRequest("POST", "/records", &body)
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	recorder := httptest.NewRecorder()
+	suite.srv.gin.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func (suite *testSuite) TestImportRecord() {
+	response := suite.uploadRecord("fault.cfg", "fault.dat", "")
+	suite.Equal(201, response.Code, response.Body.String())
+	var record EventRecord
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &record))
+
+	start := time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC)
+	suite.NotZero(record.ID)
+	suite.Equal("SUB1", record.Station)
+	suite.Equal("RELAY7", record.Device)
+	suite.Equal(start, record.Start)
+	suite.Equal(start.Add(2500*time.Microsecond), record.End)
+	suite.Equal(4, record.Samples)
+	suite.Equal(1200.0, record.SampleRate)
+
+	// Channels map to the sensors named after them, converted to the
+	// sensor's unit.
+	suite.Len(record.Channels, 5)
+	suite.Equal("L1MAG", record.Channels[0].Sensor)
+	suite.Equal(4, record.Channels[0].Readings)
+	suite.Empty(record.Channels[1].Sensor)
+	suite.Equal("L1ANG", record.Channels[3].Sensor)
+	suite.Contains(record.Channels[3].Problem, "measures deg")
+	suite.True(record.Channels[4].Digital)
+
+	response = suite.request("GET", "/sensor/L1MAG/readings", nil)
+	suite.Equal(200, response.Code)
+	var readings Readings
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &readings))
+	suite.Len(readings.Readings, 4)
+	suite.Equal(start.Add(1666667*time.Nanosecond), readings.Readings[2].Time)
+	suite.InDelta(7000, readings.Readings[2].Value, 1e-6)
+	response = suite.request("GET", "/sensor/L1ANG/readings", nil)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &readings))
+	suite.Empty(readings.Readings)
+
+	// A record is only imported once.
+	response = suite.uploadRecord("fault.cfg", "fault.dat", "")
+	suite.Equal(409, response.Code)
+
+	var records []*EventRecord
+	response = suite.request("GET", "/records", nil)
+	suite.Equal(200, response.Code)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &records))
+	suite.Len(records, 1)
+	suite.Empty(records[0].Channels)
+
+	id := strconv.FormatInt(record.ID, 10)
+	response = suite.request("GET", "/records/"+id, nil)
+	suite.Equal(200, response.Code)
+
+	// Every channel can be browsed, stored or not. Secondary values
+	// are scaled to primary ones.
+	var samples RecordSamples
+	response = suite.request("GET", "/records/"+id+"/channels/IA", nil)
+	suite.Equal(200, response.Code)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &samples))
+	suite.Equal("A", samples.Unit)
+	suite.Len(samples.Samples, 4)
+	suite.InDelta(64, samples.Samples[0].Value, 1e-9)
+	response = suite.request("GET", "/records/"+id+"/channels/VB", nil)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &samples))
+	suite.Len(samples.Samples, 3)
+	response = suite.request("GET", "/records/"+id+"/channels/TRIP", nil)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &samples))
+	suite.Equal(1.0, samples.Samples[3].Value)
+	response = suite.request("GET", "/records/"+id+"/channels/NOPE", nil)
+	suite.Equal(404, response.Code)
+
+	original, err := os.ReadFile("testdata/fault.cfg")
+	suite.Nil(err)
+	response = suite.request("GET", "/records/"+id+"/files/cfg", nil)
+	suite.Equal(200, response.Code)
+	suite.Equal(original, response.Body.Bytes())
+	response = suite.request("GET", "/records/"+id+"/files/pdf", nil)
+	suite.Equal(404, response.Code)
+
+	response = suite.request("DELETE", "/records/"+id, nil)
+	suite.Equal(204, response.Code)
+	response = suite.request("GET", "/records/"+id, nil)
+	suite.Equal(404, response.Code)
+}
+
+func (suite *testSuite) TestImportRecordMapping() {
+	rule := &AlarmRule{ID: "overcurrent", Sensor: "C1MAG", Kind: RuleAbove, Threshold: 50}
+	suite.Equal(201, suite.request("POST", "/alarms/rules", rule).Code)
+
+	// IA goes to C1MAG, and L1MAG is left out.
+	response := suite.uploadRecord("fault.cfg", "fault.dat", "IA: C1MAG\nL1MAG: ''\n")
+	suite.Equal(201, response.Code, response.Body.String())
+	var record EventRecord
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &record))
+	suite.Empty(record.Channels[0].Sensor)
+	suite.Equal("C1MAG", record.Channels[1].Sensor)
+
+	response = suite.request("GET", "/sensor/C1MAG/readings", nil)
+	var readings Readings
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &readings))
+	suite.Len(readings.Readings, 4)
+	suite.InDelta(64, readings.Readings[0].Value, 1e-9)
+	response = suite.request("GET", "/sensor/L1MAG/readings", nil)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &readings))
+	suite.Empty(readings.Readings)
+
+	// Imported readings are checked against alarm rules.
+	var alarms []*Alarm
+	suite.Nil(json.Unmarshal(suite.request("GET", "/alarms", nil).Body.Bytes(), &alarms))
+	suite.Len(alarms, 1)
+	suite.Equal("overcurrent", alarms[0].Rule)
+	suite.Equal("C1MAG", alarms[0].Sensor)
+}
+
+func (suite *testSuite) TestInvalidRecords() {
+	response := suite.uploadRecord("fault.cfg", "", "")
+	suite.Equal(400, response.Code)
+	response = suite.uploadRecord("fault.dat", "fault.dat", "")
+	suite.Equal(400, response.Code)
+	response = suite.uploadRecord("fault.cfg", "fault.cfg", "")
+	suite.Equal(400, response.Code)
+
+	// Mappings can't name channels or sensors that don't exist, as JSON
+	// or YAML.
+	response = suite.uploadRecord("fault.cfg", "fault.dat", `{"IB": "C1MAG", "IA": "C2MAG"}`)
+	suite.Equal(400, response.Code)
+	var body struct {
+		Fields []FieldError `json:"fields"`
+	}
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &body))
+	suite.Len(body.Fields, 2)
+	suite.Equal("mapping.IA", body.Fields[0].Field)
+	response = suite.uploadRecord("fault.cfg", "fault.dat", "[IA]")
+	suite.Equal(400, response.Code)
+
+	response = suite.request("GET", "/records", nil)
+	suite.Equal("[]", response.Body.String())
+	response = suite.request("GET", "/records/x", nil)
+	suite.Equal(404, response.Code)
+
+	// Uploads over the limit aren't read in full.
+	var err error
+	suite.srv, err = New("fakeaddress", WithSeed("testdata/sensors.json"), WithMaxUpload(100))
+	suite.Nil(err)
+	response = suite.uploadRecord("fault.cfg", "fault.dat", "")
+	suite.Equal(413, response.Code)
+}
@@ -0,0 +1,474 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/gin-gonic/gin"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"gopkg.in/yaml.v3"
+)
+
+// Prefix of the environment variables settings can be given in.
+const EnvPrefix = "PINGTHINGS_"
+
+// Everything about how the server runs. Settings come from a YAML or
+// TOML file, then environment variables, then flags, each overriding
+// the ones before. The variable for a setting is its path in the file
+// in upper case, joined by underscores and prefixed with PINGTHINGS_:
+// PINGTHINGS_TLS_CERT sets tls.cert.
+type Config struct {
+	Address string `yaml:"address" toml:"address"`
+	// Gin mode: debug, release or test. Defaults to the mode gin starts
+	// in, debug unless GIN_MODE says otherwise.
+	Mode          string        `yaml:"mode" toml:"mode"`
+	Database      string        `yaml:"database" toml:"database"`
+	Seed          string        `yaml:"seed" toml:"seed"`
+	LaxReferences bool          `yaml:"lax_references" toml:"lax_references"`
+	Ingresses     []string      `yaml:"ingresses" toml:"ingresses"`
+	StaleAfter    Duration      `yaml:"stale_after" toml:"stale_after"`
+	Timeouts      Timeouts      `yaml:"timeouts" toml:"timeouts"`
+	PMU           PMUConfig     `yaml:"pmu" toml:"pmu"`
+	APIKeys       bool          `yaml:"api_keys" toml:"api_keys"`
+	APIKeyFile    string        `yaml:"api_key_file" toml:"api_key_file"`
+	PrivateReads  bool          `yaml:"private_reads" toml:"private_reads"`
+	RateLimits    LimitsText    `yaml:"rate_limits" toml:"rate_limits"`
+	TLS           TLSConfig     `yaml:"tls" toml:"tls"`
+	JWT           JWTText       `yaml:"jwt" toml:"jwt"`
+	Log           LogConfig     `yaml:"log" toml:"log"`
+	Tracing       TracingConfig `yaml:"tracing" toml:"tracing"`
+	// Largest COMTRADE record upload accepted, in bytes.
+	MaxUpload int64 `yaml:"max_upload" toml:"max_upload"`
+
+	// Proxies, as addresses or CIDR ranges, whose X-Forwarded-For and
+	// X-Real-IP headers say which client a request came from.
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+// HTTP server timeouts. Zero is no timeout.
+type Timeouts struct {
+	Read       Duration `yaml:"read" toml:"read"`
+	ReadHeader Duration `yaml:"read_header" toml:"read_header"`
+	Write      Duration `yaml:"write" toml:"write"`
+	Idle       Duration `yaml:"idle" toml:"idle"`
+}
+
+// Where C37.118 streams are accepted.
+type PMUConfig struct {
+	TCP          string `yaml:"tcp" toml:"tcp"`
+	UDP          string `yaml:"udp" toml:"udp"`
+	AutoRegister bool   `yaml:"auto_register" toml:"auto_register"`
+}
+
+// Rate limits as ParseLimit reads them. Empty is unlimited.
+type LimitsText struct {
+	Read      string `yaml:"read" toml:"read"`
+	Write     string `yaml:"write" toml:"write"`
+	Expensive string `yaml:"expensive" toml:"expensive"`
+}
+
+// Certificate files for serving HTTPS.
+type TLSConfig struct {
+	Cert     string `yaml:"cert" toml:"cert"`
+	Key      string `yaml:"key" toml:"key"`
+	ClientCA string `yaml:"client_ca" toml:"client_ca"`
+}
+
+// Bearer token settings, as in JWTConfig. Tokens are only accepted
+// when JWKS is set.
+type JWTText struct {
+	JWKS         string            `yaml:"jwks" toml:"jwks"`
+	Issuer       string            `yaml:"issuer" toml:"issuer"`
+	Audience     string            `yaml:"audience" toml:"audience"`
+	RolesClaim   string            `yaml:"roles_claim" toml:"roles_claim"`
+	Roles        map[string]string `yaml:"roles,omitempty" toml:"roles,omitempty"`
+	IngressClaim string            `yaml:"ingress_claim" toml:"ingress_claim"`
+}
+
+// How the server logs, to standard error.
+type LogConfig struct {
+	// Least important records logged: debug, info, warn or error.
+	Level string `yaml:"level" toml:"level"`
+	// json or text.
+	Format string `yaml:"format" toml:"format"`
+}
+
+// Where the server exports traces of requests and store queries.
+type TracingConfig struct {
+	// otlp, stdout, or empty not to trace.
+	Exporter string `yaml:"exporter" toml:"exporter"`
+	// OTLP/HTTP collector address, such as localhost:4318. Left empty,
+	// the OTEL_EXPORTER_OTLP_* variables apply.
+	Endpoint string `yaml:"endpoint" toml:"endpoint"`
+	// Export over plain HTTP rather than HTTPS.
+	Insecure bool `yaml:"insecure" toml:"insecure"`
+	// File the stdout exporter appends to instead of standard output.
+	File        string `yaml:"file" toml:"file"`
+	ServiceName string `yaml:"service_name" toml:"service_name"`
+	// Share of traces started here that are kept, from 0 to 1. Traces
+	// continued from clients follow their sampling decision.
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+}
+
+// Timeouts used unless configured otherwise. Writes aren't limited
+// for event streams, which clear their deadline.
+var defaultTimeouts = Timeouts{
+	Read:       Duration(time.Minute),
+	ReadHeader: Duration(10 * time.Second),
+	Write:      Duration(time.Minute),
+	Idle:       Duration(2 * time.Minute),
+}
+
+// The settings used when nothing else is given.
+func DefaultConfig() Config {
+	return Config{
+		Address:    "localhost:8080",
+		Mode:       gin.Mode(),
+		Database:   ":memory:",
+		StaleAfter: Duration(defaultStaleAfter),
+		Timeouts:   defaultTimeouts,
+		JWT:        JWTText{RolesClaim: "roles"},
+		Log:        LogConfig{Level: "info", Format: "json"},
+		Tracing:    TracingConfig{ServiceName: "pingthings", SampleRatio: 1},
+		MaxUpload:  defaultMaxUpload,
+	}
+}
+
+// Load the default settings, overridden by those in a YAML or TOML
+// file if path is set, and then by environment variables found with
+// lookup, such as os.LookupEnv.
+func LoadConfig(path string, lookup func(string) (string, bool)) (cfg Config, err error) {
+	cfg = DefaultConfig()
+	if path != "" {
+		var data []byte
+		if data, err = os.ReadFile(path); err != nil {
+			return cfg, err
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".toml":
+			var meta toml.MetaData
+			if meta, err = toml.Decode(string(data), &cfg); err != nil {
+				return cfg, fmt.Errorf("%s: %w", path, err)
+			}
+			if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+				return cfg, fmt.Errorf("%s: unknown setting %s", path, undecoded[0])
+			}
+		case ".yaml", ".yml":
+			decoder := yaml.NewDecoder(bytes.NewReader(data))
+			decoder.KnownFields(true)
+			if err = decoder.Decode(&cfg); err != nil {
+				return cfg, fmt.Errorf("%s: %w", path, err)
+			}
+		default:
+			return cfg, fmt.Errorf("%s: config files must be .yaml, .yml or .toml", path)
+		}
+	}
+
+	if err = applyEnv(reflect.ValueOf(&cfg).Elem(), EnvPrefix, lookup); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// Set the fields of a struct from environment variables named after
+// their YAML keys.
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) (err error) {
+	for i := 0; i < v.NumField(); i++ {
+		key, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		name := prefix + strings.ToUpper(key)
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err = applyEnv(field, name+"_", lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		switch field.Addr().Interface().(type) {
+		case *Duration:
+			var d Duration
+			if err = d.parse(value); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			field.Set(reflect.ValueOf(d))
+		case *bool:
+			var b bool
+			if b, err = strconv.ParseBool(value); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			field.SetBool(b)
+		case *int64:
+			var n int64
+			if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			field.SetInt(n)
+		case *float64:
+			var f float64
+			if f, err = strconv.ParseFloat(value, 64); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			field.SetFloat(f)
+		case *string:
+			field.SetString(value)
+		case *[]string:
+			// Written separated by commas.
+			var items []string
+			for _, item := range strings.Split(value, ",") {
+				items = append(items, strings.TrimSpace(item))
+			}
+			field.Set(reflect.ValueOf(items))
+		case *map[string]string:
+			// Written as key=value pairs separated by commas.
+			pairs := make(map[string]string)
+			for _, pair := range strings.Split(value, ",") {
+				k, v, ok := strings.Cut(pair, "=")
+				if !ok {
+					return fmt.Errorf("%s: %q isn't key=value", name, pair)
+				}
+				pairs[strings.TrimSpace(k)] = strings.TrimSpace(v)
+			}
+			field.Set(reflect.ValueOf(pairs))
+		}
+	}
+	return nil
+}
+
+// Settings that don't make sense, by their paths in the config file.
+type ConfigError struct {
+	ValidationError
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid config: " + e.messages()
+}
+
+// Check the settings make sense together.
+func (cfg *Config) Validate() error {
+	verr := &ConfigError{}
+	if cfg.Address == "" {
+		verr.add("address", "required", "is required")
+	}
+	switch cfg.Mode {
+	case gin.DebugMode, gin.ReleaseMode, gin.TestMode:
+	default:
+		verr.add("mode", "oneof", "must be one of debug, release or test")
+	}
+	if cfg.Database == "" {
+		verr.add("database", "required", "is required")
+	}
+	if cfg.StaleAfter <= 0 {
+		verr.add("stale_after", "gt", "must be greater than 0")
+	}
+	for i, ingress := range cfg.Ingresses {
+		if ingress == "" || len(ingress) > 128 {
+			verr.add(fmt.Sprintf("ingresses[%d]", i), "name", "must be 1 to 128 characters")
+		}
+	}
+	timeouts := []struct {
+		field   string
+		timeout Duration
+	}{{"read", cfg.Timeouts.Read}, {"read_header", cfg.Timeouts.ReadHeader}, {"write", cfg.Timeouts.Write}, {"idle", cfg.Timeouts.Idle}}
+	for _, t := range timeouts {
+		if t.timeout < 0 {
+			verr.add("timeouts."+t.field, "gte", "must be 0 or more")
+		}
+	}
+	if cfg.PMU.AutoRegister && cfg.PMU.TCP == "" && cfg.PMU.UDP == "" {
+		verr.add("pmu.auto_register", "requires", "needs pmu.tcp or pmu.udp")
+	}
+	if cfg.APIKeyFile != "" && !cfg.APIKeys {
+		verr.add("api_key_file", "requires", "needs api_keys")
+	}
+	if cfg.PrivateReads && !cfg.APIKeys && cfg.JWT.JWKS == "" {
+		verr.add("private_reads", "requires", "needs api_keys or jwt.jwks")
+	}
+	limits := []struct {
+		field, text string
+	}{{"read", cfg.RateLimits.Read}, {"write", cfg.RateLimits.Write}, {"expensive", cfg.RateLimits.Expensive}}
+	for _, l := range limits {
+		if _, err := ParseLimit(l.text); err != nil {
+			verr.add("rate_limits."+l.field, "limit", err.Error())
+		}
+	}
+	for i, proxy := range cfg.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			verr.add(fmt.Sprintf("trusted_proxies[%d]", i), "cidr", "must be an IP address or CIDR range")
+		}
+	}
+	if (cfg.TLS.Cert == "") != (cfg.TLS.Key == "") {
+		verr.add("tls", "requires", "cert and key must be set together")
+	}
+	if cfg.TLS.ClientCA != "" && cfg.TLS.Cert == "" {
+		verr.add("tls.client_ca", "requires", "needs tls.cert and tls.key")
+	}
+	if cfg.JWT.JWKS == "" && (cfg.JWT.Issuer != "" || cfg.JWT.Audience != "" || cfg.JWT.IngressClaim != "" || len(cfg.JWT.Roles) > 0) {
+		verr.add("jwt.jwks", "required", "is required for the other jwt settings")
+	}
+	switch cfg.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		verr.add("log.level", "oneof", "must be one of debug, info, warn or error")
+	}
+	if cfg.Log.Format != "json" && cfg.Log.Format != "text" {
+		verr.add("log.format", "oneof", "must be json or text")
+	}
+	switch cfg.Tracing.Exporter {
+	case "", "otlp", "stdout":
+	default:
+		verr.add("tracing.exporter", "oneof", "must be otlp or stdout")
+	}
+	if cfg.Tracing.Exporter != "otlp" && (cfg.Tracing.Endpoint != "" || cfg.Tracing.Insecure) {
+		verr.add("tracing.endpoint", "requires", "needs the otlp exporter")
+	}
+	if cfg.Tracing.Exporter != "stdout" && cfg.Tracing.File != "" {
+		verr.add("tracing.file", "requires", "needs the stdout exporter")
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		verr.add("tracing.sample_ratio", "range", "must be between 0 and 1")
+	}
+	if cfg.MaxUpload <= 0 {
+		verr.add("max_upload", "gt", "must be greater than 0")
+	}
+	for _, value := range sortedKeys(cfg.JWT.Roles) {
+		if _, ok := roleScopes[cfg.JWT.Roles[value]]; !ok {
+			verr.add("jwt.roles."+value, "oneof", "must be one of viewer, operator or admin")
+		}
+	}
+	if len(verr.Fields) == 0 {
+		return nil
+	}
+	return verr
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// The options to create a server with these settings.
+func (cfg *Config) options() (opts []Option, err error) {
+	var logger *slog.Logger
+	if logger, err = NewLogger(os.Stderr, cfg.Log.Level, cfg.Log.Format); err != nil {
+		return nil, err
+	}
+	opts = []Option{
+		WithLogger(logger),
+		WithDatabase(cfg.Database),
+		WithStaleAfter(time.Duration(cfg.StaleAfter)),
+		WithTimeouts(cfg.Timeouts),
+		WithMaxUpload(cfg.MaxUpload),
+	}
+	if cfg.Seed != "" {
+		opts = append(opts, WithSeed(cfg.Seed))
+	}
+	if cfg.LaxReferences {
+		opts = append(opts, WithLaxReferences())
+	}
+	if len(cfg.Ingresses) > 0 {
+		opts = append(opts, WithIngresses(cfg.Ingresses...))
+	}
+	if cfg.PMU.TCP != "" {
+		opts = append(opts, WithPMUListener("tcp", cfg.PMU.TCP))
+	}
+	if cfg.PMU.UDP != "" {
+		opts = append(opts, WithPMUListener("udp", cfg.PMU.UDP))
+	}
+	if cfg.PMU.AutoRegister {
+		opts = append(opts, WithPMUAutoRegister())
+	}
+	if cfg.APIKeys {
+		opts = append(opts, WithAPIKeys())
+	}
+	if cfg.APIKeyFile != "" {
+		opts = append(opts, WithAPIKeyFile(cfg.APIKeyFile))
+	}
+	if cfg.PrivateReads {
+		opts = append(opts, WithPrivateReads())
+	}
+
+	var limits RateLimits
+	if limits.Read, err = ParseLimit(cfg.RateLimits.Read); err != nil {
+		return nil, err
+	}
+	if limits.Write, err = ParseLimit(cfg.RateLimits.Write); err != nil {
+		return nil, err
+	}
+	if limits.Expensive, err = ParseLimit(cfg.RateLimits.Expensive); err != nil {
+		return nil, err
+	}
+	opts = append(opts, WithRateLimits(limits))
+	if len(cfg.TrustedProxies) > 0 {
+		opts = append(opts, WithTrustedProxies(cfg.TrustedProxies...))
+	}
+
+	if cfg.TLS.Cert != "" {
+		opts = append(opts, WithTLS(cfg.TLS.Cert, cfg.TLS.Key))
+	}
+	if cfg.TLS.ClientCA != "" {
+		opts = append(opts, WithClientCA(cfg.TLS.ClientCA))
+	}
+	if cfg.JWT.JWKS != "" {
+		opts = append(opts, WithJWT(JWTConfig{
+			JWKS:         cfg.JWT.JWKS,
+			Issuer:       cfg.JWT.Issuer,
+			Audience:     cfg.JWT.Audience,
+			RolesClaim:   cfg.JWT.RolesClaim,
+			RoleMap:      cfg.JWT.Roles,
+			IngressClaim: cfg.JWT.IngressClaim,
+		}))
+	}
+	return opts, nil
+}
+
+// Set the HTTP server's timeouts.
+func WithTimeouts(t Timeouts) Option {
+	return func(o *options) {
+		o.timeouts = t
+	}
+}
+
+// Validate the settings and create a server with them.
+func NewFromConfig(cfg Config) (*Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	opts, err := cfg.options()
+	if err != nil {
+		return nil, err
+	}
+	// Built last, as it may open an exporter or file that has to be
+	// shut down again if the server can't be created.
+	var tp *sdktrace.TracerProvider
+	if tp, err = NewTracerProvider(cfg.Tracing); err != nil {
+		return nil, err
+	}
+	if tp != nil {
+		opts = append(opts, WithTracerProvider(tp))
+	}
+	gin.SetMode(cfg.Mode)
+	server, err := New(cfg.Address, opts...)
+	if err != nil && tp != nil {
+		_ = tp.Shutdown(context.Background())
+	}
+	return server, err
+}
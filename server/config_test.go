@@ -0,0 +1,174 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func (suite *testSuite) TestLoadConfig() {
+	dir := suite.T().TempDir()
+	yamlPath := filepath.Join(dir, "pingthings.yaml")
+	suite.Nil(os.WriteFile(yamlPath, []byte(`
+address: :9090
+database: pingthings.db
+stale_after: 90s
+timeouts:
+  write: 0s
+rate_limits:
+  read: 20/s
+jwt:
+  jwks: jwks.json
+  roles:
+    ops: operator
+`), 0600))
+	tomlPath := filepath.Join(dir, "pingthings.toml")
+	suite.Nil(os.WriteFile(tomlPath, []byte(`
+address = ":9090"
+stale_after = "90s"
+
+[timeouts]
+write = "0s"
+`), 0600))
+
+	env := map[string]string{}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	// Unset settings keep their defaults.
+	cfg, err := LoadConfig("", lookup)
+	suite.Nil(err)
+	suite.Equal(DefaultConfig(), cfg)
+
+	for _, path := range []string{yamlPath, tomlPath} {
+		cfg, err = LoadConfig(path, lookup)
+		suite.Nil(err, path)
+		suite.Equal(":9090", cfg.Address)
+		suite.Equal(Duration(90*time.Second), cfg.StaleAfter)
+		suite.Equal(Duration(0), cfg.Timeouts.Write)
+		suite.Equal(defaultTimeouts.Read, cfg.Timeouts.Read)
+		suite.Nil(cfg.Validate())
+	}
+	cfg, err = LoadConfig(yamlPath, lookup)
+	suite.Nil(err)
+	suite.Equal("20/s", cfg.RateLimits.Read)
+	suite.Equal(map[string]string{"ops": "operator"}, cfg.JWT.Roles)
+
+	// The environment overrides the file.
+	env["PINGTHINGS_ADDRESS"] = ":7070"
+	env["PINGTHINGS_TIMEOUTS_WRITE"] = "30s"
+	env["PINGTHINGS_API_KEYS"] = "true"
+	env["PINGTHINGS_JWT_ROLES"] = "ops=admin, guests=viewer"
+	env["PINGTHINGS_TRACING_SAMPLE_RATIO"] = "0.25"
+	env["PINGTHINGS_INGRESSES"] = "brazil, chile"
+	env["PINGTHINGS_MAX_UPLOAD"] = "1048576"
+	cfg, err = LoadConfig(yamlPath, lookup)
+	suite.Nil(err)
+	suite.Equal(":7070", cfg.Address)
+	suite.Equal(Duration(30*time.Second), cfg.Timeouts.Write)
+	suite.True(cfg.APIKeys)
+	suite.Equal(map[string]string{"ops": "admin", "guests": "viewer"}, cfg.JWT.Roles)
+	suite.Equal("pingthings.db", cfg.Database)
+	suite.Equal(0.25, cfg.Tracing.SampleRatio)
+	suite.Equal([]string{"brazil", "chile"}, cfg.Ingresses)
+	suite.Equal(int64(1<<20), cfg.MaxUpload)
+
+	env["PINGTHINGS_API_KEYS"] = "maybe"
+	_, err = LoadConfig(yamlPath, lookup)
+	suite.NotNil(err)
+	delete(env, "PINGTHINGS_API_KEYS")
+
+	// Unknown settings and file types are rejected.
+	suite.Nil(os.WriteFile(yamlPath, []byte("adress: :9090\n"), 0600))
+	_, err = LoadConfig(yamlPath, lookup)
+	suite.NotNil(err)
+	suite.Nil(os.WriteFile(tomlPath, []byte("[tls]\ncrt = \"server.pem\"\n"), 0600))
+	_, err = LoadConfig(tomlPath, lookup)
+	suite.NotNil(err)
+	_, err = LoadConfig(filepath.Join(dir, "pingthings.json"), lookup)
+	suite.NotNil(err)
+}
+
+func (suite *testSuite) TestValidateConfig() {
+	cfg := DefaultConfig()
+	cfg.Mode = "production"
+	cfg.Timeouts.Idle = Duration(-time.Second)
+	cfg.APIKeyFile = "admin.key"
+	cfg.PrivateReads = true
+	cfg.RateLimits.Write = "10/d"
+	cfg.TrustedProxies = []string{"10.0.0.0/8", "proxy.internal"}
+	cfg.TLS.Cert = "server.pem"
+	cfg.JWT.Issuer = "https://issuer.example"
+	cfg.JWT.Roles = map[string]string{"ops": "root"}
+	cfg.Tracing.File = "spans.json"
+	cfg.Tracing.SampleRatio = 2
+	cfg.MaxUpload = 0
+
+	err := cfg.Validate()
+	var cerr *ConfigError
+	suite.True(errors.As(err, &cerr))
+	fields := []string{}
+	for _, field := range cerr.Fields {
+		fields = append(fields, field.Field)
+	}
+	suite.Equal([]string{"mode", "timeouts.idle", "api_key_file", "private_reads", "rate_limits.write", "trusted_proxies[1]", "tls", "jwt.jwks", "tracing.file", "tracing.sample_ratio", "max_upload", "jwt.roles.ops"}, fields)
+	suite.Contains(err.Error(), "invalid config: mode must be one of debug, release or test")
+
+	_, err = NewFromConfig(cfg)
+	suite.NotNil(err)
+}
+
+func (suite *testSuite) TestNewFromConfigCleansUp() {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		suite.T().Skip("open files can't be listed here")
+	}
+	spans := filepath.Join(suite.T().TempDir(), "spans.json")
+	open := func() bool {
+		fds, err = os.ReadDir("/proc/self/fd")
+		suite.Nil(err)
+		for _, fd := range fds {
+			if target, _ := os.Readlink(filepath.Join("/proc/self/fd", fd.Name())); target == spans {
+				return true
+			}
+		}
+		return false
+	}
+
+	// A server that can't be created doesn't leave its trace file open.
+	cfg := DefaultConfig()
+	cfg.Mode = "test"
+	cfg.Tracing.Exporter = "stdout"
+	cfg.Tracing.File = spans
+	cfg.TLS.Cert, cfg.TLS.Key = "missing.pem", "missing-key.pem"
+	_, err = NewFromConfig(cfg)
+	suite.NotNil(err)
+	suite.FileExists(spans)
+	suite.False(open())
+
+	cfg.TLS = TLSConfig{}
+	server, err := NewFromConfig(cfg)
+	suite.Nil(err)
+	suite.True(open())
+	server.shutdown()
+	suite.False(open())
+}
+
+func (suite *testSuite) TestServerTimeouts() {
+	cfg := DefaultConfig()
+	cfg.Mode = "test"
+	cfg.Timeouts.Write = 0
+	cfg.Timeouts.Idle = Duration(time.Minute)
+	server, err := NewFromConfig(cfg)
+	suite.Nil(err)
+	defer server.db.conn.Close()
+
+	suite.Equal(cfg.Address, server.srv.Addr)
+	suite.Equal(time.Minute, server.srv.ReadTimeout)
+	suite.Equal(10*time.Second, server.srv.ReadHeaderTimeout)
+	suite.Equal(time.Duration(0), server.srv.WriteTimeout)
+	suite.Equal(time.Minute, server.srv.IdleTimeout)
+}
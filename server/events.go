@@ -0,0 +1,87 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Events buffered per subscriber before new ones are dropped for it.
+const eventBuffer = 64
+
+// Something that happened on the server, pushed to /events subscribers.
+type Event struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// Fans events out to every subscriber. A subscriber that falls
+// behind misses events rather than blocking the publisher.
+type broker struct {
+	sync.Mutex
+	subscribers map[chan *Event]struct{}
+}
+
+func newBroker() *broker {
+	return &broker{subscribers: make(map[chan *Event]struct{})}
+}
+
+// Send an event to every current subscriber.
+func (b *broker) publish(eventType string, data interface{}) {
+	event := &Event{Type: eventType, Time: time.Now().UTC(), Data: data}
+
+	b.Lock()
+	defer b.Unlock()
+	for events := range b.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// Start receiving events. Call the returned function to stop.
+func (b *broker) subscribe() (<-chan *Event, func()) {
+	events := make(chan *Event, eventBuffer)
+
+	b.Lock()
+	b.subscribers[events] = struct{}{}
+	b.Unlock()
+
+	return events, func() {
+		b.Lock()
+		delete(b.subscribers, events)
+		b.Unlock()
+	}
+}
+
+// Stream server events as server-sent events. The optional type
+// query parameter keeps only events whose type starts with it.
+func (s *Server) streamEvents(c *gin.Context) {
+	prefix := c.Query("type")
+	events, unsubscribe := s.events.subscribe()
+	defer unsubscribe()
+
+	// Streams outlive the server's write timeout. Writers that can't
+	// have their deadline changed don't have one.
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-events:
+			if strings.HasPrefix(event.Type, prefix) {
+				c.SSEvent(event.Type, event)
+			}
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		case <-s.done:
+			return false
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How far past one period readings may drift before the space
+// between them counts as a gap, as a fraction of the period.
+const defaultGapTolerance = 0.5
+
+// A run of missing samples. Start is when the first missing sample
+// was due and End is when the next reading arrived.
+type Gap struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Missing  int       `json:"missing"`
+	Duration Duration  `json:"duration"`
+}
+
+// A timestamp stored more than once.
+type Duplicate struct {
+	Time  time.Time `json:"time"`
+	Count int       `json:"count"`
+}
+
+// Completeness of a sensor's readings against its nominal sample rate.
+// Received counts distinct timestamps, so duplicates don't inflate it.
+type GapReport struct {
+	Sensor       string       `json:"sensor"`
+	SampleRate   float64      `json:"sample_rate"`
+	Start        *time.Time   `json:"start,omitempty"`
+	End          *time.Time   `json:"end,omitempty"`
+	Expected     int          `json:"expected"`
+	Received     int          `json:"received"`
+	Completeness float64      `json:"completeness"`
+	Gaps         []*Gap       `json:"gaps"`
+	Duplicates   []*Duplicate `json:"duplicates"`
+}
+
+// Find the gaps and duplicates in readings ordered by time over
+// [start, end). A reading is expected at start and every period after.
+func analyzeGaps(readings []*Reading, start, end time.Time, period time.Duration, tolerance float64) (report *GapReport) {
+	report = &GapReport{Start: &start, End: &end, Gaps: []*Gap{}, Duplicates: []*Duplicate{}}
+	report.Expected = int(math.Round(float64(end.Sub(start)) / float64(period)))
+
+	limit := time.Duration(float64(period) * (1 + tolerance))
+	previous := start.Add(-period)
+	gap := func(next time.Time) {
+		delta := next.Sub(previous)
+		if delta <= limit {
+			return
+		}
+		first := previous.Add(period)
+		report.Gaps = append(report.Gaps, &Gap{
+			Start:    first,
+			End:      next,
+			Missing:  int(math.Round(float64(delta)/float64(period))) - 1,
+			Duration: Duration(next.Sub(first)),
+		})
+	}
+
+	for i := 0; i < len(readings); {
+		at := readings[i].Time
+		j := i + 1
+		for j < len(readings) && readings[j].Time.Equal(at) {
+			j++
+		}
+		if j-i > 1 {
+			report.Duplicates = append(report.Duplicates, &Duplicate{Time: at, Count: j - i})
+		}
+		i = j
+
+		gap(at)
+		previous = at
+		report.Received++
+	}
+	gap(end)
+
+	if report.Expected > 0 {
+		report.Completeness = math.Min(percent(report.Received, report.Expected), 100)
+	}
+	return report
+}
+
+// Report gaps, duplicates and completeness of a sensor's readings.
+// The range defaults to the first reading through one period past
+// the last, and the rate query parameter overrides the sensor's
+// sample rate.
+func (s *Server) getGaps(c *gin.Context) {
+	sensor, err := s.db.querySensor(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	rate := sensor.SampleRate
+	if value := c.Query("rate"); value != "" {
+		if rate, err = strconv.ParseFloat(value, 64); err != nil || rate <= 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "rate must be a positive number of samples per second"})
+			return
+		}
+	}
+	if rate <= 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": sensor.Name + " has no sample rate, set one or pass rate"})
+		return
+	}
+	period := time.Duration(float64(time.Second) / rate)
+	if period <= 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "rate must be at most one sample per nanosecond"})
+		return
+	}
+
+	tolerance := defaultGapTolerance
+	if value := c.Query("tolerance"); value != "" {
+		if tolerance, err = strconv.ParseFloat(value, 64); err != nil || tolerance < 0 || math.IsNaN(tolerance) || math.IsInf(tolerance, 0) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "tolerance must be a non-negative fraction of the period"})
+			return
+		}
+	}
+
+	start, end, err := parseTimeRange(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	readings, err := s.db.queryReadings(c.Request.Context(), sensor.Name, start, end, qualityFilter{})
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n := len(readings); n > 0 {
+		if start.IsZero() {
+			start = readings[0].Time
+		}
+		if end.IsZero() {
+			end = readings[n-1].Time.Add(period)
+		}
+	}
+
+	// Without readings an open range has nothing to measure against.
+	report := &GapReport{Gaps: []*Gap{}, Duplicates: []*Duplicate{}}
+	if !start.IsZero() && !end.IsZero() {
+		report = analyzeGaps(readings, start, end, period, tolerance)
+	}
+	report.Sensor = sensor.Name
+	report.SampleRate = rate
+	c.IndentedJSON(http.StatusOK, report)
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"time"
+)
+
+func (suite *testSuite) TestGaps() {
+	sensor := CreateSensor("F1MAG", "amps", "Anaheim", "foo", 0, 0)
+	sensor.SampleRate = 10
+	response := suite.request("POST", "/sensor", sensor)
+	suite.Equal(201, response.Code)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(samples int) time.Time {
+		return start.Add(time.Duration(samples) * 100 * time.Millisecond)
+	}
+	response = suite.request("POST", "/sensor/F1MAG/readings", []*Reading{
+		{Time: at(0), Value: 1},
+		{Time: at(1), Value: 1},
+		{Time: at(1), Value: 1},
+		{Time: at(2), Value: 1},
+		{Time: at(6), Value: 1},
+		{Time: at(7), Value: 1},
+	})
+	suite.Equal(201, response.Code)
+
+	report := suite.gaps("/sensor/F1MAG/gaps?start=2024-01-01T00:00:00Z&end=2024-01-01T00:00:01Z")
+	suite.Equal(10.0, report.SampleRate)
+	suite.Equal(10, report.Expected)
+	suite.Equal(5, report.Received)
+	suite.Equal(50.0, report.Completeness)
+	suite.Equal([]*Duplicate{{Time: at(1), Count: 2}}, report.Duplicates)
+
+	// Samples 3 to 5 went missing, and so did everything after 7.
+	suite.Len(report.Gaps, 2)
+	suite.Equal(&Gap{Start: at(3), End: at(6), Missing: 3, Duration: Duration(300 * time.Millisecond)}, report.Gaps[0])
+	suite.Equal(&Gap{Start: at(8), End: at(10), Missing: 2, Duration: Duration(200 * time.Millisecond)}, report.Gaps[1])
+
+	// Without a range it runs from the first reading to one period past the last.
+	report = suite.gaps("/sensor/F1MAG/gaps")
+	suite.Equal(at(0), *report.Start)
+	suite.Equal(at(8), *report.End)
+	suite.Equal(8, report.Expected)
+	suite.Len(report.Gaps, 1)
+
+	// A looser tolerance or a slower rate hides the short gaps.
+	suite.Empty(suite.gaps("/sensor/F1MAG/gaps?rate=2").Gaps)
+	suite.Len(suite.gaps("/sensor/F1MAG/gaps?tolerance=5").Gaps, 0)
+
+	report = suite.gaps("/sensor/F1MAG/gaps?start=2025-01-01T00:00:00Z")
+	suite.Nil(report.Start)
+	suite.Zero(report.Expected)
+
+	response = suite.request("GET", "/sensor/C1MAG/gaps", nil)
+	suite.Equal(400, response.Code)
+	response = suite.request("GET", "/sensor/F1MAG/gaps?rate=-1", nil)
+	suite.Equal(400, response.Code)
+	response = suite.request("GET", "/sensor/F1MAG/gaps?tolerance=x", nil)
+	suite.Equal(400, response.Code)
+	for _, query := range []string{"rate=NaN", "rate=Inf", "tolerance=NaN", "tolerance=+Inf"} {
+		response = suite.request("GET", "/sensor/F1MAG/gaps?"+query, nil)
+		suite.Equal(400, response.Code, query)
+	}
+	response = suite.request("GET", "/sensor/nope/gaps", nil)
+	suite.Equal(404, response.Code)
+}
+
+// Fetch and decode a gap report.
+func (suite *testSuite) gaps(url string) (report *GapReport) {
+	response := suite.request("GET", url, nil)
+	suite.Equal(200, response.Code)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &report))
+	return report
+}
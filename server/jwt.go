@@ -0,0 +1,352 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Least time between fetches of a JWKS URL for keys it didn't have.
+const jwksRefetchInterval = time.Minute
+
+// Longest a fetched JWKS is used before fetching it again, so keys
+// dropped by the issuer stop being trusted.
+const jwksMaxAge = time.Hour
+
+// Clock skew allowed when checking a token's times.
+const tokenLeeway = 30 * time.Second
+
+// Signing algorithms accepted. Anything else, HMAC and none
+// included, is rejected.
+var tokenMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// How bearer tokens are checked and turned into roles.
+type JWTConfig struct {
+	// JWKS file or http(s) URL holding the keys tokens are signed with.
+	JWKS     string
+	Issuer   string // Required iss claim, if set.
+	Audience string // Required aud claim, if set.
+	// Claim holding the token's roles, a list or a space separated
+	// string. Dots reach into nested claims, as in realm_access.roles.
+	// Defaults to roles.
+	RolesClaim string
+	// Role each claim value grants: viewer, operator or admin. By
+	// default the role names grant themselves.
+	RoleMap map[string]string
+	// Claim listing the ingresses a token is limited to. Tokens
+	// without the claim aren't limited.
+	IngressClaim string
+}
+
+// Accept bearer tokens signed by the keys in cfg.JWKS, with roles
+// mapped to the scopes of API keys: viewer reads, operator writes and
+// admin does everything.
+func WithJWT(cfg JWTConfig) Option {
+	return func(o *options) {
+		o.jwt = &cfg
+	}
+}
+
+// Checks bearer tokens against a key set.
+type tokenVerifier struct {
+	config JWTConfig
+	keys   *keySet
+	parser *jwt.Parser
+}
+
+func newTokenVerifier(cfg JWTConfig) (_ *tokenVerifier, err error) {
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = "roles"
+	}
+	if cfg.RoleMap == nil {
+		cfg.RoleMap = map[string]string{RoleViewer: RoleViewer, RoleOperator: RoleOperator, RoleAdmin: RoleAdmin}
+	}
+	for value, role := range cfg.RoleMap {
+		if _, ok := roleScopes[role]; !ok {
+			return nil, fmt.Errorf("claim value %s maps to unknown role %q", value, role)
+		}
+	}
+
+	verifier := &tokenVerifier{config: cfg}
+	if verifier.keys, err = loadKeySet(cfg.JWKS); err != nil {
+		return nil, err
+	}
+
+	opts := []jwt.ParserOption{jwt.WithValidMethods(tokenMethods), jwt.WithExpirationRequired(), jwt.WithLeeway(tokenLeeway)}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	verifier.parser = jwt.NewParser(opts...)
+	return verifier, nil
+}
+
+// Verify a token and work out its principal. A valid token granting
+// no role gets a principal without a scope.
+func (v *tokenVerifier) verify(raw string) (*principal, error) {
+	token, err := v.parser.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return v.keys.key(kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid bearer token: %w", err)
+	}
+	claims := token.Claims.(jwt.MapClaims)
+
+	p := &principal{}
+	if p.Name, err = claims.GetSubject(); err != nil {
+		return nil, fmt.Errorf("invalid bearer token: %w", err)
+	}
+	if value, ok := lookupClaim(claims, v.config.RolesClaim); ok {
+		for _, value := range claimStrings(value) {
+			scope := roleScopes[v.config.RoleMap[value]]
+			if scopeRanks[scope] > scopeRanks[p.Scope] {
+				p.Scope = scope
+			}
+		}
+	}
+	if v.config.IngressClaim != "" {
+		if value, ok := lookupClaim(claims, v.config.IngressClaim); ok {
+			p.Ingresses = append([]string{}, claimStrings(value)...)
+		}
+	}
+	return p, nil
+}
+
+// Find a claim by a dotted path through nested objects.
+func lookupClaim(claims map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = claims
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// The strings in a claim that's a list or a space separated string.
+func claimStrings(value interface{}) (values []string) {
+	switch value := value.(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+// Public keys from a JWKS file or URL, by key ID. URLs are fetched
+// again when a token names a key they didn't have, and once they've
+// grown old.
+type keySet struct {
+	source string
+	client *http.Client
+
+	mu         sync.Mutex
+	keys       map[string]interface{}
+	fetched    time.Time
+	minRefetch time.Duration // least time between fetches for unknown keys.
+}
+
+func loadKeySet(source string) (*keySet, error) {
+	if source == "" {
+		return nil, errors.New("a JWKS file or URL is required")
+	}
+	ks := &keySet{source: source, client: &http.Client{Timeout: 10 * time.Second}, minRefetch: jwksRefetchInterval}
+	if err := ks.load(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+func (ks *keySet) remote() bool {
+	return strings.HasPrefix(ks.source, "http://") || strings.HasPrefix(ks.source, "https://")
+}
+
+// Read and parse the key set, replacing the keys held.
+func (ks *keySet) load() (err error) {
+	var data []byte
+	if !ks.remote() {
+		if data, err = os.ReadFile(ks.source); err != nil {
+			return err
+		}
+	} else {
+		var response *http.Response
+		if response, err = ks.client.Get(ks.source); err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return fmt.Errorf("fetching %s: %s", ks.source, response.Status)
+		}
+		if data, err = io.ReadAll(io.LimitReader(response.Body, 1<<20)); err != nil {
+			return err
+		}
+	}
+
+	var keys map[string]interface{}
+	if keys, err = parseJWKS(data); err != nil {
+		return fmt.Errorf("%s: %w", ks.source, err)
+	}
+	ks.keys, ks.fetched = keys, time.Now()
+	return nil
+}
+
+// The key with an ID. Tokens without a key ID can use a set's only key.
+func (ks *keySet) key(kid string) (interface{}, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if ks.remote() && time.Since(ks.fetched) > jwksMaxAge {
+		if err := ks.load(); err != nil {
+			return nil, err
+		}
+	}
+	if key, ok := ks.find(kid); ok {
+		return key, nil
+	}
+	if ks.remote() && time.Since(ks.fetched) >= ks.minRefetch {
+		if err := ks.load(); err != nil {
+			return nil, err
+		}
+		if key, ok := ks.find(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (ks *keySet) find(kid string) (interface{}, bool) {
+	if key, ok := ks.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// A JSON Web Key, with the fields of the key types understood.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Parse the signing keys of a JWKS document: RSA, EC on the NIST
+// curves and Ed25519 keys. Others are skipped.
+func parseJWKS(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", jwk.Kid, err)
+		}
+		if key != nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys in the key set")
+	}
+	return keys, nil
+}
+
+// The key's public key, nil for unsupported key types.
+func (jwk *jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[jwk.Crv]
+		if !ok {
+			names := make([]string, 0, len(curves))
+			for name := range curves {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("curve %q isn't one of %s", jwk.Crv, strings.Join(names, ", "))
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point isn't on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("invalid base64url number %q", s)
+	}
+	return new(big.Int).SetBytes(data), nil
+}
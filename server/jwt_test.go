@@ -0,0 +1,216 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// A signing key for test tokens.
+type testSigner struct {
+	kid string
+	key *ecdsa.PrivateKey
+}
+
+func (suite *testSuite) newSigner(kid string) *testSigner {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	suite.Nil(err)
+	return &testSigner{kid: kid, key: key}
+}
+
+// The JWKS document publishing the signers' public keys.
+func (suite *testSuite) jwks(signers ...*testSigner) []byte {
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	keys := []map[string]string{}
+	for _, signer := range signers {
+		keys = append(keys, map[string]string{
+			"kty": "EC",
+			"kid": signer.kid,
+			"use": "sig",
+			"crv": "P-256",
+			"x":   encode(signer.key.X.FillBytes(make([]byte, 32))),
+			"y":   encode(signer.key.Y.FillBytes(make([]byte, 32))),
+		})
+	}
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	suite.Nil(err)
+	return data
+}
+
+// Sign a token expiring in an hour, with claims added to or
+// overriding the defaults.
+func (suite *testSuite) token(signer *testSigner, claims jwt.MapClaims) string {
+	all := jwt.MapClaims{"sub": "tester", "iss": "https://issuer.test", "exp": time.Now().Add(time.Hour).Unix()}
+	for name, value := range claims {
+		all[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, all)
+	token.Header["kid"] = signer.kid
+	signed, err := token.SignedString(signer.key)
+	suite.Nil(err)
+	return signed
+}
+
+// Send a request through the router with a bearer token.
+func (suite *testSuite) requestWithToken(token, method, url string, body interface{}) *httptest.ResponseRecorder {
+	var requestBody io.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		suite.Nil(err)
+		requestBody = bytes.NewBuffer(bodyBytes)
+	}
+
+	request := httptest.NewRequest(method, url, requestBody)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+	suite.srv.gin.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func (suite *testSuite) TestBearerTokens() {
+	signer := suite.newSigner("one")
+	path := filepath.Join(suite.T().TempDir(), "jwks.json")
+	suite.Nil(os.WriteFile(path, suite.jwks(signer), 0600))
+
+	var err error
+	suite.srv, err = New("fakeaddress", WithSeed("testdata/sensors.json"), WithJWT(JWTConfig{JWKS: path, Issuer: "https://issuer.test"}))
+	suite.Nil(err)
+
+	viewer := suite.token(signer, jwt.MapClaims{"roles": []string{RoleViewer}})
+	operator := suite.token(signer, jwt.MapClaims{"roles": "viewer operator"})
+	admin := suite.token(signer, jwt.MapClaims{"roles": []string{RoleAdmin}})
+	update := CreateSensor("L1ANG", "deg", "Anaheim", "bar", 33.8, 117.9)
+
+	// Viewers read, operators change sensors, admins delete them.
+	suite.Equal(200, suite.requestWithToken(viewer, "GET", "/sensor/L1ANG", nil).Code)
+	suite.Equal(403, suite.requestWithToken(viewer, "PUT", "/sensor/L1ANG", update).Code)
+	suite.Equal(200, suite.requestWithToken(operator, "PUT", "/sensor/L1ANG", update).Code)
+	suite.Equal(403, suite.requestWithToken(operator, "DELETE", "/sensor/L1ANG", nil).Code)
+	suite.Equal(204, suite.requestWithToken(admin, "DELETE", "/sensor/L1ANG", nil).Code)
+	suite.Equal(401, suite.request("PUT", "/sensor/L1MAG", update).Code)
+
+	// A valid token without a role can't change anything.
+	suite.Equal(403, suite.requestWithToken(suite.token(signer, nil), "PUT", "/sensor/L1MAG", update).Code)
+
+	// Tokens that are expired, from another issuer, signed by an unknown
+	// key or with a shared secret are rejected.
+	expired := suite.token(signer, jwt.MapClaims{"roles": "admin", "exp": time.Now().Add(-time.Hour).Unix()})
+	wrongIssuer := suite.token(signer, jwt.MapClaims{"roles": "admin", "iss": "https://elsewhere.test"})
+	unknown := suite.token(suite.newSigner("two"), jwt.MapClaims{"roles": "admin"})
+	hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "x", "roles": "admin", "exp": time.Now().Add(time.Hour).Unix()}).SignedString([]byte("secret"))
+	suite.Nil(err)
+	for _, token := range []string{expired, wrongIssuer, unknown, hmac, "garbage"} {
+		response := suite.requestWithToken(token, "GET", "/sensor/L1MAG", nil)
+		suite.Equal(401, response.Code)
+		suite.Contains(response.Header().Get("WWW-Authenticate"), "Bearer")
+	}
+
+	// Without API keys there are none to manage.
+	suite.Equal(404, suite.requestWithToken(admin, "GET", "/keys", nil).Code)
+}
+
+func (suite *testSuite) TestTokenIngresses() {
+	signer := suite.newSigner("one")
+	path := filepath.Join(suite.T().TempDir(), "jwks.json")
+	suite.Nil(os.WriteFile(path, suite.jwks(signer), 0600))
+
+	var err error
+	suite.srv, err = New("fakeaddress", WithSeed("testdata/sensors.json"), WithJWT(JWTConfig{JWKS: path, IngressClaim: "ingresses"}))
+	suite.Nil(err)
+	limited := suite.token(signer, jwt.MapClaims{"roles": "operator", "ingresses": []string{"Anaheim"}})
+
+	// Only sensors of the token's ingresses are listed or reachable.
+	response := suite.requestWithToken(limited, "GET", "/allsensors", nil)
+	suite.Equal(200, response.Code)
+	var sensors []*Sensor
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &sensors))
+	suite.Len(sensors, 1)
+	suite.Equal("L1ANG", sensors[0].Name)
+	suite.Equal(403, suite.requestWithToken(limited, "GET", "/sensor/L1MAG", nil).Code)
+	suite.Equal(403, suite.requestWithToken(limited, "GET", "/sensor/L1MAG/readings", nil).Code)
+
+	// Sensors can't be added to or moved into other ingresses.
+	suite.Equal(201, suite.requestWithToken(limited, "POST", "/sensor", CreateSensor("NEW1", "V", "Anaheim", "bar", 1, 2)).Code)
+	suite.Equal(403, suite.requestWithToken(limited, "POST", "/sensor", CreateSensor("NEW2", "V", "New Zealand", "bar", 1, 2)).Code)
+	suite.Equal(403, suite.requestWithToken(limited, "PUT", "/sensor/NEW1", CreateSensor("NEW1", "V", "New Zealand", "bar", 1, 2)).Code)
+
+	// Routes that could reach sensors of other ingresses are closed to it.
+	for _, route := range []struct{ method, url string }{
+		{"GET", "/alarms"},
+		{"GET", "/events"},
+		{"GET", "/assets/x/sensors"},
+		{"GET", "/distillers/foo/sensors"},
+		{"GET", "/phasors/L1/values"},
+		{"GET", "/records/1/channels/IA"},
+		{"POST", "/records"},
+		{"POST", "/phasors"},
+		{"POST", "/alarms/rules"},
+	} {
+		response = suite.requestWithToken(limited, route.method, route.url, nil)
+		suite.Equal(403, response.Code, route.url)
+		suite.Contains(response.Body.String(), "limited to ingresses Anaheim")
+	}
+	suite.Equal(200, suite.requestWithToken(limited, "GET", "/ingresses/Anaheim/sensors", nil).Code)
+	suite.Equal(403, suite.requestWithToken(limited, "GET", "/ingresses/New%20Zealand/sensors", nil).Code)
+	suite.Equal(200, suite.requestWithToken(limited, "GET", "/sensors/stale", nil).Code)
+	suite.Equal(404, suite.requestWithToken(limited, "GET", "/nowhere", nil).Code)
+
+	// Tokens without the claim aren't limited.
+	unlimited := suite.token(signer, jwt.MapClaims{"roles": "viewer"})
+	suite.Equal(200, suite.requestWithToken(unlimited, "GET", "/sensor/L1MAG", nil).Code)
+}
+
+func (suite *testSuite) TestJWKSRotation() {
+	var mu sync.Mutex
+	first, second := suite.newSigner("first"), suite.newSigner("second")
+	published := suite.jwks(first)
+	fetches := 0
+	issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		w.Write(published)
+	}))
+	defer issuer.Close()
+
+	var err error
+	suite.srv, err = New("fakeaddress", WithSeed("testdata/sensors.json"), WithJWT(JWTConfig{
+		JWKS:       issuer.URL,
+		RolesClaim: "realm_access.roles",
+		RoleMap:    map[string]string{"grid-ops": RoleOperator},
+	}))
+	suite.Nil(err)
+	update := CreateSensor("L1ANG", "deg", "Anaheim", "bar", 33.8, 117.9)
+	claims := jwt.MapClaims{"realm_access": map[string]interface{}{"roles": []string{"grid-ops"}}}
+	suite.Equal(200, suite.requestWithToken(suite.token(first, claims), "PUT", "/sensor/L1ANG", update).Code)
+
+	// A token from a key published after the set was fetched is
+	// rejected until the set may be fetched again.
+	mu.Lock()
+	published = suite.jwks(first, second)
+	mu.Unlock()
+	suite.Equal(401, suite.requestWithToken(suite.token(second, claims), "PUT", "/sensor/L1ANG", update).Code)
+	suite.srv.tokens.keys.minRefetch = 0
+	suite.Equal(200, suite.requestWithToken(suite.token(second, claims), "PUT", "/sensor/L1ANG", update).Code)
+	suite.Equal(2, fetches)
+
+	// Role names that aren't mapped grant nothing.
+	unmapped := jwt.MapClaims{"realm_access": map[string]interface{}{"roles": []string{RoleAdmin}}}
+	suite.Equal(403, suite.requestWithToken(suite.token(first, unmapped), "PUT", "/sensor/L1ANG", update).Code)
+
+	_, err = New("fakeaddress", WithJWT(JWTConfig{JWKS: issuer.URL, RoleMap: map[string]string{"x": "root"}}))
+	suite.NotNil(err)
+}
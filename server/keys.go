@@ -0,0 +1,266 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Header clients send their API key in.
+const APIKeyHeader = "X-API-Key"
+
+// Prefix of every API key, followed by 48 hex digits.
+const apiKeyPrefix = "pk_"
+
+// How often a key's last use is written back to the store.
+const apiKeyTouchInterval = time.Minute
+
+var errAPIKeyNotFound = errors.New("API key not found in store")
+
+// What an API key or bearer token may do. Each scope includes the ones
+// before it: read, then write, then admin.
+type Scope string
+
+const (
+	ScopeRead  Scope = "read"  // GET requests.
+	ScopeWrite Scope = "write" // requests that change data.
+	ScopeAdmin Scope = "admin" // managing API keys, deletes and configuration.
+)
+
+var scopeRanks = map[Scope]int{ScopeRead: 1, ScopeWrite: 2, ScopeAdmin: 3}
+
+// Whether a key with scope s may make a request needing required.
+func (s Scope) allows(required Scope) bool {
+	return scopeRanks[s] >= scopeRanks[required]
+}
+
+// An API key. Only a hash of the key is stored, so Key is set just
+// once, in the response that creates it.
+type APIKey struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Scope     Scope      `json:"scope"`
+	Prefix    string     `json:"prefix"` // Start of the key, to tell keys apart.
+	CreatedAt time.Time  `json:"created_at"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Key       string     `json:"key,omitempty"`
+}
+
+// Hex SHA-256 of a key, which is what the store looks keys up by.
+// Keys are long and random, so a fast hash is enough.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Columns selected by API key queries, in the order queryAPIKeysWhere scans them.
+const apiKeyColumns = `id, name, scope, prefix, created_at, last_used, revoked_at`
+
+// Query the API keys matching a WHERE clause, oldest first.
+func (db *store) queryAPIKeysWhere(ctx context.Context, where string, args ...interface{}) (keys []*APIKey, err error) {
+	selectStatement := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE ` + where + ` ORDER BY id`
+
+	var rows *timedRows
+	if rows, err = db.conn.QueryContext(ctx, selectStatement, args...); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var created int64
+		var lastUsed, revoked sql.NullInt64
+		key := &APIKey{}
+		if err = rows.Scan(&key.ID, &key.Name, &key.Scope, &key.Prefix, &created, &lastUsed, &revoked); err != nil {
+			return nil, err
+		}
+		key.CreatedAt = time.Unix(0, created).UTC()
+		if lastUsed.Valid {
+			at := time.Unix(0, lastUsed.Int64).UTC()
+			key.LastUsed = &at
+		}
+		if revoked.Valid {
+			at := time.Unix(0, revoked.Int64).UTC()
+			key.RevokedAt = &at
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// Query the unrevoked API key with the given key.
+func (db *store) queryAPIKey(ctx context.Context, key string) (*APIKey, error) {
+	keys, err := db.queryAPIKeysWhere(ctx, `hash = ? AND revoked_at IS NULL`, hashAPIKey(key))
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, errAPIKeyNotFound
+	}
+	return keys[0], nil
+}
+
+// Generate and store a new key, returned with Key set.
+func (db *store) createAPIKey(ctx context.Context, name string, scope Scope) (_ *APIKey, err error) {
+	secret := make([]byte, 24)
+	if _, err = rand.Read(secret); err != nil {
+		return nil, err
+	}
+	key := &APIKey{
+		Name:      name,
+		Scope:     scope,
+		CreatedAt: time.Now().UTC(),
+		Key:       apiKeyPrefix + hex.EncodeToString(secret),
+	}
+	key.Prefix = key.Key[:len(apiKeyPrefix)+8]
+
+	var result sql.Result
+	insertStatement := `
+		INSERT INTO api_keys (name, scope, prefix, hash, created_at)
+		VALUES(?, ?, ?, ?, ?)`
+	if result, err = db.conn.ExecContext(ctx, insertStatement, key.Name, key.Scope, key.Prefix, hashAPIKey(key.Key), key.CreatedAt.UnixNano()); err != nil {
+		return nil, err
+	}
+	if key.ID, err = result.LastInsertId(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Revoke an API key. Revoked keys are kept so they show up in listings.
+func (db *store) revokeAPIKey(ctx context.Context, id int64, at time.Time) (err error) {
+	var result sql.Result
+	updateStatement := `UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`
+	if result, err = db.conn.ExecContext(ctx, updateStatement, at.UnixNano(), id); err != nil {
+		return err
+	}
+	return requireAffected(result, errAPIKeyNotFound)
+}
+
+// Record the use of an API key.
+func (db *store) touchAPIKey(ctx context.Context, id int64, at time.Time) (err error) {
+	_, err = db.conn.ExecContext(ctx, `UPDATE api_keys SET last_used = ? WHERE id = ?`, at.UnixNano(), id)
+	return err
+}
+
+// Make sure there's a way in once keys are required: when no admin key
+// is left, create one and write it to path, or standard error when
+// path is empty.
+func (s *Server) bootstrapAPIKey(ctx context.Context, path string) (err error) {
+	admins, err := s.db.queryAPIKeysWhere(ctx, `scope = ? AND revoked_at IS NULL`, ScopeAdmin)
+	if err != nil || len(admins) > 0 {
+		return err
+	}
+
+	// The key never goes through the logger, whose records may be
+	// shipped elsewhere. A file is created before the key so an
+	// existing one isn't overwritten or a key lost.
+	var out io.Writer = os.Stderr
+	if path != "" {
+		var file *os.File
+		if file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600); err != nil {
+			return fmt.Errorf("writing the admin API key: %w", err)
+		}
+		defer func() {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+			}
+		}()
+		out = file
+	}
+
+	key, err := s.db.createAPIKey(ctx, "bootstrap", ScopeAdmin)
+	if err != nil {
+		return err
+	}
+	if path != "" {
+		_, err = fmt.Fprintln(out, key.Key)
+	} else {
+		_, err = fmt.Fprintf(out, "Admin API key, which won't be shown again: %s\n", key.Key)
+	}
+	if err != nil {
+		return err
+	}
+	// Logged as a warning so it shows at every level but error.
+	s.logger.Warn("created admin API key", "id", key.ID, "prefix", key.Prefix, "file", path)
+	return nil
+}
+
+// Check a new API key's fields.
+func validateAPIKey(key *APIKey) error {
+	verr := &ValidationError{}
+	if key == nil {
+		verr.add("key", "required", "is required")
+		return verr
+	}
+	if strings.TrimSpace(key.Name) == "" || len(key.Name) > 128 {
+		verr.add("name", "required", "is required and at most 128 characters")
+	}
+	if _, ok := scopeRanks[key.Scope]; !ok {
+		verr.add("scope", "oneof", "must be one of read, write or admin")
+	}
+	return verr.orNil()
+}
+
+// List the API keys, revoked ones included.
+func (s *Server) listAPIKeys(c *gin.Context) {
+	keys, err := s.db.queryAPIKeysWhere(c.Request.Context(), `1 = 1`)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if keys == nil {
+		keys = []*APIKey{}
+	}
+	c.IndentedJSON(http.StatusOK, keys)
+}
+
+// Create an API key. The response is the only time the key is shown.
+func (s *Server) createAPIKey(c *gin.Context) {
+	var key *APIKey
+	if err := c.BindJSON(&key); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateAPIKey(key); err != nil {
+		abortInvalid(c, err)
+		return
+	}
+
+	created, err := s.db.createAPIKey(c.Request.Context(), key.Name, key.Scope)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, created)
+}
+
+// Revoke an API key.
+func (s *Server) revokeAPIKey(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": errAPIKeyNotFound.Error()})
+		return
+	}
+	if err = s.db.revokeAPIKey(c.Request.Context(), id, time.Now()); err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Send a request through the router with an API key, marshaling body
+// as JSON if set.
+func (suite *testSuite) requestWithKey(key, method, url string, body interface{}) *httptest.ResponseRecorder {
+	var requestBody io.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		suite.Nil(err)
+		requestBody = bytes.NewBuffer(bodyBytes)
+	}
+
+	request := httptest.NewRequest(method, url, requestBody)
+	request.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		request.Header.Set(APIKeyHeader, key)
+	}
+
+	recorder := httptest.NewRecorder()
+	suite.srv.gin.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func (suite *testSuite) TestAPIKeys() {
+	var logs bytes.Buffer
+	logger, err := NewLogger(&logs, "info", "json")
+	suite.Nil(err)
+	keyFile := filepath.Join(suite.T().TempDir(), "admin.key")
+	suite.srv, err = New("fakeaddress", WithSeed("testdata/sensors.json"), WithAPIKeys(), WithAPIKeyFile(keyFile), WithLogger(logger))
+	suite.Nil(err)
+
+	// A bootstrap admin key is created when there's none, written to
+	// the key file only.
+	keys, err := suite.srv.db.queryAPIKeysWhere(context.Background(), `1 = 1`)
+	suite.Nil(err)
+	suite.Len(keys, 1)
+	suite.Equal(ScopeAdmin, keys[0].Scope)
+	info, err := os.Stat(keyFile)
+	suite.Nil(err)
+	suite.Equal(os.FileMode(0600), info.Mode().Perm())
+	bootstrap, err := os.ReadFile(keyFile)
+	suite.Nil(err)
+	suite.Equal(200, suite.requestWithKey(strings.TrimSpace(string(bootstrap)), "GET", "/keys", nil).Code)
+	suite.Contains(logs.String(), "created admin API key")
+	suite.NotContains(logs.String(), strings.TrimSpace(string(bootstrap)))
+
+	// Existing files aren't overwritten.
+	_, err = New("fakeaddress", WithAPIKeys(), WithAPIKeyFile(keyFile))
+	suite.NotNil(err)
+	admin, err := suite.srv.db.createAPIKey(context.Background(), "test", ScopeAdmin)
+	suite.Nil(err)
+
+	create := func(scope Scope) *APIKey {
+		response := suite.requestWithKey(admin.Key, "POST", "/keys", &APIKey{Name: string(scope), Scope: scope})
+		suite.Equal(201, response.Code)
+		var key APIKey
+		suite.Nil(json.Unmarshal(response.Body.Bytes(), &key))
+		suite.Equal(key.Prefix, key.Key[:len(key.Prefix)])
+		return &key
+	}
+	reader, writer := create(ScopeRead), create(ScopeWrite)
+
+	// Reads stay open, changes need a write key.
+	sensor := CreateSensor("NEW1", "V", "Anaheim", "bar", 1, 2)
+	suite.Equal(200, suite.requestWithKey("", "GET", "/sensor/L1MAG", nil).Code)
+	suite.Equal(401, suite.requestWithKey("", "POST", "/sensor", sensor).Code)
+	suite.Equal(401, suite.requestWithKey("pk_bogus", "POST", "/sensor", sensor).Code)
+	suite.Equal(403, suite.requestWithKey(reader.Key, "POST", "/sensor", sensor).Code)
+	suite.Equal(201, suite.requestWithKey(writer.Key, "POST", "/sensor", sensor).Code)
+
+	// Deleting records with history and changing configuration needs
+	// admin.
+	suite.Equal(403, suite.requestWithKey(writer.Key, "DELETE", "/sensor/NEW1", nil).Code)
+	suite.Equal(403, suite.requestWithKey(writer.Key, "POST", "/ingresses", &Component{Name: "Fresno"}).Code)
+	suite.Equal(201, suite.requestWithKey(admin.Key, "POST", "/ingresses", &Component{Name: "Fresno"}).Code)
+
+	// Managing keys needs admin.
+	suite.Equal(403, suite.requestWithKey(writer.Key, "GET", "/keys", nil).Code)
+	response := suite.requestWithKey(admin.Key, "GET", "/keys", nil)
+	suite.Equal(200, response.Code)
+	suite.NotContains(response.Body.String(), writer.Key)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &keys))
+	suite.Len(keys, 4)
+	suite.NotNil(keys[1].LastUsed)
+
+	// Revoked keys stop working but are still listed.
+	id := strconv.FormatInt(writer.ID, 10)
+	suite.Equal(204, suite.requestWithKey(admin.Key, "DELETE", "/keys/"+id, nil).Code)
+	suite.Equal(404, suite.requestWithKey(admin.Key, "DELETE", "/keys/"+id, nil).Code)
+	suite.Equal(401, suite.requestWithKey(writer.Key, "DELETE", "/sensor/NEW1", nil).Code)
+	response = suite.requestWithKey(admin.Key, "GET", "/keys", nil)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &keys))
+	suite.NotNil(keys[3].RevokedAt)
+
+	response = suite.requestWithKey(admin.Key, "POST", "/keys", &APIKey{Name: "x", Scope: "root"})
+	suite.Equal(400, response.Code)
+}
+
+func (suite *testSuite) TestPrivateReads() {
+	var err error
+	suite.srv, err = New("fakeaddress", WithSeed("testdata/sensors.json"), WithAPIKeys(), WithPrivateReads())
+	suite.Nil(err)
+	reader, err := suite.srv.db.createAPIKey(context.Background(), "reader", ScopeRead)
+	suite.Nil(err)
+
+	suite.Equal(401, suite.requestWithKey("", "GET", "/sensor/L1MAG", nil).Code)
+	suite.Equal(200, suite.requestWithKey(reader.Key, "GET", "/sensor/L1MAG", nil).Code)
+	suite.Equal(200, suite.requestWithKey("", "GET", "/health", nil).Code)
+
+	// Without keys or tokens there'd be no way to read.
+	_, err = New("fakeaddress", WithPrivateReads())
+	suite.NotNil(err)
+}
+
+func (suite *testSuite) TestNoAPIKeys() {
+	// Without keys required, there's nothing to manage them with.
+	suite.Equal(404, suite.request("POST", "/keys", &APIKey{Name: "x", Scope: ScopeAdmin}).Code)
+	suite.Equal(201, suite.request("POST", "/sensor", CreateSensor("NEW1", "V", "Anaheim", "bar", 1, 2)).Code)
+}
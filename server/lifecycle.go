@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Lifecycle states of a sensor.
+const (
+	StatePlanned        = "planned"
+	StateCommissioned   = "commissioned"
+	StateActive         = "active"
+	StateMaintenance    = "maintenance"
+	StateDecommissioned = "decommissioned"
+)
+
+// Every lifecycle state, in the order a sensor moves through them.
+var lifecycleStates = []string{StatePlanned, StateCommissioned, StateActive, StateMaintenance, StateDecommissioned}
+
+// Legal transitions out of each state. Decommissioning is final.
+var stateTransitions = map[string][]string{
+	StatePlanned:        {StateCommissioned, StateDecommissioned},
+	StateCommissioned:   {StateActive, StateDecommissioned},
+	StateActive:         {StateMaintenance, StateDecommissioned},
+	StateMaintenance:    {StateActive, StateDecommissioned},
+	StateDecommissioned: {},
+}
+
+// A recorded change of a sensor's lifecycle state. From is
+// empty for the state a sensor was created in.
+type Transition struct {
+	From   string    `json:"from,omitempty"`
+	To     string    `json:"to"`
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Body of a transition request.
+type TransitionRequest struct {
+	To     string `json:"to"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Returned when a transition isn't allowed from a sensor's current state.
+type transitionError struct {
+	from, to string
+}
+
+func (e *transitionError) Error() string {
+	allowed := stateTransitions[e.from]
+	if len(allowed) == 0 {
+		return fmt.Sprintf("cannot transition from %s to %s, %s is final", e.from, e.to, e.from)
+	}
+	return fmt.Sprintf("cannot transition from %s to %s, allowed: %s", e.from, e.to, strings.Join(allowed, ", "))
+}
+
+func canTransition(from, to string) bool {
+	for _, allowed := range stateTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Record a transition in a sensor's history.
+func insertTransition(ctx context.Context, tx execer, name string, transition *Transition) (err error) {
+	insertStatement := `
+		INSERT INTO transitions (sensor, from_state, to_state, at, reason)
+		VALUES(?, ?, ?, ?, ?)`
+	_, err = tx.ExecContext(ctx, insertStatement, name, transition.From, transition.To, transition.At.UnixNano(), transition.Reason)
+	return err
+}
+
+// Move a sensor to a new state if the transition is legal.
+func (db *store) transitionSensor(ctx context.Context, name string, request *TransitionRequest) (transition *Transition, err error) {
+	var tx *timedTx
+	if tx, err = db.conn.BeginTx(ctx, nil); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var current string
+	if err = tx.QueryRowContext(ctx, `SELECT state FROM sensors WHERE name = ?`, name).Scan(&current); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errSensorNotFound
+		}
+		return nil, err
+	}
+	if !canTransition(current, request.To) {
+		return nil, &transitionError{from: current, to: request.To}
+	}
+
+	transition = &Transition{From: current, To: request.To, At: time.Now().UTC(), Reason: request.Reason}
+	updateStatement := `UPDATE sensors SET state = ?, state_since = ? WHERE name = ?`
+	if _, err = tx.ExecContext(ctx, updateStatement, transition.To, transition.At.UnixNano(), name); err != nil {
+		return nil, err
+	}
+	if err = insertTransition(ctx, tx, name, transition); err != nil {
+		return nil, err
+	}
+
+	return transition, db.commitSensors(tx)
+}
+
+// Query a sensor's transition history, oldest first.
+func (db *store) queryTransitions(ctx context.Context, name string) (transitions []*Transition, err error) {
+	var rows *timedRows
+	selectStatement := `
+		SELECT from_state, to_state, at, reason
+		FROM transitions
+		WHERE sensor = ?
+		ORDER BY at, rowid`
+	if rows, err = db.conn.QueryContext(ctx, selectStatement, name); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var at int64
+		transition := &Transition{}
+		if err = rows.Scan(&transition.From, &transition.To, &at, &transition.Reason); err != nil {
+			return nil, err
+		}
+		transition.At = time.Unix(0, at).UTC()
+		transitions = append(transitions, transition)
+	}
+
+	return transitions, rows.Err()
+}
+
+// Query the sensors in any of the given states.
+func (db *store) querySensorsInStates(ctx context.Context, states []string) (sensors []*Sensor, err error) {
+	if len(states) == 0 {
+		return db.queryAllSensors(ctx)
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(states)), ", ")
+	args := make([]interface{}, len(states))
+	for i, state := range states {
+		args[i] = state
+	}
+	return db.querySensorsWhere(ctx, "state IN ("+placeholders+")", args...)
+}
+
+// Parse the state query parameter, a comma separated list of states or
+// "all". By default every state except decommissioned is included.
+// Returns nil for all states.
+func parseStateFilter(c *gin.Context) ([]string, error) {
+	value := c.Query("state")
+	if value == "all" {
+		return nil, nil
+	}
+	if value == "" {
+		return lifecycleStates[:len(lifecycleStates)-1], nil
+	}
+
+	states := strings.Split(value, ",")
+	for _, state := range states {
+		if _, ok := stateTransitions[state]; !ok {
+			return nil, fmt.Errorf("unknown state %q", state)
+		}
+	}
+	return states, nil
+}
+
+// Move a sensor to another lifecycle state.
+func (s *Server) transitionSensor(c *gin.Context) {
+	var request *TransitionRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, ok := stateTransitions[request.To]; !ok {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("to must be one of %s", strings.Join(lifecycleStates, ", "))})
+		return
+	}
+
+	_, err := s.db.transitionSensor(c.Request.Context(), c.Param("name"), request)
+	var illegal *transitionError
+	switch {
+	case errors.As(err, &illegal):
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	s.respondWithSensor(c, http.StatusOK, c.Param("name"))
+}
+
+// List a sensor's lifecycle transitions.
+func (s *Server) listTransitions(c *gin.Context) {
+	if _, err := s.db.querySensor(c.Request.Context(), c.Param("name")); err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	transitions, err := s.db.queryTransitions(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, transitions)
+}
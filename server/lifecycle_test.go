@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+)
+
+func (suite *testSuite) TestLifecycle() {
+	sensor := CreateSensor("P1MAG", "kV", "Anaheim", "foo", 0.1, 0.1)
+	sensor.State = StatePlanned
+	response := suite.request("POST", "/sensor", sensor)
+	suite.Equal(201, response.Code)
+
+	// Skipping commissioning isn't allowed.
+	response = suite.request("POST", "/sensor/P1MAG/transition", &TransitionRequest{To: StateActive})
+	suite.Equal(409, response.Code)
+
+	for _, state := range []string{StateCommissioned, StateActive, StateMaintenance, StateActive} {
+		response = suite.request("POST", "/sensor/P1MAG/transition", &TransitionRequest{To: state, Reason: "test"})
+		suite.Equal(200, response.Code)
+
+		var transitioned *Sensor
+		suite.Nil(json.Unmarshal(response.Body.Bytes(), &transitioned))
+		suite.Equal(state, transitioned.State)
+	}
+
+	// State can't be changed with a plain update.
+	sensor.State = StateMaintenance
+	response = suite.request("PUT", "/sensor/P1MAG", sensor)
+	suite.Equal(400, response.Code)
+
+	response = suite.request("POST", "/sensor/P1MAG/transition", &TransitionRequest{To: StateDecommissioned})
+	suite.Equal(200, response.Code)
+	response = suite.request("POST", "/sensor/P1MAG/transition", &TransitionRequest{To: StateActive})
+	suite.Equal(409, response.Code)
+
+	response = suite.request("GET", "/sensor/P1MAG/transitions", nil)
+	suite.Equal(200, response.Code)
+
+	var transitions []*Transition
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &transitions))
+	suite.Len(transitions, 6)
+	suite.Equal(&Transition{To: StatePlanned, At: transitions[0].At, Reason: "created"}, transitions[0])
+	suite.Equal(StateActive, transitions[5].From)
+	suite.Equal(StateDecommissioned, transitions[5].To)
+
+	// Decommissioned sensors are hidden unless asked for.
+	names := func(url string) (names []string) {
+		response := suite.request("GET", url, nil)
+		suite.Equal(200, response.Code)
+
+		var sensors []*Sensor
+		suite.Nil(json.Unmarshal(response.Body.Bytes(), &sensors))
+		for _, sensor := range sensors {
+			names = append(names, sensor.Name)
+		}
+		return names
+	}
+	suite.NotContains(names("/allsensors"), "P1MAG")
+	suite.Contains(names("/allsensors?state=all"), "P1MAG")
+	suite.Equal([]string{"P1MAG"}, names("/allsensors?state=decommissioned"))
+
+	response = suite.request("GET", "/nearest/0.1/0.1", nil)
+	suite.Equal(200, response.Code)
+	var nearest *Sensor
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &nearest))
+	suite.Equal("L1MAG", nearest.Name)
+
+	response = suite.request("GET", "/allsensors?state=retired", nil)
+	suite.Equal(400, response.Code)
+	response = suite.request("POST", "/sensor/missing/transition", &TransitionRequest{To: StateActive})
+	suite.Equal(404, response.Code)
+}
+
+func (suite *testSuite) TestAddSensorInitialState() {
+	sensor := CreateSensor("P2MAG", "kV", "Anaheim", "foo", 0, 0)
+	sensor.State = StateMaintenance
+	response := suite.request("POST", "/sensor", sensor)
+	suite.Equal(400, response.Code)
+
+	sensor.State = "broken"
+	response = suite.request("POST", "/sensor", sensor)
+	suite.Equal(400, response.Code)
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// Liveness of a sensor, derived from when it was last seen.
+const (
+	StatusLive   = "live"   // seen within its staleness threshold.
+	StatusStale  = "stale"  // silent for longer than its threshold.
+	StatusUnseen = "unseen" // never sent a heartbeat or reading.
+)
+
+// Threshold used for sensors when neither they nor their ingress set one.
+const defaultStaleAfter = 5 * time.Minute
+
+// A duration written as a string such as "90s" or "5m" in JSON, YAML
+// and TOML.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) (err error) {
+	var value string
+	if err = json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	return d.parse(value)
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	return d.parse(node.Value)
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	return d.parse(string(text))
+}
+
+func (d *Duration) parse(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Work out a sensor's liveness from when it was last seen.
+func livenessStatus(lastSeen *time.Time, threshold time.Duration, now time.Time) string {
+	switch {
+	case lastSeen == nil:
+		return StatusUnseen
+	case now.Sub(*lastSeen) > threshold:
+		return StatusStale
+	}
+	return StatusLive
+}
+
+// Move a sensor's last seen time forward to at. Earlier times are
+// ignored so backfilled readings don't make a sensor look silent.
+func markSeen(ctx context.Context, exec execer, name string, at time.Time) (sql.Result, error) {
+	updateStatement := `UPDATE sensors SET last_seen = MAX(COALESCE(last_seen, 0), ?) WHERE name = ?`
+	return exec.ExecContext(ctx, updateStatement, at.UnixNano(), name)
+}
+
+// Record a heartbeat from a sensor.
+func (db *store) heartbeat(ctx context.Context, name string, at time.Time) (err error) {
+	var result sql.Result
+	if result, err = markSeen(ctx, db.conn, name, at); err != nil {
+		return err
+	}
+	return requireAffected(result, errSensorNotFound)
+}
+
+// Query the active sensors that are stale or have never been
+// seen, the ones silent the longest first.
+func (db *store) queryStaleSensors(ctx context.Context) (stale []*Sensor, err error) {
+	var sensors []*Sensor
+	if sensors, err = db.querySensorsWhere(ctx, "state = ?", StateActive); err != nil {
+		return nil, err
+	}
+
+	for _, sensor := range sensors {
+		if sensor.Status != StatusLive {
+			stale = append(stale, sensor)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		a, b := stale[i].LastSeen, stale[j].LastSeen
+		switch {
+		case a == nil && b == nil, a != nil && b != nil && a.Equal(*b):
+			return stale[i].Name < stale[j].Name
+		case a == nil || b == nil:
+			return a == nil
+		}
+		return a.Before(*b)
+	})
+	return stale, nil
+}
+
+// Record that a sensor is alive without sending readings.
+func (s *Server) heartbeat(c *gin.Context) {
+	if err := s.db.heartbeat(c.Request.Context(), c.Param("name"), time.Now().UTC()); err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	s.respondWithSensor(c, http.StatusOK, c.Param("name"))
+}
+
+// List active sensors that have gone silent.
+func (s *Server) listStaleSensors(c *gin.Context) {
+	sensors, err := s.db.queryStaleSensors(c.Request.Context())
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if sensors == nil {
+		sensors = []*Sensor{}
+	}
+	c.IndentedJSON(http.StatusOK, visibleSensors(c, sensors))
+}
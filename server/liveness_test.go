@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"time"
+)
+
+func (suite *testSuite) TestLiveness() {
+	staleNames := func() (names []string) {
+		response := suite.request("GET", "/sensors/stale", nil)
+		suite.Equal(200, response.Code)
+
+		var sensors []*Sensor
+		suite.Nil(json.Unmarshal(response.Body.Bytes(), &sensors))
+		for _, sensor := range sensors {
+			suite.NotEqual(StatusLive, sensor.Status)
+			names = append(names, sensor.Name)
+		}
+		return names
+	}
+
+	// Nothing has reported yet.
+	suite.Equal([]string{"C1MAG", "L1ANG", "L1MAG"}, staleNames())
+
+	response := suite.request("POST", "/sensor/L1MAG/heartbeat", nil)
+	suite.Equal(200, response.Code)
+
+	var sensor *Sensor
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &sensor))
+	suite.Equal(StatusLive, sensor.Status)
+	suite.NotNil(sensor.LastSeen)
+
+	// Old readings count, but only as old as they are.
+	response = suite.request("POST", "/sensor/L1ANG/readings", []*Reading{
+		{Time: time.Now().Add(-time.Hour), Value: 1},
+	})
+	suite.Equal(201, response.Code)
+	suite.Equal([]string{"C1MAG", "L1ANG"}, staleNames())
+
+	// The ingress threshold applies unless the sensor sets its own.
+	ingress := &Component{Name: "Anaheim", StaleAfter: Duration(2 * time.Hour)}
+	response = suite.request("PUT", "/ingresses/Anaheim", ingress)
+	suite.Equal(200, response.Code)
+	suite.Equal([]string{"C1MAG"}, staleNames())
+
+	response = suite.request("GET", "/sensor/L1ANG", nil)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &sensor))
+	sensor.StaleAfter = Duration(time.Minute)
+	response = suite.request("PUT", "/sensor/L1ANG", sensor)
+	suite.Equal(200, response.Code)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &sensor))
+	suite.Equal(StatusStale, sensor.Status)
+	suite.Equal(Duration(time.Minute), sensor.StaleAfter)
+
+	// Sensors that aren't active aren't expected to report.
+	response = suite.request("POST", "/sensor/C1MAG/transition", &TransitionRequest{To: StateMaintenance})
+	suite.Equal(200, response.Code)
+	suite.Equal([]string{"L1ANG"}, staleNames())
+
+	response = suite.request("POST", "/sensor/missing/heartbeat", nil)
+	suite.Equal(404, response.Code)
+	response = suite.request("PUT", "/distillers/foo", &Component{Name: "foo", StaleAfter: Duration(time.Minute)})
+	suite.Equal(400, response.Code)
+}
+
+func (suite *testSuite) TestDurationJSON() {
+	var sensor Sensor
+	suite.Nil(json.Unmarshal([]byte(`{"stale_after": "90s"}`), &sensor))
+	suite.Equal(Duration(90*time.Second), sensor.StaleAfter)
+	suite.Error(json.Unmarshal([]byte(`{"stale_after": "soon"}`), &sensor))
+
+	data, err := json.Marshal(Sensor{StaleAfter: Duration(time.Minute)})
+	suite.Nil(err)
+	suite.Contains(string(data), `"stale_after":"1m0s"`)
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Header carrying a request's ID. Clients may send their own, and get
+// back the one the server logged the request with.
+const RequestIDHeader = "X-Request-ID"
+
+// IDs accepted from clients. Others are replaced, so logs can't be
+// filled with arbitrary text.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestIDKey struct{}
+
+// The ID of the request a context belongs to, empty outside requests.
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// Log to logger instead of the default slog logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// A logger writing records at level or above to w, as JSON or text.
+// Records logged with a request's context carry its ID.
+func NewLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var minLevel slog.Level
+	if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("log level %q isn't debug, info, warn or error", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: minLevel}
+	switch format {
+	case "json":
+		return slog.New(requestIDHandler{slog.NewJSONHandler(w, opts)}), nil
+	case "text":
+		return slog.New(requestIDHandler{slog.NewTextHandler(w, opts)}), nil
+	}
+	return nil, fmt.Errorf("log format %q isn't json or text", format)
+}
+
+// Adds the request ID of the context records are logged with, and the
+// trace ID when it's part of a trace.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// Middleware giving every request an ID, kept in its context and sent
+// back in the X-Request-ID header. Requests handed to a namespace keep
+// the ID they were given.
+func (s *Server) requestID(c *gin.Context) {
+	id := RequestID(c.Request.Context())
+	if id == "" {
+		if id = c.GetHeader(RequestIDHeader); !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDKey{}, id))
+	}
+	c.Header(RequestIDHeader, id)
+	c.Next()
+}
+
+// Middleware logging requests once they're handled, at error level for
+// server errors. Namespaced requests are logged by the default
+// namespace with their full path.
+func (s *Server) logRequest(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	status := c.Writer.Status()
+	level := slog.LevelInfo
+	if status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	s.logger.LogAttrs(c.Request.Context(), level, "request",
+		slog.String("method", c.Request.Method),
+		slog.String("path", c.Request.URL.Path),
+		slog.String("route", c.FullPath()),
+		slog.Int("status", status),
+		slog.Duration("duration", time.Since(start)),
+		slog.String("client_ip", c.ClientIP()),
+		slog.Int("bytes", c.Writer.Size()),
+	)
+}
+
+// Log a handler's panic and respond with a 500.
+func (s *Server) recovered(c *gin.Context, err any) {
+	s.logger.ErrorContext(c.Request.Context(), "panic handling request", "error", err, "stack", string(debug.Stack()))
+	c.AbortWithStatus(http.StatusInternalServerError)
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+)
+
+func (suite *testSuite) requestWithID(id, method, url string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, url, nil)
+	if id != "" {
+		request.Header.Set(RequestIDHeader, id)
+	}
+	recorder := httptest.NewRecorder()
+	suite.srv.gin.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func (suite *testSuite) TestRequestIDs() {
+	response := suite.requestWithID("", "GET", "/sensor/L1MAG")
+	suite.Equal(200, response.Code)
+	suite.Regexp(`^[0-9a-f]{32}$`, response.Header().Get(RequestIDHeader))
+
+	response = suite.requestWithID("ticket-42.a", "GET", "/sensor/MISSING")
+	suite.Equal(404, response.Code)
+	suite.Equal("ticket-42.a", response.Header().Get(RequestIDHeader))
+
+	// IDs that don't look like one are replaced.
+	for _, id := range []string{"has spaces", strings.Repeat("x", 129)} {
+		response = suite.requestWithID(id, "GET", "/sensor/L1MAG")
+		suite.Regexp(`^[0-9a-f]{32}$`, response.Header().Get(RequestIDHeader), id)
+	}
+}
+
+func (suite *testSuite) TestRequestLogs() {
+	var logs bytes.Buffer
+	logger, err := NewLogger(&logs, "debug", "json")
+	suite.Nil(err)
+	suite.srv, err = New("fakeaddress", WithSeed("testdata/sensors.json"), WithLogger(logger))
+	suite.Nil(err)
+	suite.Equal(201, suite.request("POST", "/namespaces", &Namespace{Name: "east"}).Code)
+	logs.Reset()
+
+	suite.Equal(200, suite.requestWithID("ticket-1", "GET", "/sensor/L1MAG").Code)
+	suite.Equal(200, suite.requestWithID("ticket-2", "GET", "/ns/east/allsensors").Code)
+
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var record map[string]interface{}
+		suite.Nil(json.Unmarshal([]byte(line), &record), line)
+		records = append(records, record)
+	}
+	find := func(msg, id string) (found []map[string]interface{}) {
+		for _, record := range records {
+			if record["msg"] == msg && record["request_id"] == id {
+				found = append(found, record)
+			}
+		}
+		return found
+	}
+
+	requests := find("request", "ticket-1")
+	suite.Len(requests, 1)
+	suite.Equal("INFO", requests[0]["level"])
+	suite.Equal("GET", requests[0]["method"])
+	suite.Equal("/sensor/L1MAG", requests[0]["path"])
+	suite.Equal("/sensor/:name", requests[0]["route"])
+	suite.Equal(float64(200), requests[0]["status"])
+	queries := find("store query", "ticket-1")
+	suite.NotEmpty(queries)
+	suite.Equal("DEBUG", queries[0]["level"])
+	suite.Equal("querySensorsWhere", queries[0]["query"])
+
+	// Namespaced requests are logged once, and their store queries
+	// carry the ID and the namespace.
+	requests = find("request", "ticket-2")
+	suite.Len(requests, 1)
+	suite.Equal("/ns/east/allsensors", requests[0]["path"])
+	queries = find("store query", "ticket-2")
+	suite.NotEmpty(queries)
+	suite.Equal("east", queries[0]["namespace"])
+
+	_, err = NewLogger(&logs, "loud", "json")
+	suite.NotNil(err)
+	_, err = NewLogger(&logs, "info", "xml")
+	suite.NotNil(err)
+}
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Route label of requests that didn't match a route, so unknown paths
+// don't each get their own series.
+const unmatchedRoute = "unmatched"
+
+// Context key set on requests handed to a namespace, whose routes
+// count them instead.
+const namespacedKey = "pingthings.namespaced"
+
+// Metrics of a server and all its namespaces, served at /metrics in the
+// Prometheus text format.
+type metrics struct {
+	registry        *prometheus.Registry
+	handler         http.Handler
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	queryDuration   *prometheus.HistogramVec
+	readings        prometheus.Counter
+}
+
+func newMetrics(s *Server) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pingthings_http_requests_total",
+			Help: "HTTP requests handled, by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "pingthings_http_request_duration_seconds",
+			Help:    "Time taken to handle HTTP requests, by method, route and status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "pingthings_store_query_duration_seconds",
+			Help:    "Time taken by store statements and transactions, by the function running them and whether they failed.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}, []string{"query", "status"}),
+		readings: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "pingthings_readings_ingested_total",
+			Help: "Readings stored, from requests, PMU streams and event records.",
+		}),
+	}
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.queryDuration,
+		m.readings,
+		&sensorCollector{server: s},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	m.handler = promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return m
+}
+
+// Count readings that were stored.
+func (m *metrics) ingested(n int) {
+	if m == nil {
+		return
+	}
+	m.readings.Add(float64(n))
+}
+
+// Record how long a store query took, with status ok, error, or
+// rolled_back for transactions given up on.
+func (m *metrics) observeQuery(query, status string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.queryDuration.WithLabelValues(query, status).Observe(d.Seconds())
+}
+
+var sensorsDesc = prometheus.NewDesc(
+	"pingthings_sensors",
+	"Registered sensors, by namespace, unit and ingress.",
+	[]string{"namespace", "unit", "ingress"}, nil,
+)
+
+// Counts sensors in every namespace's store when scraped.
+type sensorCollector struct {
+	server *Server
+}
+
+func (sc *sensorCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sensorsDesc
+}
+
+func (sc *sensorCollector) Collect(ch chan<- prometheus.Metric) {
+	s := sc.server
+	ctx := context.Background()
+	servers := map[string]*Server{DefaultNamespace: s}
+	s.namespacesMu.Lock()
+	for name, ns := range s.namespaces {
+		servers[name] = ns
+	}
+	s.namespacesMu.Unlock()
+
+	for name, ns := range servers {
+		counts, err := ns.db.countSensors(ctx)
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(sensorsDesc, err)
+			continue
+		}
+		for _, count := range counts {
+			ch <- prometheus.MustNewConstMetric(sensorsDesc, prometheus.GaugeValue, float64(count.sensors), name, count.unit, count.ingress)
+		}
+	}
+}
+
+type sensorCount struct {
+	unit, ingress string
+	sensors       int64
+}
+
+// Count sensors by unit and ingress.
+func (db *store) countSensors(ctx context.Context) (counts []*sensorCount, err error) {
+	var rows *timedRows
+	selectStatement := `SELECT unit, ingress, COUNT(*) FROM sensors GROUP BY unit, ingress`
+	if rows, err = db.conn.QueryContext(ctx, selectStatement); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		count := &sensorCount{}
+		if err = rows.Scan(&count.unit, &count.ingress, &count.sensors); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// Middleware counting and timing requests by route. Requests under
+// /ns/:ns are counted by the namespace's own routes, prefixed with it.
+func (s *Server) instrument(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	if c.GetBool(namespacedKey) {
+		return
+	}
+
+	route := c.FullPath()
+	switch {
+	case route == "":
+		route = unmatchedRoute
+	case s.root != nil:
+		route = "/ns/:ns" + route
+	}
+	status := strconv.Itoa(c.Writer.Status())
+	s.metrics.requests.WithLabelValues(c.Request.Method, route, status).Inc()
+	s.metrics.requestDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+}
+
+// Serve the metrics in the Prometheus text format.
+func (s *Server) serveMetrics(c *gin.Context) {
+	s.metrics.handler.ServeHTTP(c.Writer, c.Request)
+}
+
+// A database timing and logging statements run outside transactions,
+// and transactions from beginning to commit, by the function running
+// them. Statements are logged at debug level with their request's ID.
+type timedDB struct {
+	*sql.DB
+	metrics *metrics     // nil when not timed.
+	logger  *slog.Logger // nil when not logged.
+	tracer  trace.Tracer // spans of statements and transactions.
+}
+
+// A transaction of a timedDB, traced as a span its statements are
+// children of.
+type timedTx struct {
+	*sql.Tx
+	db    *timedDB
+	ctx   context.Context
+	query string
+	began time.Time
+	span  trace.Span
+	ended bool // the span has ended, by committing or rolling back.
+}
+
+// Rows of a timedDB or timedTx query, timed and traced until they're
+// closed so reading them counts too.
+type timedRows struct {
+	*sql.Rows
+	end func(err error) // nil once called.
+}
+
+// Close the rows, ending their timing and span with any error from
+// reading them.
+func (rows *timedRows) Close() error {
+	readErr := rows.Rows.Err()
+	err := rows.Rows.Close()
+	if rows.end != nil {
+		if readErr == nil {
+			readErr = err
+		}
+		rows.end(readErr)
+		rows.end = nil
+	}
+	return err
+}
+
+func (db *timedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*timedRows, error) {
+	name := queryName()
+	ctx, span := db.startSpan(ctx, name, query)
+	start := time.Now()
+	end := func(err error) {
+		db.observe(ctx, name, start, &err)
+		endSpan(span, err)
+	}
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		end(err)
+		return nil, err
+	}
+	return &timedRows{Rows: rows, end: end}, nil
+}
+
+// Errors of rows queried this way only come out when scanning, so
+// they aren't logged.
+func (db *timedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	name := queryName()
+	ctx, span := db.startSpan(ctx, name, query)
+	defer span.End()
+	defer db.observe(ctx, name, time.Now(), nil)
+	return db.DB.QueryRowContext(ctx, query, args...)
+}
+
+func (db *timedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (_ sql.Result, err error) {
+	name := queryName()
+	ctx, span := db.startSpan(ctx, name, query)
+	defer func() { endSpan(span, err) }()
+	defer db.observe(ctx, name, time.Now(), &err)
+	return db.DB.ExecContext(ctx, query, args...)
+}
+
+func (db *timedDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*timedTx, error) {
+	name := queryName()
+	spanCtx, span := db.startSpan(ctx, name, "")
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &timedTx{Tx: tx, db: db, ctx: spanCtx, query: name, began: time.Now(), span: span}, nil
+}
+
+func (tx *timedTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*timedRows, error) {
+	_, span := tx.db.startSpan(trace.ContextWithSpan(ctx, tx.span), queryName(), query)
+	end := func(err error) { endSpan(span, err) }
+	rows, err := tx.Tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		end(err)
+		return nil, err
+	}
+	return &timedRows{Rows: rows, end: end}, nil
+}
+
+func (tx *timedTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	_, span := tx.db.startSpan(trace.ContextWithSpan(ctx, tx.span), queryName(), query)
+	defer span.End()
+	return tx.Tx.QueryRowContext(ctx, query, args...)
+}
+
+func (tx *timedTx) ExecContext(ctx context.Context, query string, args ...interface{}) (_ sql.Result, err error) {
+	_, span := tx.db.startSpan(trace.ContextWithSpan(ctx, tx.span), queryName(), query)
+	defer func() { endSpan(span, err) }()
+	return tx.Tx.ExecContext(ctx, query, args...)
+}
+
+// Commit the transaction, timing it.
+func (tx *timedTx) Commit() (err error) {
+	defer tx.end(&err)
+	defer tx.db.observe(tx.ctx, tx.query, tx.began, &err)
+	return tx.Tx.Commit()
+}
+
+// Roll the transaction back, which is left to a deferred call after
+// committing, so only its first call ends the span and is timed.
+func (tx *timedTx) Rollback() (err error) {
+	if !tx.ended {
+		tx.db.metrics.observeQuery(tx.query, "rolled_back", time.Since(tx.began))
+	}
+	defer tx.end(&err)
+	return tx.Tx.Rollback()
+}
+
+func (tx *timedTx) end(err *error) {
+	if tx.ended {
+		return
+	}
+	tx.ended = true
+	endSpan(tx.span, *err)
+}
+
+func (db *timedDB) observe(ctx context.Context, query string, start time.Time, err *error) {
+	elapsed := time.Since(start)
+	if err != nil && *err != nil {
+		db.metrics.observeQuery(query, "error", elapsed)
+		if db.logger != nil {
+			db.logger.DebugContext(ctx, "store query failed", "query", query, "duration", elapsed, "error", *err)
+		}
+		return
+	}
+	db.metrics.observeQuery(query, "ok", elapsed)
+	if db.logger != nil {
+		db.logger.DebugContext(ctx, "store query", "query", query, "duration", elapsed)
+	}
+}
+
+var closureSuffix = regexp.MustCompile(`(\.func\d+)+$`)
+
+// The name of the function that called the timedDB method calling
+// this, such as queryNamespaces.
+func queryName() string {
+	pc, _, _, ok := runtime.Caller(2)
+	if !ok {
+		return "unknown"
+	}
+	name := closureSuffix.ReplaceAllString(runtime.FuncForPC(pc).Name(), "")
+	return name[strings.LastIndex(name, ".")+1:]
+}
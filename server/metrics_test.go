@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+func (suite *testSuite) TestMetrics() {
+	suite.Equal(200, suite.request("GET", "/sensor/L1MAG", nil).Code)
+	suite.Equal(404, suite.request("GET", "/sensor/MISSING", nil).Code)
+	suite.Equal(404, suite.request("GET", "/no/such/route", nil).Code)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	response := suite.request("POST", "/sensor/L1MAG/readings", []*Reading{
+		{Time: start, Value: 500},
+		{Time: start.Add(time.Second), Value: 600},
+	})
+	suite.Equal(201, response.Code)
+
+	suite.Equal(201, suite.request("POST", "/namespaces", &Namespace{Name: "east"}).Code)
+	suite.Equal(200, suite.request("GET", "/ns/east/allsensors", nil).Code)
+	suite.Equal(404, suite.request("GET", "/ns/west/allsensors", nil).Code)
+	suite.Equal(404, suite.request("POST", "/sensor/MISSING/transition", &TransitionRequest{To: StateMaintenance}).Code)
+
+	// Failed statements are timed too.
+	_, err := suite.srv.db.conn.ExecContext(context.Background(), `DELETE FROM no_such_table`)
+	suite.NotNil(err)
+
+	response = suite.request("GET", "/metrics", nil)
+	suite.Equal(200, response.Code)
+	suite.Contains(response.Header().Get("Content-Type"), "text/plain")
+	body := response.Body.String()
+	for _, line := range []string{
+		`pingthings_http_requests_total{method="GET",route="/sensor/:name",status="200"} 1`,
+		`pingthings_http_requests_total{method="GET",route="/sensor/:name",status="404"} 1`,
+		`pingthings_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`pingthings_http_requests_total{method="GET",route="/ns/:ns/allsensors",status="200"} 1`,
+		`pingthings_http_requests_total{method="GET",route="/ns/:ns/*path",status="404"} 1`,
+		`pingthings_http_request_duration_seconds_count{method="POST",route="/sensor/:name/readings",status="201"} 1`,
+		`pingthings_readings_ingested_total 2`,
+		`pingthings_sensors{ingress="Anaheim",namespace="default",unit="deg"} 1`,
+		`pingthings_sensors{ingress="Middle of the Ocean",namespace="default",unit="V"} 1`,
+		`pingthings_store_query_duration_seconds_count{query="insertReadings",status="ok"} 1`,
+		`pingthings_store_query_duration_seconds_count{query="insertNamespace",status="ok"}`,
+		`pingthings_store_query_duration_seconds_count{query="transitionSensor",status="rolled_back"} 1`,
+		`pingthings_store_query_duration_seconds_count{query="TestMetrics",status="error"} 1`,
+		`go_goroutines`,
+	} {
+		suite.Contains(body, line)
+	}
+	// Requests handed to a namespace are only counted by it.
+	suite.NotContains(body, `route="/ns/:ns/*path",status="200"`)
+}
+
+func (suite *testSuite) TestQueryTimedUntilRowsClosed() {
+	m := newMetrics(suite.srv)
+	db := &timedDB{DB: suite.srv.db.conn.DB, metrics: m, tracer: newTracer(nil)}
+	observed := func() (count uint64, seconds float64) {
+		families, err := m.registry.Gather()
+		suite.Nil(err)
+		for _, family := range families {
+			if family.GetName() == "pingthings_store_query_duration_seconds" {
+				histogram := family.GetMetric()[0].GetHistogram()
+				return histogram.GetSampleCount(), histogram.GetSampleSum()
+			}
+		}
+		return 0, 0
+	}
+
+	// Time spent reading the rows counts, once they're closed.
+	rows, err := db.QueryContext(context.Background(), `SELECT name FROM sensors`)
+	suite.Nil(err)
+	for rows.Next() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	count, _ := observed()
+	suite.Zero(count)
+	suite.Nil(rows.Close())
+	suite.Nil(rows.Close())
+	count, seconds := observed()
+	suite.Equal(uint64(1), count)
+	suite.GreaterOrEqual(seconds, 0.03)
+}
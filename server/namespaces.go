@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The namespace of routes without a /ns/:ns prefix, which always exists.
+const DefaultNamespace = "default"
+
+// Route every namespaced request goes through.
+const namespaceRoute = "/ns/:ns/*path"
+
+var errNamespaceNotFound = errors.New("Namespace not found in store")
+
+var namespaceName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// A tenant's own set of sensors and everything attached to them,
+// reached through routes under /ns/{name}.
+type Namespace struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Query the namespaces other than the default one, by name.
+func (db *store) queryNamespaces(ctx context.Context) (namespaces []*Namespace, err error) {
+	var rows *timedRows
+	if rows, err = db.conn.QueryContext(ctx, `SELECT name, created_at FROM namespaces ORDER BY name`); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var created int64
+		ns := &Namespace{}
+		if err = rows.Scan(&ns.Name, &created); err != nil {
+			return nil, err
+		}
+		ns.CreatedAt = time.Unix(0, created).UTC()
+		namespaces = append(namespaces, ns)
+	}
+
+	return namespaces, rows.Err()
+}
+
+// Register a namespace.
+func (db *store) insertNamespace(ctx context.Context, ns *Namespace) (err error) {
+	var exists bool
+	if err = db.conn.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM namespaces WHERE name = ?)`, ns.Name).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return &conflictError{fmt.Sprintf("Namespace %s already exists", ns.Name)}
+	}
+
+	insertStatement := `INSERT INTO namespaces (name, created_at) VALUES(?, ?)`
+	_, err = db.conn.ExecContext(ctx, insertStatement, ns.Name, ns.CreatedAt.UnixNano())
+	return err
+}
+
+// The database a namespace is kept in, next to the default
+// namespace's: pingthings.db holds namespace east in
+// pingthings.east.db. In-memory databases stay in memory, and a shared
+// one gets a name of its own, as every connection in the process to
+// file::memory:?cache=shared reaches the same database.
+func namespaceDSN(dsn, name string) string {
+	path, query, hasQuery := strings.Cut(dsn, "?")
+	_, shared := memoryDSN(dsn)
+	switch {
+	case path == "file::memory:" && shared:
+		return "file:pingthings." + name + "?mode=memory&" + query
+	case path == ":memory:" || path == "file::memory:":
+		return dsn
+	}
+	ext := filepath.Ext(path)
+	path = strings.TrimSuffix(path, ext) + "." + name + ext
+	if hasQuery {
+		path += "?" + query
+	}
+	return path
+}
+
+// Open a namespace's store and routes, sharing the server's settings.
+// Callers hold namespacesMu.
+func (s *Server) openNamespace(name string) (ns *Server, err error) {
+	ns = &Server{
+		gin:          gin.New(),
+		root:         s,
+		events:       newBroker(),
+		done:         s.done,
+		apiKeys:      s.apiKeys,
+		tokens:       s.tokens,
+		privateReads: s.privateReads,
+		limiter:      s.limiter,
+		metrics:      s.metrics,
+		logger:       s.logger.With("namespace", name),
+		maxUpload:    s.maxUpload,
+		tracer:       s.tracer,
+		pmuStreams:   make(map[string]*PMUStream),
+
+		trustedProxies: s.trustedProxies,
+	}
+	if ns.db, err = newStore(namespaceDSN(s.dsn, name)); err != nil {
+		return nil, fmt.Errorf("opening namespace %s: %w", name, err)
+	}
+	ns.db.staleAfter = s.db.staleAfter
+	ns.db.laxReferences = s.db.laxReferences
+	ns.db.conn.metrics = s.metrics
+	ns.db.conn.logger = ns.logger
+	ns.db.conn.tracer = s.tracer
+
+	if err = ns.gin.SetTrustedProxies(s.trustedProxies); err != nil {
+		return nil, err
+	}
+	// The root engine redirects with the namespace prefix.
+	ns.gin.RedirectTrailingSlash = false
+	ns.setupRoutes()
+
+	s.namespaces[name] = ns
+	return ns, nil
+}
+
+// Open the stores of the namespaces registered in the default one.
+func (s *Server) openNamespaces(ctx context.Context) error {
+	namespaces, err := s.db.queryNamespaces(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.namespacesMu.Lock()
+	defer s.namespacesMu.Unlock()
+	for _, ns := range namespaces {
+		if _, err = s.openNamespace(ns.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The server of a namespace, the default one included.
+func (s *Server) namespace(name string) (*Server, error) {
+	if name == DefaultNamespace {
+		return s, nil
+	}
+
+	s.namespacesMu.Lock()
+	defer s.namespacesMu.Unlock()
+	if ns, ok := s.namespaces[name]; ok {
+		return ns, nil
+	}
+	return nil, errNamespaceNotFound
+}
+
+// Every namespace's server, the default one first.
+func (s *Server) allNamespaces() []*Server {
+	s.namespacesMu.Lock()
+	defer s.namespacesMu.Unlock()
+
+	names := make([]string, 0, len(s.namespaces))
+	for name := range s.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	servers := []*Server{s}
+	for _, name := range names {
+		servers = append(servers, s.namespaces[name])
+	}
+	return servers
+}
+
+// The server of the default namespace, which holds API keys and the
+// namespaces and knows whether the server is up.
+func (s *Server) base() *Server {
+	if s.root != nil {
+		return s.root
+	}
+	return s
+}
+
+// Check a new namespace's fields.
+func validateNamespace(ns *Namespace) error {
+	verr := &ValidationError{}
+	if ns == nil {
+		verr.add("namespace", "required", "is required")
+		return verr
+	}
+	switch {
+	case !namespaceName.MatchString(ns.Name):
+		verr.add("name", "namespace", "must be 1 to 63 lowercase letters, digits and hyphens, starting with a letter or digit")
+	case ns.Name == DefaultNamespace:
+		verr.add("name", "namespace", "default always exists")
+	}
+	return verr.orNil()
+}
+
+// List the namespaces other than the default one.
+func (s *Server) listNamespaces(c *gin.Context) {
+	namespaces, err := s.db.queryNamespaces(c.Request.Context())
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if namespaces == nil {
+		namespaces = []*Namespace{}
+	}
+	c.IndentedJSON(http.StatusOK, namespaces)
+}
+
+// Create a namespace, empty until sensors are added to it.
+func (s *Server) createNamespace(c *gin.Context) {
+	var ns *Namespace
+	if err := c.BindJSON(&ns); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateNamespace(ns); err != nil {
+		abortInvalid(c, err)
+		return
+	}
+	ns.CreatedAt = time.Now().UTC()
+
+	s.namespacesMu.Lock()
+	defer s.namespacesMu.Unlock()
+	if _, ok := s.namespaces[ns.Name]; ok {
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Namespace %s already exists", ns.Name)})
+		return
+	}
+
+	// The store is opened before the namespace is registered, so one
+	// that can't be opened isn't left registered.
+	opened, err := s.openNamespace(ns.Name)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	err = s.db.insertNamespace(c.Request.Context(), ns)
+	if err != nil {
+		delete(s.namespaces, ns.Name)
+		opened.db.conn.Close()
+	}
+	var conflict *conflictError
+	switch {
+	case errors.As(err, &conflict):
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, ns)
+}
+
+// Hand a request under /ns/:ns to the namespace's routes, with the
+// prefix taken off its path.
+func (s *Server) routeNamespace(c *gin.Context) {
+	ns, err := s.namespace(c.Param("ns"))
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	prefix := "/ns/" + c.Param("ns")
+	request := c.Request.Clone(c.Request.Context())
+	request.URL.Path = strings.TrimPrefix(request.URL.Path, prefix)
+	request.URL.RawPath = strings.TrimPrefix(request.URL.RawPath, prefix)
+	c.Set(namespacedKey, true)
+	ns.gin.ServeHTTP(c.Writer, request)
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+func (suite *testSuite) TestNamespaces() {
+	suite.Equal(201, suite.request("POST", "/namespaces", &Namespace{Name: "east"}).Code)
+	suite.Equal(409, suite.request("POST", "/namespaces", &Namespace{Name: "east"}).Code)
+	suite.Equal(400, suite.request("POST", "/namespaces", &Namespace{Name: "East Coast"}).Code)
+	suite.Equal(400, suite.request("POST", "/namespaces", &Namespace{Name: DefaultNamespace}).Code)
+	suite.Equal(404, suite.request("GET", "/ns/west/allsensors", nil).Code)
+
+	var namespaces []*Namespace
+	suite.Nil(json.Unmarshal(suite.request("GET", "/namespaces", nil).Body.Bytes(), &namespaces))
+	suite.Len(namespaces, 1)
+	suite.Equal("east", namespaces[0].Name)
+
+	// Names are unique per namespace, and so are the components
+	// sensors reference.
+	suite.Equal(201, suite.request("POST", "/ns/east/ingresses", &Component{Name: "Boston"}).Code)
+	suite.Equal(201, suite.request("POST", "/ns/east/distillers", &Component{Name: "foo"}).Code)
+	east := CreateSensor("L1MAG", "amps", "Boston", "foo", 10, 10)
+	suite.Equal(201, suite.request("POST", "/ns/east/sensor", east).Code)
+	suite.Equal(400, suite.request("POST", "/ns/east/sensor", east).Code)
+
+	var sensor Sensor
+	suite.Nil(json.Unmarshal(suite.request("GET", "/ns/east/sensor/L1MAG", nil).Body.Bytes(), &sensor))
+	suite.Equal("A", sensor.Tags.Unit)
+	suite.Nil(json.Unmarshal(suite.request("GET", "/sensor/L1MAG", nil).Body.Bytes(), &sensor))
+	suite.Equal("V", sensor.Tags.Unit)
+	suite.Nil(json.Unmarshal(suite.request("GET", "/ns/default/sensor/L1MAG", nil).Body.Bytes(), &sensor))
+	suite.Equal("V", sensor.Tags.Unit)
+
+	var sensors []*Sensor
+	suite.Nil(json.Unmarshal(suite.request("GET", "/ns/east/allsensors", nil).Body.Bytes(), &sensors))
+	suite.Len(sensors, 1)
+	suite.Equal(200, suite.request("GET", "/ns/east/ingresses/Boston", nil).Code)
+	suite.Equal(404, suite.request("GET", "/ingresses/Boston", nil).Code)
+
+	// The nearest sensor is looked for in the namespace only.
+	suite.Nil(json.Unmarshal(suite.request("GET", "/ns/east/nearest/0/0", nil).Body.Bytes(), &sensor))
+	suite.Equal("A", sensor.Tags.Unit)
+	suite.Nil(json.Unmarshal(suite.request("GET", "/nearest/0/0", nil).Body.Bytes(), &sensor))
+	suite.Equal("V", sensor.Tags.Unit)
+
+	// Keys and namespaces are only managed in the default namespace.
+	suite.Equal(404, suite.request("GET", "/ns/east/namespaces", nil).Code)
+}
+
+func (suite *testSuite) TestNamespaceDatabases() {
+	dsn := filepath.Join(suite.T().TempDir(), "pingthings.db")
+	suite.Equal(dsn[:len(dsn)-3]+".east.db", namespaceDSN(dsn, "east"))
+	suite.Equal("file:a.east.db?cache=shared", namespaceDSN("file:a.db?cache=shared", "east"))
+	suite.Equal(":memory:", namespaceDSN(":memory:", "east"))
+	suite.Equal("file:pingthings.east?mode=memory&cache=shared", namespaceDSN("file::memory:?cache=shared", "east"))
+
+	var err error
+	suite.srv, err = New("fakeaddress", WithDatabase(dsn), WithLaxReferences())
+	suite.Nil(err)
+	suite.Equal(201, suite.request("POST", "/namespaces", &Namespace{Name: "east"}).Code)
+	suite.Equal(201, suite.request("POST", "/ns/east/sensor", CreateSensor("E1", "V", "Boston", "foo", 1, 1)).Code)
+	suite.srv.shutdown()
+
+	// Namespaces are opened again with the server.
+	suite.srv, err = New("fakeaddress", WithDatabase(dsn), WithLaxReferences())
+	suite.Nil(err)
+	defer suite.srv.shutdown()
+	suite.Equal(200, suite.request("GET", "/ns/east/sensor/E1", nil).Code)
+	suite.Equal(404, suite.request("GET", "/sensor/E1", nil).Code)
+
+	// A namespace whose store can't be opened isn't registered.
+	suite.Nil(os.Mkdir(namespaceDSN(dsn, "west"), 0700))
+	suite.Equal(500, suite.request("POST", "/namespaces", &Namespace{Name: "west"}).Code)
+	suite.Equal(404, suite.request("GET", "/ns/west/allsensors", nil).Code)
+	var namespaces []*Namespace
+	suite.Nil(json.Unmarshal(suite.request("GET", "/namespaces", nil).Body.Bytes(), &namespaces))
+	suite.Len(namespaces, 1)
+	suite.Nil(os.Remove(namespaceDSN(dsn, "west")))
+	suite.Equal(201, suite.request("POST", "/namespaces", &Namespace{Name: "west"}).Code)
+}
+
+func (suite *testSuite) TestSharedMemoryNamespaces() {
+	var err error
+	suite.srv, err = New("fakeaddress", WithDatabase("file::memory:?cache=shared"), WithLaxReferences())
+	suite.Nil(err)
+	defer suite.srv.shutdown()
+
+	// Namespaces of a shared in-memory store don't share it.
+	suite.Equal(201, suite.request("POST", "/namespaces", &Namespace{Name: "east"}).Code)
+	suite.Equal(201, suite.request("POST", "/ns/east/sensor", CreateSensor("E1", "V", "Boston", "foo", 1, 1)).Code)
+	suite.Equal(404, suite.request("GET", "/sensor/E1", nil).Code)
+	var namespaces []*Namespace
+	suite.Nil(json.Unmarshal(suite.request("GET", "/namespaces", nil).Body.Bytes(), &namespaces))
+	suite.Len(namespaces, 1)
+}
+
+func (suite *testSuite) TestPrivateMemoryStores() {
+	// Every connection to a private in-memory database opens an empty
+	// one, so its stores and their namespaces keep to one connection.
+	for _, dsn := range []string{":memory:", "file::memory:", ":memory:?_foreign_keys=1", "file:pingthings?mode=memory"} {
+		srv, err := New("fakeaddress", WithDatabase(dsn), WithLaxReferences())
+		suite.Nil(err, dsn)
+		suite.srv = srv
+		suite.Equal(201, suite.request("POST", "/namespaces", &Namespace{Name: "east"}).Code, dsn)
+		for _, ns := range srv.allNamespaces() {
+			suite.Equal(1, ns.db.conn.Stats().MaxOpenConnections, dsn)
+		}
+		srv.shutdown()
+	}
+
+	srv, err := New("fakeaddress", WithDatabase("file::memory:?cache=shared"))
+	suite.Nil(err)
+	defer srv.shutdown()
+	suite.Zero(srv.db.conn.Stats().MaxOpenConnections)
+}
+
+func (suite *testSuite) TestNamespaceKeys() {
+	var err error
+	suite.srv, err = New("fakeaddress", WithAPIKeys(), WithLaxReferences())
+	suite.Nil(err)
+	admin, err := suite.srv.db.createAPIKey(context.Background(), "admin", ScopeAdmin)
+	suite.Nil(err)
+	writer, err := suite.srv.db.createAPIKey(context.Background(), "writer", ScopeWrite)
+	suite.Nil(err)
+
+	// Keys from the default namespace work in every namespace.
+	suite.Equal(403, suite.requestWithKey(writer.Key, "POST", "/namespaces", &Namespace{Name: "east"}).Code)
+	suite.Equal(201, suite.requestWithKey(admin.Key, "POST", "/namespaces", &Namespace{Name: "east"}).Code)
+	sensor := CreateSensor("E1", "V", "Boston", "foo", 1, 1)
+	suite.Equal(401, suite.requestWithKey("", "POST", "/ns/east/sensor", sensor).Code)
+	suite.Equal(201, suite.requestWithKey(writer.Key, "POST", "/ns/east/sensor", sensor).Code)
+	suite.Equal(403, suite.requestWithKey(writer.Key, "DELETE", "/ns/east/sensor/E1", nil).Code)
+	suite.Equal(204, suite.requestWithKey(admin.Key, "DELETE", "/ns/east/sensor/E1", nil).Code)
+}
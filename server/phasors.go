@@ -0,0 +1,456 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Magnitude and angle sensors of a phasor must be within this many km.
+const phasorLocationTolerance = 0.01
+
+var errPhasorNotFound = errors.New("Phasor not found in store")
+
+// A phasor pairs the magnitude and angle sensors that together
+// measure one quantity, e.g. L1MAG and L1ANG form phasor L1.
+type Phasor struct {
+	ID        string `json:"id"`
+	Magnitude string `json:"magnitude"`
+	Angle     string `json:"angle"`
+}
+
+// A phasor with its member sensors and, when both have
+// readings at the same instant, the latest combined value.
+type PhasorDetail struct {
+	Phasor
+	Members struct {
+		Magnitude *Sensor `json:"magnitude"`
+		Angle     *Sensor `json:"angle"`
+	} `json:"members"`
+	Latest *PhasorValue `json:"latest,omitempty"`
+}
+
+// The complex value of a phasor at one instant. Magnitudes are
+// in the magnitude sensor's unit, angles in degrees.
+type PhasorValue struct {
+	Time  time.Time `json:"time"`
+	Polar struct {
+		Magnitude float64 `json:"magnitude"`
+		Angle     float64 `json:"angle"`
+	} `json:"polar"`
+	Rectangular struct {
+		Real      float64 `json:"real"`
+		Imaginary float64 `json:"imaginary"`
+	} `json:"rectangular"`
+}
+
+// A phasor suggested from sensor names, with the reasons it
+// can't be created as-is if validation fails.
+type PhasorSuggestion struct {
+	Phasor
+	Problems []string `json:"problems,omitempty"`
+}
+
+func newPhasorValue(at time.Time, magnitude, angleRad float64) *PhasorValue {
+	value := &PhasorValue{Time: at}
+	value.Polar.Magnitude = magnitude
+	value.Polar.Angle = angleRad * 180 / math.Pi
+	value.Rectangular.Real = magnitude * math.Cos(angleRad)
+	value.Rectangular.Imaginary = magnitude * math.Sin(angleRad)
+	return value
+}
+
+// Query every phasor, ordered by id.
+func (db *store) queryPhasors(ctx context.Context) (phasors []*Phasor, err error) {
+	return db.queryPhasorsWhere(ctx, "")
+}
+
+// Query a phasor by id.
+func (db *store) queryPhasor(ctx context.Context, id string) (_ *Phasor, err error) {
+	var phasors []*Phasor
+	if phasors, err = db.queryPhasorsWhere(ctx, "id = ?", id); err != nil {
+		return nil, err
+	}
+	if len(phasors) == 0 {
+		return nil, errPhasorNotFound
+	}
+	return phasors[0], nil
+}
+
+func (db *store) queryPhasorsWhere(ctx context.Context, where string, args ...interface{}) (phasors []*Phasor, err error) {
+	selectStatement := `SELECT id, magnitude, angle FROM phasors`
+	if where != "" {
+		selectStatement += ` WHERE ` + where
+	}
+	selectStatement += ` ORDER BY id`
+
+	var rows *timedRows
+	if rows, err = db.conn.QueryContext(ctx, selectStatement, args...); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		phasor := &Phasor{}
+		if err = rows.Scan(&phasor.ID, &phasor.Magnitude, &phasor.Angle); err != nil {
+			return nil, err
+		}
+		phasors = append(phasors, phasor)
+	}
+
+	return phasors, rows.Err()
+}
+
+// Insert a phasor. Sensors can only belong to one phasor.
+func (db *store) insertPhasor(ctx context.Context, phasor *Phasor) (err error) {
+	var existing []*Phasor
+	if existing, err = db.queryPhasorsWhere(ctx,
+		"id = ? OR magnitude IN (?, ?) OR angle IN (?, ?)",
+		phasor.ID, phasor.Magnitude, phasor.Angle, phasor.Magnitude, phasor.Angle,
+	); err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return &conflictError{fmt.Sprintf("conflicts with phasor %s", existing[0].ID)}
+	}
+
+	insertStatement := `
+		INSERT INTO phasors (id, magnitude, angle)
+		VALUES(?, ?, ?)`
+	if _, err = db.conn.ExecContext(ctx, insertStatement, phasor.ID, phasor.Magnitude, phasor.Angle); err != nil {
+		return err
+	}
+	db.sensorsVersion.Add(1)
+	return nil
+}
+
+// Delete a phasor, leaving its member sensors in place.
+func (db *store) deletePhasor(ctx context.Context, id string) (err error) {
+	var result sql.Result
+	if result, err = db.conn.ExecContext(ctx, `DELETE FROM phasors WHERE id = ?`, id); err != nil {
+		return err
+	}
+	if err = requireAffected(result, errPhasorNotFound); err != nil {
+		return err
+	}
+	db.sensorsVersion.Add(1)
+	return nil
+}
+
+// Check that a phasor's members exist, measure a magnitude and an
+// angle respectively, and are at the same location.
+func (s *Server) validatePhasor(ctx context.Context, phasor *Phasor) (detail *PhasorDetail, err error) {
+	verr := &ValidationError{}
+	if phasor == nil {
+		verr.add("phasor", "required", "is required")
+		return nil, verr
+	}
+	detail = &PhasorDetail{Phasor: *phasor}
+
+	if phasor.ID == "" {
+		verr.add("id", "required", "is required")
+	}
+
+	if phasor.Magnitude == "" {
+		verr.add("magnitude", "required", "is required")
+	} else if detail.Members.Magnitude, err = s.db.querySensor(ctx, phasor.Magnitude); err != nil {
+		verr.add("magnitude", "exists", fmt.Sprintf("references unknown sensor %q", phasor.Magnitude))
+	}
+
+	if phasor.Angle == "" {
+		verr.add("angle", "required", "is required")
+	} else if detail.Members.Angle, err = s.db.querySensor(ctx, phasor.Angle); err != nil {
+		verr.add("angle", "exists", fmt.Sprintf("references unknown sensor %q", phasor.Angle))
+	}
+
+	checkPhasorMembers(verr, detail.Members.Magnitude, detail.Members.Angle)
+	return detail, verr.orNil()
+}
+
+// Check the members of a phasor measure a magnitude and an angle and
+// are at the same location, skipping members that are nil.
+func checkPhasorMembers(verr *ValidationError, magnitude, angle *Sensor) {
+	if magnitude != nil {
+		if unit, ok := LookupUnit(magnitude.Tags.Unit); ok && unit.Quantity == "angle" {
+			verr.add("magnitude", "quantity", "must not be an angle sensor")
+		}
+	}
+	if angle != nil {
+		if unit, ok := LookupUnit(angle.Tags.Unit); !ok || unit.Quantity != "angle" {
+			verr.add("angle", "quantity", "must be an angle sensor")
+		}
+	}
+
+	if magnitude != nil && angle != nil {
+		if magnitude.Name == angle.Name {
+			verr.add("angle", "distinct", "must be a different sensor than the magnitude")
+		} else if magnitude.Location == nil || angle.Location == nil {
+			verr.add("angle", "location", fmt.Sprintf("must have a location, as must %s", magnitude.Name))
+		} else if haversine(magnitude.Location, angle.Location) > phasorLocationTolerance {
+			verr.add("angle", "location", fmt.Sprintf("must be at the same location as %s", magnitude.Name))
+		}
+	}
+}
+
+// Check that updating a sensor keeps the phasor it belongs to, if any,
+// valid.
+func (s *Server) checkSensorPhasor(ctx context.Context, sensor *Sensor) error {
+	phasors, err := s.db.queryPhasorsWhere(ctx, "magnitude = ? OR angle = ?", sensor.Name, sensor.Name)
+	if err != nil {
+		return err
+	}
+
+	if len(phasors) == 0 {
+		return nil
+	}
+	// Compare the sensor where it will be, which may be its asset's location.
+	updated := *sensor
+	if err = s.db.inheritLocations(ctx, []*Sensor{&updated}); err != nil {
+		return err
+	}
+
+	verr := &ValidationError{}
+	for _, phasor := range phasors {
+		members := &ValidationError{}
+		var magnitude, angle *Sensor
+		if phasor.Magnitude == sensor.Name {
+			magnitude = &updated
+			if angle, err = s.db.querySensor(ctx, phasor.Angle); err != nil {
+				return err
+			}
+		} else {
+			angle = &updated
+			if magnitude, err = s.db.querySensor(ctx, phasor.Magnitude); err != nil {
+				return err
+			}
+		}
+		checkPhasorMembers(members, magnitude, angle)
+		for _, field := range members.Fields {
+			verr.add("phasor", field.Rule, fmt.Sprintf("would break phasor %s, whose %s %s", phasor.ID, field.Field, field.Message))
+		}
+	}
+	return verr.orNil()
+}
+
+// Find the latest instant with both a magnitude and an angle reading.
+func (s *Server) latestPhasorValue(ctx context.Context, detail *PhasorDetail) (*PhasorValue, error) {
+	values, err := s.phasorValues(ctx, detail, time.Time{}, time.Time{})
+	if err != nil || len(values) == 0 {
+		return nil, err
+	}
+	return values[len(values)-1], nil
+}
+
+// Combine the magnitude and angle readings that share a timestamp.
+func (s *Server) phasorValues(ctx context.Context, detail *PhasorDetail, start, end time.Time) (values []*PhasorValue, err error) {
+	angleUnit, ok := LookupUnit(detail.Members.Angle.Tags.Unit)
+	if !ok {
+		return nil, fmt.Errorf("angle sensor unit %s is not in the catalog", detail.Members.Angle.Tags.Unit)
+	}
+	radians, _ := LookupUnit("rad")
+
+	var magnitudes, angles []*Reading
+	if magnitudes, err = s.db.queryReadings(ctx, detail.Magnitude, start, end, qualityFilter{}); err != nil {
+		return nil, err
+	}
+	if angles, err = s.db.queryReadings(ctx, detail.Angle, start, end, qualityFilter{}); err != nil {
+		return nil, err
+	}
+	if err = s.db.calibrate(ctx, detail.Magnitude, magnitudes); err != nil {
+		return nil, err
+	}
+	if err = s.db.calibrate(ctx, detail.Angle, angles); err != nil {
+		return nil, err
+	}
+
+	// Both slices are ordered by time, walk them together.
+	for i, j := 0, 0; i < len(magnitudes) && j < len(angles); {
+		switch m, a := magnitudes[i], angles[j]; {
+		case m.Time.Before(a.Time):
+			i++
+		case a.Time.Before(m.Time):
+			j++
+		default:
+			var angle float64
+			if angle, err = convert(a.Value, angleUnit, radians); err != nil {
+				return nil, err
+			}
+			values = append(values, newPhasorValue(m.Time, m.Value, angle))
+			i++
+			j++
+		}
+	}
+
+	return values, nil
+}
+
+// Pair up sensors named <prefix>MAG and <prefix>ANG that
+// aren't already part of a phasor.
+func (s *Server) suggestPhasors(ctx context.Context) (suggestions []*PhasorSuggestion, err error) {
+	var sensors []*Sensor
+	if sensors, err = s.db.queryAllSensors(ctx); err != nil {
+		return nil, err
+	}
+
+	var phasors []*Phasor
+	if phasors, err = s.db.queryPhasors(ctx); err != nil {
+		return nil, err
+	}
+	paired := make(map[string]bool)
+	for _, phasor := range phasors {
+		paired[phasor.Magnitude] = true
+		paired[phasor.Angle] = true
+	}
+
+	names := make(map[string]bool, len(sensors))
+	for _, sensor := range sensors {
+		names[sensor.Name] = true
+	}
+
+	for _, sensor := range sensors {
+		prefix, ok := strings.CutSuffix(sensor.Name, "MAG")
+		if !ok || prefix == "" || paired[sensor.Name] {
+			continue
+		}
+		angle := prefix + "ANG"
+		if !names[angle] || paired[angle] {
+			continue
+		}
+
+		suggestion := &PhasorSuggestion{Phasor: Phasor{ID: prefix, Magnitude: sensor.Name, Angle: angle}}
+		if _, err = s.validatePhasor(ctx, &suggestion.Phasor); err != nil {
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				return nil, err
+			}
+			for _, field := range verr.Fields {
+				suggestion.Problems = append(suggestion.Problems, fmt.Sprintf("%s %s", field.Field, field.Message))
+			}
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	sort.Slice(suggestions, func(i, j int) bool { return suggestions[i].ID < suggestions[j].ID })
+
+	return suggestions, nil
+}
+
+// List every phasor.
+func (s *Server) listPhasors(c *gin.Context) {
+	phasors, err := s.db.queryPhasors(c.Request.Context())
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, phasors)
+}
+
+// Create a phasor from a magnitude and angle sensor. The id
+// defaults to the magnitude sensor's name without "MAG".
+func (s *Server) addPhasor(c *gin.Context) {
+	phasor := &Phasor{}
+	if err := c.BindJSON(phasor); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if phasor.ID == "" {
+		phasor.ID = strings.TrimSuffix(phasor.Magnitude, "MAG")
+	}
+
+	if _, err := s.validatePhasor(c.Request.Context(), phasor); err != nil {
+		abortInvalid(c, err)
+		return
+	}
+
+	err := s.db.insertPhasor(c.Request.Context(), phasor)
+	var conflict *conflictError
+	switch {
+	case errors.As(err, &conflict):
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, phasor)
+}
+
+// Query a phasor with both member sensors and its latest value.
+func (s *Server) getPhasor(c *gin.Context) {
+	phasor, err := s.db.queryPhasor(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	detail := &PhasorDetail{Phasor: *phasor}
+	if detail.Members.Magnitude, err = s.db.querySensor(c.Request.Context(), phasor.Magnitude); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if detail.Members.Angle, err = s.db.querySensor(c.Request.Context(), phasor.Angle); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if detail.Latest, err = s.latestPhasorValue(c.Request.Context(), detail); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, detail)
+}
+
+// Query the combined complex values of a phasor between
+// the optional start and end query parameters.
+func (s *Server) getPhasorValues(c *gin.Context) {
+	phasor, err := s.db.queryPhasor(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	start, end, err := parseTimeRange(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	detail := &PhasorDetail{Phasor: *phasor}
+	if detail.Members.Angle, err = s.db.querySensor(c.Request.Context(), phasor.Angle); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	values, err := s.phasorValues(c.Request.Context(), detail, start, end)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, values)
+}
+
+// Delete a phasor.
+func (s *Server) deletePhasor(c *gin.Context) {
+	if err := s.db.deletePhasor(c.Request.Context(), c.Param("id")); err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Suggest phasors from the MAG/ANG sensor naming convention.
+func (s *Server) listPhasorSuggestions(c *gin.Context) {
+	suggestions, err := s.suggestPhasors(c.Request.Context())
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, suggestions)
+}
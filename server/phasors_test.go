@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"time"
+)
+
+func (suite *testSuite) TestPhasors() {
+	// The fixture's L1 pair is suggested but at different locations.
+	response := suite.request("GET", "/phasors/suggestions", nil)
+	suite.Equal(200, response.Code)
+
+	var suggestions []*PhasorSuggestion
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &suggestions))
+	suite.Len(suggestions, 1)
+	suite.Equal(Phasor{ID: "L1", Magnitude: "L1MAG", Angle: "L1ANG"}, suggestions[0].Phasor)
+	suite.Equal([]string{"angle must be at the same location as L1MAG"}, suggestions[0].Problems)
+
+	response = suite.request("POST", "/phasors", &Phasor{Magnitude: "L1MAG", Angle: "L1ANG"})
+	suite.Equal(400, response.Code)
+
+	for _, sensor := range []*Sensor{
+		CreateSensor("L2MAG", "kV", "Anaheim", "foo", 33.8, 117.9),
+		CreateSensor("L2ANG", "deg", "Anaheim", "foo", 33.8, 117.9),
+	} {
+		response = suite.request("POST", "/sensor", sensor)
+		suite.Equal(201, response.Code)
+	}
+
+	response = suite.request("POST", "/phasors", &Phasor{Magnitude: "L2ANG", Angle: "L2MAG"})
+	suite.Equal(400, response.Code)
+	response = suite.request("POST", "/phasors", &Phasor{Magnitude: "L2MAG", Angle: "L2ANG"})
+	suite.Equal(201, response.Code)
+	response = suite.request("POST", "/phasors", &Phasor{ID: "other", Magnitude: "L2MAG", Angle: "L2ANG"})
+	suite.Equal(409, response.Code)
+
+	// No readings yet, so no latest value.
+	response = suite.request("GET", "/phasors/L2", nil)
+	suite.Equal(200, response.Code)
+
+	var detail PhasorDetail
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &detail))
+	suite.Equal("L2ANG", detail.Members.Angle.Name)
+	suite.Nil(detail.Latest)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	response = suite.request("POST", "/sensor/L2MAG/readings", []*Reading{
+		{Time: start, Value: 10},
+		{Time: start.Add(time.Second), Value: 20},
+	})
+	suite.Equal(201, response.Code)
+	response = suite.request("POST", "/sensor/L2ANG/readings", []*Reading{
+		{Time: start, Value: 90},
+		{Time: start.Add(2 * time.Second), Value: 0},
+	})
+	suite.Equal(201, response.Code)
+
+	response = suite.request("GET", "/phasors/L2/values", nil)
+	suite.Equal(200, response.Code)
+
+	var values []*PhasorValue
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &values))
+	suite.Len(values, 1)
+	suite.Equal(start, values[0].Time)
+	suite.InDelta(10, values[0].Polar.Magnitude, 1e-9)
+	suite.InDelta(90, values[0].Polar.Angle, 1e-9)
+	suite.InDelta(0, values[0].Rectangular.Real, 1e-9)
+	suite.InDelta(10, values[0].Rectangular.Imaginary, 1e-9)
+
+	response = suite.request("GET", "/phasors/L2", nil)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &detail))
+	suite.NotNil(detail.Latest)
+
+	response = suite.request("DELETE", "/sensor/L2MAG", nil)
+	suite.Equal(409, response.Code)
+	response = suite.request("DELETE", "/phasors/L2", nil)
+	suite.Equal(204, response.Code)
+	response = suite.request("GET", "/phasors/L2", nil)
+	suite.Equal(404, response.Code)
+}
+
+func (suite *testSuite) TestPhasorMembersStayValid() {
+	response := suite.request("POST", "/phasors", json.RawMessage("null"))
+	suite.Equal(400, response.Code)
+	suite.Contains(response.Body.String(), "magnitude")
+
+	for _, sensor := range []*Sensor{
+		CreateSensor("L2MAG", "kV", "Anaheim", "foo", 33.8, 117.9),
+		CreateSensor("L2ANG", "deg", "Anaheim", "foo", 33.8, 117.9),
+	} {
+		suite.Equal(201, suite.request("POST", "/sensor", sensor).Code)
+	}
+	suite.Equal(201, suite.request("POST", "/phasors", &Phasor{Magnitude: "L2MAG", Angle: "L2ANG"}).Code)
+
+	// Members can't be moved apart or stop measuring an angle.
+	response = suite.request("PUT", "/sensor/L2ANG", CreateSensor("L2ANG", "deg", "Anaheim", "foo", 40, 117.9))
+	suite.Equal(400, response.Code)
+	suite.Contains(response.Body.String(), "would break phasor L2, whose angle must be at the same location as L2MAG")
+	response = suite.request("PUT", "/sensor/L2ANG", CreateSensor("L2ANG", "volts", "Anaheim", "foo", 33.8, 117.9))
+	suite.Equal(400, response.Code)
+	response = suite.request("PUT", "/sensor/L2MAG", CreateSensor("L2MAG", "rad", "Anaheim", "foo", 33.8, 117.9))
+	suite.Equal(400, response.Code)
+
+	// Other changes are fine.
+	suite.Equal(200, suite.request("PUT", "/sensor/L2ANG", CreateSensor("L2ANG", "rad", "Anaheim", "foo", 33.8, 117.9)).Code)
+	suite.Equal(200, suite.request("GET", "/phasors/L2", nil).Code)
+}
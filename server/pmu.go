@@ -0,0 +1,581 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/cmplx"
+	"net"
+	"net/http"
+	"pingthings/c37118"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Distiller of sensors registered from PMU configuration frames.
+const pmuDistiller = "c37118"
+
+// Returned for data frames of a stream whose configuration hasn't arrived.
+var errNoPMUConfig = errors.New("no configuration received for stream")
+
+// Most PMU streams tracked at once. A configuration frame from a new
+// stream beyond it evicts the one idle longest.
+const maxPMUStreams = 1024
+
+// Characters not allowed in sensor names, replaced when deriving
+// names from PMU channels.
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// A network address PMUs send C37.118 frames to.
+type pmuListener struct {
+	network string // tcp or udp.
+	addr    string
+}
+
+// Listen for C37.118 frames from PMUs on addr, over "tcp" or "udp".
+// Can be given more than once.
+func WithPMUListener(network, addr string) Option {
+	return func(o *options) {
+		o.pmuListeners = append(o.pmuListeners, pmuListener{network, addr})
+	}
+}
+
+// Register sensors for PMU channels that don't map to one yet,
+// as described by the stream's configuration frames.
+func WithPMUAutoRegister() Option {
+	return func(o *options) {
+		o.pmuAutoRegister = true
+	}
+}
+
+// A data stream from a PMU or PDC, identified by where it comes
+// from and its IDCODE, and how its channels map to sensors.
+type PMUStream struct {
+	Network   string        `json:"network"`
+	Source    string        `json:"source"`
+	IDCode    uint16        `json:"id_code"`
+	Stations  []string      `json:"stations"`
+	Rate      float64       `json:"rate"`
+	Frames    int64         `json:"frames"`
+	Dropped   int64         `json:"dropped"`
+	LastFrame *time.Time    `json:"last_frame,omitempty"`
+	Channels  []*PMUChannel `json:"channels"`
+
+	config  *c37118.Config
+	version uint64    // store's sensorsVersion when Channels were mapped.
+	seen    time.Time // when the last frame arrived.
+}
+
+// A channel of a stream and the sensors its values are written to.
+// Phasors map to a magnitude and an angle sensor, the other kinds to
+// one sensor. Channels without sensors are ignored.
+type PMUChannel struct {
+	Station   string `json:"station"`
+	Channel   string `json:"channel"`
+	Kind      string `json:"kind"`
+	Sensor    string `json:"sensor,omitempty"`
+	Magnitude string `json:"magnitude,omitempty"`
+	Angle     string `json:"angle,omitempty"`
+
+	pmu, index int
+	// Units values arrive in and are converted from, and the units
+	// of the sensors they're converted to.
+	from, to, angleTo *Unit
+}
+
+// Kinds of PMU channel.
+const (
+	ChannelPhasor    = "phasor"
+	ChannelFrequency = "frequency"
+	ChannelROCOF     = "rocof"
+	ChannelAnalog    = "analog"
+)
+
+// Reading quality from a PMU's STAT word.
+func statQuality(stat uint16) (quality Quality) {
+	flags := []struct {
+		stat    uint16
+		quality Quality
+	}{
+		{c37118.StatDataError, QualityInvalid},
+		{c37118.StatSyncLost, QualitySyncLost},
+		{c37118.StatSortByArrival, QualitySortByArrival},
+		{c37118.StatTrigger, QualityTrigger},
+		{c37118.StatConfigChanged, QualityConfigChanged},
+		{c37118.StatModified, QualityModified},
+	}
+	for _, flag := range flags {
+		if stat&flag.stat != 0 {
+			quality |= flag.quality
+		}
+	}
+	return quality
+}
+
+// A sensor name from a channel or station name.
+func pmuSensorName(name string) string {
+	return unsafeNameChars.ReplaceAllString(strings.TrimSpace(name), "_")
+}
+
+// Map every channel of a configuration to sensors. Phasor channel X
+// maps to the members of phasor X if there is one and sensors XMAG and
+// XANG otherwise, frequency and ROCOF to STATION_FREQ and
+// STATION_ROCOF, and analogs to the sensor named after them.
+func (s *Server) mapPMUChannels(ctx context.Context, cfg *c37118.Config) (channels []*PMUChannel, err error) {
+	for i, pmu := range cfg.PMUs {
+		station := pmuSensorName(pmu.Station)
+		for j, phasor := range pmu.Phasors {
+			channel := &PMUChannel{Station: pmu.Station, Channel: phasor.Name, Kind: ChannelPhasor, pmu: i, index: j}
+			channel.from, _ = LookupUnit("V")
+			if phasor.Current {
+				channel.from, _ = LookupUnit("A")
+			}
+
+			name := pmuSensorName(phasor.Name)
+			magnitude, angle := name+"MAG", name+"ANG"
+			if registered, err := s.db.queryPhasor(ctx, name); err == nil {
+				magnitude, angle = registered.Magnitude, registered.Angle
+			}
+			if channel.to, err = s.pmuSensor(ctx, cfg, station, magnitude, channel.from); err != nil {
+				return nil, err
+			}
+			radians, _ := LookupUnit("rad")
+			if channel.angleTo, err = s.pmuSensor(ctx, cfg, station, angle, radians); err != nil {
+				return nil, err
+			}
+			if channel.to != nil && channel.angleTo != nil {
+				channel.Magnitude, channel.Angle = magnitude, angle
+			}
+			channels = append(channels, channel)
+		}
+
+		for _, kind := range []struct {
+			kind, suffix, unit string
+		}{
+			{ChannelFrequency, "_FREQ", "Hz"},
+			{ChannelROCOF, "_ROCOF", "Hz/s"},
+		} {
+			channel := &PMUChannel{Station: pmu.Station, Channel: strings.ToLower(kind.suffix[1:]), Kind: kind.kind, pmu: i}
+			channel.from, _ = LookupUnit(kind.unit)
+			if channel.to, err = s.pmuSensor(ctx, cfg, station, station+kind.suffix, channel.from); err != nil {
+				return nil, err
+			}
+			if channel.to != nil {
+				channel.Sensor = station + kind.suffix
+			}
+			channels = append(channels, channel)
+		}
+
+		// Analogs carry no unit, so they're only written to
+		// sensors that already exist, as they arrive.
+		for j, analog := range pmu.Analogs {
+			channel := &PMUChannel{Station: pmu.Station, Channel: analog.Name, Kind: ChannelAnalog, pmu: i, index: j}
+			name := pmuSensorName(analog.Name)
+			if sensor, err := s.db.querySensor(ctx, name); err == nil && sensor.State != StateDecommissioned {
+				channel.Sensor = name
+			}
+			channels = append(channels, channel)
+		}
+	}
+	return channels, nil
+}
+
+// Find the sensor a channel maps to and return its unit, registering
+// it in from's unit when auto-registering. Returns nil if there is no
+// usable sensor: it doesn't exist, is decommissioned, or measures a
+// different quantity.
+func (s *Server) pmuSensor(ctx context.Context, cfg *c37118.Config, station, name string, from *Unit) (*Unit, error) {
+	sensor, err := s.db.querySensor(ctx, name)
+	switch {
+	case err == nil:
+	case !errors.Is(err, errSensorNotFound):
+		return nil, err
+	case !s.pmuAutoRegister:
+		return nil, nil
+	default:
+		if sensor, err = s.registerPMUSensor(ctx, cfg, station, name, from); err != nil || sensor == nil {
+			return nil, err
+		}
+	}
+
+	if sensor.State == StateDecommissioned {
+		return nil, nil
+	}
+	unit, ok := LookupUnit(sensor.Tags.Unit)
+	if !ok || unit.Quantity != from.Quantity {
+		s.logger.WarnContext(ctx, "c37118: ignoring channel of a sensor measuring something else", "sensor", name, "unit", sensor.Tags.Unit, "quantity", from.Quantity)
+		return nil, nil
+	}
+	return unit, nil
+}
+
+// Register a sensor for a PMU channel under an ingress named after
+// its station. Angles are registered in degrees.
+func (s *Server) registerPMUSensor(ctx context.Context, cfg *c37118.Config, station, name string, unit *Unit) (*Sensor, error) {
+	if unit.Quantity == "angle" {
+		unit, _ = LookupUnit("deg")
+	}
+	sensor := &Sensor{
+		Name:       name,
+		SampleRate: cfg.Rate(),
+		Tags:       SensorTags{Unit: unit.Symbol, Ingress: station, Distiller: pmuDistiller},
+	}
+	if err := ValidateSensor(sensor); err != nil {
+		s.logger.WarnContext(ctx, "c37118: can't register sensor for channel", "error", err)
+		return nil, nil
+	}
+
+	for _, kind := range []*componentKind{ingressKind, distillerKind} {
+		name := station
+		if kind == distillerKind {
+			name = pmuDistiller
+		}
+		if err := ensureComponent(ctx, s.db.conn, kind, name); err != nil {
+			return nil, err
+		}
+	}
+
+	// Another stream may have registered it first.
+	if err := s.db.insertSensor(ctx, sensor); err != nil {
+		if existing, queryErr := s.db.querySensor(ctx, name); queryErr == nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+	s.logger.InfoContext(ctx, "c37118: registered sensor", "sensor", name, "station", station)
+	return s.db.querySensor(ctx, name)
+}
+
+// Key of a stream in the server's stream table.
+func pmuStreamKey(network, source string, idCode uint16) string {
+	return fmt.Sprintf("%s://%s/%d", network, source, idCode)
+}
+
+// Handle one frame from a PMU. Data frames are decoded with the
+// stream's latest configuration and written to the mapped sensors.
+func (s *Server) handlePMUFrame(ctx context.Context, network, source string, frame []byte) (err error) {
+	var header *c37118.Header
+	if header, err = c37118.ParseHeader(frame); err != nil {
+		return err
+	}
+	key := pmuStreamKey(network, source, header.IDCode)
+
+	switch header.Type {
+	case c37118.TypeConfig1, c37118.TypeConfig2:
+		var cfg *c37118.Config
+		if cfg, err = c37118.ParseConfig(frame); err != nil {
+			return err
+		}
+		return s.configurePMUStream(ctx, network, source, key, cfg)
+	case c37118.TypeData:
+	default:
+		return nil
+	}
+
+	s.pmuMu.Lock()
+	stream := s.pmuStreams[key]
+	if stream == nil || stream.config == nil {
+		s.pmuMu.Unlock()
+		return errNoPMUConfig
+	}
+	stream.seen = time.Now()
+	cfg, channels, version := stream.config, stream.Channels, stream.version
+	s.pmuMu.Unlock()
+
+	// Sensors or phasors changed since the channels were mapped, so
+	// they may map to different sensors now.
+	if current := s.db.sensorsVersion.Load(); version != current {
+		if channels, err = s.mapPMUChannels(ctx, cfg); err != nil {
+			return err
+		}
+		s.pmuMu.Lock()
+		if stream.config == cfg {
+			stream.Channels, stream.version = channels, current
+		}
+		s.pmuMu.Unlock()
+	}
+
+	data, err := c37118.ParseData(frame, cfg)
+
+	s.pmuMu.Lock()
+	if err != nil {
+		stream.Dropped++
+	} else {
+		stream.Frames++
+		at := data.Time
+		stream.LastFrame = &at
+	}
+	s.pmuMu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	return s.ingestPMUData(ctx, data, channels)
+}
+
+// Record a stream's configuration and map its channels, unless it's
+// the same as the one already mapped.
+func (s *Server) configurePMUStream(ctx context.Context, network, source, key string, cfg *c37118.Config) (err error) {
+	s.pmuMu.Lock()
+	stream := s.pmuStreams[key]
+	if stream == nil {
+		if len(s.pmuStreams) >= maxPMUStreams {
+			s.evictIdlePMUStream()
+		}
+		stream = &PMUStream{Network: network, Source: source, IDCode: cfg.IDCode}
+		s.pmuStreams[key] = stream
+	}
+	stream.seen = time.Now()
+	unchanged := stream.config != nil && sameConfig(stream.config, cfg)
+	s.pmuMu.Unlock()
+	if unchanged {
+		return nil
+	}
+
+	version := s.db.sensorsVersion.Load()
+	var channels []*PMUChannel
+	if channels, err = s.mapPMUChannels(ctx, cfg); err != nil {
+		return err
+	}
+
+	stations := make([]string, len(cfg.PMUs))
+	for i, pmu := range cfg.PMUs {
+		stations[i] = pmu.Station
+	}
+
+	s.pmuMu.Lock()
+	stream.config, stream.Channels, stream.Stations, stream.Rate = cfg, channels, stations, cfg.Rate()
+	stream.version = version
+	s.pmuMu.Unlock()
+	return nil
+}
+
+// Forget the stream that has gone longest without a frame. Called
+// with pmuMu held.
+func (s *Server) evictIdlePMUStream() {
+	var idlest string
+	for key, stream := range s.pmuStreams {
+		if idlest == "" || stream.seen.Before(s.pmuStreams[idlest].seen) {
+			idlest = key
+		}
+	}
+	delete(s.pmuStreams, idlest)
+}
+
+// Whether two configurations describe the same stream, ignoring
+// when they were sent.
+func sameConfig(a, b *c37118.Config) bool {
+	x, y := *a, *b
+	x.Time, y.Time = time.Time{}, time.Time{}
+	return reflect.DeepEqual(x, y)
+}
+
+// Write a data frame's values to the sensors their channels map to.
+// Values PMUs send as missing, NaN in float frames, are skipped, and a
+// sensor whose readings can't be stored doesn't hold up the others.
+func (s *Server) ingestPMUData(ctx context.Context, data *c37118.Data, channels []*PMUChannel) (err error) {
+	radians, _ := LookupUnit("rad")
+	batches := make(map[string][]*Reading)
+	add := func(name string, value float64, from, to *Unit, quality Quality) error {
+		if !isFinite(value) {
+			return nil
+		}
+		if value, err = convert(value, from, to); err != nil {
+			return err
+		}
+		batches[name] = append(batches[name], &Reading{Time: data.Time, Value: value, Quality: quality})
+		return nil
+	}
+
+	for _, channel := range channels {
+		values := data.PMUs[channel.pmu]
+		quality := statQuality(values.Stat)
+		switch {
+		case channel.Kind == ChannelPhasor && channel.Magnitude != "":
+			magnitude, angle := cmplx.Polar(values.Phasors[channel.index])
+			if err = add(channel.Magnitude, magnitude, channel.from, channel.to, quality); err != nil {
+				return err
+			}
+			if err = add(channel.Angle, angle, radians, channel.angleTo, quality); err != nil {
+				return err
+			}
+		case channel.Kind == ChannelFrequency && channel.Sensor != "":
+			if err = add(channel.Sensor, values.Frequency, channel.from, channel.to, quality); err != nil {
+				return err
+			}
+		case channel.Kind == ChannelROCOF && channel.Sensor != "":
+			if err = add(channel.Sensor, values.ROCOF, channel.from, channel.to, quality); err != nil {
+				return err
+			}
+		case channel.Kind == ChannelAnalog && channel.Sensor != "":
+			if value := values.Analogs[channel.index]; isFinite(value) {
+				batches[channel.Sensor] = append(batches[channel.Sensor], &Reading{Time: data.Time, Value: value, Quality: quality})
+			}
+		}
+	}
+
+	var errs []error
+	for name, readings := range batches {
+		if err = s.db.insertReadings(ctx, name, readings); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		if err = s.evaluateReadings(ctx, name, readings); err != nil {
+			s.logger.ErrorContext(ctx, "evaluating alarm rules", "sensor", name, "error", err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func isFinite(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
+
+// Start listening for PMU frames on addr, returning the address
+// bound. Listeners close when the server shuts down.
+func (s *Server) listenPMU(network, addr string) (net.Addr, error) {
+	switch network {
+	case "tcp":
+		listener, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		go func() {
+			<-s.done
+			listener.Close()
+		}()
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go s.servePMUConn(conn)
+			}
+		}()
+		s.logger.Info("listening for C37.118 frames", "network", "tcp", "address", listener.Addr().String())
+		return listener.Addr(), nil
+
+	case "udp":
+		conn, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		go func() {
+			<-s.done
+			conn.Close()
+		}()
+		go s.servePMUPackets(conn)
+		s.logger.Info("listening for C37.118 frames", "network", "udp", "address", conn.LocalAddr().String())
+		return conn.LocalAddr(), nil
+	}
+	return nil, fmt.Errorf("unknown PMU listener network %q, use tcp or udp", network)
+}
+
+// Read frames from a PMU connected over TCP. As the data concentrator
+// the server asks for the configuration and turns data on when the PMU
+// connects, and asks again for streams it has no configuration for.
+func (s *Server) servePMUConn(conn net.Conn) {
+	defer conn.Close()
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		select {
+		case <-s.done:
+			conn.Close()
+		case <-closed:
+		}
+	}()
+
+	source := conn.RemoteAddr().String()
+	command := func(idCode uint16, commands ...c37118.Command) error {
+		for _, cmd := range commands {
+			frame, err := c37118.MarshalCommand(idCode, cmd, time.Now())
+			if err == nil {
+				_, err = conn.Write(frame)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// The PMU's IDCODE isn't known yet, address the commands to any.
+	if err := command(0, c37118.CommandSendConfig2, c37118.CommandStart); err != nil {
+		s.logger.Warn("c37118: stream error", "source", source, "error", err)
+		return
+	}
+
+	ctx := context.Background()
+	requested := make(map[uint16]bool)
+	for {
+		frame, err := c37118.ReadFrame(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				s.logger.Warn("c37118: stream error", "source", source, "error", err)
+			}
+			return
+		}
+
+		switch err = s.handlePMUFrame(ctx, "tcp", source, frame); {
+		case errors.Is(err, errNoPMUConfig):
+			header, _ := c37118.ParseHeader(frame)
+			if !requested[header.IDCode] {
+				requested[header.IDCode] = true
+				if err = command(header.IDCode, c37118.CommandSendConfig2); err != nil {
+					s.logger.Warn("c37118: stream error", "source", source, "error", err)
+					return
+				}
+			}
+		case err != nil:
+			s.logger.Warn("c37118: stream error", "source", source, "error", err)
+		}
+	}
+}
+
+// Read frames sent over UDP, one per datagram. PMUs sending over UDP
+// repeat their configuration, data frames before it are dropped.
+func (s *Server) servePMUPackets(conn net.PacketConn) {
+	ctx := context.Background()
+	buffer := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.logger.Warn("c37118: receive error", "error", err)
+			}
+			return
+		}
+
+		frame := append([]byte(nil), buffer[:n]...)
+		if err = c37118.Verify(frame); err == nil {
+			err = s.handlePMUFrame(ctx, "udp", addr.String(), frame)
+		}
+		if err != nil && !errors.Is(err, errNoPMUConfig) {
+			s.logger.Warn("c37118: stream error", "source", addr.String(), "error", err)
+		}
+	}
+}
+
+// List the PMU streams seen and how their channels map to sensors.
+func (s *Server) listPMUStreams(c *gin.Context) {
+	s.pmuMu.Lock()
+	streams := make([]PMUStream, 0, len(s.pmuStreams))
+	for _, stream := range s.pmuStreams {
+		streams = append(streams, *stream)
+	}
+	s.pmuMu.Unlock()
+
+	sort.Slice(streams, func(i, j int) bool {
+		return pmuStreamKey(streams[i].Network, streams[i].Source, streams[i].IDCode) <
+			pmuStreamKey(streams[j].Network, streams[j].Source, streams[j].IDCode)
+	})
+	c.IndentedJSON(http.StatusOK, streams)
+}
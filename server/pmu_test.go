@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"math/cmplx"
+	"net"
+	"pingthings/c37118"
+	"time"
+)
+
+func pmuTestConfig(station string, phasors ...c37118.PhasorChannel) *c37118.Config {
+	return &c37118.Config{
+		IDCode:   1,
+		TimeBase: 1000000,
+		DataRate: 30,
+		PMUs: []*c37118.PMUConfig{{
+			Station:          station,
+			IDCode:           1,
+			Polar:            true,
+			FloatPhasors:     true,
+			FloatFrequency:   true,
+			Phasors:          phasors,
+			NominalFrequency: 60,
+		}},
+	}
+}
+
+func (suite *testSuite) TestPMUFrames() {
+	cfg := pmuTestConfig("SUB1", c37118.PhasorChannel{Name: "L1"}, c37118.PhasorChannel{Name: "C1", Current: true})
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	data, err := cfg.MarshalData(&c37118.Data{
+		IDCode: 1,
+		Time:   at,
+		PMUs: []*c37118.PMUData{{
+			Stat:      c37118.StatSyncLost,
+			Phasors:   []complex128{cmplx.Rect(7200, math.Pi/2), cmplx.Rect(400, 0)},
+			Frequency: 60.01,
+		}},
+	})
+	suite.Nil(err)
+
+	// Data can't be decoded before the configuration arrives.
+	suite.ErrorIs(suite.srv.handlePMUFrame(context.Background(), "tcp", "pmu:4712", data), errNoPMUConfig)
+
+	frame, err := cfg.Marshal()
+	suite.Nil(err)
+	suite.Nil(suite.srv.handlePMUFrame(context.Background(), "tcp", "pmu:4712", frame))
+	suite.Nil(suite.srv.handlePMUFrame(context.Background(), "tcp", "pmu:4712", data))
+
+	readings := func(name string) []*Reading {
+		response := suite.request("GET", "/sensor/"+name+"/readings", nil)
+		suite.Equal(200, response.Code)
+		var body Readings
+		suite.Nil(json.Unmarshal(response.Body.Bytes(), &body))
+		return body.Readings
+	}
+	magnitude := readings("L1MAG")
+	suite.Len(magnitude, 1)
+	suite.Equal(at, magnitude[0].Time)
+	suite.InDelta(7200, magnitude[0].Value, 1e-3)
+	suite.Equal(QualitySyncLost, magnitude[0].Quality)
+	angle := readings("L1ANG")
+	suite.Len(angle, 1)
+	suite.InDelta(90, angle[0].Value, 1e-3)
+
+	// C1 has no angle sensor, so neither of its values is stored.
+	suite.Empty(readings("C1MAG"))
+
+	var streams []*PMUStream
+	response := suite.request("GET", "/pmus", nil)
+	suite.Equal(200, response.Code)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &streams))
+	suite.Len(streams, 1)
+	stream := streams[0]
+	suite.Equal([]string{"SUB1"}, stream.Stations)
+	suite.Equal(int64(1), stream.Frames)
+	suite.Equal(30.0, stream.Rate)
+	suite.Equal("L1MAG", stream.Channels[0].Magnitude)
+	suite.Empty(stream.Channels[1].Magnitude)
+	suite.Equal(ChannelFrequency, stream.Channels[2].Kind)
+	suite.Empty(stream.Channels[2].Sensor)
+
+	// Channels are mapped again once the sensors change.
+	response = suite.request("POST", "/sensor", CreateSensor("C1ANG", "deg", "New Zealand", "baz", 37.8, 175.7))
+	suite.Equal(201, response.Code)
+	data, err = cfg.MarshalData(&c37118.Data{
+		IDCode: 1,
+		Time:   at.Add(time.Second),
+		PMUs:   []*c37118.PMUData{{Phasors: []complex128{cmplx.Rect(7200, math.Pi/2), cmplx.Rect(400, 0)}, Frequency: 60}},
+	})
+	suite.Nil(err)
+	suite.Nil(suite.srv.handlePMUFrame(context.Background(), "tcp", "pmu:4712", data))
+	suite.Len(readings("C1MAG"), 1)
+	suite.Len(readings("C1ANG"), 1)
+
+	// Past the limit, new streams replace the one idle longest.
+	suite.srv.pmuMu.Lock()
+	for i := len(suite.srv.pmuStreams); i < maxPMUStreams; i++ {
+		suite.srv.pmuStreams[pmuStreamKey("udp", "idle", uint16(i))] = &PMUStream{seen: time.Now()}
+	}
+	suite.srv.pmuStreams[pmuStreamKey("tcp", "pmu:4712", 1)].seen = time.Now().Add(-time.Hour)
+	suite.srv.pmuMu.Unlock()
+	suite.Nil(suite.srv.handlePMUFrame(context.Background(), "tcp", "pmu:4713", frame))
+	suite.Len(suite.srv.pmuStreams, maxPMUStreams)
+	suite.NotContains(suite.srv.pmuStreams, pmuStreamKey("tcp", "pmu:4712", 1))
+	suite.Contains(suite.srv.pmuStreams, pmuStreamKey("tcp", "pmu:4713", 1))
+}
+
+func (suite *testSuite) TestPMUAutoRegister() {
+	var err error
+	suite.srv, err = New("fakeaddress", WithPMUAutoRegister())
+	suite.Nil(err)
+
+	cfg := pmuTestConfig("SUB 1", c37118.PhasorChannel{Name: "V1"})
+	frame, err := cfg.Marshal()
+	suite.Nil(err)
+	suite.Nil(suite.srv.handlePMUFrame(context.Background(), "udp", "pmu:4713", frame))
+
+	for name, unit := range map[string]string{"V1MAG": "V", "V1ANG": "deg", "SUB_1_FREQ": "Hz", "SUB_1_ROCOF": "Hz/s"} {
+		sensor, err := suite.srv.db.querySensor(context.Background(), name)
+		suite.Nil(err, name)
+		suite.Equal(unit, sensor.Tags.Unit)
+		suite.Equal("SUB_1", sensor.Tags.Ingress)
+		suite.Equal(pmuDistiller, sensor.Tags.Distiller)
+		suite.Equal(30.0, sensor.SampleRate)
+	}
+
+	// The same configuration again doesn't remap the stream.
+	suite.Nil(suite.srv.handlePMUFrame(context.Background(), "udp", "pmu:4713", frame))
+
+	// Missing values are skipped without dropping the rest of the frame.
+	data, err := cfg.MarshalData(&c37118.Data{
+		IDCode: 1,
+		Time:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		PMUs:   []*c37118.PMUData{{Phasors: []complex128{cmplx.NaN()}, Frequency: 60, ROCOF: math.Inf(1)}},
+	})
+	suite.Nil(err)
+	suite.Nil(suite.srv.handlePMUFrame(context.Background(), "udp", "pmu:4713", data))
+	for name, count := range map[string]int{"V1MAG": 0, "V1ANG": 0, "SUB_1_FREQ": 1, "SUB_1_ROCOF": 0} {
+		response := suite.request("GET", "/sensor/"+name+"/readings", nil)
+		suite.Equal(200, response.Code, name)
+		var body Readings
+		suite.Nil(json.Unmarshal(response.Body.Bytes(), &body))
+		suite.Len(body.Readings, count, name)
+	}
+}
+
+func (suite *testSuite) TestPMUListeners() {
+	defer close(suite.srv.done)
+
+	for _, network := range []string{"tcp", "udp"} {
+		addr, err := suite.srv.listenPMU(network, "127.0.0.1:0")
+		suite.Nil(err)
+
+		generator, err := c37118.NewGenerator("SUB1", 1, 50, []string{"L1"})
+		suite.Nil(err)
+		conn, err := net.Dial(network, addr.String())
+		suite.Nil(err)
+		stop := make(chan struct{})
+		go generator.Serve(conn, stop)
+
+		// Wait for a few frames to be stored.
+		stored := func() bool {
+			readings, err := suite.srv.db.queryReadings(context.Background(), "L1MAG", time.Time{}, time.Time{}, qualityFilter{})
+			suite.Nil(err)
+			return len(readings) >= 3
+		}
+		suite.Eventually(stored, 5*time.Second, 20*time.Millisecond, network)
+
+		close(stop)
+		conn.Close()
+		_, err = suite.srv.db.conn.Exec(`DELETE FROM readings`)
+		suite.Nil(err)
+	}
+
+	_, err := suite.srv.listenPMU("sctp", "127.0.0.1:0")
+	suite.Error(err)
+}
@@ -0,0 +1,349 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Quality flags of a reading, modeled on the C37.118 STAT word.
+// A reading without flags is good.
+type Quality uint32
+
+const (
+	QualityInvalid       Quality = 1 << iota // the measurement is invalid.
+	QualitySyncLost                          // the PMU lost time synchronization.
+	QualitySortByArrival                     // sorted by arrival rather than timestamp.
+	QualityTrigger                           // the PMU trigger was set.
+	QualityConfigChanged                     // the configuration changed.
+	QualityModified                          // modified after measurement, e.g. interpolated.
+)
+
+// Every quality flag and its name, in bit order.
+var qualityFlags = []struct {
+	flag Quality
+	name string
+}{
+	{QualityInvalid, "invalid"},
+	{QualitySyncLost, "sync_lost"},
+	{QualitySortByArrival, "sort_by_arrival"},
+	{QualityTrigger, "trigger"},
+	{QualityConfigChanged, "config_changed"},
+	{QualityModified, "modified"},
+}
+
+// All quality flags set.
+const qualityAny = QualityInvalid | QualitySyncLost | QualitySortByArrival | QualityTrigger | QualityConfigChanged | QualityModified
+
+// Longest range an aggregate may split into windows.
+const maxAggregateWindows = 10000
+
+// Names of the flags set.
+func (q Quality) Names() []string {
+	names := []string{}
+	for _, f := range qualityFlags {
+		if q&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// Quality is written as a list of flag names.
+func (q Quality) MarshalJSON() ([]byte, error) {
+	return json.Marshal(q.Names())
+}
+
+// Quality is read from a list of flag names or the raw bitmask.
+func (q *Quality) UnmarshalJSON(data []byte) error {
+	var bits uint32
+	if err := json.Unmarshal(data, &bits); err == nil {
+		if Quality(bits)&^qualityAny != 0 {
+			return fmt.Errorf("unknown quality bits %#x", Quality(bits)&^qualityAny)
+		}
+		*q = Quality(bits)
+		return nil
+	}
+
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return errors.New("quality must be a list of flag names or a bitmask")
+	}
+	parsed, err := parseQuality(strings.Join(names, ","))
+	if err != nil {
+		return err
+	}
+	*q = parsed
+	return nil
+}
+
+// Parse comma separated flag names, or "any" for every flag.
+func parseQuality(list string) (quality Quality, err error) {
+	if list == "any" {
+		return qualityAny, nil
+	}
+
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, f := range qualityFlags {
+			if f.name == name {
+				quality |= f.flag
+				found = true
+			}
+		}
+		if !found {
+			names := make([]string, len(qualityFlags))
+			for i, f := range qualityFlags {
+				names[i] = f.name
+			}
+			return 0, fmt.Errorf("unknown quality flag %q, expected any or one of %s", name, strings.Join(names, ", "))
+		}
+	}
+	return quality, nil
+}
+
+// Selects readings by their quality flags. Readings with any of
+// the exclude flags are left out and, when only is set, readings
+// without at least one of its flags are too.
+type qualityFilter struct {
+	exclude Quality
+	only    Quality
+}
+
+// Parse the exclude and only query parameters.
+func parseQualityFilter(c *gin.Context) (filter qualityFilter, err error) {
+	if filter.exclude, err = parseQuality(c.Query("exclude")); err != nil {
+		return filter, err
+	}
+	if filter.only, err = parseQuality(c.Query("only")); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// How many points of a set were flagged, in total and per flag.
+type QualitySummary struct {
+	Points      int                     `json:"points"`
+	Good        int                     `json:"good"`
+	GoodPercent float64                 `json:"good_percent"`
+	Flags       map[string]*FlagSummary `json:"flags,omitempty"`
+}
+
+type FlagSummary struct {
+	Count   int     `json:"count"`
+	Percent float64 `json:"percent"`
+}
+
+// Quality of a sensor's readings over a range.
+type QualityReport struct {
+	Sensor string     `json:"sensor"`
+	Start  *time.Time `json:"start,omitempty"`
+	End    *time.Time `json:"end,omitempty"`
+	QualitySummary
+}
+
+// Statistics of the readings in one window. Min, max and mean cover the
+// points left after filtering, quality covers every point in the window.
+type AggregateWindow struct {
+	Start   time.Time      `json:"start"`
+	Count   int            `json:"count"`
+	Min     *float64       `json:"min,omitempty"`
+	Max     *float64       `json:"max,omitempty"`
+	Mean    *float64       `json:"mean,omitempty"`
+	Quality QualitySummary `json:"quality"`
+}
+
+// Windowed statistics of a sensor's readings.
+type Aggregate struct {
+	Sensor  string             `json:"sensor"`
+	Unit    string             `json:"unit"`
+	Window  Duration           `json:"window"`
+	Windows []*AggregateWindow `json:"windows"`
+}
+
+func summarizeQuality(readings []*Reading) (summary QualitySummary) {
+	summary.Points = len(readings)
+	for _, reading := range readings {
+		if reading.Quality == 0 {
+			summary.Good++
+			continue
+		}
+		for _, name := range reading.Quality.Names() {
+			if summary.Flags == nil {
+				summary.Flags = make(map[string]*FlagSummary)
+			}
+			if summary.Flags[name] == nil {
+				summary.Flags[name] = &FlagSummary{}
+			}
+			summary.Flags[name].Count++
+		}
+	}
+
+	if summary.Points > 0 {
+		summary.GoodPercent = percent(summary.Good, summary.Points)
+		for _, flag := range summary.Flags {
+			flag.Percent = percent(flag.Count, summary.Points)
+		}
+	}
+	return summary
+}
+
+// Percentage rounded to two decimals.
+func percent(count, total int) float64 {
+	return math.Round(float64(count)/float64(total)*10000) / 100
+}
+
+// Split readings ordered by time into windows aligned to multiples
+// of window since the Unix epoch. Windows without readings are left out.
+func aggregateReadings(readings []*Reading, window time.Duration, filter qualityFilter) (windows []*AggregateWindow) {
+	for i := 0; i < len(readings); {
+		nanos := readings[i].Time.UnixNano()
+		offset := nanos % int64(window)
+		if offset < 0 {
+			offset += int64(window)
+		}
+		start := time.Unix(0, nanos-offset)
+		end := start.Add(window)
+
+		j := i
+		for j < len(readings) && readings[j].Time.Before(end) {
+			j++
+		}
+		points := readings[i:j]
+		i = j
+
+		aggregate := &AggregateWindow{Start: start.UTC(), Quality: summarizeQuality(points)}
+		var sum float64
+		for _, reading := range points {
+			if !filter.keeps(reading.Quality) {
+				continue
+			}
+			value := reading.Value
+			if aggregate.Count == 0 || value < *aggregate.Min {
+				aggregate.Min = &value
+			}
+			if aggregate.Count == 0 || value > *aggregate.Max {
+				aggregate.Max = &value
+			}
+			sum += value
+			aggregate.Count++
+		}
+		if aggregate.Count > 0 {
+			mean := sum / float64(aggregate.Count)
+			aggregate.Mean = &mean
+		}
+		windows = append(windows, aggregate)
+	}
+	return windows
+}
+
+// Whether a reading with the given flags passes the filter.
+func (f qualityFilter) keeps(quality Quality) bool {
+	return quality&f.exclude == 0 && (f.only == 0 || quality&f.only != 0)
+}
+
+// Summarize the quality of a sensor's readings between
+// the optional start and end query parameters.
+func (s *Server) getQuality(c *gin.Context) {
+	sensor, err := s.db.querySensor(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	start, end, err := parseTimeRange(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	readings, err := s.db.queryReadings(c.Request.Context(), sensor.Name, start, end, qualityFilter{})
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	report := &QualityReport{Sensor: sensor.Name, QualitySummary: summarizeQuality(readings)}
+	if !start.IsZero() {
+		report.Start = &start
+	}
+	if !end.IsZero() {
+		report.End = &end
+	}
+	c.IndentedJSON(http.StatusOK, report)
+}
+
+// Aggregate a sensor's readings into windows of the window query
+// parameter, with the quality of each window. Points filtered out
+// with exclude or only still count towards the window's quality,
+// and raw skips calibration.
+func (s *Server) getAggregate(c *gin.Context) {
+	sensor, err := s.db.querySensor(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	window, err := time.ParseDuration(c.DefaultQuery("window", "1m"))
+	if err != nil || window <= 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "window must be a positive duration such as 30s or 5m"})
+		return
+	}
+	start, end, err := parseTimeRange(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter, err := parseQualityFilter(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	raw, err := parseRaw(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	readings, err := s.db.queryReadings(c.Request.Context(), sensor.Name, start, end, qualityFilter{})
+	if err == nil && !raw {
+		err = s.db.calibrate(c.Request.Context(), sensor.Name, readings)
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n := len(readings); n > 0 && readings[n-1].Time.Sub(readings[0].Time)/window >= maxAggregateWindows {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("range spans more than %d windows, use a larger window", maxAggregateWindows)})
+		return
+	}
+
+	response := &Readings{Sensor: sensor.Name, Unit: sensor.Tags.Unit, Readings: readings}
+	if unit := c.Query("unit"); unit != "" {
+		if err = response.convertTo(unit); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	windows := aggregateReadings(response.Readings, window, filter)
+	if windows == nil {
+		windows = []*AggregateWindow{}
+	}
+	c.IndentedJSON(http.StatusOK, &Aggregate{
+		Sensor:  sensor.Name,
+		Unit:    response.Unit,
+		Window:  Duration(window),
+		Windows: windows,
+	})
+}
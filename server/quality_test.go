@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"time"
+)
+
+func (suite *testSuite) TestQualityJSON() {
+	var reading Reading
+	suite.Nil(json.Unmarshal([]byte(`{"time": "2024-01-01T00:00:00Z", "value": 1, "quality": ["sync_lost", "invalid"]}`), &reading))
+	suite.Equal(QualityInvalid|QualitySyncLost, reading.Quality)
+	suite.Nil(json.Unmarshal([]byte(`{"quality": 4}`), &reading))
+	suite.Equal(QualitySortByArrival, reading.Quality)
+
+	suite.Error(json.Unmarshal([]byte(`{"quality": ["bogus"]}`), &reading))
+	suite.Error(json.Unmarshal([]byte(`{"quality": 1024}`), &reading))
+
+	data, err := json.Marshal(&Reading{Quality: QualityInvalid | QualityModified})
+	suite.Nil(err)
+	suite.Contains(string(data), `"quality":["invalid","modified"]`)
+
+	// Good readings don't carry a quality field.
+	data, err = json.Marshal(&Reading{})
+	suite.Nil(err)
+	suite.NotContains(string(data), "quality")
+}
+
+func (suite *testSuite) TestQualityRoutes() {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	response := suite.request("POST", "/sensor/L1MAG/readings", []*Reading{
+		{Time: start, Value: 1},
+		{Time: start.Add(10 * time.Second), Value: 2, Quality: QualitySyncLost},
+		{Time: start.Add(20 * time.Second), Value: 100, Quality: QualityInvalid | QualitySyncLost},
+		{Time: start.Add(30 * time.Second), Value: 3},
+		{Time: start.Add(70 * time.Second), Value: 5},
+	})
+	suite.Equal(201, response.Code)
+
+	readings := func(query string) (values []float64) {
+		response := suite.request("GET", "/sensor/L1MAG/readings"+query, nil)
+		suite.Equal(200, response.Code)
+
+		var body Readings
+		suite.Nil(json.Unmarshal(response.Body.Bytes(), &body))
+		for _, reading := range body.Readings {
+			values = append(values, reading.Value)
+		}
+		return values
+	}
+	suite.Equal([]float64{1, 2, 100, 3, 5}, readings(""))
+	suite.Equal([]float64{1, 2, 3, 5}, readings("?exclude=invalid"))
+	suite.Equal([]float64{1, 3, 5}, readings("?exclude=any"))
+	suite.Equal([]float64{2, 100}, readings("?only=sync_lost"))
+
+	response = suite.request("GET", "/sensor/L1MAG/readings?exclude=bogus", nil)
+	suite.Equal(400, response.Code)
+
+	response = suite.request("GET", "/sensor/L1MAG/quality?end=2024-01-01T00:01:00Z", nil)
+	suite.Equal(200, response.Code)
+
+	var report QualityReport
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &report))
+	suite.Nil(report.Start)
+	suite.Equal(4, report.Points)
+	suite.Equal(2, report.Good)
+	suite.Equal(50.0, report.GoodPercent)
+	suite.Equal(&FlagSummary{Count: 2, Percent: 50}, report.Flags["sync_lost"])
+	suite.Equal(&FlagSummary{Count: 1, Percent: 25}, report.Flags["invalid"])
+
+	// Statistics leave out excluded points, quality counts all of them.
+	response = suite.request("GET", "/sensor/L1MAG/aggregate?window=1m&exclude=invalid", nil)
+	suite.Equal(200, response.Code)
+
+	var aggregate Aggregate
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &aggregate))
+	suite.Equal(Duration(time.Minute), aggregate.Window)
+	suite.Len(aggregate.Windows, 2)
+
+	first := aggregate.Windows[0]
+	suite.Equal(start, first.Start)
+	suite.Equal(3, first.Count)
+	suite.Equal(1.0, *first.Min)
+	suite.Equal(3.0, *first.Max)
+	suite.Equal(2.0, *first.Mean)
+	suite.Equal(4, first.Quality.Points)
+	suite.Equal(50.0, first.Quality.GoodPercent)
+
+	second := aggregate.Windows[1]
+	suite.Equal(start.Add(time.Minute), second.Start)
+	suite.Equal(100.0, second.Quality.GoodPercent)
+
+	response = suite.request("GET", "/sensor/L1MAG/aggregate?window=0s", nil)
+	suite.Equal(400, response.Code)
+	response = suite.request("GET", "/sensor/L1MAG/aggregate?window=1ns", nil)
+	suite.Equal(400, response.Code)
+}
+
+func (suite *testSuite) TestInvalidReadingsSkipAlarms() {
+	rule := &AlarmRule{ID: "high", Sensor: "L1MAG", Kind: RuleAbove, Threshold: 10}
+	response := suite.request("POST", "/alarms/rules", rule)
+	suite.Equal(201, response.Code)
+
+	response = suite.request("POST", "/sensor/L1MAG/readings", []*Reading{
+		{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Value: 100, Quality: QualityInvalid},
+	})
+	suite.Equal(201, response.Code)
+	suite.Empty(suite.alarms("/alarms"))
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How often buckets that have filled up again are dropped.
+const bucketSweepInterval = time.Minute
+
+// Most clients tracked per class of request. Past it, the bucket idle
+// longest is dropped to make room for a new client.
+const maxBuckets = 10000
+
+// Routes that scan every sensor or the whole topology, limited
+// separately from other reads.
+var expensiveRoutes = map[string]bool{
+	"GET /nearest/:lat/:lon":    true,
+	"GET /topology/path":        true,
+	"GET /topology/islands":     true,
+	"GET /calibrations/overdue": true,
+}
+
+// A request quota: Burst requests at once, refilled at Rate a second.
+// A zero Rate is unlimited.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Parse a limit written as count/unit, where unit is s, m or h,
+// optionally followed by :burst. The burst defaults to the count, so
+// 300/m allows 300 requests at once, refilled at 5 a second.
+func ParseLimit(s string) (limit Limit, err error) {
+	if s == "" {
+		return limit, nil
+	}
+	quota, burst, hasBurst := strings.Cut(s, ":")
+	count, unit, ok := strings.Cut(quota, "/")
+	if !ok {
+		return limit, fmt.Errorf("limit %q isn't count/unit", s)
+	}
+
+	var n float64
+	if n, err = strconv.ParseFloat(count, 64); err != nil || n <= 0 {
+		return limit, fmt.Errorf("limit %q needs a positive count", s)
+	}
+	periods := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+	period, ok := periods[unit]
+	if !ok {
+		return limit, fmt.Errorf("limit %q has unit %q, not s, m or h", s, unit)
+	}
+	limit = Limit{Rate: n / period.Seconds(), Burst: int(math.Ceil(n))}
+
+	if hasBurst {
+		if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst < 1 {
+			return Limit{}, fmt.Errorf("limit %q needs a positive burst", s)
+		}
+	}
+	return limit, nil
+}
+
+func (l Limit) String() string {
+	if l.Rate == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%g/s:%d", l.Rate, l.Burst)
+}
+
+// Limits for each class of request, per client. Clients are told
+// apart by API key or token subject, or by IP address without one.
+type RateLimits struct {
+	Read      Limit // GET requests other than expensive ones.
+	Write     Limit // requests that change data.
+	Expensive Limit // queries scanning every sensor or the topology.
+}
+
+// Limit how fast each client can make requests.
+func WithRateLimits(limits RateLimits) Option {
+	return func(o *options) {
+		o.rateLimits = limits
+	}
+}
+
+// Take the client's address from the X-Forwarded-For or X-Real-IP
+// headers of requests coming from these proxies, given as addresses or
+// CIDR ranges. Without any, the headers are ignored, so clients can't
+// pick the address they're limited and logged by.
+func WithTrustedProxies(proxies ...string) Option {
+	return func(o *options) {
+		o.trustedProxies = proxies
+	}
+}
+
+// Token buckets of one class of request, by client.
+type bucketSet struct {
+	limit Limit
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newBucketSet(limit Limit) *bucketSet {
+	return &bucketSet{limit: limit, buckets: make(map[string]*bucket)}
+}
+
+// Take a token from a client's bucket. Returns whether there was one,
+// the tokens left, and how long until the bucket is full again, or
+// when there was no token, until there is one.
+func (bs *bucketSet) take(client string, now time.Time) (allowed bool, remaining int, wait time.Duration) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	burst := float64(bs.limit.Burst)
+	if now.Sub(bs.swept) > bucketSweepInterval {
+		for key, b := range bs.buckets {
+			if b.tokens+now.Sub(b.updated).Seconds()*bs.limit.Rate >= burst {
+				delete(bs.buckets, key)
+			}
+		}
+		bs.swept = now
+	}
+
+	b, ok := bs.buckets[client]
+	if !ok {
+		if len(bs.buckets) >= maxBuckets {
+			bs.evictIdlest()
+		}
+		b = &bucket{tokens: burst, updated: now}
+		bs.buckets[client] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*bs.limit.Rate)
+	b.updated = now
+
+	if b.tokens < 1 {
+		return false, 0, secondsDuration((1 - b.tokens) / bs.limit.Rate)
+	}
+	b.tokens--
+	return true, int(b.tokens), secondsDuration((burst - b.tokens) / bs.limit.Rate)
+}
+
+// Drop the bucket taken from longest ago. Called with mu held.
+func (bs *bucketSet) evictIdlest() {
+	var idlest string
+	for key, b := range bs.buckets {
+		if idlest == "" || b.updated.Before(bs.buckets[idlest].updated) {
+			idlest = key
+		}
+	}
+	delete(bs.buckets, idlest)
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// Rate limits of every class of request, shared by all namespaces.
+type rateLimiter struct {
+	read, write, expensive *bucketSet
+}
+
+func newRateLimiter(limits RateLimits) *rateLimiter {
+	if limits.Read.Rate == 0 && limits.Write.Rate == 0 && limits.Expensive.Rate == 0 {
+		return nil
+	}
+	return &rateLimiter{read: newBucketSet(limits.Read), write: newBucketSet(limits.Write), expensive: newBucketSet(limits.Expensive)}
+}
+
+// The buckets a request takes from.
+func (rl *rateLimiter) class(c *gin.Context) *bucketSet {
+	method := c.Request.Method
+	switch {
+	case expensiveRoutes[method+" "+c.FullPath()]:
+		return rl.expensive
+	case method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions:
+		return rl.read
+	}
+	return rl.write
+}
+
+// Middleware rejecting requests from clients over their limit with a
+// 429. Every limited response says how much of the limit is left in
+// RateLimit headers, and 429s say when to retry in Retry-After.
+func (s *Server) rateLimit(c *gin.Context) {
+	if c.FullPath() == namespaceRoute || c.FullPath() == "/health" || c.FullPath() == "/metrics" {
+		c.Next()
+		return
+	}
+	buckets := s.limiter.class(c)
+	if buckets.limit.Rate == 0 {
+		c.Next()
+		return
+	}
+
+	client := "ip:" + c.ClientIP()
+	if p := requestPrincipal(c); p != nil {
+		client = "principal:" + p.Name
+	}
+	allowed, remaining, wait := buckets.take(client, time.Now())
+
+	seconds := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+	c.Header("RateLimit-Limit", strconv.Itoa(buckets.limit.Burst))
+	c.Header("RateLimit-Remaining", strconv.Itoa(remaining))
+	c.Header("RateLimit-Reset", seconds)
+	if !allowed {
+		c.Header("Retry-After", seconds)
+		c.IndentedJSON(http.StatusTooManyRequests, gin.H{"error": fmt.Sprintf("rate limit exceeded, retry in %ss", seconds)})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"time"
+)
+
+func (suite *testSuite) TestParseLimit() {
+	limit, err := ParseLimit("300/m")
+	suite.Nil(err)
+	suite.Equal(Limit{Rate: 5, Burst: 300}, limit)
+	limit, err = ParseLimit("10/s:2")
+	suite.Nil(err)
+	suite.Equal(Limit{Rate: 10, Burst: 2}, limit)
+	limit, err = ParseLimit("")
+	suite.Nil(err)
+	suite.Equal("unlimited", limit.String())
+
+	for _, invalid := range []string{"10", "10/d", "-1/s", "10/s:0", "x/s"} {
+		_, err = ParseLimit(invalid)
+		suite.NotNil(err, invalid)
+	}
+}
+
+func (suite *testSuite) TestTokenBuckets() {
+	buckets := newBucketSet(Limit{Rate: 2, Burst: 2})
+	start := time.Now()
+
+	allowed, remaining, wait := buckets.take("a", start)
+	suite.True(allowed)
+	suite.Equal(1, remaining)
+	suite.Equal(500*time.Millisecond, wait)
+	allowed, _, _ = buckets.take("a", start)
+	suite.True(allowed)
+	allowed, _, wait = buckets.take("a", start)
+	suite.False(allowed)
+	suite.Equal(500*time.Millisecond, wait)
+
+	// Other clients have their own buckets, which refill over time.
+	allowed, _, _ = buckets.take("b", start)
+	suite.True(allowed)
+	allowed, _, _ = buckets.take("a", start.Add(500*time.Millisecond))
+	suite.True(allowed)
+
+	// Buckets that have filled up again are dropped.
+	buckets.take("c", start.Add(2*bucketSweepInterval))
+	suite.Len(buckets.buckets, 1)
+
+	// Past the cap, the bucket idle longest makes room.
+	buckets = newBucketSet(Limit{Rate: 0.001, Burst: 1})
+	for i := 0; i < maxBuckets; i++ {
+		buckets.take(fmt.Sprint(i), start.Add(time.Duration(i)*time.Millisecond))
+	}
+	buckets.take("0", start.Add(time.Duration(maxBuckets)*time.Millisecond))
+	buckets.take("new", start.Add(time.Duration(maxBuckets+1)*time.Millisecond))
+	suite.Len(buckets.buckets, maxBuckets)
+	suite.Contains(buckets.buckets, "0")
+	suite.NotContains(buckets.buckets, "1")
+	suite.Contains(buckets.buckets, "new")
+}
+
+func (suite *testSuite) TestRateLimits() {
+	var err error
+	suite.srv, err = New("fakeaddress", WithSeed("testdata/sensors.json"), WithRateLimits(RateLimits{
+		Read:      Limit{Rate: 1, Burst: 2},
+		Expensive: Limit{Rate: 0.01, Burst: 1},
+	}))
+	suite.Nil(err)
+
+	response := suite.request("GET", "/sensor/L1MAG", nil)
+	suite.Equal(200, response.Code)
+	suite.Equal("2", response.Header().Get("RateLimit-Limit"))
+	suite.Equal("1", response.Header().Get("RateLimit-Remaining"))
+	suite.Equal(200, suite.request("GET", "/allsensors", nil).Code)
+	response = suite.request("GET", "/sensor/L1MAG", nil)
+	suite.Equal(429, response.Code)
+	suite.Equal("0", response.Header().Get("RateLimit-Remaining"))
+	suite.Equal("1", response.Header().Get("Retry-After"))
+
+	// Spatial queries have their own, tighter limit.
+	suite.Equal(200, suite.request("GET", "/nearest/0/0", nil).Code)
+	response = suite.request("GET", "/nearest/0/0", nil)
+	suite.Equal(429, response.Code)
+	suite.Equal("100", response.Header().Get("Retry-After"))
+
+	// Writes and health checks aren't limited here, and other
+	// addresses have their own limits.
+	response = suite.request("POST", "/sensor", CreateSensor("NEW1", "V", "Anaheim", "bar", 1, 2))
+	suite.Equal(201, response.Code)
+	suite.Empty(response.Header().Get("RateLimit-Limit"))
+	suite.Equal(200, suite.request("GET", "/health", nil).Code)
+
+	request := httptest.NewRequest("GET", "/sensor/L1MAG", nil)
+	request.RemoteAddr = "198.51.100.7:4000"
+	recorder := httptest.NewRecorder()
+	suite.srv.gin.ServeHTTP(recorder, request)
+	suite.Equal(200, recorder.Code)
+}
+
+func (suite *testSuite) TestRateLimitsBehindProxies() {
+	limits := WithRateLimits(RateLimits{Read: Limit{Rate: 0.01, Burst: 1}})
+	get := func(remoteAddr, forwardedFor string) int {
+		request := httptest.NewRequest("GET", "/sensor/L1MAG", nil)
+		request.RemoteAddr = remoteAddr
+		request.Header.Set("X-Forwarded-For", forwardedFor)
+		recorder := httptest.NewRecorder()
+		suite.srv.gin.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	// Forwarding headers are ignored by default, so clients can't
+	// spoof their way out of their limit.
+	var err error
+	suite.srv, err = New("fakeaddress", WithSeed("testdata/sensors.json"), limits)
+	suite.Nil(err)
+	suite.Equal(200, get("198.51.100.7:4000", "203.0.113.1"))
+	suite.Equal(429, get("198.51.100.7:4000", "203.0.113.2"))
+
+	// Behind a trusted proxy, each forwarded client has its own limit.
+	suite.srv, err = New("fakeaddress", WithSeed("testdata/sensors.json"), limits, WithTrustedProxies("198.51.100.0/24"))
+	suite.Nil(err)
+	suite.Equal(200, get("198.51.100.7:4000", "203.0.113.1"))
+	suite.Equal(200, get("198.51.100.7:4000", "203.0.113.2"))
+	suite.Equal(429, get("198.51.100.8:4000", "203.0.113.1"))
+
+	_, err = New("fakeaddress", WithTrustedProxies("proxy.internal"))
+	suite.NotNil(err)
+}
+
+func (suite *testSuite) TestRateLimitsPerKey() {
+	var err error
+	suite.srv, err = New("fakeaddress", WithSeed("testdata/sensors.json"), WithAPIKeys(), WithRateLimits(RateLimits{
+		Write: Limit{Rate: 0.01, Burst: 1},
+	}))
+	suite.Nil(err)
+	first, err := suite.srv.db.createAPIKey(context.Background(), "first", ScopeWrite)
+	suite.Nil(err)
+	second, err := suite.srv.db.createAPIKey(context.Background(), "second", ScopeWrite)
+	suite.Nil(err)
+
+	// Clients with keys are limited by key, not by address.
+	suite.Equal(201, suite.requestWithKey(first.Key, "POST", "/sensor", CreateSensor("NEW1", "V", "Anaheim", "bar", 1, 2)).Code)
+	suite.Equal(429, suite.requestWithKey(first.Key, "POST", "/sensor", CreateSensor("NEW2", "V", "Anaheim", "bar", 1, 2)).Code)
+	suite.Equal(201, suite.requestWithKey(second.Key, "POST", "/sensor", CreateSensor("NEW2", "V", "Anaheim", "bar", 1, 2)).Code)
+
+	// Namespaces share limits.
+	admin, err := suite.srv.db.createAPIKey(context.Background(), "admin", ScopeAdmin)
+	suite.Nil(err)
+	suite.Equal(201, suite.requestWithKey(admin.Key, "POST", "/namespaces", &Namespace{Name: "east"}).Code)
+	suite.Equal(429, suite.requestWithKey(first.Key, "POST", "/ns/east/sensor", CreateSensor("NEW3", "V", "Anaheim", "bar", 1, 2)).Code)
+}
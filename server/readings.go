@@ -0,0 +1,247 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A single timestamped value reported by a sensor, in the sensor's unit.
+type Reading struct {
+	Time    time.Time `json:"time"`
+	Value   float64   `json:"value"`
+	Quality Quality   `json:"quality,omitempty"`
+}
+
+// Readings for one sensor expressed in Unit.
+type Readings struct {
+	Sensor   string     `json:"sensor"`
+	Unit     string     `json:"unit"`
+	Readings []*Reading `json:"readings"`
+}
+
+// Store a batch of readings for a sensor, which counts as seeing it
+// at the latest of their times.
+func (db *store) insertReadings(ctx context.Context, name string, readings []*Reading) (err error) {
+	var tx *timedTx
+	if tx, err = db.conn.BeginTx(ctx, nil); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = insertReadingRows(ctx, tx, name, readings); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	db.conn.metrics.ingested(len(readings))
+	return nil
+}
+
+// Insert readings for a sensor and mark it seen, as part of a
+// larger transaction.
+func insertReadingRows(ctx context.Context, tx execer, name string, readings []*Reading) (err error) {
+	insertStatement := `
+		INSERT INTO readings (sensor, time, value, quality) 
+		VALUES(?, ?, ?, ?)`
+	var latest time.Time
+	for _, reading := range readings {
+		if _, err = tx.ExecContext(ctx, insertStatement, name, reading.Time.UnixNano(), reading.Value, reading.Quality); err != nil {
+			return err
+		}
+		if reading.Time.After(latest) {
+			latest = reading.Time
+		}
+	}
+
+	// Readings stamped in the future don't keep a sensor alive.
+	if now := time.Now(); latest.After(now) {
+		latest = now
+	}
+	if len(readings) > 0 {
+		if _, err = markSeen(ctx, tx, name, latest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query a sensor's readings in [start, end) that pass filter, oldest
+// first. Zero times leave that end of the range open.
+func (db *store) queryReadings(ctx context.Context, name string, start, end time.Time, filter qualityFilter) (readings []*Reading, err error) {
+	from, to := int64(math.MinInt64), int64(math.MaxInt64)
+	if !start.IsZero() {
+		from = start.UnixNano()
+	}
+	if !end.IsZero() {
+		to = end.UnixNano()
+	}
+
+	var rows *timedRows
+	selectStatement := `
+		SELECT time, value, quality 
+		FROM readings 
+		WHERE sensor = ? AND time >= ? AND time < ? 
+			AND quality & ? = 0 AND (? = 0 OR quality & ? != 0)
+		ORDER BY time`
+	if rows, err = db.conn.QueryContext(ctx, selectStatement, name, from, to, filter.exclude, filter.only, filter.only); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var nanos int64
+		reading := &Reading{}
+		if err = rows.Scan(&nanos, &reading.Value, &reading.Quality); err != nil {
+			return nil, err
+		}
+		reading.Time = time.Unix(0, nanos).UTC()
+		readings = append(readings, reading)
+	}
+
+	return readings, rows.Err()
+}
+
+// Query a sensor's last valid reading before t, nil if there is none.
+func (db *store) queryReadingBefore(ctx context.Context, name string, t time.Time) (_ *Reading, err error) {
+	var nanos int64
+	reading := &Reading{}
+	selectStatement := `
+		SELECT time, value, quality 
+		FROM readings 
+		WHERE sensor = ? AND time < ? AND quality & ? = 0
+		ORDER BY time DESC 
+		LIMIT 1`
+	if err = db.conn.QueryRowContext(ctx, selectStatement, name, t.UnixNano(), QualityInvalid).Scan(&nanos, &reading.Value, &reading.Quality); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	reading.Time = time.Unix(0, nanos).UTC()
+	return reading, nil
+}
+
+// Add a batch of readings to a sensor and check its alarm rules.
+func (s *Server) addReadings(c *gin.Context) {
+	name := c.Param("name")
+	if _, err := s.db.querySensor(c.Request.Context(), name); err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	var readings []*Reading
+	if err := c.BindJSON(&readings); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, reading := range readings {
+		if reading == nil || reading.Time.IsZero() {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "every reading needs a time"})
+			return
+		}
+	}
+
+	if err := s.db.insertReadings(c.Request.Context(), name, readings); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.evaluateReadings(c.Request.Context(), name, readings); err != nil {
+		s.logger.ErrorContext(c.Request.Context(), "evaluating alarm rules", "sensor", name, "error", err)
+	}
+	c.IndentedJSON(http.StatusCreated, gin.H{"sensor": name, "inserted": len(readings)})
+}
+
+// Query a sensor's readings between the optional start and end
+// query parameters, converted to the unit query parameter if set.
+// The exclude and only parameters filter readings by quality flags,
+// and raw skips calibration.
+func (s *Server) getReadings(c *gin.Context) {
+	sensor, err := s.db.querySensor(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	start, end, err := parseTimeRange(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter, err := parseQualityFilter(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	raw, err := parseRaw(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	readings, err := s.db.queryReadings(c.Request.Context(), sensor.Name, start, end, filter)
+	if err == nil && !raw {
+		err = s.db.calibrate(c.Request.Context(), sensor.Name, readings)
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := &Readings{Sensor: sensor.Name, Unit: sensor.Tags.Unit, Readings: readings}
+	if unit := c.Query("unit"); unit != "" {
+		if err = response.convertTo(unit); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.IndentedJSON(http.StatusOK, response)
+}
+
+// Convert every reading in place to another unit of the same quantity.
+func (r *Readings) convertTo(symbol string) error {
+	from, ok := LookupUnit(r.Unit)
+	if !ok {
+		return errors.New("sensor unit " + r.Unit + " is not in the catalog")
+	}
+	to, ok := LookupUnit(symbol)
+	if !ok {
+		return errors.New("unknown unit " + symbol)
+	}
+
+	for _, reading := range r.Readings {
+		value, err := convert(reading.Value, from, to)
+		if err != nil {
+			return err
+		}
+		reading.Value = value
+	}
+	r.Unit = to.Symbol
+
+	return nil
+}
+
+// Parse the optional start and end query parameters as RFC 3339 times.
+func parseTimeRange(c *gin.Context) (start, end time.Time, err error) {
+	if value := c.Query("start"); value != "" {
+		if start, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return start, end, errors.New("start must be an RFC 3339 time")
+		}
+	}
+	if value := c.Query("end"); value != "" {
+		if end, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return start, end, errors.New("end must be an RFC 3339 time")
+		}
+	}
+	if !start.IsZero() && !end.IsZero() && !end.After(start) {
+		return start, end, errors.New("end must be after start")
+	}
+	return start, end, nil
+}
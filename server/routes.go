@@ -1,6 +1,8 @@
 package server
 
 import (
+	"errors"
+	"fmt"
 	"math"
 	"net/http"
 	"strconv"
@@ -9,14 +11,24 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// Query all sensors in the database.
+// Query all sensors in the database, by default
+// leaving out decommissioned ones.
 func (s *Server) listSensors(c *gin.Context) {
-	sensors, err := s.db.queryAllSensors()
+	states, err := parseStateFilter(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sensors, err := s.db.querySensorsInStates(c.Request.Context(), states)
 	if err != nil {
 		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
-	c.IndentedJSON(http.StatusOK, sensors)
+	if sensors == nil {
+		sensors = []*Sensor{}
+	}
+	c.IndentedJSON(http.StatusOK, visibleSensors(c, sensors))
 }
 
 // Add a new sensor to the database.
@@ -27,18 +39,27 @@ func (s *Server) addSensor(c *gin.Context) {
 		return
 	}
 
-	if err := s.db.insertSensor(
-		newSensor.Name,
-		newSensor.Tags.Unit,
-		newSensor.Tags.Ingress,
-		newSensor.Tags.Distiller,
-		newSensor.Location.Latitude,
-		newSensor.Location.Longitude,
-	); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := s.validateSensor(newSensor, ""); err != nil {
+		abortInvalid(c, err)
 		return
 	}
-	c.IndentedJSON(http.StatusCreated, newSensor)
+	if !allowIngress(c, newSensor.Tags.Ingress) {
+		return
+	}
+	switch newSensor.State {
+	case "", StatePlanned, StateCommissioned, StateActive:
+	default:
+		verr := &ValidationError{}
+		verr.add("state", "initial", "must be planned, commissioned or active for a new sensor")
+		abortInvalid(c, verr)
+		return
+	}
+
+	if err := s.db.insertSensor(c.Request.Context(), newSensor); err != nil {
+		abortInvalid(c, err)
+		return
+	}
+	s.respondWithSensor(c, http.StatusCreated, newSensor.Name)
 }
 
 // Update a sensor already in the database.
@@ -49,25 +70,100 @@ func (s *Server) updateSensor(c *gin.Context) {
 		return
 	}
 
-	if err := s.db.updateSensor(
-		updatedSensor.Name,
-		updatedSensor.Tags.Unit,
-		updatedSensor.Tags.Ingress,
-		updatedSensor.Tags.Distiller,
-		updatedSensor.Location.Latitude,
-		updatedSensor.Location.Longitude,
-	); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := s.validateSensor(updatedSensor, c.Param("name")); err != nil {
+		abortInvalid(c, err)
 		return
 	}
-	c.IndentedJSON(http.StatusOK, updatedSensor)
+	// Moving a sensor needs access to the ingress it moves to as well.
+	if !allowIngress(c, updatedSensor.Tags.Ingress) {
+		return
+	}
+
+	current, err := s.db.querySensor(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if updatedSensor.State != "" && updatedSensor.State != current.State {
+		verr := &ValidationError{}
+		verr.add("state", "transition", fmt.Sprintf("can only change through POST /sensor/%s/transition", current.Name))
+		abortInvalid(c, verr)
+		return
+	}
+
+	if err := s.checkSensorPhasor(c.Request.Context(), updatedSensor); err != nil {
+		abortInvalid(c, err)
+		return
+	}
+
+	if err := s.db.updateSensor(c.Request.Context(), updatedSensor); err != nil {
+		abortInvalid(c, err)
+		return
+	}
+	s.respondWithSensor(c, http.StatusOK, updatedSensor.Name)
+}
+
+// Respond with a sensor as it is stored, including
+// its lifecycle state and any inherited location.
+func (s *Server) respondWithSensor(c *gin.Context, status int, name string) {
+	sensor, err := s.db.querySensor(c.Request.Context(), name)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(status, sensor)
+}
+
+// Validate a sensor payload against the shared rules. pathName is the
+// sensor named in the URL, if any. The components it references are
+// checked when it's written.
+func (s *Server) validateSensor(sensor *Sensor, pathName string) error {
+	verr := &ValidationError{}
+	if err := ValidateSensor(sensor); err != nil {
+		if !errors.As(err, &verr) {
+			return err
+		}
+	}
+
+	if sensor == nil {
+		return verr.orNil()
+	}
+	if pathName != "" && sensor.Name != pathName {
+		verr.add("name", "path", fmt.Sprintf("must match the sensor in the URL (%s)", pathName))
+	}
+	return verr.orNil()
+}
+
+// Respond with a 400 and, for validation errors, the failing fields.
+func abortInvalid(c *gin.Context, err error) {
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error(), "fields": verr.Fields})
+		return
+	}
+	c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// Delete a sensor from the database by name.
+func (s *Server) deleteSensor(c *gin.Context) {
+	err := s.db.deleteSensor(c.Request.Context(), c.Param("name"))
+	var conflict *conflictError
+	switch {
+	case errors.As(err, &conflict):
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
 }
 
 // Query a specific sensor by name.
 func (s *Server) getSensor(c *gin.Context) {
 	var err error
 	var sensor *Sensor
-	if sensor, err = s.db.querySensor(c.Param("name")); err != nil {
+	if sensor, err = s.db.querySensor(c.Request.Context(), c.Param("name")); err != nil {
 		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
@@ -75,8 +171,8 @@ func (s *Server) getSensor(c *gin.Context) {
 }
 
 // Retrieve the nearest sensor in the database to the
-// query parameter coordinates. Queries all sensors
-// and iterates over them.
+// query parameter coordinates. Queries all sensors in
+// the requested states and iterates over them.
 func (s *Server) getNearestSensor(c *gin.Context) {
 	// Parse the query parameters to float64.
 	var err error
@@ -101,7 +197,12 @@ func (s *Server) getNearestSensor(c *gin.Context) {
 	}
 
 	// Query all sensors.
-	sensors, err := s.db.queryAllSensors()
+	states, err := parseStateFilter(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sensors, err := s.db.querySensorsInStates(c.Request.Context(), states)
 	if err != nil {
 		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
@@ -111,8 +212,11 @@ func (s *Server) getNearestSensor(c *gin.Context) {
 	min := math.Inf(1)
 	var minSensor *Sensor
 	userCoordinates := &Coordinates{Latitude: latitude, Longitude: longitude}
-	for _, sensor := range sensors {
-		distance := haversine(userCoordinates, &sensor.Location)
+	for _, sensor := range visibleSensors(c, sensors) {
+		if sensor.Location == nil {
+			continue
+		}
+		distance := haversine(userCoordinates, sensor.Location)
 		if distance < min {
 			min = distance
 			minSensor = sensor
@@ -161,8 +265,8 @@ type Status struct {
 // include the server version if there was one.
 func (s *Server) statusCheck(c *gin.Context) {
 	status := Status{
-		Ok:     s.healthy,
-		Uptime: time.Since(s.started).String(),
+		Ok:     s.base().healthy,
+		Uptime: time.Since(s.base().started).String(),
 	}
 	c.IndentedJSON(http.StatusOK, status)
 }
@@ -25,7 +25,7 @@ func (suite *testSuite) SetupTest() {
 	suite.setupRecorder()
 
 	var err error
-	suite.srv, err = New("fakeaddress")
+	suite.srv, err = New("fakeaddress", WithSeed("testdata/sensors.json"))
 	suite.Nil(err)
 }
 
@@ -33,6 +33,25 @@ func (suite *testSuite) setupRecorder() {
 	recorder := httptest.NewRecorder()
 	suite.responseRecorder = recorder
 	suite.testContext, _ = gin.CreateTestContext(recorder)
+	// Handlers pass their request's context on to the store.
+	suite.testContext.Request = httptest.NewRequest("GET", "/", nil)
+}
+
+// Send a request through the router, marshaling body as JSON if set.
+func (suite *testSuite) request(method, url string, body interface{}) *httptest.ResponseRecorder {
+	var requestBody io.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		suite.Nil(err)
+		requestBody = bytes.NewBuffer(bodyBytes)
+	}
+
+	request := httptest.NewRequest(method, url, requestBody)
+	request.Header.Set("Content-Type", "application/json")
+
+	recorder := httptest.NewRecorder()
+	suite.srv.gin.ServeHTTP(recorder, request)
+	return recorder
 }
 
 func TestExampleTestSuite(t *testing.T) {
@@ -55,7 +74,7 @@ func (suite *testSuite) TestAddSensor() {
 	suite.testContext.Request.Method = "POST"
 	suite.testContext.Request.Header.Set("Content-Type", "application/json")
 
-	requestSensor := CreateSensor("foo", "bar", "baz", "buz", 0, 0)
+	requestSensor := CreateSensor("foo", "volts", "Anaheim", "baz", 0, 0)
 	bodyBytes, err := json.Marshal(requestSensor)
 	suite.Nil(err)
 
@@ -67,19 +86,68 @@ func (suite *testSuite) TestAddSensor() {
 	responseBody, err := io.ReadAll(suite.responseRecorder.Body)
 	suite.Nil(err)
 
+	// Unit aliases are normalized to the catalog symbol.
 	var responseSensor *Sensor
 	suite.Nil(json.Unmarshal(responseBody, &responseSensor))
+	requestSensor.Tags.Unit = "V"
+
+	// The stored sensor is returned, including its lifecycle state and liveness.
+	suite.Equal(StateActive, responseSensor.State)
+	suite.NotNil(responseSensor.StateSince)
+	suite.Equal(StatusUnseen, responseSensor.Status)
+	requestSensor.State, requestSensor.StateSince = responseSensor.State, responseSensor.StateSince
+	requestSensor.Status = responseSensor.Status
 	suite.Equal(responseSensor, requestSensor)
 }
 
+func (suite *testSuite) TestAddInvalidSensor() {
+	suite.testContext.Request = &http.Request{
+		Header: make(http.Header),
+	}
+	suite.testContext.Request.Method = "POST"
+	suite.testContext.Request.Header.Set("Content-Type", "application/json")
+
+	requestSensor := CreateSensor("", "parsecs", "baz", "buz", 500, 0)
+	bodyBytes, err := json.Marshal(requestSensor)
+	suite.Nil(err)
+
+	suite.testContext.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	suite.srv.addSensor(suite.testContext)
+	suite.Equal(400, suite.responseRecorder.Code)
+
+	var response struct {
+		Fields []FieldError `json:"fields"`
+	}
+	suite.Nil(json.Unmarshal(suite.responseRecorder.Body.Bytes(), &response))
+
+	fields := make(map[string]string)
+	for _, field := range response.Fields {
+		fields[field.Field] = field.Rule
+	}
+	suite.Equal(map[string]string{
+		"name":              "required",
+		"location.latitude": "lte",
+		"tags.unit":         "unit",
+	}, fields)
+
+	// Units in the wrong case aren't taken for another scale.
+	recorder := suite.request("POST", "/sensor", CreateSensor("P1", "mW", "Anaheim", "bar", 1, 2))
+	suite.Equal(400, recorder.Code)
+	suite.Contains(recorder.Body.String(), "tags.unit")
+}
+
 func (suite *testSuite) TestUpdateSensor() {
 	suite.testContext.Request = &http.Request{
 		Header: make(http.Header),
 	}
 	suite.testContext.Request.Method = "POST"
 	suite.testContext.Request.Header.Set("Content-Type", "application/json")
+	suite.testContext.Params = []gin.Param{{
+		Key:   "name",
+		Value: "C1MAG",
+	}}
 
-	requestSensor := CreateSensor("C1MAG", "amps", "florida", "foo", 10, 20)
+	requestSensor := CreateSensor("C1MAG", "amps", "Anaheim", "foo", 10, 20)
 	bodyBytes, err := json.Marshal(requestSensor)
 	suite.Nil(err)
 
@@ -93,6 +161,14 @@ func (suite *testSuite) TestUpdateSensor() {
 
 	var responseSensor *Sensor
 	suite.Nil(json.Unmarshal(responseBody, &responseSensor))
+	requestSensor.Tags.Unit = "A"
+
+	// The stored sensor is returned, including its lifecycle state and liveness.
+	suite.Equal(StateActive, responseSensor.State)
+	suite.NotNil(responseSensor.StateSince)
+	suite.Equal(StatusUnseen, responseSensor.Status)
+	requestSensor.State, requestSensor.StateSince = responseSensor.State, responseSensor.StateSince
+	requestSensor.Status = responseSensor.Status
 	suite.Equal(responseSensor, requestSensor)
 }
 
@@ -113,6 +189,31 @@ func (suite *testSuite) TestGetSensor() {
 	suite.Equal(404, suite.responseRecorder.Code)
 }
 
+func (suite *testSuite) TestDeleteSensor() {
+	suite.testContext.Params = []gin.Param{{
+		Key:   "name",
+		Value: "L1MAG",
+	}}
+	suite.srv.deleteSensor(suite.testContext)
+	suite.Equal(204, suite.testContext.Writer.Status())
+
+	suite.setupRecorder()
+	suite.testContext.Params = []gin.Param{{
+		Key:   "name",
+		Value: "L1MAG",
+	}}
+	suite.srv.getSensor(suite.testContext)
+	suite.Equal(404, suite.responseRecorder.Code)
+
+	suite.setupRecorder()
+	suite.testContext.Params = []gin.Param{{
+		Key:   "name",
+		Value: "NOTASENSOR",
+	}}
+	suite.srv.deleteSensor(suite.testContext)
+	suite.Equal(404, suite.responseRecorder.Code)
+}
+
 func (suite *testSuite) TestNearestSensor() {
 	suite.testContext.Params = []gin.Param{
 		{
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Seed files use the same layout as pingcli manifests, a
+// list of sensors under a top level "sensors" key.
+type seedFile struct {
+	Sensors []*Sensor `json:"sensors" yaml:"sensors"`
+}
+
+// Columns expected in the header of CSV seed files.
+var seedColumns = []string{"name", "latitude", "longitude", "unit", "ingress", "distiller"}
+
+// Load sensors from a JSON, YAML or CSV fixture,
+// picking the format from the file extension.
+func loadSeed(path string) (sensors []*Sensor, err error) {
+	var file *os.File
+	if file, err = os.Open(path); err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		seed := &seedFile{}
+		if err = json.NewDecoder(file).Decode(seed); err != nil {
+			return nil, fmt.Errorf("could not parse seed file %s: %w", path, err)
+		}
+		sensors = seed.Sensors
+	case ".yaml", ".yml":
+		seed := &seedFile{}
+		if err = yaml.NewDecoder(file).Decode(seed); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("could not parse seed file %s: %w", path, err)
+		}
+		sensors = seed.Sensors
+	case ".csv":
+		if sensors, err = readSeedCSV(file); err != nil {
+			return nil, fmt.Errorf("could not parse seed file %s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported seed file format %q", filepath.Ext(path))
+	}
+
+	for i, sensor := range sensors {
+		if err = ValidateSensor(sensor); err != nil {
+			return nil, fmt.Errorf("seed sensor %d: %w", i, err)
+		}
+	}
+
+	return sensors, nil
+}
+
+// Read sensors from CSV with a header row naming the seed columns.
+func readSeedCSV(r io.Reader) (sensors []*Sensor, err error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	var header []string
+	if header, err = reader.Read(); err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int, len(header))
+	for i, column := range header {
+		index[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	for _, column := range seedColumns {
+		if _, ok := index[column]; !ok {
+			return nil, fmt.Errorf("missing column %q", column)
+		}
+	}
+
+	for {
+		var record []string
+		if record, err = reader.Read(); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		sensor := &Sensor{
+			Name: record[index["name"]],
+			Tags: SensorTags{
+				Name:      record[index["name"]],
+				Unit:      record[index["unit"]],
+				Ingress:   record[index["ingress"]],
+				Distiller: record[index["distiller"]],
+			},
+		}
+
+		// Leave both coordinates empty for sensors without a location.
+		latitude, longitude := record[index["latitude"]], record[index["longitude"]]
+		if latitude != "" || longitude != "" {
+			sensor.Location = &Coordinates{}
+			if sensor.Location.Latitude, err = strconv.ParseFloat(latitude, 64); err != nil {
+				return nil, err
+			}
+			if sensor.Location.Longitude, err = strconv.ParseFloat(longitude, 64); err != nil {
+				return nil, err
+			}
+		}
+
+		sensors = append(sensors, sensor)
+	}
+
+	return sensors, nil
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+)
+
+func (suite *testSuite) TestLoadSeed() {
+	expected, err := loadSeed("testdata/sensors.json")
+	suite.Nil(err)
+	suite.Len(expected, 3)
+	suite.Equal(CreateSensor("L1ANG", "deg", "Anaheim", "bar", 33.8, 117.9), expected[1])
+
+	for _, path := range []string{"testdata/sensors.yaml", "testdata/sensors.csv"} {
+		sensors, err := loadSeed(path)
+		suite.Nil(err, path)
+		suite.Equal(expected, sensors, path)
+	}
+
+	_, err = loadSeed("testdata/sensors.txt")
+	suite.NotNil(err)
+}
+
+func (suite *testSuite) TestSeedIsIdempotent() {
+	dsn := filepath.Join(suite.T().TempDir(), "sensors.db")
+
+	srv, err := New("fakeaddress", WithDatabase(dsn), WithSeed("testdata/sensors.json"))
+	suite.Nil(err)
+	suite.Nil(ensureComponent(context.Background(), srv.db.conn, ingressKind, "florida"))
+	suite.Nil(srv.db.updateSensor(context.Background(), CreateSensor("C1MAG", "A", "florida", "foo", 10, 20)))
+	srv.db.conn.Close()
+
+	srv, err = New("fakeaddress", WithDatabase(dsn), WithSeed("testdata/sensors.json"))
+	suite.Nil(err)
+	defer srv.db.conn.Close()
+
+	sensors, err := srv.db.queryAllSensors(context.Background())
+	suite.Nil(err)
+	suite.Len(sensors, 3)
+
+	sensor, err := srv.db.querySensor(context.Background(), "C1MAG")
+	suite.Nil(err)
+	suite.Equal("florida", sensor.Tags.Ingress)
+}
+
+func (suite *testSuite) TestUnseededStore() {
+	srv, err := New("fakeaddress")
+	suite.Nil(err)
+
+	sensors, err := srv.db.queryAllSensors(context.Background())
+	suite.Nil(err)
+	suite.Empty(sensors)
+}
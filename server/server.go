@@ -2,56 +2,282 @@ package server
 
 import (
 	"context"
-	"log"
+	"errors"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Server struct {
-	srv     *http.Server // http server for API defaults.
-	gin     *gin.Engine  // http handler.
-	db      *store       // SQLite connection.
-	healthy bool         // server state for health checks.
-	started time.Time    // when the server started.
+	srv          *http.Server   // http server for API defaults.
+	gin          *gin.Engine    // http handler.
+	db           *store         // SQLite connection.
+	healthy      bool           // server state for health checks.
+	started      time.Time      // when the server started.
+	events       *broker        // stream of server events.
+	alarmsMu     sync.Mutex     // serializes alarm evaluation.
+	done         chan struct{}  // closed on shutdown to stop background work.
+	stopped      chan struct{}  // closed once shutdown has finished.
+	apiKeys      bool           // require API keys for requests that need a scope.
+	tokens       *tokenVerifier // checks bearer tokens, nil when they aren't accepted.
+	privateReads bool           // require a read key for reads too.
+	limiter      *rateLimiter   // limits request rates per client, nil for no limits.
+	metrics      *metrics       // request, store and sensor metrics, shared by namespaces.
+	logger       *slog.Logger   // structured logs of requests and background work.
+	maxUpload    int64          // largest record upload accepted, in bytes.
+
+	trustedProxies []string // proxies whose forwarding headers give the client's address.
+
+	tracer         trace.Tracer         // spans of requests and store queries, shared by namespaces.
+	tracerProvider trace.TracerProvider // flushed on shutdown, nil when not tracing.
+
+	pmuListeners    []pmuListener         // addresses to receive C37.118 frames on.
+	pmuAutoRegister bool                  // register sensors from PMU configurations.
+	pmuMu           sync.Mutex            // guards pmuStreams.
+	pmuStreams      map[string]*PMUStream // PMU streams seen, by pmuStreamKey.
+
+	dsn          string             // data source name of the default namespace's store.
+	root         *Server            // the default namespace's server, nil for the default namespace.
+	namespacesMu sync.Mutex         // guards namespaces.
+	namespaces   map[string]*Server // servers of the other namespaces, by name.
 }
 
 type Sensor struct {
-	Name     string      `json:"name"`
-	Location Coordinates `json:"location"`
-	Tags     SensorTags  `json:"tags"`
+	Name     string       `json:"name" yaml:"name" validate:"required,sensorname"`
+	Location *Coordinates `json:"location,omitempty" yaml:"location,omitempty"`
+	// Set to the asset a sensor inherits its location from when it
+	// doesn't have one of its own. Inherited locations aren't stored.
+	LocationFrom string `json:"location_from,omitempty" yaml:"-"`
+	Asset        string `json:"asset,omitempty" yaml:"asset,omitempty"`
+	// Lifecycle state, changed through transitions once the sensor exists.
+	State      string     `json:"state,omitempty" yaml:"state,omitempty" validate:"omitempty,oneof=planned commissioned active maintenance decommissioned"`
+	StateSince *time.Time `json:"state_since,omitempty" yaml:"-"`
+	// Liveness, set from heartbeats and readings. StaleAfter overrides
+	// the threshold of the sensor's ingress when set.
+	LastSeen   *time.Time `json:"last_seen,omitempty" yaml:"-"`
+	Status     string     `json:"status,omitempty" yaml:"-"`
+	StaleAfter Duration   `json:"stale_after,omitempty" yaml:"stale_after,omitempty" validate:"gte=0"`
+	// Nominal reporting rate in samples per second, used to find gaps.
+	SampleRate float64    `json:"sample_rate,omitempty" yaml:"sample_rate,omitempty" validate:"gte=0"`
+	Tags       SensorTags `json:"tags" yaml:"tags"`
 }
 
 type Coordinates struct {
-	Latitude  float64 `json:"latitude"`
-	Longitude float64 `json:"longitude"`
+	Latitude  float64 `json:"latitude" yaml:"latitude" validate:"gte=-90,lte=90"`
+	Longitude float64 `json:"longitude" yaml:"longitude" validate:"gte=-180,lte=180"`
 }
 
 type SensorTags struct {
-	Name      string `json:"name"`
-	Unit      string `json:"unit"`
-	Ingress   string `json:"ingress"`
-	Distiller string `json:"distiller"`
+	Name      string `json:"name" yaml:"name"`
+	Unit      string `json:"unit" yaml:"unit" validate:"required,unit"`
+	Ingress   string `json:"ingress" yaml:"ingress" validate:"required,max=128"`
+	Distiller string `json:"distiller" yaml:"distiller" validate:"required,max=128"`
+}
+
+// Option configures optional behaviour of a Server.
+type Option func(*options)
+
+type options struct {
+	database      string        // SQLite data source name.
+	seed          string        // fixture file to seed the store with.
+	laxReferences bool          // create unknown ingresses and distillers on the fly.
+	ingresses     []string      // ingresses to register when starting.
+	staleAfter    time.Duration // default liveness threshold.
+	apiKeys       bool          // require API keys.
+	apiKeyFile    string        // where the bootstrap admin key is written.
+	jwt           *JWTConfig    // accept bearer tokens.
+	tlsCert       string        // serve HTTPS with this certificate.
+	tlsKey        string        // key of tlsCert.
+	clientCA      string        // require client certificates signed by these CAs.
+	rateLimits    RateLimits    // request rate limits per client.
+	privateReads  bool          // require API keys for reads too.
+	timeouts      Timeouts      // HTTP server timeouts.
+	logger        *slog.Logger  // where logs go.
+	maxUpload     int64         // largest record upload accepted.
+
+	trustedProxies []string // proxies whose forwarding headers are believed.
+
+	tracerProvider trace.TracerProvider // where spans go, nil not to trace.
+
+	pmuListeners    []pmuListener // addresses to receive C37.118 frames on.
+	pmuAutoRegister bool          // register sensors from PMU configurations.
+}
+
+// Open the SQLite database at dsn instead of the default in-memory one.
+func WithDatabase(dsn string) Option {
+	return func(o *options) {
+		o.database = dsn
+	}
+}
+
+// Seed the store with the sensors in a JSON, YAML or CSV fixture file.
+// Sensors that already exist are left untouched.
+func WithSeed(path string) Option {
+	return func(o *options) {
+		o.seed = path
+	}
+}
+
+// Consider sensors stale after d without a heartbeat or reading,
+// unless they or their ingress set their own threshold.
+func WithStaleAfter(d time.Duration) Option {
+	return func(o *options) {
+		o.staleAfter = d
+	}
+}
+
+// Create ingresses and distillers referenced by sensors
+// when they don't exist instead of rejecting the sensor.
+func WithLaxReferences() Option {
+	return func(o *options) {
+		o.laxReferences = true
+	}
+}
+
+// Register ingresses when the server starts, if they don't exist yet.
+// Without lax references, sensors can only reference ingresses that
+// are registered, so this also restricts them as it did before
+// ingresses could be managed.
+func WithIngresses(ingresses ...string) Option {
+	return func(o *options) {
+		o.ingresses = append(o.ingresses, ingresses...)
+	}
+}
+
+// Require an API key with write scope for requests that change data,
+// and admin scope for managing keys, deleting records with history and
+// changing configuration. If there's no admin key yet, one is created
+// written to standard error.
+func WithAPIKeys() Option {
+	return func(o *options) {
+		o.apiKeys = true
+	}
 }
 
-func New(addr string) (server *Server, err error) {
-	ginEngine := gin.Default()
+// Write the admin key WithAPIKeys creates to a new file at path, only
+// readable by its owner, instead of standard error.
+func WithAPIKeyFile(path string) Option {
+	return func(o *options) {
+		o.apiKeyFile = path
+	}
+}
+
+// Also require read scope for reads, other than health checks. Needs
+// WithAPIKeys or WithJWT.
+func WithPrivateReads() Option {
+	return func(o *options) {
+		o.privateReads = true
+	}
+}
+
+func New(addr string, opts ...Option) (server *Server, err error) {
+	conf := &options{database: ":memory:", timeouts: defaultTimeouts, maxUpload: defaultMaxUpload}
+	for _, opt := range opts {
+		opt(conf)
+	}
+	if conf.privateReads && !conf.apiKeys && conf.jwt == nil {
+		return nil, errors.New("private reads need API keys or bearer tokens")
+	}
+
+	if conf.logger == nil {
+		conf.logger = slog.New(requestIDHandler{slog.Default().Handler()})
+	}
+
+	ginEngine := gin.New()
+	if err = ginEngine.SetTrustedProxies(conf.trustedProxies); err != nil {
+		return nil, err
+	}
 	server = &Server{
 		srv: &http.Server{
-			Addr:    addr,
-			Handler: ginEngine,
+			Addr:              addr,
+			Handler:           ginEngine,
+			ReadTimeout:       time.Duration(conf.timeouts.Read),
+			ReadHeaderTimeout: time.Duration(conf.timeouts.ReadHeader),
+			WriteTimeout:      time.Duration(conf.timeouts.Write),
+			IdleTimeout:       time.Duration(conf.timeouts.Idle),
 		},
-		gin:     ginEngine,
-		healthy: false,
+		gin:          ginEngine,
+		healthy:      false,
+		events:       newBroker(),
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+		apiKeys:      conf.apiKeys,
+		privateReads: conf.privateReads,
+		limiter:      newRateLimiter(conf.rateLimits),
+		logger:       conf.logger,
+		maxUpload:    conf.maxUpload,
+
+		trustedProxies: conf.trustedProxies,
+
+		tracer:         newTracer(conf.tracerProvider),
+		tracerProvider: conf.tracerProvider,
+
+		pmuListeners:    conf.pmuListeners,
+		pmuAutoRegister: conf.pmuAutoRegister,
+		pmuStreams:      make(map[string]*PMUStream),
+
+		dsn:        conf.database,
+		namespaces: make(map[string]*Server),
 	}
 
-	if server.db, err = newStore(); err != nil {
+	// Setting up the store isn't part of any request.
+	ctx := context.Background()
+	if server.db, err = newStore(conf.database); err != nil {
 		return nil, err
 	}
+	server.metrics = newMetrics(server)
+	server.db.conn.metrics = server.metrics
+	server.db.conn.logger = server.logger
+	server.db.conn.tracer = server.tracer
+	if conf.staleAfter > 0 {
+		server.db.staleAfter = conf.staleAfter
+	}
+	server.db.laxReferences = conf.laxReferences
+	for _, ingress := range conf.ingresses {
+		if err = ensureComponent(ctx, server.db.conn, ingressKind, ingress); err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.seed != "" {
+		var sensors []*Sensor
+		if sensors, err = loadSeed(conf.seed); err != nil {
+			return nil, err
+		}
+
+		var inserted int64
+		if inserted, err = server.db.seedSensors(ctx, sensors); err != nil {
+			return nil, err
+		}
+		server.logger.Info("seeded sensors", "seeded", inserted, "sensors", len(sensors), "file", conf.seed)
+	}
+
+	if server.apiKeys {
+		if err = server.bootstrapAPIKey(ctx, conf.apiKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	if conf.jwt != nil {
+		if server.tokens, err = newTokenVerifier(*conf.jwt); err != nil {
+			return nil, err
+		}
+	}
+	if err = server.openNamespaces(ctx); err != nil {
+		return nil, err
+	}
+	if conf.tlsCert != "" || conf.tlsKey != "" {
+		if server.srv.TLSConfig, err = loadTLSConfig(conf.tlsCert, conf.tlsKey, conf.clientCA); err != nil {
+			return nil, err
+		}
+	}
 
 	server.setupRoutes()
 
@@ -68,32 +294,156 @@ func (s *Server) Serve() (err error) {
 	signal.Notify(interupt, syscall.SIGINT)
 	go func() {
 		<-interupt
-		log.Println("shutting down server")
+		s.logger.Info("shutting down server")
 		s.shutdown()
 	}()
 
-	log.Println("server starting")
-	if err = s.srv.ListenAndServe(); err != nil {
+	go s.watchStale(staleCheckInterval)
+	for _, listener := range s.pmuListeners {
+		if _, err = s.listenPMU(listener.network, listener.addr); err != nil {
+			return err
+		}
+	}
+
+	s.logger.Info("server starting", "address", s.srv.Addr, "tls", s.srv.TLSConfig != nil)
+	if s.srv.TLSConfig != nil {
+		// The certificate is already loaded into TLSConfig.
+		err = s.srv.ListenAndServeTLS("", "")
+	} else {
+		err = s.srv.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		// Serve returns as soon as the http server starts shutting
+		// down, before the stores are closed and spans flushed.
+		<-s.stopped
+	}
+	if err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// Gracefully shutdown the server. Closes
-// the database connection and http server.
+// Gracefully shutdown the server. Stops background work, lets the
+// http server finish the requests in flight, then closes the database
+// connections and exports the spans those requests left buffered.
 func (s *Server) shutdown() {
-	s.db.conn.Close()
+	defer close(s.stopped)
+	close(s.done)
 	ctx := context.Background()
 	_ = s.srv.Shutdown(ctx)
+	for _, ns := range s.allNamespaces() {
+		ns.db.conn.Close()
+	}
+	if tp, ok := s.tracerProvider.(interface{ Shutdown(context.Context) error }); ok {
+		if err := tp.Shutdown(ctx); err != nil {
+			s.logger.Warn("flushing traces", "error", err)
+		}
+	}
 }
 
 // Add REST endpoints to the Gin engine.
 func (s *Server) setupRoutes() {
+	// Namespaces get the ID and span and are logged by the default
+	// namespace. Tracing comes before recovering, so spans of requests
+	// that panicked end as server errors.
+	if s.root == nil {
+		s.gin.Use(s.requestID, s.traceRequest, s.logRequest)
+	} else {
+		s.gin.Use(s.traceRequest)
+	}
+	s.gin.Use(gin.CustomRecoveryWithWriter(io.Discard, s.recovered))
+	// Before the rest, so requests rejected by them are counted too.
+	s.gin.Use(s.instrument)
+	if s.apiKeys || s.tokens != nil {
+		s.gin.Use(s.authorize)
+	}
+	// After authorizing, so clients with keys or tokens are limited by
+	// them rather than by address.
+	if s.limiter != nil {
+		s.gin.Use(s.rateLimit)
+	}
+	// Keys can only be managed while they're required, so none can be
+	// minted on a server left open. They and namespaces are kept by the
+	// default namespace.
+	if s.root == nil {
+		s.gin.GET("/namespaces", s.listNamespaces)
+		s.gin.POST("/namespaces", s.createNamespace)
+		s.gin.Any(namespaceRoute, s.routeNamespace)
+		s.gin.GET("/metrics", s.serveMetrics)
+	}
+	if s.apiKeys && s.root == nil {
+		s.gin.GET("/keys", s.listAPIKeys)
+		s.gin.POST("/keys", s.createAPIKey)
+		s.gin.DELETE("/keys/:id", s.revokeAPIKey)
+	}
+
 	s.gin.GET("/allsensors", s.listSensors)
 	s.gin.GET("/sensor/:name", s.getSensor)
 	s.gin.POST("/sensor", s.addSensor)
 	s.gin.PUT("/sensor/:name", s.updateSensor)
+	s.gin.DELETE("/sensor/:name", s.deleteSensor)
+	s.gin.GET("/sensor/:name/readings", s.getReadings)
+	s.gin.POST("/sensor/:name/readings", s.addReadings)
+	s.gin.GET("/sensor/:name/aggregate", s.getAggregate)
+	s.gin.GET("/sensor/:name/quality", s.getQuality)
+	s.gin.GET("/sensor/:name/gaps", s.getGaps)
+	s.gin.POST("/sensor/:name/transition", s.transitionSensor)
+	s.gin.GET("/sensor/:name/transitions", s.listTransitions)
+	s.gin.POST("/sensor/:name/heartbeat", s.heartbeat)
+	s.gin.GET("/sensor/:name/calibrations", s.listCalibrations)
+	s.gin.POST("/sensor/:name/calibrations", s.addCalibration)
+	s.gin.DELETE("/sensor/:name/calibrations/:id", s.deleteCalibration)
+	s.gin.GET("/calibrations/overdue", s.listOverdueCalibrations)
+	s.gin.GET("/sensors/stale", s.listStaleSensors)
 	s.gin.GET("/nearest/:lat/:lon", s.getNearestSensor)
+	s.gin.GET("/units", s.listUnits)
+	s.gin.GET("/units/convert", s.convertUnit)
+	s.gin.GET("/units/:symbol", s.getUnit)
 	s.gin.GET("/health", s.statusCheck)
+	s.gin.GET("/tree", s.getTree)
+	s.gin.GET("/assets", s.listAssets)
+	s.gin.POST("/assets", s.addAsset)
+	s.gin.GET("/assets/:id", s.getAsset)
+	s.gin.PUT("/assets/:id", s.updateAsset)
+	s.gin.DELETE("/assets/:id", s.deleteAsset)
+	s.gin.GET("/assets/:id/tree", s.getAssetTree)
+	s.gin.GET("/assets/:id/sensors", s.listAssetSensors)
+	s.gin.GET("/topology", s.getTopology)
+	s.gin.PUT("/topology", s.importTopology)
+	s.gin.GET("/topology/path", s.getTopologyPath)
+	s.gin.GET("/topology/islands", s.getTopologyIslands)
+	s.gin.GET("/topology/neighbors/:name", s.getTopologyNeighbors)
+	s.gin.GET("/events", s.streamEvents)
+	s.gin.GET("/alarms", s.listAlarms)
+	s.gin.GET("/alarms/:id", s.getAlarm)
+	s.gin.POST("/alarms/:id/ack", s.acknowledgeAlarm)
+	s.gin.GET("/alarms/rules", s.listAlarmRules)
+	s.gin.POST("/alarms/rules", s.addAlarmRule)
+	s.gin.GET("/alarms/rules/:id", s.getAlarmRule)
+	s.gin.PUT("/alarms/rules/:id", s.updateAlarmRule)
+	s.gin.DELETE("/alarms/rules/:id", s.deleteAlarmRule)
+	s.gin.GET("/phasors", s.listPhasors)
+	s.gin.GET("/pmus", s.listPMUStreams)
+	s.gin.GET("/records", s.listRecords)
+	s.gin.POST("/records", s.importRecord)
+	s.gin.GET("/records/:id", s.getRecord)
+	s.gin.DELETE("/records/:id", s.deleteRecord)
+	s.gin.GET("/records/:id/channels/:channel", s.getRecordChannel)
+	s.gin.GET("/records/:id/files/:file", s.getRecordFile)
+	s.gin.POST("/phasors", s.addPhasor)
+	s.gin.GET("/phasors/suggestions", s.listPhasorSuggestions)
+	s.gin.GET("/phasors/:id", s.getPhasor)
+	s.gin.GET("/phasors/:id/values", s.getPhasorValues)
+	s.gin.DELETE("/phasors/:id", s.deletePhasor)
+
+	for _, kind := range []*componentKind{ingressKind, distillerKind} {
+		group := s.gin.Group("/" + kind.table)
+		group.GET("", s.listComponents(kind))
+		group.POST("", s.addComponent(kind))
+		group.GET("/:name", s.getComponent(kind))
+		group.PUT("/:name", s.updateComponent(kind))
+		group.DELETE("/:name", s.deleteComponent(kind))
+		group.GET("/:name/sensors", s.listComponentSensors(kind))
+	}
 }
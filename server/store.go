@@ -1,124 +1,627 @@
 package server
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+var errSensorNotFound = errors.New("Sensor not found in store")
+
 type store struct {
-	conn *sql.DB
+	conn          *timedDB
+	staleAfter    time.Duration // liveness threshold when a sensor's ingress doesn't set one.
+	laxReferences bool          // create unknown ingresses and distillers sensors reference.
+
+	// Bumped whenever sensors or phasors change, so PMU streams
+	// know to map their channels again.
+	sensorsVersion atomic.Uint64
+}
+
+// Commit a transaction that changed sensors or phasors.
+func (db *store) commitSensors(tx *timedTx) error {
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	db.sensorsVersion.Add(1)
+	return nil
+}
+
+// Tables and indexes created when the store is opened, as they are
+// now. Columns added to tables since they were first made also need a
+// migration.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS sensors (
+		name string PRIMARY KEY,
+		latitude REAL,
+		longitude REAL,
+		unit string,
+		ingress string,
+		distiller string,
+		asset string NOT NULL DEFAULT '',
+		state string NOT NULL DEFAULT 'active',
+		state_since INTEGER,
+		last_seen INTEGER,
+		stale_after INTEGER NOT NULL DEFAULT 0,
+		sample_rate REAL NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS transitions (
+		sensor string NOT NULL,
+		from_state string NOT NULL DEFAULT '',
+		to_state string NOT NULL,
+		at INTEGER NOT NULL,
+		reason string NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX IF NOT EXISTS transitions_sensor ON transitions (sensor, at)`,
+	`CREATE TABLE IF NOT EXISTS readings (
+		sensor string NOT NULL,
+		time INTEGER NOT NULL,
+		value REAL NOT NULL,
+		quality INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX IF NOT EXISTS readings_sensor_time ON readings (sensor, time)`,
+	`CREATE TABLE IF NOT EXISTS ingresses (
+		name string PRIMARY KEY,
+		description string NOT NULL DEFAULT '',
+		metadata string NOT NULL DEFAULT 'null',
+		stale_after INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS assets (
+		id string PRIMARY KEY,
+		name string NOT NULL,
+		kind string NOT NULL,
+		parent string NOT NULL DEFAULT '',
+		latitude REAL,
+		longitude REAL
+	)`,
+	`CREATE INDEX IF NOT EXISTS assets_parent ON assets (parent)`,
+	`CREATE TABLE IF NOT EXISTS topology_buses (
+		id string PRIMARY KEY,
+		name string NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS topology_branches (
+		id string PRIMARY KEY,
+		kind string NOT NULL,
+		from_bus string NOT NULL,
+		to_bus string NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS topology_attachments (
+		sensor string PRIMARY KEY,
+		bus string NOT NULL DEFAULT '',
+		branch string NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS phasors (
+		id string PRIMARY KEY,
+		magnitude string NOT NULL UNIQUE,
+		angle string NOT NULL UNIQUE
+	)`,
+	`CREATE TABLE IF NOT EXISTS alarm_rules (
+		id string PRIMARY KEY,
+		sensor string NOT NULL DEFAULT '',
+		selector string NOT NULL DEFAULT 'null',
+		kind string NOT NULL,
+		threshold REAL NOT NULL DEFAULT 0,
+		unit string NOT NULL DEFAULT '',
+		description string NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS alarms (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		rule string NOT NULL,
+		sensor string NOT NULL,
+		state string NOT NULL,
+		message string NOT NULL DEFAULT '',
+		value REAL,
+		raised_at INTEGER NOT NULL,
+		acknowledged_at INTEGER,
+		acknowledged_by string NOT NULL DEFAULT '',
+		cleared_at INTEGER
+	)`,
+	`CREATE INDEX IF NOT EXISTS alarms_rule_sensor ON alarms (rule, sensor, state)`,
+	`CREATE TABLE IF NOT EXISTS calibrations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		sensor string NOT NULL,
+		scale REAL NOT NULL,
+		offset REAL NOT NULL DEFAULT 0,
+		effective_from INTEGER NOT NULL,
+		certificate string NOT NULL DEFAULT '',
+		due_at INTEGER
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS calibrations_sensor_effective ON calibrations (sensor, effective_from)`,
+	`CREATE TABLE IF NOT EXISTS event_records (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		station string NOT NULL,
+		device string NOT NULL,
+		revision INTEGER NOT NULL,
+		format string NOT NULL,
+		frequency REAL NOT NULL,
+		sample_rate REAL NOT NULL DEFAULT 0,
+		start INTEGER NOT NULL,
+		trigger_at INTEGER NOT NULL,
+		end_at INTEGER NOT NULL,
+		samples INTEGER NOT NULL,
+		imported_at INTEGER NOT NULL,
+		channels string NOT NULL DEFAULT '[]',
+		cfg BLOB NOT NULL,
+		dat BLOB NOT NULL
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS event_records_recorder_start ON event_records (station, device, start)`,
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name string NOT NULL,
+		scope string NOT NULL,
+		prefix string NOT NULL,
+		hash string NOT NULL UNIQUE,
+		created_at INTEGER NOT NULL,
+		last_used INTEGER,
+		revoked_at INTEGER
+	)`,
+	`CREATE TABLE IF NOT EXISTS namespaces (
+		name string PRIMARY KEY,
+		created_at INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS distillers (
+		name string PRIMARY KEY,
+		description string NOT NULL DEFAULT '',
+		metadata string NOT NULL DEFAULT 'null',
+		stale_after INTEGER NOT NULL DEFAULT 0
+	)`,
 }
 
-// Create a new store struct, initializing
-// the database with three default sensors.
-func newStore() (db *store, err error) {
+// A change to tables that may already exist, so isn't made by the
+// statements in schema.
+type migration struct {
+	statement string
+	// Column the statement adds to table. It's only added to tables
+	// missing it, as databases made before migrations were versioned
+	// may have any of them.
+	table, column string
+}
+
+// A migration adding a column, which has to have a default or allow
+// nulls as existing rows get it too.
+func addColumn(table, column, definition string) migration {
+	return migration{
+		statement: fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition),
+		table:     table,
+		column:    column,
+	}
+}
+
+// Migrations in the order they were added. Databases record how many
+// they've had applied in PRAGMA user_version, so new ones go at the end.
+var migrations = []migration{
+	addColumn("sensors", "asset", "string NOT NULL DEFAULT ''"),
+	addColumn("sensors", "state", "string NOT NULL DEFAULT 'active'"),
+	addColumn("sensors", "state_since", "INTEGER"),
+	addColumn("sensors", "last_seen", "INTEGER"),
+	addColumn("sensors", "stale_after", "INTEGER NOT NULL DEFAULT 0"),
+	addColumn("ingresses", "stale_after", "INTEGER NOT NULL DEFAULT 0"),
+	addColumn("distillers", "stale_after", "INTEGER NOT NULL DEFAULT 0"),
+	addColumn("readings", "quality", "INTEGER NOT NULL DEFAULT 0"),
+	addColumn("sensors", "sample_rate", "REAL NOT NULL DEFAULT 0"),
+	// Register the components sensors stored before they were managed
+	// reference.
+	{statement: `INSERT INTO ingresses (name) SELECT DISTINCT ingress FROM sensors WHERE ingress != '' ON CONFLICT(name) DO NOTHING`},
+	{statement: `INSERT INTO distillers (name) SELECT DISTINCT distiller FROM sensors WHERE distiller != '' ON CONFLICT(name) DO NOTHING`},
+}
+
+// Create missing tables and apply the migrations a database hasn't
+// had yet, in one transaction.
+func migrate(conn *sql.DB) (err error) {
+	var tx *sql.Tx
+	if tx, err = conn.Begin(); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, createStatement := range schema {
+		if _, err = tx.Exec(createStatement); err != nil {
+			return err
+		}
+	}
+
+	var version int
+	if err = tx.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database is at schema version %d, newer than this server's %d", version, len(migrations))
+	}
+	for i, m := range migrations[version:] {
+		if m.column != "" {
+			var exists bool
+			if exists, err = hasColumn(tx, m.table, m.column); err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+		}
+		if _, err = tx.Exec(m.statement); err != nil {
+			return fmt.Errorf("migrating schema to version %d: %w", version+i+1, err)
+		}
+	}
+	// PRAGMA doesn't take parameters.
+	if _, err = tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, len(migrations))); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Whether a table has a column.
+func hasColumn(tx *sql.Tx, table, column string) (_ bool, err error) {
+	var rows *sql.Rows
+	if rows, err = tx.Query(`SELECT name FROM pragma_table_info(?)`, table); err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// Implemented by both the store's database and its transactions.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Returned when a change would break a reference between records.
+type conflictError struct {
+	message string
+}
+
+func (e *conflictError) Error() string {
+	return e.message
+}
+
+// Return notFound if a statement didn't change any rows.
+func requireAffected(result sql.Result, notFound error) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// Create a new store struct, opening the database at dsn
+// (":memory:" for a throwaway in-memory database).
+func newStore(dsn string) (db *store, err error) {
 	var conn *sql.DB
-	if conn, err = sql.Open("sqlite3", ":memory:"); err != nil {
+	if conn, err = sql.Open("sqlite3", dsn); err != nil {
 		return nil, err
 	}
 
-	createStatement := `
-		CREATE TABLE IF NOT EXISTS sensors (
-			name string PRIMARY KEY,
-			latitude REAL,
-			longitude REAL,
-			unit string,
-			ingress string,
-			distiller string
-		)`
-	if _, err = conn.Exec(createStatement); err != nil {
-		return nil, err
+	// Every connection to a private in-memory database gets its
+	// own empty one, so keep the pool to a single connection.
+	if memory, shared := memoryDSN(dsn); memory && !shared {
+		conn.SetMaxOpenConns(1)
 	}
 
-	insertStatement := `
-		INSERT INTO sensors(name, latitude, longitude, unit, ingress, distiller) 
-		VALUES('L1MAG', 0, 0, 'volts', 'Middle of the Ocean', 'foo'),
-			('L1ANG', 33.8, 117.9, 'deg', 'Anaheim', 'bar'),
-			('C1MAG', 37.8, 175.7, 'amps', 'New Zealand', 'baz')`
-	if _, err = conn.Exec(insertStatement); err != nil {
+	if err = migrate(conn); err != nil {
 		return nil, err
 	}
 
-	return &store{conn: conn}, nil
+	return &store{conn: &timedDB{DB: conn, tracer: newTracer(nil)}, staleAfter: defaultStaleAfter}, nil
 }
 
-// Query a particular sensor by name, returning it as a sensor struct.
-func (db *store) querySensor(name string) (sensor *Sensor, err error) {
-	var rows *sql.Rows
-	selectStatement := `
-		SELECT latitude, longitude, unit, ingress, distiller 
-		FROM sensors 
-		WHERE name=(?)`
-	if rows, err = db.conn.Query(selectStatement, name); err != nil {
-		return nil, err
+// Whether dsn names an in-memory database, as ":memory:",
+// "file::memory:" or with mode=memory, and whether its connections
+// share it through the shared cache rather than each opening its own.
+func memoryDSN(dsn string) (memory, shared bool) {
+	path, query, _ := strings.Cut(dsn, "?")
+	params, _ := url.ParseQuery(query)
+	memory = path == ":memory:" || path == "file::memory:" || params.Get("mode") == "memory"
+	return memory, memory && params.Get("cache") == "shared"
+}
+
+// Insert seed sensors, skipping any that already exist so
+// seeding a persistent database more than once is harmless.
+// Ingresses and distillers the sensors reference are created
+// as needed. Returns the number of sensors actually inserted.
+func (db *store) seedSensors(ctx context.Context, sensors []*Sensor) (inserted int64, err error) {
+	var tx *timedTx
+	if tx, err = db.conn.BeginTx(ctx, nil); err != nil {
+		return 0, err
 	}
+	defer tx.Rollback()
 
-	var lat, lon float64
-	var unit, ingress, distiller string
-	for rows.Next() {
-		rows.Scan(&lat, &lon, &unit, &ingress, &distiller)
-		sensor = CreateSensor(name, unit, ingress, distiller, lat, lon)
+	for _, sensor := range sensors {
+		if err = ensureComponent(ctx, tx, ingressKind, sensor.Tags.Ingress); err != nil {
+			return 0, err
+		}
+		if err = ensureComponent(ctx, tx, distillerKind, sensor.Tags.Distiller); err != nil {
+			return 0, err
+		}
+
+		var ok bool
+		if ok, err = insertSensorRow(ctx, tx, sensor, true); err != nil {
+			return 0, err
+		}
+		if ok {
+			inserted++
+		}
 	}
 
-	if sensor == nil {
-		return nil, errors.New("Sensor not found in store")
+	if err = tx.Commit(); err != nil {
+		return 0, err
 	}
+	return inserted, nil
+}
 
-	return sensor, nil
+// Columns selected by sensor queries, in the order scanSensor reads them.
+// The last one is the staleness threshold of the sensor's ingress.
+const sensorColumns = `name, latitude, longitude, unit, ingress, distiller, asset, stale_after, sample_rate, state, state_since, last_seen,
+	(SELECT stale_after FROM ingresses WHERE ingresses.name = sensors.ingress)`
+
+// Columns written for a sensor, in the order sensorValues returns them.
+const sensorWriteColumns = `name, latitude, longitude, unit, ingress, distiller, asset, stale_after, sample_rate`
+
+// Query a particular sensor by name, returning it as a sensor struct.
+func (db *store) querySensor(ctx context.Context, name string) (sensor *Sensor, err error) {
+	var sensors []*Sensor
+	if sensors, err = db.querySensorsWhere(ctx, "name = ?", name); err != nil {
+		return nil, err
+	}
+
+	if len(sensors) == 0 {
+		return nil, errSensorNotFound
+	}
+
+	return sensors[0], nil
 }
 
 // Queries all sensors from the database and returns them as a slice.
-func (db *store) queryAllSensors() (sensors []*Sensor, err error) {
-	var rows *sql.Rows
-	selectStatement := `
-		SELECT name, latitude, longitude, unit, ingress, distiller 
-		FROM sensors`
-	if rows, err = db.conn.Query(selectStatement); err != nil {
+func (db *store) queryAllSensors(ctx context.Context) (sensors []*Sensor, err error) {
+	return db.querySensorsWhere(ctx, "")
+}
+
+// Query the sensors matching a WHERE clause, or every sensor if it is
+// empty. Sensors without a location inherit the one of their asset.
+func (db *store) querySensorsWhere(ctx context.Context, where string, args ...interface{}) (sensors []*Sensor, err error) {
+	selectStatement := `SELECT ` + sensorColumns + ` FROM sensors`
+	if where != "" {
+		selectStatement += ` WHERE ` + where
+	}
+
+	var rows *timedRows
+	if rows, err = db.conn.QueryContext(ctx, selectStatement, args...); err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	var lat, lon float64
-	var unit, name, ingress, distiller string
 	for rows.Next() {
-		rows.Scan(&name, &lat, &lon, &unit, &ingress, &distiller)
-		newSensor := CreateSensor(name, unit, ingress, distiller, lat, lon)
-		sensors = append(sensors, newSensor)
+		var sensor *Sensor
+		if sensor, err = db.scanSensor(ctx, rows); err != nil {
+			return nil, err
+		}
+		sensors = append(sensors, sensor)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
 
+	if err = db.inheritLocations(ctx, sensors); err != nil {
+		return nil, err
+	}
 	return sensors, nil
 }
 
+// Scan a row selected with sensorColumns into a sensor and
+// work out its liveness.
+func (db *store) scanSensor(ctx context.Context, rows *timedRows) (_ *Sensor, err error) {
+	var lat, lon sql.NullFloat64
+	var stateSince, lastSeen, ingressStaleAfter sql.NullInt64
+	sensor := &Sensor{}
+	if err = rows.Scan(
+		&sensor.Name,
+		&lat,
+		&lon,
+		&sensor.Tags.Unit,
+		&sensor.Tags.Ingress,
+		&sensor.Tags.Distiller,
+		&sensor.Asset,
+		&sensor.StaleAfter,
+		&sensor.SampleRate,
+		&sensor.State,
+		&stateSince,
+		&lastSeen,
+		&ingressStaleAfter,
+	); err != nil {
+		return nil, err
+	}
+
+	sensor.Tags.Name = sensor.Name
+	if lat.Valid && lon.Valid {
+		sensor.Location = &Coordinates{Latitude: lat.Float64, Longitude: lon.Float64}
+	}
+	if stateSince.Valid {
+		since := time.Unix(0, stateSince.Int64).UTC()
+		sensor.StateSince = &since
+	}
+	if lastSeen.Valid {
+		seen := time.Unix(0, lastSeen.Int64).UTC()
+		sensor.LastSeen = &seen
+	}
+
+	threshold := time.Duration(sensor.StaleAfter)
+	if threshold == 0 {
+		threshold = time.Duration(ingressStaleAfter.Int64)
+	}
+	if threshold == 0 {
+		threshold = db.staleAfter
+	}
+	sensor.Status = livenessStatus(sensor.LastSeen, threshold, time.Now())
+	return sensor, nil
+}
+
+// Column values for sensorWriteColumns. Inherited locations aren't stored.
+func sensorValues(sensor *Sensor) []interface{} {
+	var lat, lon sql.NullFloat64
+	if sensor.Location != nil && sensor.LocationFrom == "" {
+		lat = sql.NullFloat64{Float64: sensor.Location.Latitude, Valid: true}
+		lon = sql.NullFloat64{Float64: sensor.Location.Longitude, Valid: true}
+	}
+	return []interface{}{
+		sensor.Name,
+		lat,
+		lon,
+		sensor.Tags.Unit,
+		sensor.Tags.Ingress,
+		sensor.Tags.Distiller,
+		sensor.Asset,
+		int64(sensor.StaleAfter),
+		sensor.SampleRate,
+	}
+}
+
 // Insert sensor into the database.
-func (db *store) insertSensor(name, ingress, distiller, unit string, lat, lon float64) (err error) {
-	insertStatement := `
-		INSERT INTO sensors (name, latitude, longitude, unit, ingress, distiller) 
-		VALUES(?, ?, ?, ?, ?, ?)`
-	if _, err = db.conn.Exec(insertStatement, name, lat, lon, unit, ingress, distiller); err != nil {
+func (db *store) insertSensor(ctx context.Context, sensor *Sensor) (err error) {
+	var tx *timedTx
+	if tx, err = db.conn.BeginTx(ctx, nil); err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
+	if err = db.checkReferences(ctx, tx, sensor); err != nil {
+		return err
+	}
+	if _, err = insertSensorRow(ctx, tx, sensor, false); err != nil {
+		return err
+	}
+	return db.commitSensors(tx)
 }
 
-// Update a sensor already in the database.
-func (db *store) updateSensor(name, ingress, distiller, unit string, lat, lon float64) (err error) {
+// Insert a sensor row and record the lifecycle state it starts in,
+// active unless set. With ignoreExisting an existing sensor is left
+// untouched and inserted is false.
+func insertSensorRow(ctx context.Context, tx execer, sensor *Sensor, ignoreExisting bool) (inserted bool, err error) {
+	if sensor.State == "" {
+		sensor.State = StateActive
+	}
+	now := time.Now().UTC()
+	sensor.StateSince = &now
+
+	insertStatement := `
+		INSERT INTO sensors (` + sensorWriteColumns + `, state, state_since)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	if ignoreExisting {
+		insertStatement += ` ON CONFLICT(name) DO NOTHING`
+	}
+
+	var result sql.Result
+	if result, err = tx.ExecContext(ctx, insertStatement, append(sensorValues(sensor), sensor.State, now.UnixNano())...); err != nil {
+		return false, err
+	}
+
+	var affected int64
+	if affected, err = result.RowsAffected(); err != nil || affected == 0 {
+		return false, err
+	}
+
+	created := &Transition{To: sensor.State, At: now, Reason: "created"}
+	if err = insertTransition(ctx, tx, sensor.Name, created); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Update a sensor already in the database. The lifecycle
+// state only changes through transitionSensor.
+func (db *store) updateSensor(ctx context.Context, sensor *Sensor) (err error) {
+	var tx *timedTx
+	if tx, err = db.conn.BeginTx(ctx, nil); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = db.checkReferences(ctx, tx, sensor); err != nil {
+		return err
+	}
+
+	var result sql.Result
 	updateStatement := `
 		UPDATE sensors 
-		SET name=?, latitude=?, longitude=?, unit=?, ingress=?, distiller=? 
+		SET name=?, latitude=?, longitude=?, unit=?, ingress=?, distiller=?, asset=?, stale_after=?, sample_rate=? 
 		WHERE name = ?`
-	if _, err = db.conn.Exec(updateStatement, name, lat, lon, unit, ingress, distiller, name); err != nil {
+	if result, err = tx.ExecContext(ctx, updateStatement, append(sensorValues(sensor), sensor.Name)...); err != nil {
 		return err
 	}
-	return nil
+	if err = requireAffected(result, errSensorNotFound); err != nil {
+		return err
+	}
+	return db.commitSensors(tx)
+}
+
+// Delete a sensor, its readings, history and topology attachment from the
+// database by name. Sensors that are part of a phasor or named by an
+// alarm rule can't be deleted.
+func (db *store) deleteSensor(ctx context.Context, name string) (err error) {
+	var tx *timedTx
+	if tx, err = db.conn.BeginTx(ctx, nil); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var phasor string
+	phasorStatement := `SELECT id FROM phasors WHERE magnitude = ? OR angle = ?`
+	switch err = tx.QueryRowContext(ctx, phasorStatement, name, name).Scan(&phasor); {
+	case err == nil:
+		return &conflictError{fmt.Sprintf("Sensor is part of phasor %s", phasor)}
+	case !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+
+	var rule string
+	switch err = tx.QueryRowContext(ctx, `SELECT id FROM alarm_rules WHERE sensor = ?`, name).Scan(&rule); {
+	case err == nil:
+		return &conflictError{fmt.Sprintf("Sensor has alarm rule %s", rule)}
+	case !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+
+	var result sql.Result
+	deleteStatement := `
+		DELETE FROM sensors 
+		WHERE name = ?`
+	if result, err = tx.ExecContext(ctx, deleteStatement, name); err != nil {
+		return err
+	}
+	if err = requireAffected(result, errSensorNotFound); err != nil {
+		return err
+	}
+
+	for _, table := range []string{"readings", "transitions", "topology_attachments", "calibrations"} {
+		if _, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE sensor = ?`, name); err != nil {
+			return err
+		}
+	}
+
+	return db.commitSensors(tx)
 }
 
 // Helper function to create a sensor struct.
 func CreateSensor(name, unit, ingress, distiller string, lat, lon float64) *Sensor {
 	return &Sensor{
 		Name: name,
-		Location: Coordinates{
+		Location: &Coordinates{
 			Latitude:  lat,
 			Longitude: lon,
 		},
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"time"
+)
+
+func (suite *testSuite) TestMigrations() {
+	// A database made before any columns were added.
+	dsn := filepath.Join(suite.T().TempDir(), "baseline.db")
+	conn, err := sql.Open("sqlite3", dsn)
+	suite.Nil(err)
+	_, err = conn.Exec(`
+		CREATE TABLE IF NOT EXISTS sensors (
+			name string PRIMARY KEY,
+			latitude REAL,
+			longitude REAL,
+			unit string,
+			ingress string,
+			distiller string
+		)`)
+	suite.Nil(err)
+	_, err = conn.Exec(`INSERT INTO sensors VALUES ('OLDMAG', 33.8, 117.9, 'V', 'Anaheim', 'bar')`)
+	suite.Nil(err)
+	suite.Nil(conn.Close())
+
+	for i := 0; i < 2; i++ {
+		suite.srv, err = New("fakeaddress", WithDatabase(dsn))
+		suite.Nil(err)
+
+		response := suite.request("GET", "/sensor/OLDMAG", nil)
+		suite.Equal(200, response.Code, response.Body.String())
+		sensor := &Sensor{}
+		suite.Nil(json.Unmarshal(response.Body.Bytes(), sensor))
+		suite.Equal("active", sensor.State)
+		suite.Equal("V", sensor.Tags.Unit)
+
+		response = suite.request("POST", "/sensor/OLDMAG/readings", []*Reading{{Time: time.Now().Add(-time.Second), Value: 230}})
+		suite.Equal(201, response.Code, response.Body.String())
+		suite.Equal(200, suite.request("GET", "/sensor/OLDMAG/readings", nil).Code)
+
+		// The components it references are registered, so it can be
+		// updated.
+		suite.Equal(200, suite.request("GET", "/ingresses/Anaheim", nil).Code)
+		suite.Equal(200, suite.request("GET", "/distillers/bar", nil).Code)
+		sensor.Location.Latitude = 34
+		suite.Equal(200, suite.request("PUT", "/sensor/OLDMAG", sensor).Code)
+
+		var version int
+		suite.Nil(suite.srv.db.conn.QueryRow(`PRAGMA user_version`).Scan(&version))
+		suite.Equal(len(migrations), version)
+		suite.srv.db.conn.Close()
+	}
+
+	// Databases from newer servers aren't opened.
+	conn, err = sql.Open("sqlite3", dsn)
+	suite.Nil(err)
+	_, err = conn.Exec(`PRAGMA user_version = 1000`)
+	suite.Nil(err)
+	suite.Nil(conn.Close())
+	_, err = New("fakeaddress", WithDatabase(dsn))
+	suite.NotNil(err)
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Serve HTTPS with the PEM certificate chain and key in certFile and
+// keyFile.
+func WithTLS(certFile, keyFile string) Option {
+	return func(o *options) {
+		o.tlsCert, o.tlsKey = certFile, keyFile
+	}
+}
+
+// Require clients to present a certificate signed by one of the CAs
+// in the PEM bundle caFile. Only takes effect along with WithTLS.
+func WithClientCA(caFile string) Option {
+	return func(o *options) {
+		o.clientCA = caFile
+	}
+}
+
+// The TLS configuration for a certificate and key, requiring client
+// certificates signed by the CAs in clientCA when it's set.
+func loadTLSConfig(certFile, keyFile, clientCA string) (_ *tls.Config, err error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("TLS needs both a certificate and a key")
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	var cert tls.Certificate
+	if cert, err = tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	config.Certificates = []tls.Certificate{cert}
+
+	if clientCA != "" {
+		if config.ClientCAs, err = LoadCertPool(clientCA); err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// Read a pool of certificates from a PEM bundle.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no PEM certificates", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+)
+
+func (suite *testSuite) TestTLS() {
+	var err error
+	suite.srv, err = New("fakeaddress", WithSeed("testdata/sensors.json"),
+		WithTLS("testdata/tls/server.pem", "testdata/tls/server-key.pem"), WithClientCA("testdata/tls/ca.pem"))
+	suite.Nil(err)
+	suite.Equal(tls.RequireAndVerifyClientCert, suite.srv.srv.TLSConfig.ClientAuth)
+
+	listener := httptest.NewUnstartedServer(suite.srv.gin)
+	listener.TLS = suite.srv.srv.TLSConfig
+	listener.StartTLS()
+	defer listener.Close()
+
+	roots, err := LoadCertPool("testdata/tls/ca.pem")
+	suite.Nil(err)
+	clientCert, err := tls.LoadX509KeyPair("testdata/tls/client.pem", "testdata/tls/client-key.pem")
+	suite.Nil(err)
+	get := func(config *tls.Config) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		return client.Get(listener.URL + "/sensor/L1MAG")
+	}
+
+	// Clients need a certificate signed by the CA, and to trust the
+	// server's.
+	response, err := get(&tls.Config{RootCAs: roots, Certificates: []tls.Certificate{clientCert}})
+	suite.Nil(err)
+	suite.Equal(200, response.StatusCode)
+	response.Body.Close()
+	_, err = get(&tls.Config{RootCAs: roots})
+	suite.NotNil(err)
+	_, err = get(&tls.Config{Certificates: []tls.Certificate{clientCert}})
+	suite.NotNil(err)
+
+	// The certificate needs its own key.
+	_, err = New("fakeaddress", WithTLS("testdata/tls/server.pem", ""))
+	suite.NotNil(err)
+	_, err = New("fakeaddress", WithTLS("testdata/tls/server.pem", "testdata/tls/client-key.pem"))
+	suite.NotNil(err)
+}
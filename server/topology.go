@@ -0,0 +1,600 @@
+package server
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The electrical network: buses joined by branches (lines and
+// transformers), with sensors attached to either.
+type Topology struct {
+	Buses       []*Bus        `json:"buses"`
+	Branches    []*Branch     `json:"branches"`
+	Attachments []*Attachment `json:"attachments"`
+}
+
+type Bus struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+type Branch struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Attaches a sensor to a bus or a branch, exactly one must be set.
+type Attachment struct {
+	Sensor string `json:"sensor"`
+	Bus    string `json:"bus,omitempty"`
+	Branch string `json:"branch,omitempty"`
+}
+
+// An electrical path between two sensors as alternating buses and branches.
+type TopologyPath struct {
+	From     string   `json:"from"`
+	To       string   `json:"to"`
+	Hops     int      `json:"hops"`
+	Buses    []string `json:"buses"`
+	Branches []string `json:"branches"`
+}
+
+// A sensor and its distance in branches from another sensor.
+type Neighbor struct {
+	Sensor string `json:"sensor"`
+	Hops   int    `json:"hops"`
+}
+
+// A set of buses that are connected to each other, and their sensors.
+type Island struct {
+	Buses   []string `json:"buses"`
+	Sensors []string `json:"sensors"`
+}
+
+var branchKinds = map[string]bool{"line": true, "transformer": true}
+
+// Header of CSV topology files. The element column is bus, line,
+// transformer or sensor. Branches use from and to for their buses,
+// sensors use from for the bus or branch they are attached to.
+var topologyColumns = []string{"element", "id", "from", "to", "name"}
+
+// Read a topology from CSV.
+func parseTopologyCSV(r io.Reader) (_ *Topology, err error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = len(topologyColumns)
+
+	var header []string
+	if header, err = reader.Read(); err != nil {
+		return nil, err
+	}
+	for i, column := range topologyColumns {
+		if strings.ToLower(strings.TrimSpace(header[i])) != column {
+			return nil, fmt.Errorf("topology CSV header must be %s", strings.Join(topologyColumns, ","))
+		}
+	}
+
+	topology := &Topology{}
+	var attachments [][]string
+	for line := 2; ; line++ {
+		var record []string
+		if record, err = reader.Read(); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		element, id, from, to, name := record[0], record[1], record[2], record[3], record[4]
+		switch element {
+		case "bus":
+			topology.Buses = append(topology.Buses, &Bus{ID: id, Name: name})
+		case "line", "transformer":
+			topology.Branches = append(topology.Branches, &Branch{ID: id, Kind: element, From: from, To: to})
+		case "sensor":
+			attachments = append(attachments, []string{id, from})
+		default:
+			return nil, fmt.Errorf("line %d: unknown element %q", line, element)
+		}
+	}
+
+	// Sensors name the element they attach to, which may be declared later.
+	buses := make(map[string]bool, len(topology.Buses))
+	for _, bus := range topology.Buses {
+		buses[bus.ID] = true
+	}
+	for _, attachment := range attachments {
+		if buses[attachment[1]] {
+			topology.Attachments = append(topology.Attachments, &Attachment{Sensor: attachment[0], Bus: attachment[1]})
+		} else {
+			topology.Attachments = append(topology.Attachments, &Attachment{Sensor: attachment[0], Branch: attachment[1]})
+		}
+	}
+
+	return topology, nil
+}
+
+// Check that ids are unique and every reference resolves. sensors
+// holds the names of the sensors registered in the store.
+func validateTopology(topology *Topology, sensors map[string]bool) error {
+	verr := &ValidationError{}
+	ids := make(map[string]string)
+	claim := func(field, id string) {
+		if id == "" {
+			verr.add(field, "required", "is required")
+		} else if other, ok := ids[id]; ok {
+			verr.add(field, "unique", fmt.Sprintf("%s is already used by %s", id, other))
+		} else {
+			ids[id] = field
+		}
+	}
+
+	for i, bus := range topology.Buses {
+		if bus == nil {
+			verr.add(fmt.Sprintf("buses[%d]", i), "required", "is required")
+			continue
+		}
+		claim(fmt.Sprintf("buses[%d].id", i), bus.ID)
+	}
+	for i, branch := range topology.Branches {
+		if branch == nil {
+			verr.add(fmt.Sprintf("branches[%d]", i), "required", "is required")
+			continue
+		}
+		claim(fmt.Sprintf("branches[%d].id", i), branch.ID)
+		if !branchKinds[branch.Kind] {
+			verr.add(fmt.Sprintf("branches[%d].kind", i), "kind", "must be line or transformer")
+		}
+		for field, bus := range map[string]string{"from": branch.From, "to": branch.To} {
+			if !strings.HasPrefix(ids[bus], "buses") {
+				verr.add(fmt.Sprintf("branches[%d].%s", i, field), "exists", fmt.Sprintf("references unknown bus %q", bus))
+			}
+		}
+		if branch.From == branch.To {
+			verr.add(fmt.Sprintf("branches[%d].to", i), "distinct", "must be a different bus than from")
+		}
+	}
+
+	attached := make(map[string]bool)
+	for i, attachment := range topology.Attachments {
+		field := fmt.Sprintf("attachments[%d]", i)
+		if attachment == nil {
+			verr.add(field, "required", "is required")
+			continue
+		}
+		if !sensors[attachment.Sensor] {
+			verr.add(field+".sensor", "exists", fmt.Sprintf("references unknown sensor %q", attachment.Sensor))
+		} else if attached[attachment.Sensor] {
+			verr.add(field+".sensor", "unique", "is attached more than once")
+		}
+		attached[attachment.Sensor] = true
+
+		switch {
+		case (attachment.Bus == "") == (attachment.Branch == ""):
+			verr.add(field, "element", "must set exactly one of bus or branch")
+		case attachment.Bus != "" && !strings.HasPrefix(ids[attachment.Bus], "buses"):
+			verr.add(field+".bus", "exists", fmt.Sprintf("references unknown bus %q", attachment.Bus))
+		case attachment.Branch != "" && !strings.HasPrefix(ids[attachment.Branch], "branches"):
+			verr.add(field+".branch", "exists", fmt.Sprintf("references unknown branch %q", attachment.Branch))
+		}
+	}
+
+	// Map iteration makes branch errors unordered.
+	sort.SliceStable(verr.Fields, func(i, j int) bool { return verr.Fields[i].Field < verr.Fields[j].Field })
+	return verr.orNil()
+}
+
+// Replace the stored topology.
+func (db *store) replaceTopology(ctx context.Context, topology *Topology) (err error) {
+	var tx *timedTx
+	if tx, err = db.conn.BeginTx(ctx, nil); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"topology_buses", "topology_branches", "topology_attachments"} {
+		if _, err = tx.ExecContext(ctx, `DELETE FROM `+table); err != nil {
+			return err
+		}
+	}
+
+	for _, bus := range topology.Buses {
+		if _, err = tx.ExecContext(ctx, `INSERT INTO topology_buses (id, name) VALUES(?, ?)`, bus.ID, bus.Name); err != nil {
+			return err
+		}
+	}
+	for _, branch := range topology.Branches {
+		insertStatement := `
+			INSERT INTO topology_branches (id, kind, from_bus, to_bus)
+			VALUES(?, ?, ?, ?)`
+		if _, err = tx.ExecContext(ctx, insertStatement, branch.ID, branch.Kind, branch.From, branch.To); err != nil {
+			return err
+		}
+	}
+	for _, attachment := range topology.Attachments {
+		insertStatement := `
+			INSERT INTO topology_attachments (sensor, bus, branch)
+			VALUES(?, ?, ?)`
+		if _, err = tx.ExecContext(ctx, insertStatement, attachment.Sensor, attachment.Bus, attachment.Branch); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Query the stored topology.
+func (db *store) queryTopology(ctx context.Context) (topology *Topology, err error) {
+	topology = &Topology{Buses: []*Bus{}, Branches: []*Branch{}, Attachments: []*Attachment{}}
+
+	var rows *timedRows
+	if rows, err = db.conn.QueryContext(ctx, `SELECT id, name FROM topology_buses ORDER BY id`); err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		bus := &Bus{}
+		if err = rows.Scan(&bus.ID, &bus.Name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		topology.Buses = append(topology.Buses, bus)
+	}
+	rows.Close()
+
+	if rows, err = db.conn.QueryContext(ctx, `SELECT id, kind, from_bus, to_bus FROM topology_branches ORDER BY id`); err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		branch := &Branch{}
+		if err = rows.Scan(&branch.ID, &branch.Kind, &branch.From, &branch.To); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		topology.Branches = append(topology.Branches, branch)
+	}
+	rows.Close()
+
+	if rows, err = db.conn.QueryContext(ctx, `SELECT sensor, bus, branch FROM topology_attachments ORDER BY sensor`); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		attachment := &Attachment{}
+		if err = rows.Scan(&attachment.Sensor, &attachment.Bus, &attachment.Branch); err != nil {
+			return nil, err
+		}
+		topology.Attachments = append(topology.Attachments, attachment)
+	}
+
+	return topology, rows.Err()
+}
+
+// In memory adjacency view of a topology for graph queries.
+type grid struct {
+	topology *Topology
+	edges    map[string][]*Branch // branches touching each bus.
+	sensors  map[string][]string  // buses each sensor is electrically at.
+	removed  map[string]bool      // branches taken out of service.
+}
+
+func newGrid(topology *Topology, removed ...string) *grid {
+	g := &grid{
+		topology: topology,
+		edges:    make(map[string][]*Branch),
+		sensors:  make(map[string][]string),
+		removed:  make(map[string]bool),
+	}
+	for _, id := range removed {
+		g.removed[id] = true
+	}
+
+	branches := make(map[string]*Branch)
+	for _, branch := range topology.Branches {
+		branches[branch.ID] = branch
+		if g.removed[branch.ID] {
+			continue
+		}
+		g.edges[branch.From] = append(g.edges[branch.From], branch)
+		g.edges[branch.To] = append(g.edges[branch.To], branch)
+	}
+
+	// A sensor on a branch sits at both of its ends.
+	for _, attachment := range topology.Attachments {
+		if attachment.Bus != "" {
+			g.sensors[attachment.Sensor] = []string{attachment.Bus}
+		} else if branch, ok := branches[attachment.Branch]; ok {
+			g.sensors[attachment.Sensor] = []string{branch.From, branch.To}
+		}
+	}
+	return g
+}
+
+var errSensorNotAttached = errors.New("Sensor is not attached to the topology")
+
+// Breadth first search from a sensor's buses. Returns the hop count to
+// every reachable bus and the branch used to first reach each bus.
+func (g *grid) search(sensor string) (hops map[string]int, via map[string]*Branch, err error) {
+	start, ok := g.sensors[sensor]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", errSensorNotAttached, sensor)
+	}
+
+	hops = make(map[string]int)
+	via = make(map[string]*Branch)
+	queue := append([]string{}, start...)
+	for _, bus := range start {
+		hops[bus] = 0
+	}
+
+	for len(queue) > 0 {
+		bus := queue[0]
+		queue = queue[1:]
+		for _, branch := range g.edges[bus] {
+			next := branch.To
+			if next == bus {
+				next = branch.From
+			}
+			if _, seen := hops[next]; !seen {
+				hops[next] = hops[bus] + 1
+				via[next] = branch
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	return hops, via, nil
+}
+
+// The shortest electrical path between two sensors.
+func (g *grid) path(from, to string) (*TopologyPath, error) {
+	hops, via, err := g.search(from)
+	if err != nil {
+		return nil, err
+	}
+	targets, ok := g.sensors[to]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errSensorNotAttached, to)
+	}
+
+	end, best := "", -1
+	for _, bus := range targets {
+		if h, ok := hops[bus]; ok && (best < 0 || h < best) {
+			end, best = bus, h
+		}
+	}
+	if best < 0 {
+		return nil, nil
+	}
+
+	path := &TopologyPath{From: from, To: to, Hops: best, Buses: []string{end}, Branches: []string{}}
+	for bus := end; via[bus] != nil; {
+		branch := via[bus]
+		path.Branches = append([]string{branch.ID}, path.Branches...)
+		if branch.To == bus {
+			bus = branch.From
+		} else {
+			bus = branch.To
+		}
+		path.Buses = append([]string{bus}, path.Buses...)
+	}
+	return path, nil
+}
+
+// Every other sensor within maxHops branches of a sensor, nearest first.
+func (g *grid) neighbors(sensor string, maxHops int) ([]*Neighbor, error) {
+	hops, _, err := g.search(sensor)
+	if err != nil {
+		return nil, err
+	}
+
+	neighbors := []*Neighbor{}
+	for other, buses := range g.sensors {
+		if other == sensor {
+			continue
+		}
+		best := -1
+		for _, bus := range buses {
+			if h, ok := hops[bus]; ok && (best < 0 || h < best) {
+				best = h
+			}
+		}
+		if best >= 0 && best <= maxHops {
+			neighbors = append(neighbors, &Neighbor{Sensor: other, Hops: best})
+		}
+	}
+
+	sort.Slice(neighbors, func(i, j int) bool {
+		if neighbors[i].Hops != neighbors[j].Hops {
+			return neighbors[i].Hops < neighbors[j].Hops
+		}
+		return neighbors[i].Sensor < neighbors[j].Sensor
+	})
+	return neighbors, nil
+}
+
+// Group buses into connected islands, ignoring removed branches.
+func (g *grid) islands() []*Island {
+	island := make(map[string]int)
+	var islands []*Island
+
+	for _, bus := range g.topology.Buses {
+		if _, seen := island[bus.ID]; seen {
+			continue
+		}
+
+		current := &Island{Buses: []string{}, Sensors: []string{}}
+		index := len(islands)
+		islands = append(islands, current)
+
+		island[bus.ID] = index
+		queue := []string{bus.ID}
+		for len(queue) > 0 {
+			next := queue[0]
+			queue = queue[1:]
+			current.Buses = append(current.Buses, next)
+			for _, branch := range g.edges[next] {
+				for _, end := range []string{branch.From, branch.To} {
+					if _, seen := island[end]; !seen {
+						island[end] = index
+						queue = append(queue, end)
+					}
+				}
+			}
+		}
+		sort.Strings(current.Buses)
+	}
+
+	// A sensor on a removed branch between two islands belongs to both.
+	for sensor, buses := range g.sensors {
+		added := make(map[int]bool)
+		for _, bus := range buses {
+			if index, ok := island[bus]; ok && !added[index] {
+				added[index] = true
+				islands[index].Sensors = append(islands[index].Sensors, sensor)
+			}
+		}
+	}
+	for _, current := range islands {
+		sort.Strings(current.Sensors)
+	}
+
+	return islands
+}
+
+// Load the stored topology as a grid.
+func (s *Server) loadGrid(ctx context.Context, removed ...string) (*grid, error) {
+	topology, err := s.db.queryTopology(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newGrid(topology, removed...), nil
+}
+
+// Return the stored topology.
+func (s *Server) getTopology(c *gin.Context) {
+	topology, err := s.db.queryTopology(c.Request.Context())
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, topology)
+}
+
+// Replace the topology with a JSON or, with a text/csv content type, CSV document.
+func (s *Server) importTopology(c *gin.Context) {
+	var err error
+	topology := &Topology{}
+	if c.ContentType() == "text/csv" {
+		topology, err = parseTopologyCSV(c.Request.Body)
+	} else {
+		err = c.ShouldBindJSON(topology)
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sensors, err := s.db.queryAllSensors(c.Request.Context())
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	names := make(map[string]bool, len(sensors))
+	for _, sensor := range sensors {
+		names[sensor.Name] = true
+	}
+
+	if err = validateTopology(topology, names); err != nil {
+		abortInvalid(c, err)
+		return
+	}
+	if err = s.db.replaceTopology(c.Request.Context(), topology); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{
+		"buses":       len(topology.Buses),
+		"branches":    len(topology.Branches),
+		"attachments": len(topology.Attachments),
+	})
+}
+
+// The shortest electrical path between the from and to sensors.
+func (s *Server) getTopologyPath(c *gin.Context) {
+	g, err := s.loadGrid(c.Request.Context())
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	path, err := g.path(c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if path == nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "Sensors are not electrically connected"})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, path)
+}
+
+// Every sensor within the hops query parameter (default 1) of a sensor.
+func (s *Server) getTopologyNeighbors(c *gin.Context) {
+	hops, err := strconv.Atoi(c.DefaultQuery("hops", "1"))
+	if err != nil || hops < 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "hops must be a non-negative integer"})
+		return
+	}
+
+	g, err := s.loadGrid(c.Request.Context())
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	neighbors, err := g.neighbors(c.Param("name"), hops)
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, neighbors)
+}
+
+// Connected islands after removing the branches in the without
+// query parameter, which may be repeated or comma separated.
+func (s *Server) getTopologyIslands(c *gin.Context) {
+	var removed []string
+	for _, value := range c.QueryArray("without") {
+		removed = append(removed, strings.Split(value, ",")...)
+	}
+
+	g, err := s.loadGrid(c.Request.Context(), removed...)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	known := make(map[string]bool)
+	for _, branch := range g.topology.Branches {
+		known[branch.ID] = true
+	}
+	for _, id := range removed {
+		if !known[id] {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown branch %q", id)})
+			return
+		}
+	}
+
+	c.IndentedJSON(http.StatusOK, g.islands())
+}
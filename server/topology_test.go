@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+)
+
+// Import the CSV fixture: B1 -L12- B2 -L23- B3 with transformer T24
+// from B2 to B4. L1MAG is at B1, L1ANG on L23 and C1MAG at B4.
+func (suite *testSuite) importTopologyFixture() {
+	file, err := os.Open("testdata/topology.csv")
+	suite.Nil(err)
+	defer file.Close()
+
+	request := httptest.NewRequest("PUT", "/topology", file)
+	request.Header.Set("Content-Type", "text/csv")
+	response := httptest.NewRecorder()
+	suite.srv.gin.ServeHTTP(response, request)
+	suite.Equal(200, response.Code, response.Body.String())
+}
+
+func (suite *testSuite) TestTopologyImport() {
+	suite.importTopologyFixture()
+
+	response := suite.request("GET", "/topology", nil)
+	suite.Equal(200, response.Code)
+
+	var topology Topology
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &topology))
+	suite.Len(topology.Buses, 4)
+	suite.Len(topology.Branches, 3)
+	suite.Contains(topology.Attachments, &Attachment{Sensor: "L1ANG", Branch: "L23"})
+
+	// JSON imports are validated as a whole.
+	response = suite.request("PUT", "/topology", &Topology{
+		Buses:       []*Bus{{ID: "B1"}},
+		Branches:    []*Branch{{ID: "B1", Kind: "cable", From: "B1", To: "B9"}},
+		Attachments: []*Attachment{{Sensor: "NOTASENSOR", Bus: "B1"}},
+	})
+	suite.Equal(400, response.Code)
+
+	var invalid struct {
+		Fields []FieldError `json:"fields"`
+	}
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &invalid))
+	rules := make([]string, 0, len(invalid.Fields))
+	for _, field := range invalid.Fields {
+		rules = append(rules, field.Field+":"+field.Rule)
+	}
+	suite.Equal([]string{
+		"attachments[0].sensor:exists",
+		"branches[0].id:unique",
+		"branches[0].kind:kind",
+		"branches[0].to:exists",
+	}, rules)
+
+	// Null elements are rejected rather than dereferenced.
+	response = suite.request("PUT", "/topology", json.RawMessage(`{"buses": [null], "branches": [null], "attachments": [null]}`))
+	suite.Equal(400, response.Code)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &invalid))
+	rules = rules[:0]
+	for _, field := range invalid.Fields {
+		rules = append(rules, field.Field+":"+field.Rule)
+	}
+	suite.Equal([]string{"attachments[0]:required", "branches[0]:required", "buses[0]:required"}, rules)
+}
+
+func (suite *testSuite) TestTopologyQueries() {
+	suite.importTopologyFixture()
+
+	response := suite.request("GET", "/topology/path?from=L1MAG&to=C1MAG", nil)
+	suite.Equal(200, response.Code)
+
+	var path TopologyPath
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &path))
+	suite.Equal(2, path.Hops)
+	suite.Equal([]string{"B1", "B2", "B4"}, path.Buses)
+	suite.Equal([]string{"L12", "T24"}, path.Branches)
+
+	response = suite.request("GET", "/topology/path?from=L1MAG&to=NOTASENSOR", nil)
+	suite.Equal(404, response.Code)
+
+	// L1ANG sits on L23 so it is one hop from B1 through B2.
+	response = suite.request("GET", "/topology/neighbors/L1MAG?hops=1", nil)
+	suite.Equal(200, response.Code)
+
+	var neighbors []*Neighbor
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &neighbors))
+	suite.Equal([]*Neighbor{{Sensor: "L1ANG", Hops: 1}}, neighbors)
+
+	response = suite.request("GET", "/topology/neighbors/L1MAG?hops=2", nil)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &neighbors))
+	suite.Len(neighbors, 2)
+
+	response = suite.request("GET", "/topology/islands?without=T24", nil)
+	suite.Equal(200, response.Code)
+
+	var islands []*Island
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &islands))
+	suite.Equal([]*Island{
+		{Buses: []string{"B1", "B2", "B3"}, Sensors: []string{"L1ANG", "L1MAG"}},
+		{Buses: []string{"B4"}, Sensors: []string{"C1MAG"}},
+	}, islands)
+
+	response = suite.request("GET", "/topology/islands?without=T24,L12", nil)
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &islands))
+	suite.Len(islands, 3)
+
+	response = suite.request("GET", "/topology/path?from=L1MAG&to=C1MAG", nil)
+	suite.Equal(200, response.Code)
+
+	response = suite.request("GET", "/topology/islands?without=NOPE", nil)
+	suite.Equal(400, response.Code)
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Name of the tracer spans are created with.
+const tracerName = "pingthings/server"
+
+// Trace context is taken from requests' W3C traceparent and tracestate
+// headers.
+var propagator = propagation.TraceContext{}
+
+// Trace requests and store queries with tp. Providers that can be shut
+// down are when the server is, flushing spans not yet exported.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = tp
+	}
+}
+
+// A tracer provider exporting spans as cfg says, nil when it doesn't
+// say to export them. Shutting it down closes the file spans go to.
+func NewTracerProvider(cfg TracingConfig) (_ *sdktrace.TracerProvider, err error) {
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case "":
+		return nil, nil
+	case "otlp":
+		// Without an endpoint the exporter follows the OTEL_EXPORTER_OTLP_*
+		// environment variables, and defaults to localhost:4318.
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if exporter, err = otlptracehttp.New(context.Background(), opts...); err != nil {
+			return nil, err
+		}
+	case "stdout":
+		if cfg.File == "" {
+			if exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout)); err != nil {
+				return nil, err
+			}
+			break
+		}
+		var file *os.File
+		if file, err = os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			return nil, err
+		}
+		if exporter, err = stdouttrace.New(stdouttrace.WithWriter(file)); err != nil {
+			file.Close()
+			return nil, err
+		}
+		exporter = &fileExporter{SpanExporter: exporter, file: file}
+	default:
+		return nil, fmt.Errorf("trace exporter %q isn't otlp or stdout", cfg.Exporter)
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.ServiceName))),
+	), nil
+}
+
+// The tracer of a provider, or one that doesn't record anything when
+// there's none.
+// An exporter closing the file it writes to once shut down.
+type fileExporter struct {
+	sdktrace.SpanExporter
+	file *os.File
+}
+
+func (e *fileExporter) Shutdown(ctx context.Context) error {
+	err := e.SpanExporter.Shutdown(ctx)
+	if closeErr := e.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func newTracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = noop.NewTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+// Middleware starting a span for every request, continuing the trace
+// the client propagated. Namespaces rename the span after their own
+// routes instead of starting another.
+func (s *Server) traceRequest(c *gin.Context) {
+	if s.root != nil {
+		route := "/ns/:ns" + c.FullPath()
+		span := trace.SpanFromContext(c.Request.Context())
+		span.SetName(c.Request.Method + " " + route)
+		span.SetAttributes(semconv.HTTPRoute(route))
+		c.Next()
+		return
+	}
+
+	ctx := propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	name := c.Request.Method
+	if c.FullPath() != "" {
+		name += " " + c.FullPath()
+	}
+	ctx, span := s.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(c.Request.Method),
+			semconv.HTTPRoute(c.FullPath()),
+			semconv.URLPath(c.Request.URL.Path),
+			semconv.ClientAddress(c.ClientIP()),
+			attribute.String("request_id", RequestID(ctx)),
+		),
+	)
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
+
+// Start a span for a store statement, or for a transaction when query
+// is empty, named after the function running it.
+func (db *timedDB) startSpan(ctx context.Context, name, query string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{semconv.DBSystemSqlite}
+	if words := strings.Fields(query); len(words) > 0 {
+		attrs = append(attrs,
+			semconv.DBQueryText(strings.TrimSpace(query)),
+			semconv.DBOperationName(strings.ToUpper(words[0])),
+		)
+	}
+	return db.tracer.Start(ctx, "store."+name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// End a store span, marking it failed if err is set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
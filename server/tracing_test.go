@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func (suite *testSuite) TestTracing() {
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	var err error
+	suite.srv, err = New("fakeaddress", WithSeed("testdata/sensors.json"), WithTracerProvider(tp))
+	suite.Nil(err)
+	suite.Equal(201, suite.request("POST", "/namespaces", &Namespace{Name: "east"}).Code)
+
+	find := func(name string) (found []sdktrace.ReadOnlySpan) {
+		for _, span := range spans.Ended() {
+			if span.Name() == name {
+				found = append(found, span)
+			}
+		}
+		return found
+	}
+	attr := func(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+		for _, kv := range span.Attributes() {
+			if kv.Key == key {
+				return kv.Value
+			}
+		}
+		return attribute.Value{}
+	}
+
+	// Requests continue the trace the client sent.
+	request := httptest.NewRequest("GET", "/sensor/L1MAG", nil)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	request.Header.Set(RequestIDHeader, "ticket-7")
+	suite.srv.gin.ServeHTTP(httptest.NewRecorder(), request)
+
+	handled := find("GET /sensor/:name")
+	suite.Len(handled, 1)
+	suite.Equal(trace.SpanKindServer, handled[0].SpanKind())
+	suite.Equal("4bf92f3577b34da6a3ce929d0e0e4736", handled[0].SpanContext().TraceID().String())
+	suite.Equal("00f067aa0ba902b7", handled[0].Parent().SpanID().String())
+	suite.Equal("/sensor/:name", attr(handled[0], "http.route").AsString())
+	suite.Equal(int64(200), attr(handled[0], "http.response.status_code").AsInt64())
+	suite.Equal("ticket-7", attr(handled[0], "request_id").AsString())
+
+	queries := find("store.querySensorsWhere")
+	suite.NotEmpty(queries)
+	suite.Equal(handled[0].SpanContext().SpanID(), queries[0].Parent().SpanID())
+	suite.Equal("sqlite", attr(queries[0], "db.system").AsString())
+	suite.Equal("SELECT", attr(queries[0], "db.operation.name").AsString())
+	suite.Contains(attr(queries[0], "db.query.text").AsString(), "FROM sensors")
+
+	// Statements in a transaction are children of its span.
+	suite.Equal(201, suite.request("POST", "/sensor/L1MAG/readings", []*Reading{{Time: time.Now(), Value: 1}}).Code)
+	transactions := find("store.insertReadings")
+	suite.Len(transactions, 1)
+	suite.Empty(attr(transactions[0], "db.query.text").AsString())
+	inserts := find("store.insertReadingRows")
+	suite.NotEmpty(inserts)
+	suite.Equal(transactions[0].SpanContext().SpanID(), inserts[0].Parent().SpanID())
+	suite.Equal("INSERT", attr(inserts[0], "db.operation.name").AsString())
+
+	// Namespaced requests get one span, named after the namespace's route.
+	suite.Equal(404, suite.request("GET", "/ns/east/sensor/MISSING", nil).Code)
+	suite.Len(find("GET /ns/:ns/sensor/:name"), 1)
+	suite.Empty(find("GET /ns/:ns/*path"))
+}
+
+func (suite *testSuite) TestQuerySpansEndWhenRowsClosed() {
+	spans := tracetest.NewSpanRecorder()
+	db := &timedDB{DB: suite.srv.db.conn.DB, tracer: newTracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))}
+	read := func(rows *timedRows) {
+		for rows.Next() {
+			time.Sleep(10 * time.Millisecond)
+		}
+		suite.Empty(spans.Ended())
+		suite.Nil(rows.Close())
+		suite.Len(spans.Ended(), 1)
+		span := spans.Ended()[0]
+		suite.GreaterOrEqual(span.EndTime().Sub(span.StartTime()), 30*time.Millisecond)
+	}
+
+	// Time spent reading the rows lands in the query's span.
+	rows, err := db.QueryContext(context.Background(), `SELECT name FROM sensors`)
+	suite.Nil(err)
+	read(rows)
+
+	spans = tracetest.NewSpanRecorder()
+	db.tracer = newTracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	tx, err := db.BeginTx(context.Background(), nil)
+	suite.Nil(err)
+	defer tx.Rollback()
+	rows, err = tx.QueryContext(context.Background(), `SELECT name FROM sensors`)
+	suite.Nil(err)
+	read(rows)
+}
+
+// A tracer provider noting whether the server had stopped listening
+// and closed its store by the time it's flushed.
+type flushRecorder struct {
+	*sdktrace.TracerProvider
+	addr        string
+	db          *store
+	listening   bool
+	storeClosed bool
+}
+
+func (tp *flushRecorder) Shutdown(ctx context.Context) error {
+	if conn, err := net.Dial("tcp", tp.addr); err == nil {
+		conn.Close()
+		tp.listening = true
+	}
+	tp.storeClosed = tp.db.conn.Ping() != nil
+	return tp.TracerProvider.Shutdown(ctx)
+}
+
+func (suite *testSuite) TestShutdownFlushesSpansLast() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	suite.Nil(err)
+	addr := listener.Addr().String()
+	suite.Nil(listener.Close())
+
+	tp := &flushRecorder{TracerProvider: sdktrace.NewTracerProvider(), addr: addr}
+	srv, err := New(addr, WithTracerProvider(tp))
+	suite.Nil(err)
+	tp.db = srv.db
+	served := make(chan error)
+	go func() { served <- srv.Serve() }()
+	suite.Eventually(func() bool {
+		response, err := http.Get("http://" + addr + "/health")
+		if err == nil {
+			response.Body.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Serve waits for the stores to close and the spans to be flushed,
+	// after the http server has stopped.
+	srv.shutdown()
+	suite.ErrorIs(<-served, http.ErrServerClosed)
+	suite.False(tp.listening)
+	suite.True(tp.storeClosed)
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Exponents of the SI base dimensions a unit is made of.
+// Volts for example are kg·m²·s⁻³·A⁻¹.
+type Dimension struct {
+	Mass        int `json:"mass,omitempty"`
+	Length      int `json:"length,omitempty"`
+	Time        int `json:"time,omitempty"`
+	Current     int `json:"current,omitempty"`
+	Temperature int `json:"temperature,omitempty"`
+	Amount      int `json:"amount,omitempty"`
+	Luminosity  int `json:"luminosity,omitempty"`
+}
+
+// Format the dimension in SI base units, "1" if dimensionless.
+func (d Dimension) String() string {
+	bases := []struct {
+		symbol   string
+		exponent int
+	}{
+		{"kg", d.Mass}, {"m", d.Length}, {"s", d.Time}, {"A", d.Current},
+		{"K", d.Temperature}, {"mol", d.Amount}, {"cd", d.Luminosity},
+	}
+
+	var parts []string
+	for _, base := range bases {
+		switch base.exponent {
+		case 0:
+		case 1:
+			parts = append(parts, base.symbol)
+		default:
+			parts = append(parts, base.symbol+superscript(base.exponent))
+		}
+	}
+	if len(parts) == 0 {
+		return "1"
+	}
+	return strings.Join(parts, "·")
+}
+
+func superscript(n int) string {
+	digits := []rune("⁰¹²³⁴⁵⁶⁷⁸⁹")
+	var out []rune
+	if n < 0 {
+		out = append(out, '⁻')
+		n = -n
+	}
+	for _, r := range strconv.Itoa(n) {
+		out = append(out, digits[r-'0'])
+	}
+	return string(out)
+}
+
+// A unit in the catalog. Values convert between units of the same
+// quantity through the quantity's coherent SI unit: si = value * Factor.
+type Unit struct {
+	Symbol    string    `json:"symbol"`
+	Name      string    `json:"name"`
+	Quantity  string    `json:"quantity"`
+	Dimension Dimension `json:"dimension"`
+	SI        string    `json:"si"`
+	Factor    float64   `json:"factor"`
+	Aliases   []string  `json:"aliases,omitempty"`
+}
+
+var (
+	voltage   = Dimension{Mass: 1, Length: 2, Time: -3, Current: -1}
+	current   = Dimension{Current: 1}
+	power     = Dimension{Mass: 1, Length: 2, Time: -3}
+	frequency = Dimension{Time: -1}
+	rocof     = Dimension{Time: -2}
+	impedance = Dimension{Mass: 1, Length: 2, Time: -3, Current: -2}
+	none      = Dimension{}
+)
+
+// The canonical unit catalog. Symbols are what sensors store,
+// aliases are accepted on write and normalized to the symbol.
+var unitCatalog = []*Unit{
+	{Symbol: "V", Name: "volt", Quantity: "voltage", Dimension: voltage, Factor: 1, Aliases: []string{"volt", "volts"}},
+	{Symbol: "kV", Name: "kilovolt", Quantity: "voltage", Dimension: voltage, Factor: 1e3, Aliases: []string{"kilovolt", "kilovolts"}},
+	{Symbol: "A", Name: "ampere", Quantity: "current", Dimension: current, Factor: 1, Aliases: []string{"amp", "amps", "ampere", "amperes"}},
+	{Symbol: "kA", Name: "kiloampere", Quantity: "current", Dimension: current, Factor: 1e3, Aliases: []string{"kiloamp", "kiloamps", "kiloampere", "kiloamperes"}},
+	{Symbol: "rad", Name: "radian", Quantity: "angle", Dimension: none, Factor: 1, Aliases: []string{"radian", "radians"}},
+	{Symbol: "deg", Name: "degree", Quantity: "angle", Dimension: none, Factor: math.Pi / 180, Aliases: []string{"°", "degree", "degrees"}},
+	{Symbol: "Hz", Name: "hertz", Quantity: "frequency", Dimension: frequency, Factor: 1, Aliases: []string{"hertz"}},
+	{Symbol: "Hz/s", Name: "hertz per second", Quantity: "rate of change of frequency", Dimension: rocof, Factor: 1, Aliases: []string{"rocof"}},
+	{Symbol: "W", Name: "watt", Quantity: "active power", Dimension: power, Factor: 1, Aliases: []string{"watt", "watts"}},
+	{Symbol: "kW", Name: "kilowatt", Quantity: "active power", Dimension: power, Factor: 1e3, Aliases: []string{"kilowatt", "kilowatts"}},
+	{Symbol: "MW", Name: "megawatt", Quantity: "active power", Dimension: power, Factor: 1e6, Aliases: []string{"megawatt", "megawatts"}},
+	{Symbol: "var", Name: "volt-ampere reactive", Quantity: "reactive power", Dimension: power, Factor: 1, Aliases: []string{"vars"}},
+	{Symbol: "kvar", Name: "kilovolt-ampere reactive", Quantity: "reactive power", Dimension: power, Factor: 1e3, Aliases: []string{"kvars"}},
+	{Symbol: "Mvar", Name: "megavolt-ampere reactive", Quantity: "reactive power", Dimension: power, Factor: 1e6},
+	{Symbol: "VA", Name: "volt-ampere", Quantity: "apparent power", Dimension: power, Factor: 1, Aliases: []string{"volt-ampere", "volt-amperes"}},
+	{Symbol: "kVA", Name: "kilovolt-ampere", Quantity: "apparent power", Dimension: power, Factor: 1e3},
+	{Symbol: "MVA", Name: "megavolt-ampere", Quantity: "apparent power", Dimension: power, Factor: 1e6},
+	{Symbol: "ohm", Name: "ohm", Quantity: "impedance", Dimension: impedance, Factor: 1, Aliases: []string{"Ω", "ohms"}},
+	{Symbol: "pu", Name: "per unit", Quantity: "ratio", Dimension: none, Factor: 1, Aliases: []string{"p.u."}},
+	{Symbol: "%", Name: "percent", Quantity: "ratio", Dimension: none, Factor: 0.01, Aliases: []string{"percent"}},
+}
+
+// Lookup tables built from the catalog. Exact symbols and aliases
+// win. Only aliases also match in any case, and only when unambiguous:
+// the case of a symbol's prefix is its scale, mW isn't MW.
+var unitsByName, unitsByFold = indexUnits(unitCatalog)
+
+func indexUnits(catalog []*Unit) (exact, folded map[string]*Unit) {
+	exact = make(map[string]*Unit)
+	folded = make(map[string]*Unit)
+	ambiguous := make(map[string]bool)
+
+	for _, unit := range catalog {
+		unit.SI = unit.Dimension.String()
+		exact[unit.Symbol] = unit
+		for _, name := range unit.Aliases {
+			exact[name] = unit
+
+			key := strings.ToLower(name)
+			if other, ok := folded[key]; ok && other != unit {
+				ambiguous[key] = true
+			}
+			folded[key] = unit
+		}
+	}
+
+	for key := range ambiguous {
+		delete(folded, key)
+	}
+	return exact, folded
+}
+
+// Find a unit by its symbol or one of its aliases.
+func LookupUnit(name string) (*Unit, bool) {
+	name = strings.TrimSpace(name)
+	if unit, ok := unitsByName[name]; ok {
+		return unit, true
+	}
+	unit, ok := unitsByFold[strings.ToLower(name)]
+	return unit, ok
+}
+
+// Convert a value between two units of the same quantity.
+func ConvertUnit(value float64, from, to string) (float64, error) {
+	fromUnit, ok := LookupUnit(from)
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", from)
+	}
+	toUnit, ok := LookupUnit(to)
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", to)
+	}
+	return convert(value, fromUnit, toUnit)
+}
+
+func convert(value float64, from, to *Unit) (float64, error) {
+	if from.Quantity != to.Quantity || from.Dimension != to.Dimension {
+		return 0, fmt.Errorf("cannot convert %s (%s) to %s (%s)", from.Symbol, from.Quantity, to.Symbol, to.Quantity)
+	}
+	if from == to {
+		return value, nil
+	}
+	return value * from.Factor / to.Factor, nil
+}
+
+// List the unit catalog.
+func (s *Server) listUnits(c *gin.Context) {
+	units := make([]*Unit, len(unitCatalog))
+	copy(units, unitCatalog)
+	sort.SliceStable(units, func(i, j int) bool { return units[i].Quantity < units[j].Quantity })
+	c.IndentedJSON(http.StatusOK, units)
+}
+
+// Look up a single unit by symbol or alias.
+func (s *Server) getUnit(c *gin.Context) {
+	unit, ok := LookupUnit(c.Param("symbol"))
+	if !ok {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "Unit not found in catalog"})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, unit)
+}
+
+type Conversion struct {
+	Value  float64 `json:"value"`
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Result float64 `json:"result"`
+}
+
+// Convert a value, e.g. a threshold, between compatible units.
+func (s *Server) convertUnit(c *gin.Context) {
+	value, err := strconv.ParseFloat(c.Query("value"), 64)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "value must be a number"})
+		return
+	}
+
+	from, ok := LookupUnit(c.Query("from"))
+	if !ok {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown unit %q", c.Query("from"))})
+		return
+	}
+	to, ok := LookupUnit(c.Query("to"))
+	if !ok {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown unit %q", c.Query("to"))})
+		return
+	}
+
+	result, err := convert(value, from, to)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, Conversion{Value: value, From: from.Symbol, To: to.Symbol, Result: result})
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+func (suite *testSuite) TestLookupUnit() {
+	for _, name := range []string{"V", "volt", "volts", "Volts", "VOLTS"} {
+		unit, ok := LookupUnit(name)
+		suite.True(ok, name)
+		suite.Equal("V", unit.Symbol, name)
+	}
+
+	// Symbols only match in their own case, as prefixes differ by it.
+	for _, name := range []string{"v", "mW", "mVA", "mvar", "mvars"} {
+		_, ok := LookupUnit(name)
+		suite.False(ok, name)
+	}
+
+	unit, ok := LookupUnit("kV")
+	suite.True(ok)
+	suite.Equal("kg·m²·s⁻³·A⁻¹", unit.SI)
+
+	_, ok = LookupUnit("parsecs")
+	suite.False(ok)
+}
+
+func (suite *testSuite) TestConvertUnit() {
+	value, err := ConvertUnit(180, "deg", "rad")
+	suite.Nil(err)
+	suite.InDelta(math.Pi, value, 1e-12)
+
+	value, err = ConvertUnit(1.2, "kV", "volts")
+	suite.Nil(err)
+	suite.InDelta(1200, value, 1e-9)
+
+	_, err = ConvertUnit(1, "kV", "amps")
+	suite.NotNil(err)
+
+	// Both dimensionless, but not the same quantity.
+	_, err = ConvertUnit(1, "deg", "%")
+	suite.NotNil(err)
+}
+
+func (suite *testSuite) TestUnitRoutes() {
+	response := suite.request("GET", "/units", nil)
+	suite.Equal(200, response.Code)
+
+	var units []*Unit
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &units))
+	suite.Len(units, len(unitCatalog))
+
+	response = suite.request("GET", "/units/amps", nil)
+	suite.Equal(200, response.Code)
+
+	response = suite.request("GET", "/units/parsecs", nil)
+	suite.Equal(404, response.Code)
+
+	response = suite.request("GET", "/units/convert?value=90&from=deg&to=rad", nil)
+	suite.Equal(200, response.Code)
+
+	var conversion Conversion
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &conversion))
+	suite.InDelta(math.Pi/2, conversion.Result, 1e-12)
+
+	response = suite.request("GET", "/units/convert?value=90&from=deg&to=V", nil)
+	suite.Equal(400, response.Code)
+}
+
+func (suite *testSuite) TestReadings() {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	readings := []*Reading{
+		{Time: start, Value: 90},
+		{Time: start.Add(time.Second), Value: 180},
+		{Time: start.Add(2 * time.Second), Value: 270},
+	}
+
+	response := suite.request("POST", "/sensor/L1ANG/readings", readings)
+	suite.Equal(201, response.Code)
+
+	response = suite.request("POST", "/sensor/NOTASENSOR/readings", readings)
+	suite.Equal(404, response.Code)
+
+	response = suite.request("GET", "/sensor/L1ANG/readings?end=2024-01-01T00:00:02Z&unit=rad", nil)
+	suite.Equal(200, response.Code)
+
+	var result Readings
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &result))
+	suite.Equal("rad", result.Unit)
+	suite.Len(result.Readings, 2)
+	suite.InDelta(math.Pi/2, result.Readings[0].Value, 1e-12)
+	suite.InDelta(math.Pi, result.Readings[1].Value, 1e-12)
+
+	response = suite.request("GET", "/sensor/L1ANG/readings?unit=kV", nil)
+	suite.Equal(400, response.Code)
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Sensor names start with a letter or digit and may contain
+// letters, digits, underscores, dashes and dots.
+var sensorNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// A single field that failed validation. Field uses the
+// JSON path of the field, e.g. "tags.unit".
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Returned when a payload fails validation, listing every failing field.
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid request: " + e.messages()
+}
+
+func (e *ValidationError) messages() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, fmt.Sprintf("%s %s", field.Field, field.Message))
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Add a field error, used for checks that need more context than struct tags.
+func (e *ValidationError) add(field, rule, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Rule: rule, Message: message})
+}
+
+// Return the error if any field failed, otherwise nil.
+func (e *ValidationError) orNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+var sensorValidator = newSensorValidator()
+
+func newSensorValidator() *validator.Validate {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	// Report fields by their JSON names rather than Go names.
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	validate.RegisterValidation("sensorname", func(fl validator.FieldLevel) bool {
+		return sensorNamePattern.MatchString(fl.Field().String())
+	})
+	validate.RegisterValidation("unit", func(fl validator.FieldLevel) bool {
+		_, ok := LookupUnit(fl.Field().String())
+		return ok
+	})
+
+	// The tag name duplicates the sensor name and must agree with it.
+	validate.RegisterStructValidation(func(sl validator.StructLevel) {
+		sensor := sl.Current().Interface().(Sensor)
+		if sensor.Tags.Name != "" && sensor.Tags.Name != sensor.Name {
+			sl.ReportError(sensor.Tags.Name, "tags.name", "Name", "tagname", "")
+		}
+	}, Sensor{})
+
+	return validate
+}
+
+// Check a sensor against the static validation rules shared by
+// the server and the CLI. An empty tag name defaults to the sensor
+// name and unit aliases are normalized to the catalog symbol.
+// Returns a *ValidationError describing every failing field.
+func ValidateSensor(sensor *Sensor) error {
+	if sensor == nil {
+		return &ValidationError{Fields: []FieldError{{Field: "sensor", Rule: "required", Message: "is required"}}}
+	}
+	if sensor.Tags.Name == "" {
+		sensor.Tags.Name = sensor.Name
+	}
+	if unit, ok := LookupUnit(sensor.Tags.Unit); ok {
+		sensor.Tags.Unit = unit.Symbol
+	}
+
+	err := sensorValidator.Struct(sensor)
+	if err == nil {
+		return nil
+	}
+
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return err
+	}
+
+	verr := &ValidationError{}
+	for _, fe := range fieldErrors {
+		verr.add(fieldPath(fe), fe.Tag(), ruleMessage(fe))
+	}
+	return verr
+}
+
+// Strip the root struct name from a validator namespace, "Sensor.tags.unit" -> "tags.unit".
+func fieldPath(fe validator.FieldError) string {
+	namespace := fe.Namespace()
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return namespace
+}
+
+func ruleMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "sensorname":
+		return "must start with a letter or digit and only contain letters, digits, '_', '-' or '.' (at most 64 characters)"
+	case "unit":
+		return "must be a unit from the catalog at /units"
+	case "tagname":
+		return "must match the sensor name"
+	case "gte":
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "lte":
+		return fmt.Sprintf("must be at most %s", fe.Param())
+	case "max":
+		return fmt.Sprintf("must be at most %s characters", fe.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of %s", strings.ReplaceAll(fe.Param(), " ", ", "))
+	}
+	return fmt.Sprintf("failed the %q rule", fe.Tag())
+}
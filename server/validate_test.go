@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"errors"
+)
+
+func (suite *testSuite) TestValidateSensor() {
+	suite.Nil(ValidateSensor(CreateSensor("C2MAG", "amps", "brazil", "foo", 38.4, 26.9)))
+
+	// An empty tag name defaults to the sensor name.
+	sensor := CreateSensor("C2MAG", "amps", "brazil", "foo", 38.4, 26.9)
+	sensor.Tags.Name = ""
+	suite.Nil(ValidateSensor(sensor))
+	suite.Equal("C2MAG", sensor.Tags.Name)
+
+	sensor.Tags.Name = "C3MAG"
+	sensor.Location.Longitude = -181
+	sensor.Tags.Distiller = ""
+	err := ValidateSensor(sensor)
+
+	var verr *ValidationError
+	suite.True(errors.As(err, &verr))
+	suite.ElementsMatch([]FieldError{
+		{Field: "location.longitude", Rule: "gte", Message: "must be at least -180"},
+		{Field: "tags.distiller", Rule: "required", Message: "is required"},
+		{Field: "tags.name", Rule: "tagname", Message: "must match the sensor name"},
+	}, verr.Fields)
+
+	suite.NotNil(ValidateSensor(CreateSensor("has space", "amps", "brazil", "foo", 0, 0)))
+	suite.NotNil(ValidateSensor(nil))
+}
+
+func (suite *testSuite) TestValidateSensorAgainstServer() {
+	suite.Nil(suite.srv.validateSensor(CreateSensor("C2MAG", "amps", "Anaheim", "foo", 0, 0), "C2MAG"))
+
+	err := suite.srv.validateSensor(CreateSensor("C2MAG", "amps", "Anaheim", "foo", 0, 0), "C3MAG")
+	var verr *ValidationError
+	suite.True(errors.As(err, &verr))
+	suite.Len(verr.Fields, 1)
+	suite.Equal("path", verr.Fields[0].Rule)
+
+	// References are checked when the sensor is written.
+	err = suite.srv.db.insertSensor(context.Background(), CreateSensor("C2MAG", "amps", "brazil", "qux", 0, 0))
+	suite.True(errors.As(err, &verr))
+	suite.Equal([]FieldError{
+		{Field: "tags.ingress", Rule: "exists", Message: `references unknown ingress "brazil"`},
+		{Field: "tags.distiller", Rule: "exists", Message: `references unknown distiller "qux"`},
+	}, verr.Fields)
+}